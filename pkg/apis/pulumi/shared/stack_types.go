@@ -11,8 +11,22 @@ type StackSpec struct {
 
 	// (optional) AccessTokenSecret is the name of a secret containing the PULUMI_ACCESS_TOKEN for Pulumi access.
 	// Deprecated: use EnvRefs with a "secret" entry with the key PULUMI_ACCESS_TOKEN instead.
+	// If no access token is given for the stack, the operator-wide secret named by the environment
+	// variable PULUMI_DEFAULT_ACCESS_TOKEN_SECRET is used, if set.
 	AccessTokenSecret string `json:"accessTokenSecret,omitempty"`
 
+	// (optional) Env is an optional map of environment variables to set, given inline. This is meant
+	// for values that are not sensitive; use EnvRefs for secrets. Values given here are overridden
+	// by any given for the same variable in EnvRefs, Envs, SecretEnvs, Backend or AccessTokenSecret.
+	Env map[string]string `json:"env,omitempty"`
+
+	// (optional) EnableCLIUpdateCheck can be set to true to let the Pulumi CLI check for a newer
+	// version of itself, as it does by default outside the operator. The operator otherwise sets
+	// PULUMI_SKIP_UPDATE_CHECK=true in the workspace, which saves a request to the Pulumi service
+	// and a warning in the logs each time the CLI runs. A value for PULUMI_SKIP_UPDATE_CHECK given
+	// in Env or EnvRefs takes precedence either way.
+	EnableCLIUpdateCheck bool `json:"enableCliUpdateCheck,omitempty"`
+
 	// (optional) Envs is an optional array of config maps containing environment variables to set.
 	// Deprecated: use EnvRefs instead.
 	Envs []string `json:"envs,omitempty"`
@@ -26,6 +40,12 @@ type StackSpec struct {
 	// Deprecated: use EnvRefs instead.
 	SecretEnvs []string `json:"envSecrets,omitempty"`
 
+	// (optional) CredentialsEndpoint points cloud providers at a local endpoint which vends
+	// short-lived credentials, e.g., a sidecar in the operator's pod, so that no long-lived
+	// credentials need be stored in Kubernetes. It sets the environment variables the cloud's
+	// SDKs read for such an endpoint; these override Env, and are overridden by EnvRefs.
+	CredentialsEndpoint *CredentialsEndpoint `json:"credentialsEndpoint,omitempty"`
+
 	// (optional) Backend is an optional backend URL to use for all Pulumi operations.<br/>
 	// Examples:<br/>
 	//   - Pulumi Service:              "https://app.pulumi.com" (default)<br/>
@@ -35,15 +55,78 @@ type StackSpec struct {
 	//   - Azure:                       "azblob://<my-pulumi-state-bucket>" <br/>
 	//   - GCP:                         "gs://<my-pulumi-state-bucket>" <br/>
 	// See: https://www.pulumi.com/docs/intro/concepts/state/
+	// If omitted, the operator-wide value from the environment variable PULUMI_DEFAULT_BACKEND_URL
+	// is used, if set.
 	Backend string `json:"backend,omitempty"`
 
+	// (optional) UserAgentSuffix is appended to the user agent the operator reports to the Pulumi
+	// backend for updates, refreshes and destroys, e.g., to attribute activity to a particular cluster
+	// or tenant. If omitted, the operator-wide value from the environment variable
+	// PULUMI_USER_AGENT_SUFFIX is used, if set.
+	UserAgentSuffix string `json:"userAgentSuffix,omitempty"`
+	// (optional) LogOptions says how Pulumi's output for updates, refreshes, previews and destroys
+	// is rendered in the operator's logs. If omitted, the output is not colorized.
+	LogOptions *LogOptions `json:"logOptions,omitempty"`
+
+	// (optional) Kubeconfig is a reference to a kubeconfig for the cluster targeted by the stack's
+	// Kubernetes resources. It is written to a file in the stack's workspace, and KUBECONFIG and
+	// KUBE_CONFIG_PATH are set to point at it. If omitted, the ambient kubeconfig is used (by
+	// default, that of the cluster in which the operator runs).
+	Kubeconfig *ResourceRef `json:"kubeconfig,omitempty"`
+	// (optional) ProviderCACerts are references to PEM-encoded CA certificates to be trusted by the
+	// stack's program and providers, e.g., for an internal API. They are written to a file in the
+	// stack's workspace after the system's CA certificates, and SSL_CERT_FILE, NODE_EXTRA_CA_CERTS
+	// and REQUESTS_CA_BUNDLE are set to point at it, so that the Go, Node.js and Python runtimes
+	// trust them.
+	ProviderCACerts []ResourceRef `json:"providerCACerts,omitempty"`
+	// (optional) KubeContext is the context to use from the kubeconfig, if not its current context.
+	// It is given to the Kubernetes provider as the configuration value "kubernetes:context", unless
+	// that is given in Config.
+	KubeContext string `json:"kubeContext,omitempty"`
+
 	// Stack identity:
 
-	// Stack is the fully qualified name of the stack to deploy (<org>/<stack>).
+	// Stack is the fully qualified name of the stack to deploy (<org>/<stack>). With a self-managed
+	// backend (file://, s3://, azblob:// or gs://), which has no organizations, it is just <stack>.
 	Stack string `json:"stack"`
 	// (optional) Config is the configuration for this stack, which can be optionally specified inline. If this
 	// is omitted, configuration is assumed to be checked in and taken from the source repository.
 	Config map[string]string `json:"config,omitempty"`
+	// (optional) ProviderDefaults is configuration for providers used by this stack, keyed by provider
+	// namespace (e.g., "aws") then by setting (e.g., "region"). Each entry is written to the stack
+	// configuration as a namespaced key (e.g., "aws:region"). Values given in Config take precedence
+	// over those given here, when the same namespaced key appears in both.
+	ProviderDefaults map[string]map[string]string `json:"providerDefaults,omitempty"`
+	// (optional) ResourceDefaults are defaults for the resources of this stack, written to the stack
+	// configuration. Only DisableDefaultProviders is enforced by the Pulumi engine; the others are
+	// conventions for the program (or a library it uses) to apply, e.g., with a stack transformation.
+	// Values given in Config take precedence over those given here, when the same key appears in both.
+	ResourceDefaults *ResourceDefaults `json:"resourceDefaults,omitempty"`
+	// (optional) ConfigFile is the path, relative to the root of the source repository, of a file of
+	// stack settings to use in place of Pulumi.<stack>.yaml, as with `pulumi up --config-file`; e.g.,
+	// "config/prod.yaml". It must exist and be valid stack settings. It is then the checked-in
+	// configuration, as far as ConfigMergeMode is concerned, so that configuration given in the spec
+	// takes precedence over it.
+	ConfigFile string `json:"configFile,omitempty"`
+	// (optional) ConfigMergeMode says how configuration given in the spec combines with configuration
+	// checked in to the source repository (in Pulumi.<stack>.yaml, or ConfigFile). With "merge", the default, the
+	// checked-in configuration is the base, and values from the spec override it. With "replace",
+	// checked-in configuration is disregarded, and only values from the spec are used. Within the
+	// spec, values are applied in this order, later ones taking precedence for the same key:
+	// ProviderDefaults, ResourceDefaults, KubeContext, Config, ConfigRefs, Secrets, SecretRefs.
+	// +kubebuilder:validation:Enum=merge;replace
+	ConfigMergeMode ConfigMergeMode `json:"configMergeMode,omitempty"`
+	// (optional) ConfigInterpolation, when true, has references in the values in Config replaced
+	// before they are set: "${key}" with the value of another key in Config (e.g.,
+	// "https://${app:host}:${app:port}"), "${env:NAME}" with the value of NAME in Env, and "$$"
+	// with "$". A reference to anything not given, or references which form a cycle, fail the
+	// stack. Secret values (Secrets and SecretRefs), provider and resource defaults, and
+	// checked-in configuration are not interpolated, and cannot be referred to.
+	ConfigInterpolation bool `json:"configInterpolation,omitempty"`
+	// (optional) ConfigRefs is configuration for this stack whose values are given through
+	// ResourceRef, e.g., from a field of the Stack object with the Downward selector. Unlike
+	// SecretRefs, the values are set as plain (not secret) configuration.
+	ConfigRefs map[string]ResourceRef `json:"configRefs,omitempty"`
 	// (optional) Secrets is the secret configuration for this stack, which can be optionally specified inline. If this
 	// is omitted, secrets configuration is assumed to be checked in and taken from the source repository.
 	// Deprecated: use SecretRefs instead.
@@ -52,19 +135,99 @@ type StackSpec struct {
 	// (optional) SecretRefs is the secret configuration for this stack which can be specified through ResourceRef.
 	// If this is omitted, secrets configuration is assumed to be checked in and taken from the source repository.
 	SecretRefs map[string]ResourceRef `json:"secretsRef,omitempty"`
+	// (optional) Matrix, if given, has this Stack object stand for a set of stacks, one for each
+	// variant listed. Each variant is deployed by a Stack object generated by the operator, and named
+	// "<name>-<stackSuffix>", which has this object's spec with the suffix appended to Stack (e.g.,
+	// "acme/app-us-east-1"), and the variant's Config and SecretRefs given precedence over this
+	// object's. The generated objects have this object's labels, and are owned by it, so are deleted
+	// along with it, and whatever is done on their deletion (e.g., DestroyOnFinalize) is done for
+	// each variant. This object deploys nothing itself; its status reports on each variant, and it
+	// is ready when all of them are. A Stack object of the same name not generated for this matrix
+	// is never taken over; this object is marked stalled instead.
+	Matrix []MatrixVariant `json:"matrix,omitempty"`
 	// (optional) SecretsProvider is used to initialize a Stack with alternative encryption.
 	// Examples:
 	//   - AWS:   "awskms:///arn:aws:kms:us-east-1:111122223333:key/1234abcd-12ab-34bc-56ef-1234567890ab?region=us-east-1"
 	//   - Azure: "azurekeyvault://acmecorpvault.vault.azure.net/keys/mykeyname"
 	//   - GCP:   "gcpkms://projects/MYPROJECT/locations/MYLOCATION/keyRings/MYKEYRING/cryptoKeys/MYKEY"
-	//   -
+	//   - Vault: "hashivault://mykey" (see VaultAddressRef and VaultTokenRef)
 	// See: https://www.pulumi.com/docs/intro/concepts/secrets/#initializing-a-stack-with-alternative-encryption
 	SecretsProvider string `json:"secretsProvider,omitempty"`
+	// (optional) SecretsProviderRef is a reference to the secrets provider, to be used instead of
+	// SecretsProvider when the provider URL includes sensitive parts, e.g., a passphrase or
+	// credentials. Any credentials the provider needs from the environment can be given with
+	// EnvRefs. Only one of SecretsProvider and SecretsProviderRef may be given.
+	SecretsProviderRef *ResourceRef `json:"secretsProviderRef,omitempty"`
+	// (optional) SecretsProviderKey gives the key for a cloud secrets provider in parts, from which
+	// the provider URL is composed, as an alternative to writing the URL in SecretsProvider. It is
+	// ignored if SecretsProvider or SecretsProviderRef is given.
+	SecretsProviderKey *SecretsProviderKey `json:"secretsProviderKey,omitempty"`
+	// (optional) PassphraseRef is a reference to the passphrase for the passphrase secrets provider.
+	// It is given to Pulumi as PULUMI_CONFIG_PASSPHRASE_FILE if it refers to a file, and as
+	// PULUMI_CONFIG_PASSPHRASE otherwise. A stack using the passphrase secrets provider, whether
+	// given by SecretsProvider or in the checked-in stack settings, fails if there is no passphrase
+	// here or in the environment.
+	PassphraseRef *ResourceRef `json:"passphraseRef,omitempty"`
+	// (optional) VaultAddressRef is a reference to the address of the HashiCorp Vault server for
+	// the Vault transit secrets provider (`hashivault://`). It is given to Pulumi as VAULT_ADDR.
+	VaultAddressRef *ResourceRef `json:"vaultAddressRef,omitempty"`
+	// (optional) VaultTokenRef is a reference to the token with which to authenticate to HashiCorp
+	// Vault for the Vault transit secrets provider. It is given to Pulumi as VAULT_TOKEN. A stack
+	// using the Vault transit secrets provider, whether given by SecretsProvider or in the
+	// checked-in stack settings, fails if either VAULT_ADDR or VAULT_TOKEN is not given here or in
+	// the environment.
+	VaultTokenRef *ResourceRef `json:"vaultTokenRef,omitempty"`
+
+	// (optional) StackReferences lists the stacks whose outputs are read by this stack's program,
+	// using StackReference. Stack references are resolved by the engine against this stack's own
+	// backend, with the same credentials, so each referenced stack must be readable from there. An
+	// access token can be given for a reference; it must resolve before the stack is run, and is
+	// used as PULUMI_ACCESS_TOKEN if the stack does not otherwise have one. If references give
+	// different tokens, the stack must have its own, since none of them can be assumed to serve it.
+	StackReferences []StackReference `json:"stackReferences,omitempty"`
+
+	// (optional) Outputs selects which stack outputs are recorded in the status, by name, and may
+	// limit their size. If omitted, all outputs are recorded. Outputs marked as secret are always
+	// redacted.
+	Outputs *OutputSelector `json:"outputs,omitempty"`
+	// (optional) AllOutputsSecret can be set to true to redact the value of every output recorded in
+	// the status, as though it were marked as secret, so that no plaintext values appear there.
+	AllOutputsSecret bool `json:"allOutputsSecret,omitempty"`
+	// (optional) OutputsJSON, when true, also records the outputs in status.outputsJSON, as a single
+	// JSON object with its keys sorted, for tools which would rather parse one document. Secret
+	// outputs are left out of it rather than redacted.
+	OutputsJSON bool `json:"outputsJSON,omitempty"`
+	// (optional) RequiredOutputs lists the names of outputs the stack must export, e.g., because
+	// other stacks read them. If any is missing after an update, the stack is marked as failed, so
+	// that a program which has stopped exporting one is caught before the stacks using it break.
+	// This applies whether or not the output is selected by Outputs.
+	RequiredOutputs []string `json:"requiredOutputs,omitempty"`
+	// (optional) ReportResources, when true, summarizes the resources in the stack in its status
+	// after each successful update, as the number of each type. This reads the stack's state, so is
+	// off by default.
+	ReportResources bool `json:"reportResources,omitempty"`
 
 	// Source control:
 
-	// ProjectRepo is the git source control repository from which we fetch the project code and configuration.
-	ProjectRepo string `json:"projectRepo"`
+	// (optional) ProjectRepo is the git source control repository from which we fetch the project
+	// code and configuration. Exactly one of ProjectRepo and LocalPath must be given.
+	ProjectRepo string `json:"projectRepo,omitempty"`
+	// (optional) LocalPath is the absolute path of a directory in the operator's filesystem holding
+	// the project code and configuration, e.g., on a volume synced out of band in an air-gapped
+	// cluster. It is used in place of ProjectRepo, and copied rather than cloned; RepoDir is
+	// relative to it. If it's a git checkout, the commit checked out is recorded as for
+	// ProjectRepo; otherwise, a digest of its contents is recorded instead. Either way, it is
+	// checked for changes at the resync frequency, as for a tracked branch.
+	LocalPath string `json:"localPath,omitempty"`
+	// (optional) ProjectRepoMirrors lists other URLs for the project repository, which are tried
+	// in order, with the same authentication, if cloning ProjectRepo fails. The mirrors are assumed
+	// to be kept in sync with ProjectRepo. The URL used is recorded in the status.
+	ProjectRepoMirrors []string `json:"projectRepoMirrors,omitempty"`
+	// (optional) CloneTimeoutSeconds limits how long cloning the project repository may take, for
+	// each of ProjectRepo and its mirrors. If cloning takes longer, it is abandoned, and the stack
+	// is retried later. If omitted, cloning is not limited.
+	// +kubebuilder:validation:Minimum=1
+	CloneTimeoutSeconds int64 `json:"cloneTimeoutSeconds,omitempty"`
 	// (optional) GitAuthSecret is the the name of a secret containing an
 	// authentication option for the git repository.
 	// There are 3 different authentication options:
@@ -86,10 +249,67 @@ type StackSpec struct {
 	// with ssh private key/password preferred first, then personal access token, and finally
 	// basic auth credentials.
 	GitAuth *GitAuthConfig `json:"gitAuth,omitempty"`
+	// (optional) GitAuthFallbacks lists further git authentication options, which are tried in
+	// order if cloning the project repository with GitAuth (or GitAuthSecret) fails; e.g., an
+	// SSH key for a repository reachable by SSH only from some nodes, then an access token for
+	// HTTPS. Each URL (ProjectRepo, then each of ProjectRepoMirrors) is tried with those options
+	// which suit its scheme: SSH keys for SSH URLs, and access tokens or basic auth for HTTP(S)
+	// URLs. The option used is recorded in the status.
+	GitAuthFallbacks []GitAuthConfig `json:"gitAuthFallbacks,omitempty"`
 	// (optional) RepoDir is the directory to work from in the project's source repository
 	// where Pulumi.yaml is located. It is used in case Pulumi.yaml is not
-	// in the project source root.
+	// in the project source root. It may be a Go template, which is given the stack's inline
+	// configuration and environment as .Config and .Env, e.g., `regions/{{ .Config.region }}`, or
+	// `regions/{{ index .Config "aws:region" }}` for a namespaced key.
 	RepoDir string `json:"repoDir,omitempty"`
+	// (optional) SparseCheckoutPaths lists directories in the repository to check out, so that
+	// only part of a large repository is fetched. RepoDir is always checked out, as are the files
+	// at the top level of the repository and of each directory leading to those given. If empty,
+	// the whole repository is checked out. This uses the git command, which must be installed.
+	SparseCheckoutPaths []string `json:"sparseCheckoutPaths,omitempty"`
+	// (optional) SingleBranch says to fetch only Branch when cloning the project repository, rather
+	// than all of its branches, which saves time and memory for repositories with many branches. It
+	// needs Branch to be given. This uses the git command, which must be installed.
+	SingleBranch bool `json:"singleBranch,omitempty"`
+	// (optional) GitLFS says to fetch the content of files tracked with Git LFS (e.g., large
+	// binary assets used by the program) after cloning the project repository. This uses the git
+	// command and git-lfs, which must be installed; the stack fails if git-lfs is not.
+	GitLFS bool `json:"gitLFS,omitempty"`
+	// (optional) GitCommitter is the identity the operator uses as author and committer of any
+	// commits it makes to the project repository. The operator does not yet write to the
+	// repository, so this is only validated for now.
+	GitCommitter *GitCommitter `json:"gitCommitter,omitempty"`
+	// (optional) WorkspaceFiles lists files to write into the project directory, with contents taken
+	// from a ConfigMap or Secret in the stack's namespace, e.g., to layer environment-specific
+	// files over those checked in. Files are written after the source is fetched and before the
+	// stack is configured, replacing any checked-in file at the same path, and are removed along
+	// with the workspace once the stack has been processed.
+	WorkspaceFiles []WorkspaceFile `json:"workspaceFiles,omitempty"`
+	// (optional) ProjectTemplate is the name of a ConfigMap in the stack's namespace holding templates
+	// for the project settings, under the key "Pulumi.yaml", and the stack settings, under the key
+	// "Pulumi.<stack>.yaml"; other keys are ignored. The templates are rendered and written into the
+	// project directory, replacing any checked-in settings, before the project is loaded. They are Go
+	// templates, given the stack's inline configuration and environment as .Config and .Env, and the
+	// stack name (without any organization or project) as .Stack.
+	ProjectTemplate string `json:"projectTemplate,omitempty"`
+	// (optional) SourcePreprocess gives a command to run in the project directory to generate files
+	// from the source, e.g., stack settings rendered with Kustomize. It is run after WorkspaceFiles
+	// are written, and before the stack is selected and configured.
+	SourcePreprocess *SourcePreprocess `json:"sourcePreprocess,omitempty"`
+	// (optional) RunAsUser gives the user and group to run the commands the operator runs itself
+	// on the project source as: SourcePreprocess, and installing the project's dependencies (e.g.,
+	// `npm install`); e.g., for tools which refuse to run as root. The workspace is made owned by
+	// the user, which needs the operator to run as root. The Pulumi program and the pulumi CLI still
+	// run as the operator's user.
+	RunAsUser *PosixUser `json:"runAsUser,omitempty"`
+	// (optional) Plugins lists resource plugins to install before the program is run, e.g., so that
+	// they are downloaded from a mirror where the default download host can't be reached. Plugins
+	// the program needs which aren't listed are downloaded as usual.
+	Plugins []PluginSpec `json:"plugins,omitempty"`
+	// (optional) PluginDownloadURL is the server from which to download each of Plugins which
+	// doesn't give its own, e.g., an internal mirror of https://get.pulumi.com/releases/plugins. It
+	// must be an http(s) URL.
+	PluginDownloadURL string `json:"pluginDownloadURL,omitempty"`
 	// (optional) Commit is the hash of the commit to deploy. If used, HEAD will be in detached mode. This
 	// is mutually exclusive with the Branch setting. Either value needs to be specified.
 	Commit string `json:"commit,omitempty"`
@@ -98,6 +318,26 @@ type StackSpec struct {
 	// When specified, the operator will periodically poll to check if the branch has any new commits.
 	// The frequency of the polling is configurable through ResyncFrequencySeconds, defaulting to every 60 seconds.
 	Branch string `json:"branch,omitempty"`
+	// (optional) GitWebhookSecret refers to the secret shared with a webhook in the git host, which
+	// notifies the operator of pushes to ProjectRepo so that a new commit to Branch is deployed
+	// without waiting to poll for it. This needs the operator to be run with the environment
+	// variable GIT_WEBHOOK_ADDRESS giving the address to listen on for webhook deliveries; then a
+	// push event for the branch, signed (GitHub) or carrying a token (GitLab) using this secret, has
+	// the stack processed straight away. Polling continues as a fallback, in case a delivery is
+	// missed, so ResyncFrequencySeconds can be made longer.
+	GitWebhookSecret *ResourceRef `json:"gitWebhookSecret,omitempty"`
+	// (optional) Paths restricts which changes to a tracked branch cause the stack to be updated. When
+	// given, a new commit is only applied if it changes a file matching one of the patterns, compared
+	// with the last commit applied successfully; otherwise the commit is recorded as applied without
+	// running an update. Paths are relative to the root of the repository, and patterns have the
+	// syntax of Go's path.Match (e.g., "infra/*.ts"); a pattern matching a directory matches
+	// everything under it (e.g., "infra/app"). If omitted, every new commit is applied.
+	Paths []string `json:"paths,omitempty"`
+	// (optional) CommitDebounceSeconds, when set, has a new commit on the tracked branch applied
+	// only once no newer commit has been seen for this many seconds, so that a burst of commits is
+	// applied in one update. A change to the Stack object is applied without waiting.
+	// +kubebuilder:validation:Minimum=0
+	CommitDebounceSeconds int64 `json:"commitDebounceSeconds,omitempty"`
 	// (optional) ContinueResyncOnCommitMatch - when true - informs the operator to continue trying to update stacks
 	// even if the commit matches. This might be useful in environments where Pulumi programs have dynamic elements
 	// for example, calls to internal APIs where GitOps style commit tracking is not sufficient.
@@ -107,15 +347,87 @@ type StackSpec struct {
 
 	// Lifecycle:
 
+	// (optional) StackConfigOnly can be set to true to have the stack created in the backend (if
+	// it doesn't exist) and given the configuration from the spec, without running the program;
+	// e.g., to seed a stack before its first update is run by hand. The stack is not refreshed or
+	// updated, and is reconfigured when the spec changes or, if tracking a branch, on each resync.
+	StackConfigOnly bool `json:"stackConfigOnly,omitempty"`
 	// (optional) Refresh can be set to true to refresh the stack before it is updated.
 	Refresh bool `json:"refresh,omitempty"`
+	// (optional) RefreshDuringUpdate can be set to true to refresh the stack as part of each update,
+	// in one pass, as with `pulumi up --refresh`, rather than as a separate step before it. This
+	// takes the place of Refresh: if both are set, there is no separate refresh (unless
+	// DeleteOrphanedResources needs one). It is done by setting the project option `refresh:
+	// always`, so previews the operator runs refresh too. ExpectNoRefreshChanges can't be checked
+	// against a refresh that is part of an update, so can't be given as well.
+	RefreshDuringUpdate bool `json:"refreshDuringUpdate,omitempty"`
 	// (optional) ExpectNoRefreshChanges can be set to true if a stack is not expected to have
 	// changes during a refresh before the update is run.
 	// This could occur, for example, is a resource's state is changing outside of Pulumi
 	// (e.g., metadata, timestamps).
 	ExpectNoRefreshChanges bool `json:"expectNoRefreshChanges,omitempty"`
+	// (optional) RefreshTargets lists the URNs of the resources to refresh, when the stack is
+	// refreshed (whether because of Refresh, RefreshSchedule or DeleteOrphanedResources). If empty,
+	// all resources are refreshed. With ExpectNoRefreshChanges, only changes to these resources are
+	// considered.
+	RefreshTargets []string `json:"refreshTargets,omitempty"`
+	// (optional) RefreshIgnore lists patterns for resources whose changes found by a refresh are
+	// tolerated by ExpectNoRefreshChanges, e.g., because they are also managed by another system.
+	// Each pattern is matched against the resource's type (e.g., `aws:ec2/instance:Instance`) and
+	// its URN, and may contain `*` to match any sequence of characters (e.g., `aws:*`).
+	RefreshIgnore []string `json:"refreshIgnore,omitempty"`
+	// (optional) ExpectNoChanges can be set to true to check, after each successful update, that a
+	// preview of the stack shows no further changes; that is, that the stack has converged. If it
+	// has not (e.g., because the program is not deterministic), the update is treated as failed.
+	ExpectNoChanges bool `json:"expectNoChanges,omitempty"`
+	// (optional) RefreshSchedule, when given, has the stack refreshed periodically, to keep the recorded
+	// state in line with the real resources. Unlike Refresh, a scheduled refresh is run on its own and
+	// is not followed by an update, so it never changes the resources. Scheduled refreshes are only run
+	// while the stack is up to date with its source; a new commit or a change to the Stack object is
+	// processed as usual.
+	RefreshSchedule *RefreshSchedule `json:"refreshSchedule,omitempty"`
+	// (optional) StateBackup, when given, has the stack's state exported (as with `pulumi stack
+	// export`) to a Secret periodically, for disaster recovery. A backup is taken after the stack is
+	// updated successfully, and whenever the interval has passed since the last backup. Secret values
+	// in the state remain encrypted by the stack's secrets provider. A backup is not taken while an
+	// update is in progress, so that it's consistent.
+	StateBackup *StateBackup `json:"stateBackup,omitempty"`
+	// (optional) StateExport, when given, has the stack's last deployment exported (as with
+	// `pulumi stack export`) to a Secret after each successful update or refresh, so that it can be
+	// inspected without access to the backend. The Secret is referenced from
+	// status.lastUpdate.stateRef.
+	StateExport *StateExport `json:"stateExport,omitempty"`
+	// (optional) MaintenanceWindow, when given, restricts when the stack may be updated. A new
+	// commit or change to the Stack object outside the window is recorded in status.pendingCommit,
+	// and the update is deferred until the window next opens. Scheduled refreshes and backups are
+	// not restricted.
+	MaintenanceWindow *MaintenanceWindow `json:"maintenanceWindow,omitempty"`
+
+	// (optional) ObjectMeta gives labels and annotations to add to the Kubernetes objects the
+	// operator creates for the stack, e.g., the Secret for StateBackup. They are applied whenever
+	// the operator writes such an object, so changes take effect the next time it does.
+	ObjectMeta *ObjectMetadata `json:"objectMeta,omitempty"`
+	// (optional) DeleteOrphanedResources can be set to true to refresh the stack before each update,
+	// so that the update deletes resources the program no longer declares even if they have drifted
+	// out-of-band. The number of resources deleted is recorded in the status. Since this may delete
+	// resources, it must be used with DeletionGuard.
+	DeleteOrphanedResources bool `json:"deleteOrphanedResources,omitempty"`
+	// (optional) DeleteBeforeReplace is a stack-wide request for delete-before-replace semantics.
+	// The Pulumi engine only supports delete-before-replace per resource (through the
+	// `deleteBeforeReplace` resource option in the program), so setting this to true is rejected
+	// as an invalid spec rather than being silently ignored. Leave it unset, or set it to false.
+	DeleteBeforeReplace bool `json:"deleteBeforeReplace,omitempty"`
+	// (optional) DeletionGuard, when given, has each update previewed first, and refused if it would
+	// delete (or replace) more resources than allowed. A refused update marks the stack as failed,
+	// and is not retried until the stack or its source changes.
+	DeletionGuard *DeletionGuard `json:"deletionGuard,omitempty"`
 	// (optional) DestroyOnFinalize can be set to true to destroy the stack completely upon deletion of the CRD.
 	DestroyOnFinalize bool `json:"destroyOnFinalize,omitempty"`
+	// (optional) FinalizeTargets lists the URNs of the resources to destroy when DestroyOnFinalize
+	// is set, e.g., to decommission part of a stack. Other resources are left intact, so the stack
+	// itself is not removed from the backend. If empty, all resources are destroyed and the stack
+	// is removed.
+	FinalizeTargets []string `json:"finalizeTargets,omitempty"`
 	// (optional) RetryOnUpdateConflict issues a stack update retry reconciliation loop
 	// in the event that the update hits a HTTP 409 conflict due to
 	// another update in progress.
@@ -124,6 +436,26 @@ type StackSpec struct {
 	// all spawned retries succeed. This will also create a more populated,
 	// and randomized activity timeline for the stack in the Pulumi Service.
 	RetryOnUpdateConflict bool `json:"retryOnUpdateConflict,omitempty"`
+	// (optional) BreakLockAfterSeconds, when set, cancels the update holding the stack's lock if an
+	// update has been prevented by it for at least this long, e.g., because an operator pod crashed
+	// while updating the stack. Only set this if nothing else updates the stack, since it will also
+	// cancel a genuine concurrent update that runs for longer. The stack is retried while locked,
+	// whether or not RetryOnUpdateConflict is set.
+	// +kubebuilder:validation:Minimum=1
+	BreakLockAfterSeconds int64 `json:"breakLockAfterSeconds,omitempty"`
+	// (optional) MaxUpdateDurationSeconds limits how long an update may run. An update running for
+	// longer is cancelled, which releases the stack's lock, and the stack is marked failed and
+	// retried later. Backends which can't cancel an update (e.g., self-managed backends) have the
+	// pulumi process stopped instead, which may leave the stack locked, or with pending operations.
+	// If omitted, updates are not limited.
+	// +kubebuilder:validation:Minimum=1
+	MaxUpdateDurationSeconds int64 `json:"maxUpdateDurationSeconds,omitempty"`
+
+	// (optional) RetryPolicy configures how soon the operator retries a stack after a failed attempt
+	// to process it. The delay increases with each consecutive failure, up to a maximum, and has
+	// random jitter added so that failing stacks are spread out. If omitted, the default policy is
+	// used.
+	RetryPolicy *RetryPolicy `json:"retryPolicy,omitempty"`
 
 	// (optional) UseLocalStackOnly can be set to true to prevent the operator from
 	// creating stacks that do not exist in the tracking git repo.
@@ -133,10 +465,262 @@ type StackSpec struct {
 	// (optional) ResyncFrequencySeconds when set to a non-zero value, triggers a resync of the stack at
 	// the specified frequency even if no changes to the custom-resource are detected.
 	// If branch tracking is enabled (branch is non-empty), commit polling will occur at this frequency.
-	// The minimal resync frequency supported is 60 seconds.
+	// The minimal resync frequency supported is 60 seconds, unless the operator is run with the
+	// environment variable ALLOW_SUBMINUTE_RESYNC set to "true". Resyncing more often fetches the
+	// source, and may run the program, correspondingly more often, which adds load to the operator,
+	// the git host and the backend; so that is best kept to controlled, e.g., development, environments.
 	ResyncFrequencySeconds int64 `json:"resyncFrequencySeconds,omitempty"`
 }
 
+// MatrixVariant is one of the stacks a Stack object with a matrix stands for.
+type MatrixVariant struct {
+	// StackSuffix distinguishes the variant: it is appended, after a "-", to the stack name and to
+	// the name of the Stack object generated for the variant. It must be unique within the matrix.
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	// +kubebuilder:validation:MaxLength=63
+	StackSuffix string `json:"stackSuffix"`
+	// (optional) Config is configuration for the variant, which takes precedence over the Config of
+	// the Stack object for the same key.
+	Config map[string]string `json:"config,omitempty"`
+	// (optional) SecretRefs is secret configuration for the variant, which takes precedence over the
+	// SecretRefs of the Stack object for the same key.
+	SecretRefs map[string]ResourceRef `json:"secretsRef,omitempty"`
+}
+
+// RetryPolicy configures the backoff applied when retrying a stack after failures. The delay before
+// the nth consecutive retry is InitialDelaySeconds * Multiplier^(n-1), capped at MaxDelaySeconds,
+// plus up to JitterPercent of that again at random (still capped at MaxDelaySeconds).
+type RetryPolicy struct {
+	// (optional) InitialDelaySeconds is the delay before retrying after the first failure. Defaults
+	// to 5 seconds.
+	InitialDelaySeconds int64 `json:"initialDelaySeconds,omitempty"`
+	// (optional) MaxDelaySeconds is the longest delay between retries. Defaults to 300 seconds.
+	MaxDelaySeconds int64 `json:"maxDelaySeconds,omitempty"`
+	// (optional) Multiplier is the factor by which the delay grows with each consecutive failure.
+	// Defaults to 2.
+	Multiplier int64 `json:"multiplier,omitempty"`
+	// (optional) JitterPercent is the largest random amount added to each delay, as a percentage of
+	// the delay. Defaults to 10.
+	JitterPercent int64 `json:"jitterPercent,omitempty"`
+	// (optional) NotFoundDelaySeconds is the delay before retrying an update that failed because
+	// the stack was not found in the backend, e.g., because the backend is slow to become
+	// consistent. If omitted, the delay backs off as for other failures.
+	NotFoundDelaySeconds int64 `json:"notFoundDelaySeconds,omitempty"`
+	// (optional) MaxNotFoundRetries is how many times in a row an update that failed because the
+	// stack was not found in the backend is retried, before giving up. Defaults to 10.
+	MaxNotFoundRetries int64 `json:"maxNotFoundRetries,omitempty"`
+	// (optional) MaxConsecutiveFailures is how many times in a row processing the stack may fail
+	// before it is no longer retried. The stack is then marked as stalled until its spec is changed.
+	// If omitted, failures are retried indefinitely.
+	// +kubebuilder:validation:Minimum=1
+	MaxConsecutiveFailures int64 `json:"maxConsecutiveFailures,omitempty"`
+}
+
+// LogOptions say how Pulumi's output is rendered in the operator's logs.
+type LogOptions struct {
+	// (optional) Color says whether Pulumi's output is colorized: "never", the default, gives plain
+	// text, which suits log aggregation; "always" gives ANSI color codes; and "auto" leaves it to
+	// Pulumi.
+	// +kubebuilder:validation:Enum=never;always;auto
+	Color LogColor `json:"color,omitempty"`
+	// (optional) Diff says to render a detailed diff of the changes made by updates (and the
+	// previews run before them), as with `pulumi up --diff`.
+	Diff bool `json:"diff,omitempty"`
+}
+
+// LogColor says whether Pulumi's output is colorized.
+type LogColor string
+
+const (
+	// LogColorNever gives plain text.
+	LogColorNever = LogColor("never")
+	// LogColorAlways gives ANSI color codes.
+	LogColorAlways = LogColor("always")
+	// LogColorAuto leaves colorization to Pulumi.
+	LogColorAuto = LogColor("auto")
+)
+
+// ConfigMergeMode says how configuration given in a stack's spec combines with checked-in
+// configuration.
+type ConfigMergeMode string
+
+const (
+	// ConfigMergeModeMerge overrides checked-in configuration with that given in the spec.
+	ConfigMergeModeMerge = ConfigMergeMode("merge")
+	// ConfigMergeModeReplace disregards checked-in configuration, using only that given in the spec.
+	ConfigMergeModeReplace = ConfigMergeMode("replace")
+)
+
+// DeletionGuard limits the number of resources an update may delete, counting those it would
+// replace. If neither MaxDeletes nor MaxDeletePercent is given, no deletions are allowed. If both
+// are given, an update exceeding either is refused.
+type DeletionGuard struct {
+	// (optional) MaxDeletes is the most resources an update may delete.
+	MaxDeletes *int64 `json:"maxDeletes,omitempty"`
+	// (optional) MaxDeletePercent is the most resources an update may delete, as a percentage of
+	// the resources already in the stack.
+	MaxDeletePercent *int64 `json:"maxDeletePercent,omitempty"`
+	// (optional) OverrideCommit lets an update of the given commit (as a full hexadecimal commit
+	// SHA) go ahead regardless of the limits. Since it applies only to that commit, updates of
+	// later commits are guarded again.
+	OverrideCommit string `json:"overrideCommit,omitempty"`
+}
+
+// StateBackup says where and how often to back up a stack's state.
+type StateBackup struct {
+	// SecretName is the name of the Secret, in the stack's namespace, in which to store backups. It
+	// is created if it does not exist. Each backup is stored under a key giving the time it was
+	// taken (e.g., "checkpoint-20220601T120000Z.json"); one larger than 64KiB is gzip-compressed,
+	// and ".gz" appended to its key. Since a Secret is limited to 1MiB in total, the oldest backups
+	// are removed to make room for a new one, even if fewer than Retain are then kept.
+	SecretName string `json:"secretName"`
+	// (optional) IntervalSeconds is the interval between backups. Defaults to a day; the minimum
+	// interval supported is 60 seconds.
+	IntervalSeconds int64 `json:"intervalSeconds,omitempty"`
+	// (optional) Retain is the number of backups to keep, the oldest being removed first. Defaults
+	// to 3.
+	Retain int64 `json:"retain,omitempty"`
+}
+
+// StateExport says where to export a stack's last deployment.
+type StateExport struct {
+	// SecretName is the name of the Secret, in the stack's namespace, in which to store the
+	// export. It is created if it does not exist. A Secret is used rather than a ConfigMap since
+	// the state holds resource properties, and the ciphertext of secret values. The export is
+	// stored under the key "deployment.json", or, if it's larger than 64KiB, gzip-compressed under
+	// the key "deployment.json.gz".
+	SecretName string `json:"secretName"`
+}
+
+// StateRef refers to a stack's exported deployment.
+type StateRef struct {
+	// SecretName is the name of the Secret, in the stack's namespace, holding the export.
+	SecretName string `json:"secretName"`
+	// Key is the key of the export in the Secret.
+	Key string `json:"key"`
+}
+
+// ObjectMetadata gives labels and annotations for Kubernetes objects.
+type ObjectMetadata struct {
+	// (optional) Labels to add to the objects.
+	Labels map[string]string `json:"labels,omitempty"`
+	// (optional) Annotations to add to the objects.
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// MaintenanceWindow gives the times at which a stack may be updated.
+type MaintenanceWindow struct {
+	// (optional) Days lists the days of the week on which the window opens, as three-letter
+	// abbreviations (e.g., "Mon"). If empty, the window opens every day.
+	Days []string `json:"days,omitempty"`
+	// Start is the time of day at which the window opens, as "HH:MM".
+	// +kubebuilder:validation:Pattern=`^([01][0-9]|2[0-3]):[0-5][0-9]$`
+	Start string `json:"start"`
+	// End is the time of day at which the window closes, as "HH:MM". If it is not after Start, the
+	// window closes on the following day.
+	// +kubebuilder:validation:Pattern=`^([01][0-9]|2[0-3]):[0-5][0-9]$`
+	End string `json:"end"`
+	// (optional) Timezone is the name of the time zone for Days, Start and End, from the IANA time
+	// zone database (e.g., "Europe/London"). Defaults to UTC.
+	Timezone string `json:"timezone,omitempty"`
+}
+
+// RefreshSchedule says how often to refresh a stack.
+type RefreshSchedule struct {
+	// IntervalSeconds is the interval between refreshes. The minimum interval supported is 60
+	// seconds.
+	IntervalSeconds int64 `json:"intervalSeconds"`
+}
+
+// OutputSelector selects stack outputs by name, using glob patterns as understood by Go's
+// path.Match (e.g., "bucket*").
+type OutputSelector struct {
+	// (optional) Include lists patterns for the names of outputs to record. If empty, all outputs
+	// are included.
+	Include []string `json:"include,omitempty"`
+	// (optional) Exclude lists patterns for the names of outputs not to record. An output matching
+	// both Include and Exclude is excluded.
+	Exclude []string `json:"exclude,omitempty"`
+	// (optional) MaxSize limits the size in bytes of the selected outputs, serialized as JSON, so
+	// that large outputs don't make the Stack object too big to store. If the outputs are larger,
+	// the largest are omitted until they fit, and status.outputsTruncated is set.
+	// +kubebuilder:validation:Minimum=1
+	MaxSize int64 `json:"maxSize,omitempty"`
+}
+
+// StackReference identifies another stack read by the program, and any credentials needed to
+// read it.
+type StackReference struct {
+	// Name is the fully qualified name of the referenced stack (<org>/<project>/<stack>). On a
+	// self-managed backend, which has no organizations, the organization may be left out.
+	Name string `json:"name"`
+	// (optional) AccessToken is a Pulumi access token with permission to read the referenced stack.
+	AccessToken *ResourceRef `json:"accessToken,omitempty"`
+}
+
+// WorkspaceFile identifies the contents of a file to write into a stack's workspace. Exactly one of
+// ConfigMapRef and SecretRef must be given.
+type WorkspaceFile struct {
+	// Path is where to write the file, relative to the project directory. It must not lead outside
+	// the project directory.
+	Path string `json:"path"`
+	// (optional) ConfigMapRef is the name of a ConfigMap holding the contents of the file.
+	ConfigMapRef string `json:"configMapRef,omitempty"`
+	// (optional) SecretRef is the name of a Secret holding the contents of the file.
+	SecretRef string `json:"secretRef,omitempty"`
+	// Key is the key within the ConfigMap or Secret that holds the contents of the file.
+	Key string `json:"key"`
+}
+
+// ResourceDefaults are defaults for the resources of a stack, given to it as configuration.
+type ResourceDefaults struct {
+	// (optional) DisableDefaultProviders lists the packages (e.g., "aws", or "*" for all) whose
+	// default providers may not be used, so that each resource must be given an explicit provider.
+	// It is written as "pulumi:disable-default-providers", and enforced by the Pulumi engine.
+	DisableDefaultProviders []string `json:"disableDefaultProviders,omitempty"`
+	// (optional) Protect says resources should be created with the protect option. It is written
+	// as "resourceDefaults:protect", and must be applied by the program.
+	Protect bool `json:"protect,omitempty"`
+	// (optional) RetainOnDelete says resources should be created with the retainOnDelete option. It
+	// is written as "resourceDefaults:retainOnDelete", and must be applied by the program.
+	RetainOnDelete bool `json:"retainOnDelete,omitempty"`
+	// (optional) AdditionalTags are tags to add to resources which support them. They are written as
+	// a JSON object to "resourceDefaults:additionalTags", and must be applied by the program.
+	AdditionalTags map[string]string `json:"additionalTags,omitempty"`
+}
+
+// SecretsProviderType is the kind of key used by a secrets provider.
+// +kubebuilder:validation:Enum=awskms;azurekeyvault;gcpkms;hashivault
+type SecretsProviderType string
+
+const (
+	// SecretsProviderAWSKMS is a key in AWS Key Management Service.
+	SecretsProviderAWSKMS SecretsProviderType = "awskms"
+	// SecretsProviderAzureKeyVault is a key in Azure Key Vault.
+	SecretsProviderAzureKeyVault SecretsProviderType = "azurekeyvault"
+	// SecretsProviderGCPKMS is a key in Google Cloud Key Management Service.
+	SecretsProviderGCPKMS SecretsProviderType = "gcpkms"
+	// SecretsProviderHashiVault is a key for the HashiCorp Vault transit secrets engine.
+	SecretsProviderHashiVault SecretsProviderType = "hashivault"
+)
+
+// SecretsProviderKey gives the key for a cloud secrets provider, from which the provider URL is
+// composed.
+type SecretsProviderKey struct {
+	// Type is the kind of key.
+	Type SecretsProviderType `json:"type"`
+	// KeyID identifies the key, in the form the provider expects:
+	//   - awskms:        the key ID, ARN, or alias (e.g., "alias/mykey")
+	//   - azurekeyvault: the vault and key name (e.g., "acmecorpvault.vault.azure.net/keys/mykeyname")
+	//   - gcpkms:        the key resource name (e.g., "projects/P/locations/L/keyRings/R/cryptoKeys/K")
+	//   - hashivault:    the name of the transit key
+	KeyID string `json:"keyId"`
+	// (optional) Region is the region of the key. It is required for awskms, and not used otherwise.
+	Region string `json:"region,omitempty"`
+	// (optional) Params are further query parameters for the provider URL, e.g., "awssdk" for awskms.
+	Params map[string]string `json:"params,omitempty"`
+}
+
 // GitAuthConfig specifies git authentication configuration options.
 // There are 3 different authentication options:
 //   * Personal access token
@@ -163,12 +747,85 @@ type BasicAuth struct {
 	Password ResourceRef `json:"password"`
 }
 
+// SourcePreprocess gives a command to transform the project source before it is used.
+type SourcePreprocess struct {
+	// Command is the program to run, followed by its arguments, e.g., ["kustomize", "build",
+	// "config"]. It is run without a shell, with the stack's environment, and must be installed in
+	// the operator image.
+	// +kubebuilder:validation:MinItems=1
+	Command []string `json:"command"`
+	// (optional) OutputPath is the file to which to write what the command prints, relative to the
+	// project directory, e.g., "Pulumi.dev.yaml"; any file already there is replaced. If empty,
+	// the output is discarded, and the command is expected to write any files itself.
+	OutputPath string `json:"outputPath,omitempty"`
+	// (optional) TimeoutSeconds is how long the command may run before it is stopped and treated
+	// as failed. The default is 300 seconds.
+	// +kubebuilder:validation:Minimum=1
+	TimeoutSeconds int64 `json:"timeoutSeconds,omitempty"`
+}
+
+// PluginSpec identifies a resource plugin to install.
+type PluginSpec struct {
+	// Name is the name of the plugin, e.g., "aws".
+	Name string `json:"name"`
+	// Version is the version of the plugin, e.g., "v5.10.0".
+	Version string `json:"version"`
+	// (optional) Server is the http(s) URL of the server from which to download the plugin. If
+	// empty, the spec's PluginDownloadURL is used, if given.
+	Server string `json:"server,omitempty"`
+}
+
+// PosixUser identifies a POSIX user and group by number.
+type PosixUser struct {
+	// UID is the user ID.
+	// +kubebuilder:validation:Minimum=0
+	UID int64 `json:"uid"`
+	// GID is the group ID.
+	// +kubebuilder:validation:Minimum=0
+	GID int64 `json:"gid"`
+}
+
+// GitCommitter gives the identity recorded in git commits. Both Name and Email are required.
+type GitCommitter struct {
+	// Name is the name of the author and committer, e.g., "Pulumi Operator".
+	Name string `json:"name"`
+	// Email is the email address of the author and committer, e.g., "operator@example.com".
+	Email string `json:"email"`
+}
+
+// CloudCredentialsKind says which cloud's convention a credentials endpoint follows.
+type CloudCredentialsKind string
+
+const (
+	// AWSCredentialsEndpoint is an endpoint following the convention of ECS container
+	// credentials.
+	AWSCredentialsEndpoint CloudCredentialsKind = "aws"
+	// GCPCredentialsEndpoint is an endpoint emulating the GCE metadata server.
+	GCPCredentialsEndpoint CloudCredentialsKind = "gcp"
+)
+
+// CredentialsEndpoint is a local endpoint from which cloud providers fetch credentials.
+type CredentialsEndpoint struct {
+	// URL is the address of the endpoint, e.g., "http://127.0.0.1:9911/creds". It must be on a
+	// loopback address (localhost, 127.0.0.0/8 or ::1), so that credentials are never fetched
+	// from, nor requests for them sent to, anywhere off the pod.
+	URL string `json:"url"`
+	// (optional) Cloud says which convention the endpoint follows, and so which environment
+	// variables are set: "aws", the default, sets AWS_CONTAINER_CREDENTIALS_FULL_URI to the URL;
+	// "gcp" sets GCE_METADATA_HOST (and GCE_METADATA_IP) to its host and port.
+	// +kubebuilder:validation:Enum=aws;gcp
+	Cloud CloudCredentialsKind `json:"cloud,omitempty"`
+	// (optional) AuthorizationToken refers to a token to present to the endpoint; for "aws", it
+	// sets AWS_CONTAINER_AUTHORIZATION_TOKEN.
+	AuthorizationToken *ResourceRef `json:"authorizationToken,omitempty"`
+}
+
 // ResourceRef identifies a resource from which information can be loaded.
-// Environment variables, files on the filesystem, Kubernetes secrets and literal
-// strings are currently supported.
+// Environment variables, files on the filesystem, Kubernetes secrets, literal
+// strings and fields of the Stack object are currently supported.
 type ResourceRef struct {
 	// SelectorType is required and signifies the type of selector. Must be one of:
-	// Env, FS, Secret, Literal
+	// Env, FS, Secret, Literal, Downward
 	SelectorType     ResourceSelectorType `json:"type"`
 	ResourceSelector `json:",inline"`
 }
@@ -235,10 +892,12 @@ const (
 	ResourceSelectorSecret = ResourceSelectorType("Secret")
 	// ResourceSelectorLiteral indicates the resource is a literal
 	ResourceSelectorLiteral = ResourceSelectorType("Literal")
+	// ResourceSelectorDownward indicates the resource is a field of the Stack object
+	ResourceSelectorDownward = ResourceSelectorType("Downward")
 )
 
 // ResourceSelector is a union over resource selectors supporting one of
-// filesystem, environment variable, Kubernetes Secret and literal values.
+// filesystem, environment variable, Kubernetes Secret, literal and downward values.
 type ResourceSelector struct {
 	// FileSystem selects a file on the operator's file system
 	FileSystem *FSSelector `json:"filesystem,omitempty"`
@@ -248,11 +907,16 @@ type ResourceSelector struct {
 	SecretRef *SecretSelector `json:"secret,omitempty"`
 	// LiteralRef refers to a literal value
 	LiteralRef *LiteralRef `json:"literal,omitempty"`
+	// Downward refers to a field of the Stack object, or of the operator's configuration
+	Downward *DownwardSelector `json:"downward,omitempty"`
 }
 
 // FSSelector identifies the path to load information from.
 type FSSelector struct {
-	// Path on the filesystem to use to load information from.
+	// Path on the filesystem to use to load information from. The file is read each time the
+	// value is needed, so it can be a secret mounted into the operator's pod by the Secrets Store
+	// CSI driver (e.g., "/mnt/secrets-store/db-password"), and rotated values are picked up. Used in
+	// SecretRefs, the value is set as secret configuration, and is not logged.
 	Path string `json:"path"`
 }
 
@@ -278,6 +942,15 @@ type LiteralRef struct {
 	Value string `json:"value"`
 }
 
+// DownwardSelector identifies a field to load information from, in the manner of the Kubernetes
+// downward API.
+type DownwardSelector struct {
+	// FieldPath is the field to use: one of metadata.name, metadata.namespace, metadata.uid,
+	// metadata.labels['<key>'] and metadata.annotations['<key>'] of the Stack object, or clusterName,
+	// which is given to the operator by the environment variable CLUSTER_NAME.
+	FieldPath string `json:"fieldPath"`
+}
+
 // StackStatus defines the observed state of Stack
 type StackStatus struct {
 	// Outputs contains the exported stack output variables resulting from a deployment.
@@ -293,18 +966,84 @@ type StackOutputs map[string]apiextensionsv1.JSON
 
 // StackUpdateState is the status of a stack update
 type StackUpdateState struct {
+	// Kind is the kind of operation last run on the stack: `update`, `refresh`, `destroy` or
+	// `preview`. A refresh before an update is superseded by the update.
+	Kind StackOperationKind `json:"kind,omitempty"`
 	// State is the state of the stack update - one of `succeeded` or `failed`
 	State StackUpdateStateMessage `json:"state,omitempty"`
-	// Last commit attempted
+	// ProjectRepo is the URL the project repository was cloned from for the last attempt, which
+	// is one of the mirrors if the primary repository couldn't be cloned.
+	ProjectRepo string `json:"projectRepo,omitempty"`
+	// GitAuthMethod is the git authentication option with which the project repository was
+	// cloned for the last attempt: "gitAuth" for that given by GitAuth (or GitAuthSecret), or
+	// "gitAuthFallbacks[N]" for one of the fallbacks.
+	GitAuthMethod string `json:"gitAuthMethod,omitempty"`
+	// PulumiVersion is the version of the Pulumi CLI, and so of the engine, used for the last
+	// attempt, e.g., "3.39.3".
+	PulumiVersion string `json:"pulumiVersion,omitempty"`
+	// Progress is the rough percentage of resource operations finished, while an update is
+	// running. It's estimated from the resources already in the stack, and cleared when the update
+	// finishes.
+	Progress int64 `json:"progress,omitempty"`
+	// Last commit attempted, as a full hexadecimal commit SHA
 	LastAttemptedCommit string `json:"lastAttemptedCommit,omitempty"`
-	// Last commit successfully applied
+	// Last commit successfully applied, as a full hexadecimal commit SHA
 	LastSuccessfulCommit string `json:"lastSuccessfulCommit,omitempty"`
 	// Permalink is the Pulumi Console URL of the stack operation.
 	Permalink Permalink `json:"permalink,omitempty"`
 	// LastResyncTime contains a timestamp for the last time a resync of the stack took place.
 	LastResyncTime metav1.Time `json:"lastResyncTime,omitempty"`
+	// StartedAt is the time at which the last update started.
+	StartedAt metav1.Time `json:"startedAt,omitempty"`
+	// FinishedAt is the time at which the last update finished, whether or not it succeeded.
+	FinishedAt metav1.Time `json:"finishedAt,omitempty"`
+	// DurationSeconds is the wall-clock time the last update took, in seconds.
+	DurationSeconds int64 `json:"durationSeconds,omitempty"`
+	// Changed records whether the last successful update changed any resources. It is false when
+	// the update found nothing to do.
+	Changed bool `json:"changed,omitempty"`
+	// ResourcesDeleted is the number of resources deleted by the last successful update.
+	ResourcesDeleted int64 `json:"resourcesDeleted,omitempty"`
+	// ConfigChanges lists the configuration keys that the last successful update added, removed
+	// or changed, compared to the previous successful update. Only keys are recorded, never
+	// values, so that secret values are not revealed.
+	ConfigChanges *ConfigChanges `json:"configChanges,omitempty"`
+	// StateRef refers to the stack's last deployment, as exported after the last successful
+	// update or refresh, if spec.stateExport is given.
+	StateRef *StateRef `json:"stateRef,omitempty"`
+	// NotFoundRetries is the number of attempts in a row to update the stack that have failed
+	// because the stack was not found in the backend.
+	NotFoundRetries int64 `json:"notFoundRetries,omitempty"`
+	// ConsecutiveFailures is the number of attempts to process the stack that have failed since
+	// the last success. It determines the delay before the next retry.
+	ConsecutiveFailures int64 `json:"consecutiveFailures,omitempty"`
+}
+
+// ConfigChanges lists the keys of the configuration of a stack which differ between two updates.
+type ConfigChanges struct {
+	// Added lists the keys set for the later update, and not the earlier.
+	Added []string `json:"added,omitempty"`
+	// Removed lists the keys set for the earlier update, and not the later.
+	Removed []string `json:"removed,omitempty"`
+	// Changed lists the keys set for both updates, with a different value or secretness.
+	Changed []string `json:"changed,omitempty"`
 }
 
+// StackOperationKind is the kind of Pulumi operation run on a stack.
+// +kubebuilder:validation:Enum=update;refresh;destroy;preview
+type StackOperationKind string
+
+const (
+	// StackOperationUpdate is an update of the stack, i.e., `pulumi up`.
+	StackOperationUpdate StackOperationKind = "update"
+	// StackOperationRefresh is a refresh of the stack's state, i.e., `pulumi refresh`.
+	StackOperationRefresh StackOperationKind = "refresh"
+	// StackOperationDestroy is the destruction of the stack's resources, i.e., `pulumi destroy`.
+	StackOperationDestroy StackOperationKind = "destroy"
+	// StackOperationPreview is a preview of an update, i.e., `pulumi preview`.
+	StackOperationPreview StackOperationKind = "preview"
+)
+
 // StackUpdateStatus is the status code for the result of a Stack Update run.
 type StackUpdateStatus int
 
@@ -322,6 +1061,12 @@ const (
 	// StackNotFound indicates that the stack update failed to complete due
 	// to stack not being found (HTTP 404) in the Pulumi Service.
 	StackNotFound StackUpdateStatus = 4
+	// StackBackendUnavailable indicates that the stack update failed to complete because
+	// the Pulumi backend could not be reached.
+	StackBackendUnavailable StackUpdateStatus = 5
+	// StackUpdateTimedOut indicates that the stack update was cancelled because it ran for
+	// longer than allowed.
+	StackUpdateTimedOut StackUpdateStatus = 6
 )
 
 type StackUpdateStateMessage string
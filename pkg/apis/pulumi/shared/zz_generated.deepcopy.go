@@ -24,6 +24,96 @@ func (in *BasicAuth) DeepCopy() *BasicAuth {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConfigChanges) DeepCopyInto(out *ConfigChanges) {
+	*out = *in
+	if in.Added != nil {
+		in, out := &in.Added, &out.Added
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Removed != nil {
+		in, out := &in.Removed, &out.Removed
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Changed != nil {
+		in, out := &in.Changed, &out.Changed
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConfigChanges.
+func (in *ConfigChanges) DeepCopy() *ConfigChanges {
+	if in == nil {
+		return nil
+	}
+	out := new(ConfigChanges)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CredentialsEndpoint) DeepCopyInto(out *CredentialsEndpoint) {
+	*out = *in
+	if in.AuthorizationToken != nil {
+		in, out := &in.AuthorizationToken, &out.AuthorizationToken
+		*out = new(ResourceRef)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CredentialsEndpoint.
+func (in *CredentialsEndpoint) DeepCopy() *CredentialsEndpoint {
+	if in == nil {
+		return nil
+	}
+	out := new(CredentialsEndpoint)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DeletionGuard) DeepCopyInto(out *DeletionGuard) {
+	*out = *in
+	if in.MaxDeletes != nil {
+		in, out := &in.MaxDeletes, &out.MaxDeletes
+		*out = new(int64)
+		**out = **in
+	}
+	if in.MaxDeletePercent != nil {
+		in, out := &in.MaxDeletePercent, &out.MaxDeletePercent
+		*out = new(int64)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DeletionGuard.
+func (in *DeletionGuard) DeepCopy() *DeletionGuard {
+	if in == nil {
+		return nil
+	}
+	out := new(DeletionGuard)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DownwardSelector) DeepCopyInto(out *DownwardSelector) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DownwardSelector.
+func (in *DownwardSelector) DeepCopy() *DownwardSelector {
+	if in == nil {
+		return nil
+	}
+	out := new(DownwardSelector)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *EnvSelector) DeepCopyInto(out *EnvSelector) {
 	*out = *in
@@ -84,6 +174,21 @@ func (in *GitAuthConfig) DeepCopy() *GitAuthConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GitCommitter) DeepCopyInto(out *GitCommitter) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GitCommitter.
+func (in *GitCommitter) DeepCopy() *GitCommitter {
+	if in == nil {
+		return nil
+	}
+	out := new(GitCommitter)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *LiteralRef) DeepCopyInto(out *LiteralRef) {
 	*out = *in
@@ -99,6 +204,196 @@ func (in *LiteralRef) DeepCopy() *LiteralRef {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LogOptions) DeepCopyInto(out *LogOptions) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LogOptions.
+func (in *LogOptions) DeepCopy() *LogOptions {
+	if in == nil {
+		return nil
+	}
+	out := new(LogOptions)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MaintenanceWindow) DeepCopyInto(out *MaintenanceWindow) {
+	*out = *in
+	if in.Days != nil {
+		in, out := &in.Days, &out.Days
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MaintenanceWindow.
+func (in *MaintenanceWindow) DeepCopy() *MaintenanceWindow {
+	if in == nil {
+		return nil
+	}
+	out := new(MaintenanceWindow)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MatrixVariant) DeepCopyInto(out *MatrixVariant) {
+	*out = *in
+	if in.Config != nil {
+		in, out := &in.Config, &out.Config
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.SecretRefs != nil {
+		in, out := &in.SecretRefs, &out.SecretRefs
+		*out = make(map[string]ResourceRef, len(*in))
+		for key, val := range *in {
+			(*out)[key] = *val.DeepCopy()
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MatrixVariant.
+func (in *MatrixVariant) DeepCopy() *MatrixVariant {
+	if in == nil {
+		return nil
+	}
+	out := new(MatrixVariant)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ObjectMetadata) DeepCopyInto(out *ObjectMetadata) {
+	*out = *in
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Annotations != nil {
+		in, out := &in.Annotations, &out.Annotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ObjectMetadata.
+func (in *ObjectMetadata) DeepCopy() *ObjectMetadata {
+	if in == nil {
+		return nil
+	}
+	out := new(ObjectMetadata)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OutputSelector) DeepCopyInto(out *OutputSelector) {
+	*out = *in
+	if in.Include != nil {
+		in, out := &in.Include, &out.Include
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Exclude != nil {
+		in, out := &in.Exclude, &out.Exclude
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OutputSelector.
+func (in *OutputSelector) DeepCopy() *OutputSelector {
+	if in == nil {
+		return nil
+	}
+	out := new(OutputSelector)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PluginSpec) DeepCopyInto(out *PluginSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PluginSpec.
+func (in *PluginSpec) DeepCopy() *PluginSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PluginSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PosixUser) DeepCopyInto(out *PosixUser) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PosixUser.
+func (in *PosixUser) DeepCopy() *PosixUser {
+	if in == nil {
+		return nil
+	}
+	out := new(PosixUser)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RefreshSchedule) DeepCopyInto(out *RefreshSchedule) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RefreshSchedule.
+func (in *RefreshSchedule) DeepCopy() *RefreshSchedule {
+	if in == nil {
+		return nil
+	}
+	out := new(RefreshSchedule)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ResourceDefaults) DeepCopyInto(out *ResourceDefaults) {
+	*out = *in
+	if in.DisableDefaultProviders != nil {
+		in, out := &in.DisableDefaultProviders, &out.DisableDefaultProviders
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AdditionalTags != nil {
+		in, out := &in.AdditionalTags, &out.AdditionalTags
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ResourceDefaults.
+func (in *ResourceDefaults) DeepCopy() *ResourceDefaults {
+	if in == nil {
+		return nil
+	}
+	out := new(ResourceDefaults)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ResourceRef) DeepCopyInto(out *ResourceRef) {
 	*out = *in
@@ -138,6 +433,11 @@ func (in *ResourceSelector) DeepCopyInto(out *ResourceSelector) {
 		*out = new(LiteralRef)
 		**out = **in
 	}
+	if in.Downward != nil {
+		in, out := &in.Downward, &out.Downward
+		*out = new(DownwardSelector)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ResourceSelector.
@@ -150,6 +450,21 @@ func (in *ResourceSelector) DeepCopy() *ResourceSelector {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RetryPolicy) DeepCopyInto(out *RetryPolicy) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RetryPolicy.
+func (in *RetryPolicy) DeepCopy() *RetryPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(RetryPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SSHAuth) DeepCopyInto(out *SSHAuth) {
 	*out = *in
@@ -186,6 +501,48 @@ func (in *SecretSelector) DeepCopy() *SecretSelector {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SecretsProviderKey) DeepCopyInto(out *SecretsProviderKey) {
+	*out = *in
+	if in.Params != nil {
+		in, out := &in.Params, &out.Params
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretsProviderKey.
+func (in *SecretsProviderKey) DeepCopy() *SecretsProviderKey {
+	if in == nil {
+		return nil
+	}
+	out := new(SecretsProviderKey)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SourcePreprocess) DeepCopyInto(out *SourcePreprocess) {
+	*out = *in
+	if in.Command != nil {
+		in, out := &in.Command, &out.Command
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SourcePreprocess.
+func (in *SourcePreprocess) DeepCopy() *SourcePreprocess {
+	if in == nil {
+		return nil
+	}
+	out := new(SourcePreprocess)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in StackOutputs) DeepCopyInto(out *StackOutputs) {
 	{
@@ -207,9 +564,36 @@ func (in StackOutputs) DeepCopy() StackOutputs {
 	return *out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StackReference) DeepCopyInto(out *StackReference) {
+	*out = *in
+	if in.AccessToken != nil {
+		in, out := &in.AccessToken, &out.AccessToken
+		*out = new(ResourceRef)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StackReference.
+func (in *StackReference) DeepCopy() *StackReference {
+	if in == nil {
+		return nil
+	}
+	out := new(StackReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *StackSpec) DeepCopyInto(out *StackSpec) {
 	*out = *in
+	if in.Env != nil {
+		in, out := &in.Env, &out.Env
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
 	if in.Envs != nil {
 		in, out := &in.Envs, &out.Envs
 		*out = make([]string, len(*in))
@@ -227,6 +611,28 @@ func (in *StackSpec) DeepCopyInto(out *StackSpec) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.CredentialsEndpoint != nil {
+		in, out := &in.CredentialsEndpoint, &out.CredentialsEndpoint
+		*out = new(CredentialsEndpoint)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.LogOptions != nil {
+		in, out := &in.LogOptions, &out.LogOptions
+		*out = new(LogOptions)
+		**out = **in
+	}
+	if in.Kubeconfig != nil {
+		in, out := &in.Kubeconfig, &out.Kubeconfig
+		*out = new(ResourceRef)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ProviderCACerts != nil {
+		in, out := &in.ProviderCACerts, &out.ProviderCACerts
+		*out = make([]ResourceRef, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	if in.Config != nil {
 		in, out := &in.Config, &out.Config
 		*out = make(map[string]string, len(*in))
@@ -234,6 +640,35 @@ func (in *StackSpec) DeepCopyInto(out *StackSpec) {
 			(*out)[key] = val
 		}
 	}
+	if in.ProviderDefaults != nil {
+		in, out := &in.ProviderDefaults, &out.ProviderDefaults
+		*out = make(map[string]map[string]string, len(*in))
+		for key, val := range *in {
+			var outVal map[string]string
+			if val == nil {
+				(*out)[key] = nil
+			} else {
+				in, out := &val, &outVal
+				*out = make(map[string]string, len(*in))
+				for key, val := range *in {
+					(*out)[key] = val
+				}
+			}
+			(*out)[key] = outVal
+		}
+	}
+	if in.ResourceDefaults != nil {
+		in, out := &in.ResourceDefaults, &out.ResourceDefaults
+		*out = new(ResourceDefaults)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ConfigRefs != nil {
+		in, out := &in.ConfigRefs, &out.ConfigRefs
+		*out = make(map[string]ResourceRef, len(*in))
+		for key, val := range *in {
+			(*out)[key] = *val.DeepCopy()
+		}
+	}
 	if in.Secrets != nil {
 		in, out := &in.Secrets, &out.Secrets
 		*out = make(map[string]string, len(*in))
@@ -248,11 +683,162 @@ func (in *StackSpec) DeepCopyInto(out *StackSpec) {
 			(*out)[key] = *val.DeepCopy()
 		}
 	}
+	if in.Matrix != nil {
+		in, out := &in.Matrix, &out.Matrix
+		*out = make([]MatrixVariant, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.SecretsProviderRef != nil {
+		in, out := &in.SecretsProviderRef, &out.SecretsProviderRef
+		*out = new(ResourceRef)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.SecretsProviderKey != nil {
+		in, out := &in.SecretsProviderKey, &out.SecretsProviderKey
+		*out = new(SecretsProviderKey)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.PassphraseRef != nil {
+		in, out := &in.PassphraseRef, &out.PassphraseRef
+		*out = new(ResourceRef)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.VaultAddressRef != nil {
+		in, out := &in.VaultAddressRef, &out.VaultAddressRef
+		*out = new(ResourceRef)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.VaultTokenRef != nil {
+		in, out := &in.VaultTokenRef, &out.VaultTokenRef
+		*out = new(ResourceRef)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.StackReferences != nil {
+		in, out := &in.StackReferences, &out.StackReferences
+		*out = make([]StackReference, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Outputs != nil {
+		in, out := &in.Outputs, &out.Outputs
+		*out = new(OutputSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.RequiredOutputs != nil {
+		in, out := &in.RequiredOutputs, &out.RequiredOutputs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ProjectRepoMirrors != nil {
+		in, out := &in.ProjectRepoMirrors, &out.ProjectRepoMirrors
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	if in.GitAuth != nil {
 		in, out := &in.GitAuth, &out.GitAuth
 		*out = new(GitAuthConfig)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.GitAuthFallbacks != nil {
+		in, out := &in.GitAuthFallbacks, &out.GitAuthFallbacks
+		*out = make([]GitAuthConfig, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.SparseCheckoutPaths != nil {
+		in, out := &in.SparseCheckoutPaths, &out.SparseCheckoutPaths
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.GitCommitter != nil {
+		in, out := &in.GitCommitter, &out.GitCommitter
+		*out = new(GitCommitter)
+		**out = **in
+	}
+	if in.WorkspaceFiles != nil {
+		in, out := &in.WorkspaceFiles, &out.WorkspaceFiles
+		*out = make([]WorkspaceFile, len(*in))
+		copy(*out, *in)
+	}
+	if in.SourcePreprocess != nil {
+		in, out := &in.SourcePreprocess, &out.SourcePreprocess
+		*out = new(SourcePreprocess)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.RunAsUser != nil {
+		in, out := &in.RunAsUser, &out.RunAsUser
+		*out = new(PosixUser)
+		**out = **in
+	}
+	if in.Plugins != nil {
+		in, out := &in.Plugins, &out.Plugins
+		*out = make([]PluginSpec, len(*in))
+		copy(*out, *in)
+	}
+	if in.GitWebhookSecret != nil {
+		in, out := &in.GitWebhookSecret, &out.GitWebhookSecret
+		*out = new(ResourceRef)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Paths != nil {
+		in, out := &in.Paths, &out.Paths
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.RefreshTargets != nil {
+		in, out := &in.RefreshTargets, &out.RefreshTargets
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.RefreshIgnore != nil {
+		in, out := &in.RefreshIgnore, &out.RefreshIgnore
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.RefreshSchedule != nil {
+		in, out := &in.RefreshSchedule, &out.RefreshSchedule
+		*out = new(RefreshSchedule)
+		**out = **in
+	}
+	if in.StateBackup != nil {
+		in, out := &in.StateBackup, &out.StateBackup
+		*out = new(StateBackup)
+		**out = **in
+	}
+	if in.StateExport != nil {
+		in, out := &in.StateExport, &out.StateExport
+		*out = new(StateExport)
+		**out = **in
+	}
+	if in.MaintenanceWindow != nil {
+		in, out := &in.MaintenanceWindow, &out.MaintenanceWindow
+		*out = new(MaintenanceWindow)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ObjectMeta != nil {
+		in, out := &in.ObjectMeta, &out.ObjectMeta
+		*out = new(ObjectMetadata)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.DeletionGuard != nil {
+		in, out := &in.DeletionGuard, &out.DeletionGuard
+		*out = new(DeletionGuard)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.FinalizeTargets != nil {
+		in, out := &in.FinalizeTargets, &out.FinalizeTargets
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.RetryPolicy != nil {
+		in, out := &in.RetryPolicy, &out.RetryPolicy
+		*out = new(RetryPolicy)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StackSpec.
@@ -296,6 +882,18 @@ func (in *StackStatus) DeepCopy() *StackStatus {
 func (in *StackUpdateState) DeepCopyInto(out *StackUpdateState) {
 	*out = *in
 	in.LastResyncTime.DeepCopyInto(&out.LastResyncTime)
+	in.StartedAt.DeepCopyInto(&out.StartedAt)
+	in.FinishedAt.DeepCopyInto(&out.FinishedAt)
+	if in.ConfigChanges != nil {
+		in, out := &in.ConfigChanges, &out.ConfigChanges
+		*out = new(ConfigChanges)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.StateRef != nil {
+		in, out := &in.StateRef, &out.StateRef
+		*out = new(StateRef)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StackUpdateState.
@@ -307,3 +905,63 @@ func (in *StackUpdateState) DeepCopy() *StackUpdateState {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StateBackup) DeepCopyInto(out *StateBackup) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StateBackup.
+func (in *StateBackup) DeepCopy() *StateBackup {
+	if in == nil {
+		return nil
+	}
+	out := new(StateBackup)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StateExport) DeepCopyInto(out *StateExport) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StateExport.
+func (in *StateExport) DeepCopy() *StateExport {
+	if in == nil {
+		return nil
+	}
+	out := new(StateExport)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StateRef) DeepCopyInto(out *StateRef) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StateRef.
+func (in *StateRef) DeepCopy() *StateRef {
+	if in == nil {
+		return nil
+	}
+	out := new(StateRef)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WorkspaceFile) DeepCopyInto(out *WorkspaceFile) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WorkspaceFile.
+func (in *WorkspaceFile) DeepCopy() *WorkspaceFile {
+	if in == nil {
+		return nil
+	}
+	out := new(WorkspaceFile)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -36,12 +36,38 @@ const (
 	StackUpdateFailure          StackEventReason = "StackUpdateFailure"
 	StackUpdateConflictDetected StackEventReason = "StackUpdateConflictDetected"
 	StackOutputRetrievalFailure StackEventReason = "StackOutputRetrievalFailure"
+	StackBackendUnavailable     StackEventReason = "StackBackendUnavailable"
+	StackRefreshFailure         StackEventReason = "StackRefreshFailure"
+	StackDeletionGuardTripped   StackEventReason = "StackDeletionGuardTripped"
+	StackNotConverged           StackEventReason = "StackNotConverged"
+	StackStateBackupFailure     StackEventReason = "StackStateBackupFailure"
+	StackStateExportFailure     StackEventReason = "StackStateExportFailure"
+	StackLockBroken             StackEventReason = "StackLockBroken"
+	StackOutputsTruncated       StackEventReason = "StackOutputsTruncated"
+	StackProjectNotFound        StackEventReason = "StackProjectNotFound"
+	StackDestroyWithoutSource   StackEventReason = "StackDestroyWithoutSource"
+	StackNotFoundGaveUp         StackEventReason = "StackNotFoundGaveUp"
+	StackGitTimeout             StackEventReason = "StackGitTimeout"
+	StackAuthMissing            StackEventReason = "StackAuthMissing"
+	StackUpdateTimeout          StackEventReason = "StackUpdateTimeout"
+	StackStalled                StackEventReason = "StackStalled"
+	StackPolicyViolation        StackEventReason = "StackPolicyViolation"
+	StackPreprocessFailure      StackEventReason = "StackPreprocessFailure"
+	StackInsufficientDisk       StackEventReason = "StackInsufficientDisk"
+	StackPluginInstallFailure   StackEventReason = "StackPluginInstallFailure"
+	StackMissingOutput          StackEventReason = "StackMissingOutput"
 
 	// Normals
 
-	StackUpdateDetected   StackEventReason = "StackUpdateDetected"
-	StackNotFound         StackEventReason = "StackNotFound"
-	StackUpdateSuccessful StackEventReason = "StackCreated"
+	StackUpdateDetected         StackEventReason = "StackUpdateDetected"
+	StackNotFound               StackEventReason = "StackNotFound"
+	StackUpdateSuccessful       StackEventReason = "StackCreated"
+	StackUpdateNoChanges        StackEventReason = "StackUnchanged"
+	StackRefreshSuccessful      StackEventReason = "StackRefreshed"
+	StackSkippedUnrelatedChange StackEventReason = "StackSkippedUnrelatedChange"
+	StackDeferredOutsideWindow  StackEventReason = "StackDeferredOutsideWindow"
+	StackConfigured             StackEventReason = "StackConfigured"
+	StackCreatedInBackend       StackEventReason = "StackCreatedInBackend"
 )
 
 func StackConfigInvalidEvent() StackEvent {
@@ -68,6 +94,86 @@ func StackOutputRetrievalFailureEvent() StackEvent {
 	return StackEvent{eventType: EventTypeWarning, reason: StackOutputRetrievalFailure}
 }
 
+func StackBackendUnavailableEvent() StackEvent {
+	return StackEvent{eventType: EventTypeWarning, reason: StackBackendUnavailable}
+}
+
+func StackRefreshFailureEvent() StackEvent {
+	return StackEvent{eventType: EventTypeWarning, reason: StackRefreshFailure}
+}
+
+func StackDeletionGuardTrippedEvent() StackEvent {
+	return StackEvent{eventType: EventTypeWarning, reason: StackDeletionGuardTripped}
+}
+
+func StackNotConvergedEvent() StackEvent {
+	return StackEvent{eventType: EventTypeWarning, reason: StackNotConverged}
+}
+
+func StackStateBackupFailureEvent() StackEvent {
+	return StackEvent{eventType: EventTypeWarning, reason: StackStateBackupFailure}
+}
+
+func StackStateExportFailureEvent() StackEvent {
+	return StackEvent{eventType: EventTypeWarning, reason: StackStateExportFailure}
+}
+
+func StackLockBrokenEvent() StackEvent {
+	return StackEvent{eventType: EventTypeWarning, reason: StackLockBroken}
+}
+
+func StackOutputsTruncatedEvent() StackEvent {
+	return StackEvent{eventType: EventTypeWarning, reason: StackOutputsTruncated}
+}
+
+func StackProjectNotFoundEvent() StackEvent {
+	return StackEvent{eventType: EventTypeWarning, reason: StackProjectNotFound}
+}
+
+func StackDestroyWithoutSourceEvent() StackEvent {
+	return StackEvent{eventType: EventTypeWarning, reason: StackDestroyWithoutSource}
+}
+
+func StackNotFoundGaveUpEvent() StackEvent {
+	return StackEvent{eventType: EventTypeWarning, reason: StackNotFoundGaveUp}
+}
+
+func StackGitTimeoutEvent() StackEvent {
+	return StackEvent{eventType: EventTypeWarning, reason: StackGitTimeout}
+}
+
+func StackAuthMissingEvent() StackEvent {
+	return StackEvent{eventType: EventTypeWarning, reason: StackAuthMissing}
+}
+
+func StackUpdateTimeoutEvent() StackEvent {
+	return StackEvent{eventType: EventTypeWarning, reason: StackUpdateTimeout}
+}
+
+func StackStalledEvent() StackEvent {
+	return StackEvent{eventType: EventTypeWarning, reason: StackStalled}
+}
+
+func StackPolicyViolationEvent() StackEvent {
+	return StackEvent{eventType: EventTypeWarning, reason: StackPolicyViolation}
+}
+
+func StackPreprocessFailureEvent() StackEvent {
+	return StackEvent{eventType: EventTypeWarning, reason: StackPreprocessFailure}
+}
+
+func StackInsufficientDiskEvent() StackEvent {
+	return StackEvent{eventType: EventTypeWarning, reason: StackInsufficientDisk}
+}
+
+func StackPluginInstallFailureEvent() StackEvent {
+	return StackEvent{eventType: EventTypeWarning, reason: StackPluginInstallFailure}
+}
+
+func StackMissingOutputEvent() StackEvent {
+	return StackEvent{eventType: EventTypeWarning, reason: StackMissingOutput}
+}
+
 func StackUpdateDetectedEvent() StackEvent {
 	return StackEvent{eventType: EventTypeNormal, reason: StackUpdateDetected}
 }
@@ -79,3 +185,27 @@ func StackNotFoundEvent() StackEvent {
 func StackUpdateSuccessfulEvent() StackEvent {
 	return StackEvent{eventType: EventTypeNormal, reason: StackUpdateSuccessful}
 }
+
+func StackUpdateNoChangesEvent() StackEvent {
+	return StackEvent{eventType: EventTypeNormal, reason: StackUpdateNoChanges}
+}
+
+func StackRefreshSuccessfulEvent() StackEvent {
+	return StackEvent{eventType: EventTypeNormal, reason: StackRefreshSuccessful}
+}
+
+func StackSkippedUnrelatedChangeEvent() StackEvent {
+	return StackEvent{eventType: EventTypeNormal, reason: StackSkippedUnrelatedChange}
+}
+
+func StackDeferredOutsideWindowEvent() StackEvent {
+	return StackEvent{eventType: EventTypeNormal, reason: StackDeferredOutsideWindow}
+}
+
+func StackConfiguredEvent() StackEvent {
+	return StackEvent{eventType: EventTypeNormal, reason: StackConfigured}
+}
+
+func StackCreatedEvent() StackEvent {
+	return StackEvent{eventType: EventTypeNormal, reason: StackCreatedInBackend}
+}
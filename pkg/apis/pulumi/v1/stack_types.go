@@ -13,8 +13,77 @@ import (
 type StackStatus struct {
 	// Outputs contains the exported stack output variables resulting from a deployment.
 	Outputs shared.StackOutputs `json:"outputs,omitempty"`
+	// OutputsTruncated is true if some outputs were omitted from Outputs because together they
+	// exceeded the size limit given in the spec.
+	// +optional
+	OutputsTruncated bool `json:"outputsTruncated,omitempty"`
+	// OutputsJSON holds the non-secret outputs in Outputs as a single JSON object, with its keys
+	// sorted, if the spec asks for it.
+	// +optional
+	OutputsJSON string `json:"outputsJSON,omitempty"`
 	// LastUpdate contains details of the status of the last update.
 	LastUpdate *shared.StackUpdateState `json:"lastUpdate,omitempty"`
+	// LastRefresh records when the stack was last refreshed successfully, whether on schedule or
+	// before an update.
+	// +optional
+	LastRefresh *metav1.Time `json:"lastRefresh,omitempty"`
+	// LastBackup records when the stack's state was last backed up successfully.
+	// +optional
+	LastBackup *metav1.Time `json:"lastBackup,omitempty"`
+	// LastPollTime records when the operator last checked the stack's source for a new commit,
+	// whether or not it found one; so a stack whose branch hasn't changed shows that it is
+	// still being polled.
+	// +optional
+	LastPollTime *metav1.Time `json:"lastPollTime,omitempty"`
+	// PendingCommit records a commit whose update has been deferred until the maintenance window
+	// opens.
+	// +optional
+	PendingCommit string `json:"pendingCommit,omitempty"`
+	// SettlingCommit records the latest commit detected on the tracked branch while waiting for
+	// commitDebounceSeconds to pass without another, and SettlingSince when it was detected.
+	// +optional
+	SettlingCommit string `json:"settlingCommit,omitempty"`
+	// +optional
+	SettlingSince *metav1.Time `json:"settlingSince,omitempty"`
+	// LockedSince records when an update was first prevented by the stack being locked, if the
+	// last attempt to update it was.
+	// +optional
+	LockedSince *metav1.Time `json:"lockedSince,omitempty"`
+	// Project records the name of the Pulumi project the stack belongs to, as last read from the
+	// project repository. It is used to destroy the stack on deletion without fetching the
+	// repository.
+	// +optional
+	Project string `json:"project,omitempty"`
+	// Backend records the URL of the backend the stack was last processed with, as given by the
+	// environment or the project file; it's empty if neither gave one, in which case Pulumi used
+	// whichever backend it's logged in to. It is used along with Project to destroy the stack on
+	// deletion without fetching the repository.
+	// +optional
+	Backend string `json:"backend,omitempty"`
+	// StackName records the name of the stack as given to the backend when it was last processed,
+	// e.g., with a fully qualified name reduced to the stack name for a self-managed backend.
+	// +optional
+	StackName string `json:"stackName,omitempty"`
+	// StackCreatedAt records when the operator created the stack in the backend, as opposed to
+	// finding it already there. It is not set for a stack which already existed.
+	// +optional
+	StackCreatedAt *metav1.Time `json:"stackCreatedAt,omitempty"`
+	// Resources summarizes the resources in the stack after the last successful update, as the
+	// number of each type, most numerous first. It is populated only if the spec asks for it.
+	// +optional
+	Resources []ResourceTypeCount `json:"resources,omitempty"`
+	// ResourcesTruncated is true if some resource types were omitted from Resources to limit its
+	// size.
+	// +optional
+	ResourcesTruncated bool `json:"resourcesTruncated,omitempty"`
+	// PolicyViolations lists the violations of mandatory policies which blocked the last update, if
+	// it was blocked by policy. It is cleared when an update succeeds.
+	// +optional
+	PolicyViolations []PolicyViolation `json:"policyViolations,omitempty"`
+	// Variants reports on the stack for each variant in the spec's matrix, if it has one, in the
+	// order they are listed.
+	// +optional
+	Variants []VariantStatus `json:"variants,omitempty"`
 	// ObservedGeneration records the value of .meta.generation at the point the controller last processed this object
 	// +optional
 	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
@@ -22,6 +91,45 @@ type StackStatus struct {
 	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
+// ResourceTypeCount gives the number of resources of a type in a stack.
+type ResourceTypeCount struct {
+	// Type is the resource type, e.g., "aws:s3/bucket:Bucket".
+	Type string `json:"type"`
+	// Count is the number of resources of the type.
+	Count int `json:"count"`
+}
+
+// PolicyViolation records a resource's violation of a policy, as reported by the policy pack.
+type PolicyViolation struct {
+	// PolicyPack is the name of the policy pack, with its version if known, e.g.,
+	// "aws-compliance@1.2.0".
+	PolicyPack string `json:"policyPack"`
+	// Rule is the name of the policy violated.
+	Rule string `json:"rule"`
+	// Resource is the URN of the resource in violation, if the policy applies to a resource.
+	// +optional
+	Resource string `json:"resource,omitempty"`
+	// Message explains the violation.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// VariantStatus summarizes the status of the Stack object generated for a variant in a matrix.
+type VariantStatus struct {
+	// Name is the name of the Stack object generated for the variant.
+	Name string `json:"name"`
+	// Stack is the name of the variant's stack.
+	Stack string `json:"stack"`
+	// Ready is true if the variant's Stack object is ready.
+	Ready bool `json:"ready"`
+	// State is the state of the last update of the variant's stack, if there has been one.
+	// +optional
+	State shared.StackUpdateStateMessage `json:"state,omitempty"`
+	// LastSuccessfulCommit is the last commit successfully deployed to the variant's stack.
+	// +optional
+	LastSuccessfulCommit string `json:"lastSuccessfulCommit,omitempty"`
+}
+
 // The conditions form part of the API. They are used to implement a "ready protocol" which works
 // with tooling like kstatus
 // (https://github.com/kubernetes-sigs/cli-utils/blob/master/pkg/kstatus/README.md), as follows:
@@ -60,6 +168,12 @@ const (
 	ReconcilingProcessingMessage = "stack is being processed"
 	// Reconciling because it failed, and has been requeued
 	ReconcilingRetryReason = "RetryingAfterFailure"
+	// Reconciling because the update has been deferred until the maintenance window opens
+	ReconcilingDeferredReason = "DeferredOutsideWindow"
+	// Reconciling because a new commit is waiting for the debounce period to pass
+	ReconcilingSettlingReason = "WaitingForCommitToSettle"
+	// Reconciling because the stacks for the variants in the matrix are not all ready
+	ReconcilingVariantsReason = "WaitingForVariants"
 
 	// Stalled because the .spec can't be processed as it is
 	StalledSpecInvalidReason = "SpecInvalid"
@@ -67,6 +181,14 @@ const (
 	StalledSourceUnavailableReason = "SourceUnavailable"
 	// Stalled because there was a conflict with another update, and retryOnConflict was not set.
 	StalledConflictReason = "UpdateConflict"
+	// Stalled because the update would delete more resources than the deletion guard allows.
+	StalledDeletionGuardReason = "DeletionGuardTripped"
+	// Stalled because the stack was still not found in the backend after retrying.
+	StalledStackNotFoundReason = "StackNotFound"
+	// Stalled because processing the stack failed too many times in a row.
+	StalledTooManyFailuresReason = "TooManyFailures"
+	// Stalled because the stack has been paused, e.g., by a StackControl.
+	StalledPausedReason = "Paused"
 
 	// Ready because processing has completed
 	ReadyCompletedReason = "ProcessingCompleted"
@@ -133,6 +255,7 @@ func (s *StackStatus) MarkReadyCondition() {
 // +kubebuilder:storageversion
 // +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 // +kubebuilder:printcolumn:name="State",type="string",JSONPath=".status.lastUpdate.state"
+// +kubebuilder:printcolumn:name="Operation",type="string",JSONPath=".status.lastUpdate.kind"
 type Stack struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
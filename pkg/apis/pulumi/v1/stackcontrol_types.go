@@ -0,0 +1,94 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package v1
+
+import (
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// PausedAnnotationPrefix starts the key of an annotation which pauses a Stack: while a Stack
+	// has any annotation with a key starting with this prefix (and a value of "true"), it is not
+	// processed, except to be deleted. A StackControl pauses the stacks it selects with the key
+	// "paused.pulumi.com/<name of the StackControl>", so a StackControl's name can be no longer
+	// than 63 characters; other keys can be used to pause a stack by hand.
+	PausedAnnotationPrefix = "paused.pulumi.com/"
+	// ReconcileRequestAnnotation is an annotation which has a Stack processed again when its
+	// value changes, e.g., to the current time, rather than waiting for its next resync.
+	ReconcileRequestAnnotation = "pulumi.com/reconcile-request"
+)
+
+// StackControlSpec selects Stacks, and says what to do with them.
+type StackControlSpec struct {
+	// Selector selects the Stacks to control, by their labels. Only Stacks in the same namespace as
+	// the StackControl are selected; so being able to create a StackControl in a namespace gives
+	// control over only the Stacks in that namespace. The Stacks generated for the variants of a
+	// Stack with a matrix have its labels, so are selected along with it.
+	Selector metav1.LabelSelector `json:"selector"`
+	// (optional) Paused, when true, pauses the selected Stacks until it is set to false, the
+	// StackControl is deleted, or a Stack is no longer selected. A paused Stack is not processed,
+	// except to be deleted; an update already running when it is paused runs to completion.
+	Paused bool `json:"paused,omitempty"`
+	// (optional) ReconcileRequest, when changed, has the selected Stacks processed again, rather
+	// than waiting for their next resync; e.g., to have stacks tracking a branch look for a new
+	// commit straight away. Any value can be used, e.g., the current time.
+	ReconcileRequest string `json:"reconcileRequest,omitempty"`
+}
+
+// StackControlStatus records the Stacks selected by a StackControl.
+type StackControlStatus struct {
+	// Stacks lists the names of the Stacks selected, as of the last time the StackControl was
+	// processed.
+	// +optional
+	Stacks []string `json:"stacks,omitempty"`
+	// Error gives the reason the StackControl could not be applied, e.g., an invalid selector; it
+	// then selects no Stacks, and those it paused are resumed.
+	// +optional
+	Error string `json:"error,omitempty"`
+	// ObservedGeneration records the value of .meta.generation at the point the controller last
+	// processed this object.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// StackControl pauses, resumes, or requests the processing of, all the Stacks in its namespace
+// which match a label selector, e.g., to pause every Stack labelled `team=payments` during a
+// migration.
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:path=stackcontrols,scope=Namespaced
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+// +kubebuilder:printcolumn:name="Paused",type="boolean",JSONPath=".spec.paused"
+type StackControl struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   StackControlSpec   `json:"spec,omitempty"`
+	Status StackControlStatus `json:"status,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// StackControlList contains a list of StackControl
+type StackControlList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []StackControl `json:"items"`
+}
+
+// IsPaused says whether a Stack is paused, and if so, by which annotation.
+func (s *Stack) IsPaused() (string, bool) {
+	for k, v := range s.GetAnnotations() {
+		if strings.HasPrefix(k, PausedAnnotationPrefix) && v == "true" {
+			return k, true
+		}
+	}
+	return "", false
+}
+
+func init() {
+	SchemeBuilder.Register(&StackControl{}, &StackControlList{})
+}
@@ -0,0 +1,12 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package controller
+
+import (
+	"github.com/pulumi/pulumi-kubernetes-operator/pkg/controller/stackcontrol"
+)
+
+func init() {
+	// AddToManagerFuncs is a list of functions to create controllers and add them to a manager.
+	AddToManagerFuncs = append(AddToManagerFuncs, stackcontrol.Add)
+}
@@ -0,0 +1,171 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/shared"
+	pulumiv1 "github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/events"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+)
+
+const (
+	// auditLogSinkEnv names the environment variable giving where to write an audit log entry for
+	// each successful update: "stdout", or an http(s) URL to which each entry is POSTed as JSON.
+	// Unset disables the audit log.
+	auditLogSinkEnv     = "AUDIT_LOG_SINK"
+	auditLogStdout      = "stdout"
+	auditLogPostTimeout = 10 * time.Second
+)
+
+// auditEntry records a change applied to a stack. Entries are written once, after the update has
+// succeeded, and are not revised afterwards.
+type auditEntry struct {
+	Time      time.Time `json:"time"`
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	UID       string    `json:"uid"`
+	// Generation is the generation of the Stack object which was applied.
+	Generation  int64            `json:"generation"`
+	Stack       string           `json:"stack"`
+	ProjectRepo string           `json:"projectRepo,omitempty"`
+	LocalPath   string           `json:"localPath,omitempty"`
+	Commit      string           `json:"commit"`
+	Permalink   shared.Permalink `json:"permalink,omitempty"`
+	// PulumiVersion is the version of the Pulumi CLI which ran the update.
+	PulumiVersion string `json:"pulumiVersion,omitempty"`
+	// Created, Updated, Replaced and Deleted are the URNs of the resources changed by the update.
+	Created  []string `json:"created"`
+	Updated  []string `json:"updated"`
+	Replaced []string `json:"replaced"`
+	Deleted  []string `json:"deleted"`
+}
+
+// newAuditEntry makes the audit entry for an update of the stack, from the steps it completed.
+func newAuditEntry(instance *pulumiv1.Stack, commit string, permalink shared.Permalink, steps []apitype.StepEventMetadata, now time.Time) auditEntry {
+	entry := auditEntry{
+		Time:        now.UTC(),
+		Namespace:   instance.GetNamespace(),
+		Name:        instance.GetName(),
+		UID:         string(instance.GetUID()),
+		Generation:  instance.GetGeneration(),
+		Stack:       instance.Spec.Stack,
+		ProjectRepo: instance.Spec.ProjectRepo,
+		LocalPath:   instance.Spec.LocalPath,
+		Commit:      commit,
+		Permalink:   permalink,
+		Created:     []string{},
+		Updated:     []string{},
+		Replaced:    []string{},
+		Deleted:     []string{},
+	}
+	// A replacement is made of several steps for the same resource; it's recorded once.
+	replaced := map[string]bool{}
+	for _, step := range steps {
+		switch step.Op {
+		case apitype.OpCreate:
+			entry.Created = append(entry.Created, step.URN)
+		case apitype.OpUpdate:
+			entry.Updated = append(entry.Updated, step.URN)
+		case apitype.OpDelete:
+			entry.Deleted = append(entry.Deleted, step.URN)
+		case apitype.OpReplace, apitype.OpCreateReplacement, apitype.OpDeleteReplaced:
+			if !replaced[step.URN] {
+				replaced[step.URN] = true
+				entry.Replaced = append(entry.Replaced, step.URN)
+			}
+		}
+	}
+	for _, urns := range [][]string{entry.Created, entry.Updated, entry.Replaced, entry.Deleted} {
+		sort.Strings(urns)
+	}
+	return entry
+}
+
+// collectSteps gives a channel for an update's engine events, and a func which returns the steps
+// the update completed. The func waits for the channel to be closed, which the automation API does
+// once the update has run, so it must only be called after the update has succeeded.
+func collectSteps() (chan<- events.EngineEvent, func() []apitype.StepEventMetadata) {
+	engineEvents := make(chan events.EngineEvent)
+	var steps []apitype.StepEventMetadata
+	done := make(chan struct{})
+	go func() {
+		for event := range engineEvents {
+			if event.ResOutputsEvent != nil {
+				steps = append(steps, event.ResOutputsEvent.Metadata)
+			}
+		}
+		close(done)
+	}()
+	return engineEvents, func() []apitype.StepEventMetadata {
+		<-done
+		return steps
+	}
+}
+
+// auditLog writes audit entries, one JSON object per entry, to a sink.
+type auditLog struct {
+	// out is written to when entries go to a stream; otherwise, entries are POSTed to endpoint.
+	out      io.Writer
+	endpoint string
+	client   *http.Client
+
+	mu sync.Mutex
+}
+
+// auditLogFromEnv gives the audit log configured in the environment, or nil if there's none.
+func auditLogFromEnv() (*auditLog, error) {
+	sink := os.Getenv(auditLogSinkEnv)
+	switch sink {
+	case "":
+		return nil, nil
+	case auditLogStdout:
+		return &auditLog{out: os.Stdout}, nil
+	}
+	u, err := url.Parse(sink)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.Errorf("invalid %s %q: expected %q or an http(s) URL", auditLogSinkEnv, sink, auditLogStdout)
+	}
+	return &auditLog{endpoint: sink, client: &http.Client{Timeout: auditLogPostTimeout}}, nil
+}
+
+// record writes an entry to the audit log.
+func (a *auditLog) record(ctx context.Context, entry auditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if a.out != nil {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		_, err = a.out.Write(append(data, '\n'))
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("audit log endpoint responded with %s", resp.Status)
+	}
+	return nil
+}
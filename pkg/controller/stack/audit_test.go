@@ -0,0 +1,102 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/shared"
+	pulumiv1 "github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_NewAuditEntry(t *testing.T) {
+	instance := &pulumiv1.Stack{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app", UID: "1234", Generation: 3},
+		Spec:       shared.StackSpec{Stack: "acme/app/prod", ProjectRepo: "https://github.com/acme/app"},
+	}
+	steps := []apitype.StepEventMetadata{
+		{Op: apitype.OpSame, URN: "urn:pulumi:prod::app::pulumi:pulumi:Stack::app-prod"},
+		{Op: apitype.OpCreate, URN: "urn:pulumi:prod::app::aws:s3/bucket:Bucket::b"},
+		{Op: apitype.OpCreate, URN: "urn:pulumi:prod::app::aws:s3/bucket:Bucket::a"},
+		{Op: apitype.OpUpdate, URN: "urn:pulumi:prod::app::aws:s3/bucket:Bucket::c"},
+		{Op: apitype.OpCreateReplacement, URN: "urn:pulumi:prod::app::aws:ec2/instance:Instance::vm"},
+		{Op: apitype.OpReplace, URN: "urn:pulumi:prod::app::aws:ec2/instance:Instance::vm"},
+		{Op: apitype.OpDeleteReplaced, URN: "urn:pulumi:prod::app::aws:ec2/instance:Instance::vm"},
+		{Op: apitype.OpDelete, URN: "urn:pulumi:prod::app::aws:s3/bucket:Bucket::old"},
+	}
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	entry := newAuditEntry(instance, "abc123", shared.Permalink("https://app.pulumi.com/acme/app/prod/updates/7"), steps, now)
+	assert.Equal(t, now, entry.Time)
+	assert.Equal(t, "default", entry.Namespace)
+	assert.Equal(t, "app", entry.Name)
+	assert.Equal(t, "1234", entry.UID)
+	assert.Equal(t, int64(3), entry.Generation)
+	assert.Equal(t, "acme/app/prod", entry.Stack)
+	assert.Equal(t, "abc123", entry.Commit)
+	assert.Equal(t, []string{
+		"urn:pulumi:prod::app::aws:s3/bucket:Bucket::a",
+		"urn:pulumi:prod::app::aws:s3/bucket:Bucket::b",
+	}, entry.Created)
+	assert.Equal(t, []string{"urn:pulumi:prod::app::aws:s3/bucket:Bucket::c"}, entry.Updated)
+	assert.Equal(t, []string{"urn:pulumi:prod::app::aws:ec2/instance:Instance::vm"}, entry.Replaced)
+	assert.Equal(t, []string{"urn:pulumi:prod::app::aws:s3/bucket:Bucket::old"}, entry.Deleted)
+
+	empty := newAuditEntry(instance, "abc123", "", nil, now)
+	data, err := json.Marshal(empty)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"created":[]`, "no changes are recorded as empty lists")
+}
+
+func Test_AuditLogSinks(t *testing.T) {
+	entry := auditEntry{Namespace: "default", Name: "app", Commit: "abc123", Created: []string{"urn:a"}}
+
+	var out bytes.Buffer
+	stream := &auditLog{out: &out}
+	require.NoError(t, stream.record(context.Background(), entry))
+	require.NoError(t, stream.record(context.Background(), entry))
+	lines := bytes.Split(bytes.TrimSpace(out.Bytes()), []byte("\n"))
+	require.Len(t, lines, 2, "one line per entry")
+	var written auditEntry
+	require.NoError(t, json.Unmarshal(lines[0], &written))
+	assert.Equal(t, entry.Created, written.Created)
+
+	var posted auditEntry
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&posted))
+		if posted.Name == "rejected" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	t.Setenv(auditLogSinkEnv, server.URL)
+	endpoint, err := auditLogFromEnv()
+	require.NoError(t, err)
+	require.NoError(t, endpoint.record(context.Background(), entry))
+	assert.Equal(t, "abc123", posted.Commit)
+
+	entry.Name = "rejected"
+	assert.Error(t, endpoint.record(context.Background(), entry), "a non-2xx response is an error")
+
+	t.Setenv(auditLogSinkEnv, "")
+	none, err := auditLogFromEnv()
+	assert.NoError(t, err)
+	assert.Nil(t, none)
+
+	t.Setenv(auditLogSinkEnv, "syslog")
+	_, err = auditLogFromEnv()
+	assert.Error(t, err)
+}
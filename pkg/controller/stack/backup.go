@@ -0,0 +1,150 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	pulumiv1 "github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	defaultBackupIntervalSeconds = 24 * 60 * 60
+	defaultBackupRetain          = 3
+	backupKeyPrefix              = "checkpoint-"
+	backupKeyTimeFormat          = "20060102T150405Z"
+)
+
+// backupOnSchedule backs up the state of a stack that is otherwise up to date, and gives the
+// result for processing the stack again.
+func (r *ReconcileStack) backupOnSchedule(ctx context.Context, sess *reconcileStackSession, instance *pulumiv1.Stack, resyncFreq time.Duration) (reconcile.Result, error) {
+	if err := r.backupState(ctx, sess, instance); err != nil {
+		instance.Status.MarkReconcilingCondition(pulumiv1.ReconcilingRetryReason, err.Error())
+		recordFailure(instance)
+		return retryAfterFailure(instance), nil
+	}
+	instance.Status.MarkReadyCondition()
+	return reconcile.Result{RequeueAfter: requeueAfter(instance, resyncFreq, time.Now())}, nil
+}
+
+// backupState backs up the state of the stack, and records when in its status. A failure is
+// reported with an event, as well as being returned.
+func (r *ReconcileStack) backupState(ctx context.Context, sess *reconcileStackSession, instance *pulumiv1.Stack) error {
+	now := metav1.Now()
+	if err := sess.BackupState(ctx, now.Time); err != nil {
+		r.emitEvent(instance, pulumiv1.StackStateBackupFailureEvent(), "Failed to back up stack state: %v.", err.Error())
+		sess.logger.Error(err, "Failed to back up stack state", "Stack.Name", sess.stack.Stack)
+		return err
+	}
+	sess.logger.Info("Backed up stack state", "Stack.Name", sess.stack.Stack, "Secret", sess.stack.StateBackup.SecretName)
+	instance.Status.LastBackup = &now
+	return nil
+}
+
+// untilBackup gives how long it is from now until the stack is due a backup of its state; zero or
+// less means it is due. The second return value is false if the stack is not backed up.
+func untilBackup(instance *pulumiv1.Stack, now time.Time) (time.Duration, bool) {
+	backup := instance.Spec.StateBackup
+	if backup == nil {
+		return 0, false
+	}
+	interval := backup.IntervalSeconds
+	switch {
+	case interval == 0:
+		interval = defaultBackupIntervalSeconds
+	case interval < 60:
+		interval = 60
+	}
+	if instance.Status.LastBackup == nil {
+		return 0, true
+	}
+	return instance.Status.LastBackup.Add(time.Duration(interval) * time.Second).Sub(now), true
+}
+
+// BackupState exports the state of the stack, and stores it in the backup secret under a key for
+// the time given.
+func (sess *reconcileStackSession) BackupState(ctx context.Context, at time.Time) error {
+	data, err := sess.exportDeployment(ctx)
+	if err != nil {
+		return err
+	}
+	return sess.storeBackup(ctx, backupKeyPrefix+at.UTC().Format(backupKeyTimeFormat)+".json", data)
+}
+
+// exportDeployment exports the stack's deployment, as with `pulumi stack export`. The CLI has no
+// way to hold the backend's update lock for an export, so for the export to be consistent it is
+// refused if an update is in progress, or has run, while it's taken.
+func (sess *reconcileStackSession) exportDeployment(ctx context.Context) ([]byte, error) {
+	before, err := sess.autoStack.Info(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting stack info")
+	}
+	if before.UpdateInProgress {
+		return nil, errors.New("the stack is being updated")
+	}
+	deployment, err := sess.autoStack.Export(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "exporting stack")
+	}
+	after, err := sess.autoStack.Info(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting stack info")
+	}
+	if after.UpdateInProgress || after.LastUpdate != before.LastUpdate {
+		return nil, errors.New("the stack was updated while it was exported")
+	}
+	data, err := json.Marshal(deployment)
+	if err != nil {
+		return nil, errors.Wrap(err, "encoding exported stack")
+	}
+	return data, nil
+}
+
+// storeBackup stores a backup in the stack's backup secret, compressed if it's large, creating the
+// secret if necessary and removing the oldest backups beyond those to be retained or which don't
+// fit.
+func (sess *reconcileStackSession) storeBackup(ctx context.Context, key string, data []byte) error {
+	backup := sess.stack.StateBackup
+	retain := int(backup.Retain)
+	if retain <= 0 {
+		retain = defaultBackupRetain
+	}
+	key, data, err := secretValue(key, data)
+	if err != nil {
+		return err
+	}
+	err = sess.storeSecret(ctx, backup.SecretName, func(secret *corev1.Secret) {
+		addBackup(secret, key, data, retain)
+	})
+	return errors.Wrap(err, "storing backup")
+}
+
+// addBackup adds a backup to the secret under the key given, then removes the oldest backups
+// until no more than retain are left, and the data fits in a Secret. Other entries in the secret
+// are left alone.
+func addBackup(secret *corev1.Secret, key string, data []byte, retain int) {
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	secret.Data[key] = data
+
+	var keys []string
+	for k := range secret.Data {
+		if strings.HasPrefix(k, backupKeyPrefix) && k != key {
+			keys = append(keys, k)
+		}
+	}
+	// The keys include the time in a format that sorts chronologically.
+	sort.Strings(keys)
+	for ; len(keys) > 0 && (len(keys)+1 > retain || secretDataSize(secret) > maxSecretDataSize); keys = keys[1:] {
+		delete(secret.Data, keys[0])
+	}
+}
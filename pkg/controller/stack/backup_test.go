@@ -0,0 +1,122 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/shared"
+	pulumiv1 "github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/v1"
+	"github.com/pulumi/pulumi-kubernetes-operator/pkg/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func Test_UntilBackup(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	stack := &pulumiv1.Stack{}
+
+	_, scheduled := untilBackup(stack, now)
+	assert.False(t, scheduled)
+
+	stack.Spec.StateBackup = &shared.StateBackup{SecretName: "backups"}
+	wait, scheduled := untilBackup(stack, now)
+	assert.True(t, scheduled)
+	assert.Equal(t, time.Duration(0), wait, "a stack never backed up is due")
+
+	lastBackup := metav1.NewTime(now.Add(-time.Hour))
+	stack.Status.LastBackup = &lastBackup
+	wait, _ = untilBackup(stack, now)
+	assert.Equal(t, 23*time.Hour, wait, "defaults to daily")
+
+	stack.Spec.StateBackup.IntervalSeconds = 1800
+	wait, _ = untilBackup(stack, now)
+	assert.Equal(t, -30*time.Minute, wait)
+
+	stack.Spec.StateBackup.IntervalSeconds = 5
+	lastBackup = metav1.NewTime(now)
+	wait, _ = untilBackup(stack, now)
+	assert.Equal(t, time.Minute, wait, "no more often than a minute")
+}
+
+func Test_AddBackup(t *testing.T) {
+	secret := &corev1.Secret{
+		Data: map[string][]byte{"README": []byte("backups of stack state")},
+	}
+	for _, key := range []string{
+		"checkpoint-20220601T120000Z.json",
+		"checkpoint-20220602T120000Z.json",
+		"checkpoint-20220603T120000Z.json",
+	} {
+		addBackup(secret, key, []byte(key), 2)
+	}
+	assert.Equal(t, map[string][]byte{
+		"README":                           []byte("backups of stack state"),
+		"checkpoint-20220602T120000Z.json": []byte("checkpoint-20220602T120000Z.json"),
+		"checkpoint-20220603T120000Z.json": []byte("checkpoint-20220603T120000Z.json"),
+	}, secret.Data)
+}
+
+func Test_AddBackupFits(t *testing.T) {
+	secret := &corev1.Secret{}
+	for _, key := range []string{
+		"checkpoint-20220601T120000Z.json.gz",
+		"checkpoint-20220602T120000Z.json.gz",
+		"checkpoint-20220603T120000Z.json.gz",
+	} {
+		addBackup(secret, key, make([]byte, maxSecretDataSize/3), 3)
+	}
+	// There's room for only two of the backups, so the oldest is removed, although three are to be
+	// retained.
+	assert.Len(t, secret.Data, 2)
+	assert.Contains(t, secret.Data, "checkpoint-20220603T120000Z.json.gz")
+	assert.Contains(t, secret.Data, "checkpoint-20220602T120000Z.json.gz")
+}
+
+func Test_StoreBackup(t *testing.T) {
+	logger := logging.NewLogger(t.Name(), "Request.Test", "Test_StoreBackup")
+	client := fake.NewFakeClientWithScheme(scheme.Scheme)
+	sess := newReconcileStackSession(logger, shared.StackSpec{
+		StateBackup: &shared.StateBackup{SecretName: "backups", Retain: 1},
+	}, client, namespace)
+
+	require.NoError(t, sess.storeBackup(context.TODO(), "checkpoint-20220601T120000Z.json", []byte("first")))
+	require.NoError(t, sess.storeBackup(context.TODO(), "checkpoint-20220602T120000Z.json", []byte("second")))
+
+	var secret corev1.Secret
+	require.NoError(t, client.Get(context.TODO(), types.NamespacedName{Namespace: namespace, Name: "backups"}, &secret))
+	assert.Equal(t, map[string][]byte{"checkpoint-20220602T120000Z.json": []byte("second")}, secret.Data)
+}
+
+func Test_StoreBackupObjectMeta(t *testing.T) {
+	logger := logging.NewLogger(t.Name(), "Request.Test", "Test_StoreBackupObjectMeta")
+	existing := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      "backups",
+			Labels:    map[string]string{"app": "infra"},
+		},
+	}
+	client := fake.NewFakeClientWithScheme(scheme.Scheme, existing)
+	sess := newReconcileStackSession(logger, shared.StackSpec{
+		StateBackup: &shared.StateBackup{SecretName: "backups"},
+		ObjectMeta: &shared.ObjectMetadata{
+			Labels:      map[string]string{"cost-centre": "platform"},
+			Annotations: map[string]string{"example.com/owner": "team-infra"},
+		},
+	}, client, namespace)
+
+	require.NoError(t, sess.storeBackup(context.TODO(), "checkpoint-20220601T120000Z.json", []byte("first")))
+
+	var secret corev1.Secret
+	require.NoError(t, client.Get(context.TODO(), types.NamespacedName{Namespace: namespace, Name: "backups"}, &secret))
+	assert.Equal(t, map[string]string{"app": "infra", "cost-centre": "platform"}, secret.Labels)
+	assert.Equal(t, map[string]string{"example.com/owner": "team-infra"}, secret.Annotations)
+}
@@ -0,0 +1,71 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"context"
+	"sort"
+
+	"github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/shared"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+)
+
+// configHistoryPageSize is how many updates in the stack's history are looked through for the
+// last successful update, whose configuration is compared with the current configuration.
+const configHistoryPageSize = 10
+
+// diffConfig compares the configuration of two updates, giving the keys added, removed and
+// changed, each in order. It gives nil if there are no differences.
+func diffConfig(previous, current auto.ConfigMap) *shared.ConfigChanges {
+	var changes shared.ConfigChanges
+	for k, v := range current {
+		if prev, ok := previous[k]; !ok {
+			changes.Added = append(changes.Added, k)
+		} else if prev != v {
+			changes.Changed = append(changes.Changed, k)
+		}
+	}
+	for k := range previous {
+		if _, ok := current[k]; !ok {
+			changes.Removed = append(changes.Removed, k)
+		}
+	}
+	if len(changes.Added)+len(changes.Removed)+len(changes.Changed) == 0 {
+		return nil
+	}
+	sort.Strings(changes.Added)
+	sort.Strings(changes.Removed)
+	sort.Strings(changes.Changed)
+	return &changes
+}
+
+// lastSuccessfulConfig gives the configuration of the last successful update of the stack, as
+// recorded by the backend, and whether there was one among the most recent updates.
+func lastSuccessfulConfig(history []auto.UpdateSummary) (auto.ConfigMap, bool) {
+	for _, update := range history {
+		if update.Kind == "update" && update.Result == "succeeded" {
+			return update.Config, true
+		}
+	}
+	return nil, false
+}
+
+// configChanges compares the stack's configuration, as about to be used for an update, with that
+// of its last successful update, and gives the keys which differ. The workspace holds only the
+// configuration checked in and given in the spec, so the configuration last used comes from the
+// stack's history in the backend. Failing to read either is logged, and gives nil, since the
+// comparison is informational.
+func (sess *reconcileStackSession) configChanges(ctx context.Context) *shared.ConfigChanges {
+	current, err := sess.autoStack.GetAllConfig(ctx)
+	if err != nil {
+		sess.logger.Error(err, "Could not read stack configuration to compare", "Stack.Name", sess.stack.Stack)
+		return nil
+	}
+	history, err := sess.autoStack.History(ctx, configHistoryPageSize, 1)
+	if err != nil {
+		sess.logger.Error(err, "Could not read stack history to compare configuration", "Stack.Name", sess.stack.Stack)
+		return nil
+	}
+	previous, _ := lastSuccessfulConfig(history)
+	return diffConfig(previous, current)
+}
@@ -0,0 +1,52 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"testing"
+
+	"github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/shared"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_DiffConfig(t *testing.T) {
+	previous := auto.ConfigMap{
+		"app:replicas": {Value: "2"},
+		"app:region":   {Value: "eu-west-1"},
+		"app:password": {Value: "hunter2", Secret: true},
+		"app:token":    {Value: "abc", Secret: true},
+		"app:debug":    {Value: "true"},
+	}
+	current := auto.ConfigMap{
+		"app:replicas": {Value: "3"},
+		"app:region":   {Value: "eu-west-1"},
+		"app:password": {Value: "correct horse", Secret: true},
+		"app:token":    {Value: "abc"},
+		"app:zone":     {Value: "b"},
+	}
+	assert.Equal(t, &shared.ConfigChanges{
+		Added:   []string{"app:zone"},
+		Removed: []string{"app:debug"},
+		Changed: []string{"app:password", "app:replicas", "app:token"},
+	}, diffConfig(previous, current))
+
+	assert.Nil(t, diffConfig(current, current))
+	assert.Equal(t, &shared.ConfigChanges{Added: []string{"app:replicas"}},
+		diffConfig(nil, auto.ConfigMap{"app:replicas": {Value: "3"}}), "the first update adds all its configuration")
+}
+
+func Test_LastSuccessfulConfig(t *testing.T) {
+	config := auto.ConfigMap{"app:replicas": {Value: "2"}}
+	history := []auto.UpdateSummary{
+		{Kind: "update", Result: "failed", Config: auto.ConfigMap{"app:replicas": {Value: "4"}}},
+		{Kind: "refresh", Result: "succeeded", Config: auto.ConfigMap{"app:replicas": {Value: "3"}}},
+		{Kind: "update", Result: "succeeded", Config: config},
+	}
+	got, ok := lastSuccessfulConfig(history)
+	assert.True(t, ok)
+	assert.Equal(t, config, got)
+
+	_, ok = lastSuccessfulConfig(history[:2])
+	assert.False(t, ok)
+}
@@ -0,0 +1,79 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// configReference matches a reference in a configuration value, "${key}" or "${env:NAME}", or
+// "$$", which stands for a literal "$".
+var configReference = regexp.MustCompile(`\$\$|\$\{([^}]*)\}`)
+
+// envReferencePrefix marks a reference to an environment variable rather than a configuration key.
+const envReferencePrefix = "env:"
+
+// interpolateConfig replaces the references in configuration values with the values of the
+// configuration keys, or environment variables, they name. Only the plain configuration given in
+// the spec is interpolated, so secret values, which may contain anything, are never rewritten or
+// substituted into plain values. A reference to a key or variable which isn't given, or references
+// which form a cycle, are errors.
+func interpolateConfig(config map[string]string, env map[string]string) (map[string]string, error) {
+	resolved := make(map[string]string, len(config))
+	visiting := map[string]bool{}
+
+	var resolve func(key string, path []string) (string, error)
+	resolve = func(key string, path []string) (string, error) {
+		if v, ok := resolved[key]; ok {
+			return v, nil
+		}
+		path = append(path, key)
+		if visiting[key] {
+			return "", errors.Errorf("config references form a cycle: %s", strings.Join(path, " -> "))
+		}
+		visiting[key] = true
+		defer delete(visiting, key)
+
+		v := config[key]
+		var b strings.Builder
+		last := 0
+		for _, match := range configReference.FindAllStringSubmatchIndex(v, -1) {
+			b.WriteString(v[last:match[0]])
+			last = match[1]
+			if match[2] < 0 { // "$$"
+				b.WriteString("$")
+				continue
+			}
+			ref := v[match[2]:match[3]]
+			if name := strings.TrimPrefix(ref, envReferencePrefix); name != ref {
+				value, ok := env[name]
+				if !ok {
+					return "", errors.Errorf("config %q refers to environment variable %q, which is not given in env", key, name)
+				}
+				b.WriteString(value)
+				continue
+			}
+			if _, ok := config[ref]; !ok {
+				return "", errors.Errorf("config %q refers to config %q, which is not given in config", key, ref)
+			}
+			refValue, err := resolve(ref, path)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(refValue)
+		}
+		b.WriteString(v[last:])
+		resolved[key] = b.String()
+		return resolved[key], nil
+	}
+
+	for key := range config {
+		if _, err := resolve(key, nil); err != nil {
+			return nil, err
+		}
+	}
+	return resolved, nil
+}
@@ -0,0 +1,49 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_InterpolateConfig(t *testing.T) {
+	config := map[string]string{
+		"app:host":     "db.internal",
+		"app:port":     "5432",
+		"app:endpoint": "https://${app:host}:${app:port}",
+		"app:dsn":      "postgres://${app:endpoint}/${env:DB_NAME}",
+		"app:price":    "$$5 or ${not interpolated",
+	}
+	resolved, err := interpolateConfig(config, map[string]string{"DB_NAME": "orders"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"app:host":     "db.internal",
+		"app:port":     "5432",
+		"app:endpoint": "https://db.internal:5432",
+		"app:dsn":      "postgres://https://db.internal:5432/orders",
+		"app:price":    "$5 or ${not interpolated",
+	}, resolved)
+	assert.Equal(t, "https://${app:host}:${app:port}", config["app:endpoint"], "the config given should not be changed")
+}
+
+func Test_InterpolateConfigErrors(t *testing.T) {
+	_, err := interpolateConfig(map[string]string{"a": "${b}"}, nil)
+	assert.Error(t, err)
+
+	_, err = interpolateConfig(map[string]string{"a": "${env:MISSING}"}, nil)
+	assert.Error(t, err)
+
+	_, err = interpolateConfig(map[string]string{
+		"a": "${b}",
+		"b": "${c}",
+		"c": "${a}",
+	}, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cycle")
+
+	_, err = interpolateConfig(map[string]string{"a": "${a}"}, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,73 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"context"
+	"net"
+	"net/url"
+
+	"github.com/pkg/errors"
+	"github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/shared"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+)
+
+// validateCredentialsEndpoint checks that a credentials endpoint, if given, is an HTTP(S) URL on a
+// loopback address, and only has an authorization token where one can be used.
+func validateCredentialsEndpoint(ep *shared.CredentialsEndpoint) error {
+	if ep == nil {
+		return nil
+	}
+	u, err := url.Parse(ep.URL)
+	if err != nil {
+		return errors.Wrapf(err, "parsing url %q", ep.URL)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.Errorf("url %q must be http or https", ep.URL)
+	}
+	if !isLoopback(u.Hostname()) {
+		return errors.Errorf("url %q must be on a loopback address", ep.URL)
+	}
+	if ep.Cloud == shared.GCPCredentialsEndpoint && ep.AuthorizationToken != nil {
+		return errors.New("authorizationToken is not used with cloud \"gcp\"")
+	}
+	return nil
+}
+
+// isLoopback says whether a host is a loopback address, given by name or by IP. Only "localhost"
+// is accepted by name, since any other name could resolve elsewhere.
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// setCredentialsEndpoint sets the environment variables in the workspace which point the cloud's
+// SDKs at the stack's credentials endpoint, if it gives one.
+func (sess *reconcileStackSession) setCredentialsEndpoint(ctx context.Context, w auto.Workspace) error {
+	ep := sess.stack.CredentialsEndpoint
+	if ep == nil {
+		return nil
+	}
+	if err := validateCredentialsEndpoint(ep); err != nil {
+		return err
+	}
+	switch ep.Cloud {
+	case shared.GCPCredentialsEndpoint:
+		u, _ := url.Parse(ep.URL)
+		w.SetEnvVar("GCE_METADATA_HOST", u.Host)
+		w.SetEnvVar("GCE_METADATA_IP", u.Host)
+	default:
+		w.SetEnvVar("AWS_CONTAINER_CREDENTIALS_FULL_URI", ep.URL)
+		if ep.AuthorizationToken != nil {
+			token, err := sess.resolveResourceRef(ctx, ep.AuthorizationToken)
+			if err != nil {
+				return errors.Wrap(err, "resolving credentials endpoint authorization token")
+			}
+			w.SetEnvVar("AWS_CONTAINER_AUTHORIZATION_TOKEN", token)
+		}
+	}
+	return nil
+}
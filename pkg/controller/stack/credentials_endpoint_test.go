@@ -0,0 +1,64 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/shared"
+	"github.com/pulumi/pulumi-kubernetes-operator/pkg/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ValidateCredentialsEndpoint(t *testing.T) {
+	token := shared.NewLiteralResourceRef("t0ken")
+	for _, tc := range []struct {
+		ep    *shared.CredentialsEndpoint
+		valid bool
+	}{
+		{ep: nil, valid: true},
+		{ep: &shared.CredentialsEndpoint{URL: "http://127.0.0.1:9911/creds"}, valid: true},
+		{ep: &shared.CredentialsEndpoint{URL: "http://localhost:9911/creds"}, valid: true},
+		{ep: &shared.CredentialsEndpoint{URL: "http://[::1]:9911/creds"}, valid: true},
+		{ep: &shared.CredentialsEndpoint{URL: "http://127.0.0.1:9911", Cloud: shared.GCPCredentialsEndpoint}, valid: true},
+		{ep: &shared.CredentialsEndpoint{URL: "http://169.254.169.254/latest"}},
+		{ep: &shared.CredentialsEndpoint{URL: "http://localhost.example.com/creds"}},
+		{ep: &shared.CredentialsEndpoint{URL: "file:///var/run/creds"}},
+		{ep: &shared.CredentialsEndpoint{URL: "127.0.0.1:9911"}},
+		{ep: &shared.CredentialsEndpoint{URL: "http://127.0.0.1:9911", Cloud: shared.GCPCredentialsEndpoint, AuthorizationToken: &token}},
+	} {
+		err := validateCredentialsEndpoint(tc.ep)
+		if tc.valid {
+			assert.NoError(t, err, "%+v", tc.ep)
+		} else {
+			assert.Error(t, err, "%+v", tc.ep)
+		}
+	}
+}
+
+func Test_SetCredentialsEndpoint(t *testing.T) {
+	logger := logging.NewLogger(t.Name(), "Request.Test", "SetCredentialsEndpoint")
+	token := shared.NewLiteralResourceRef("t0ken")
+
+	sess := newReconcileStackSession(logger, shared.StackSpec{
+		CredentialsEndpoint: &shared.CredentialsEndpoint{URL: "http://127.0.0.1:9911/creds", AuthorizationToken: &token},
+	}, nil, namespace)
+	w := &envWorkspace{envs: map[string]string{}}
+	require.NoError(t, sess.setCredentialsEndpoint(context.Background(), w))
+	assert.Equal(t, map[string]string{
+		"AWS_CONTAINER_CREDENTIALS_FULL_URI": "http://127.0.0.1:9911/creds",
+		"AWS_CONTAINER_AUTHORIZATION_TOKEN":  "t0ken",
+	}, w.envs)
+
+	sess = newReconcileStackSession(logger, shared.StackSpec{
+		CredentialsEndpoint: &shared.CredentialsEndpoint{URL: "http://127.0.0.1:9911", Cloud: shared.GCPCredentialsEndpoint},
+	}, nil, namespace)
+	w = &envWorkspace{envs: map[string]string{}}
+	require.NoError(t, sess.setCredentialsEndpoint(context.Background(), w))
+	assert.Equal(t, map[string]string{
+		"GCE_METADATA_HOST": "127.0.0.1:9911",
+		"GCE_METADATA_IP":   "127.0.0.1:9911",
+	}, w.envs)
+}
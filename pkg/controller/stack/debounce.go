@@ -0,0 +1,28 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"fmt"
+	"time"
+
+	pulumiv1 "github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// settleCommit records commit as the latest detected, and gives how long until it has gone
+// debounceSeconds without a newer commit being detected, or zero if it already has. A commit other
+// than the one recorded starts the wait again.
+func settleCommit(instance *pulumiv1.Stack, commit string, debounceSeconds int64, now time.Time) time.Duration {
+	if instance.Status.SettlingCommit != commit || instance.Status.SettlingSince == nil {
+		since := metav1.NewTime(now)
+		instance.Status.SettlingCommit, instance.Status.SettlingSince = commit, &since
+	}
+	wait := instance.Status.SettlingSince.Add(time.Duration(debounceSeconds) * time.Second).Sub(now)
+	if wait <= 0 {
+		return 0
+	}
+	instance.Status.MarkReconcilingCondition(pulumiv1.ReconcilingSettlingReason,
+		fmt.Sprintf("waiting for commit %q to settle before applying it", commit))
+	return wait
+}
@@ -0,0 +1,30 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"testing"
+	"time"
+
+	pulumiv1 "github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_SettleCommit(t *testing.T) {
+	instance := &pulumiv1.Stack{}
+	start := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	// A new commit waits for the whole period.
+	assert.Equal(t, 30*time.Second, settleCommit(instance, "abc", 30, start))
+	assert.Equal(t, "abc", instance.Status.SettlingCommit)
+
+	// The same commit waits for what's left.
+	assert.Equal(t, 20*time.Second, settleCommit(instance, "abc", 30, start.Add(10*time.Second)))
+
+	// A newer commit starts the wait again.
+	assert.Equal(t, 30*time.Second, settleCommit(instance, "def", 30, start.Add(20*time.Second)))
+	assert.Equal(t, "def", instance.Status.SettlingCommit)
+
+	// Once settled, the commit can be applied.
+	assert.Equal(t, time.Duration(0), settleCommit(instance, "def", 30, start.Add(50*time.Second)))
+}
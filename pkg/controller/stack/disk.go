@@ -0,0 +1,60 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+const (
+	// minWorkspaceFreeMBEnv names the environment variable giving the least free disk space, in
+	// MiB, there must be where workspaces are made before the source is fetched into one. Zero
+	// disables the check.
+	minWorkspaceFreeMBEnv = "MIN_WORKSPACE_FREE_MB"
+	// defaultMinWorkspaceFreeMB is the least free disk space for a workspace if the environment
+	// doesn't say.
+	defaultMinWorkspaceFreeMB = 100
+)
+
+// insufficientDiskError is returned when there's too little free disk space to set up a workspace.
+type insufficientDiskError struct {
+	dir       string
+	available uint64
+	required  uint64
+}
+
+func (e *insufficientDiskError) Error() string {
+	return fmt.Sprintf("insufficient disk space for the workspace in %s: %d MiB available, %d MiB required "+
+		"(set by %s)", e.dir, e.available>>20, e.required>>20, minWorkspaceFreeMBEnv)
+}
+
+// minWorkspaceFreeBytes gives the least free disk space there must be for a workspace, as
+// configured in the environment.
+func minWorkspaceFreeBytes() uint64 {
+	mb := uint64(defaultMinWorkspaceFreeMB)
+	if raw := os.Getenv(minWorkspaceFreeMBEnv); raw != "" {
+		parsed, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			log.Error(err, "ignoring invalid setting for minimum free disk space", "env", minWorkspaceFreeMBEnv, "value", raw)
+		} else {
+			mb = parsed
+		}
+	}
+	return mb << 20
+}
+
+// checkDiskSpace returns an insufficientDiskError if the filesystem holding dir has less than
+// required bytes free. If the free space can't be found, the check is passed, so that a failure
+// to find it doesn't stop stacks being processed.
+func checkDiskSpace(dir string, required uint64) error {
+	if required == 0 {
+		return nil
+	}
+	available, ok := availableDiskSpace(dir)
+	if !ok || available >= required {
+		return nil
+	}
+	return &insufficientDiskError{dir: dir, available: available, required: required}
+}
@@ -0,0 +1,34 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_CheckDiskSpace(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, checkDiskSpace(dir, 0))
+	assert.NoError(t, checkDiskSpace(dir, 1))
+
+	err := checkDiskSpace(dir, math.MaxUint64)
+	var insufficient *insufficientDiskError
+	require.True(t, errors.As(err, &insufficient))
+	assert.Contains(t, err.Error(), minWorkspaceFreeMBEnv)
+}
+
+func Test_MinWorkspaceFreeBytes(t *testing.T) {
+	t.Setenv(minWorkspaceFreeMBEnv, "")
+	assert.Equal(t, uint64(defaultMinWorkspaceFreeMB)<<20, minWorkspaceFreeBytes())
+	t.Setenv(minWorkspaceFreeMBEnv, "2048")
+	assert.Equal(t, uint64(2048)<<20, minWorkspaceFreeBytes())
+	t.Setenv(minWorkspaceFreeMBEnv, "0")
+	assert.Equal(t, uint64(0), minWorkspaceFreeBytes())
+	t.Setenv(minWorkspaceFreeMBEnv, "lots")
+	assert.Equal(t, uint64(defaultMinWorkspaceFreeMB)<<20, minWorkspaceFreeBytes())
+}
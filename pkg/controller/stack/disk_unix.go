@@ -0,0 +1,18 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+//go:build !windows
+// +build !windows
+
+package stack
+
+import "syscall"
+
+// availableDiskSpace gives the number of bytes available to an unprivileged user on the
+// filesystem holding dir, and whether it could be found.
+func availableDiskSpace(dir string) (uint64, bool) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, false
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), true
+}
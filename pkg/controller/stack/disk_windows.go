@@ -0,0 +1,9 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+// availableDiskSpace is not implemented on Windows, where the operator isn't run; the disk space
+// check is always passed.
+func availableDiskSpace(dir string) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,94 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// eventThrottleWindowEnv names the environment variable giving the window (as a duration, e.g.,
+	// "10m") within which repeated identical events for a stack are coalesced. Zero disables the
+	// throttle.
+	eventThrottleWindowEnv     = "EVENT_THROTTLE_WINDOW"
+	defaultEventThrottleWindow = 10 * time.Minute
+)
+
+// eventKey identifies events which are considered the same, for throttling.
+type eventKey struct {
+	namespace, name string
+	reason, message string
+}
+
+type eventRecord struct {
+	lastEmitted time.Time
+	suppressed  int
+}
+
+// eventThrottle coalesces repeated identical events for a stack, so that stacks processed often
+// (e.g., when tracking a branch) don't flood the event stream. The first occurrence of an event is
+// always allowed; identical events within the window after that are suppressed, and the next one
+// after the window is allowed again, along with the number suppressed in the meantime.
+type eventThrottle struct {
+	window time.Duration
+	now    func() time.Time
+
+	mu   sync.Mutex
+	seen map[eventKey]*eventRecord
+}
+
+func newEventThrottle(window time.Duration) *eventThrottle {
+	return &eventThrottle{
+		window: window,
+		now:    time.Now,
+		seen:   map[eventKey]*eventRecord{},
+	}
+}
+
+// allow reports whether the event identified by key should be emitted, and if so, how many
+// identical events were suppressed before it.
+func (t *eventThrottle) allow(key eventKey) (bool, int) {
+	if t.window <= 0 {
+		return true, 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	// Forget about events that have not been seen for a window, so the records don't accumulate.
+	for k, rec := range t.seen {
+		if k != key && now.Sub(rec.lastEmitted) >= t.window {
+			delete(t.seen, k)
+		}
+	}
+
+	rec, ok := t.seen[key]
+	if !ok {
+		t.seen[key] = &eventRecord{lastEmitted: now}
+		return true, 0
+	}
+	if now.Sub(rec.lastEmitted) < t.window {
+		rec.suppressed++
+		return false, 0
+	}
+	suppressed := rec.suppressed
+	rec.lastEmitted, rec.suppressed = now, 0
+	return true, suppressed
+}
+
+// eventThrottleWindow returns the window for coalescing events, as configured in the environment,
+// or the default.
+func eventThrottleWindow() time.Duration {
+	raw, set := os.LookupEnv(eventThrottleWindowEnv)
+	if !set {
+		return defaultEventThrottleWindow
+	}
+	window, err := time.ParseDuration(raw)
+	if err != nil {
+		log.Error(err, "ignoring invalid event throttle window", "env", eventThrottleWindowEnv, "value", raw)
+		return defaultEventThrottleWindow
+	}
+	return window
+}
@@ -0,0 +1,63 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_EventThrottle(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	throttle := newEventThrottle(10 * time.Minute)
+	throttle.now = func() time.Time { return now }
+
+	updated := eventKey{namespace: "default", name: "s1", reason: "StackCreated", message: "Successfully updated stack."}
+	other := eventKey{namespace: "default", name: "s2", reason: "StackCreated", message: "Successfully updated stack."}
+
+	allowed, _ := throttle.allow(updated)
+	assert.True(t, allowed, "first occurrence")
+	allowed, _ = throttle.allow(other)
+	assert.True(t, allowed, "same event for another stack")
+
+	for i := 0; i < 3; i++ {
+		now = now.Add(time.Minute)
+		allowed, _ = throttle.allow(updated)
+		assert.False(t, allowed, "repeat within the window")
+	}
+
+	now = now.Add(8 * time.Minute)
+	allowed, suppressed := throttle.allow(updated)
+	assert.True(t, allowed, "repeat after the window")
+	assert.Equal(t, 3, suppressed)
+
+	now = now.Add(time.Minute)
+	allowed, _ = throttle.allow(updated)
+	assert.False(t, allowed, "window restarts")
+}
+
+func Test_EventThrottleDisabled(t *testing.T) {
+	throttle := newEventThrottle(0)
+	key := eventKey{reason: "StackCreated", message: "Successfully updated stack."}
+	for i := 0; i < 3; i++ {
+		allowed, _ := throttle.allow(key)
+		assert.True(t, allowed)
+	}
+}
+
+func Test_EventThrottleWindow(t *testing.T) {
+	assert.Equal(t, defaultEventThrottleWindow, eventThrottleWindow())
+
+	os.Setenv(eventThrottleWindowEnv, "0")
+	defer os.Unsetenv(eventThrottleWindowEnv)
+	assert.Equal(t, time.Duration(0), eventThrottleWindow())
+
+	os.Setenv(eventThrottleWindowEnv, "1h")
+	assert.Equal(t, time.Hour, eventThrottleWindow())
+
+	os.Setenv(eventThrottleWindowEnv, "often")
+	assert.Equal(t, defaultEventThrottleWindow, eventThrottleWindow())
+}
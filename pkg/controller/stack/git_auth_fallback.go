@@ -0,0 +1,83 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+)
+
+// gitAuthCandidate is a set of credentials with which to try cloning the project repository,
+// named as recorded in the status.
+type gitAuthCandidate struct {
+	name string
+	auth *auto.GitAuth
+}
+
+// gitAuthCandidates gives the credentials to try cloning with, in order: the primary, then each
+// of the fallbacks.
+func gitAuthCandidates(primary *auto.GitAuth, fallbacks []*auto.GitAuth) []gitAuthCandidate {
+	candidates := []gitAuthCandidate{{name: "gitAuth", auth: primary}}
+	for i, auth := range fallbacks {
+		candidates = append(candidates, gitAuthCandidate{name: fmt.Sprintf("gitAuthFallbacks[%d]", i), auth: auth})
+	}
+	return candidates
+}
+
+// firstSuitableAuth tries cloning from the URL with each of the candidate credentials suited to
+// it, in order, until one succeeds, and gives the name of that one. If there are no fallbacks,
+// the primary credentials are tried whatever the URL, as they always have been.
+func firstSuitableAuth(url string, candidates []gitAuthCandidate, try func(*auto.GitAuth) error) (string, error) {
+	var failures []string
+	for _, c := range candidates {
+		if len(candidates) > 1 && !gitAuthSuits(url, c.auth) {
+			continue
+		}
+		err := try(c.auth)
+		if err == nil {
+			return c.name, nil
+		}
+		failures = append(failures, fmt.Sprintf("with %s: %s", c.name, err.Error()))
+	}
+	if len(failures) == 0 {
+		return "", errors.New("no git authentication option suits the URL")
+	}
+	return "", errors.New(strings.Join(failures, "; "))
+}
+
+// gitAuthSuits reports whether the credentials can be used to clone from the URL: SSH keys only
+// with SSH URLs, and access tokens and basic auth only with HTTP(S) URLs. No credentials suit
+// any URL.
+func gitAuthSuits(url string, auth *auto.GitAuth) bool {
+	switch {
+	case auth == nil:
+		return true
+	case auth.SSHPrivateKey != "":
+		return isSSHURL(url)
+	case auth.PersonalAccessToken != "" || auth.Username != "":
+		return strings.HasPrefix(url, "https://") || strings.HasPrefix(url, "http://")
+	}
+	return true
+}
+
+// isSSHURL reports whether a git URL uses SSH, either explicitly (ssh://) or in the scp-like
+// form (git@host:path).
+func isSSHURL(url string) bool {
+	if strings.HasPrefix(url, "ssh://") {
+		return true
+	}
+	return !strings.Contains(url, "://") && strings.Contains(url, "@") && strings.Contains(url, ":")
+}
+
+// usesSSHKey reports whether any of the credentials to be tried is an SSH key.
+func usesSSHKey(primary *auto.GitAuth, fallbacks []*auto.GitAuth) bool {
+	for _, c := range gitAuthCandidates(primary, fallbacks) {
+		if c.auth != nil && c.auth.SSHPrivateKey != "" {
+			return true
+		}
+	}
+	return false
+}
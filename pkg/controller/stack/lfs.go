@@ -0,0 +1,57 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+)
+
+// gitLFSBinary is the git extension which fetches the content of files tracked with Git LFS.
+const gitLFSBinary = "git-lfs"
+
+// gitLFSMissingError is returned when the stack asks for Git LFS content, and git-lfs is not
+// installed in the operator's image.
+type gitLFSMissingError struct {
+	err error
+}
+
+func (e *gitLFSMissingError) Error() string {
+	return "the stack sets gitLFS, but " + gitLFSBinary + " is not installed in the operator: " + e.err.Error()
+}
+
+func (e *gitLFSMissingError) Unwrap() error {
+	return e.err
+}
+
+// checkGitLFS checks that git-lfs can be run, if the stack asks for Git LFS content, so that
+// the stack fails clearly rather than with files missing from its program.
+func (sess *reconcileStackSession) checkGitLFS() error {
+	if !sess.stack.GitLFS {
+		return nil
+	}
+	if _, err := exec.LookPath(gitLFSBinary); err != nil {
+		return &gitLFSMissingError{err: err}
+	}
+	return nil
+}
+
+// gitLFSPull fetches the content of the files tracked with Git LFS in the repository cloned into
+// dir, and puts it in place in the working tree, using the same authentication as the clone.
+func (sess *reconcileStackSession) gitLFSPull(ctx context.Context, dir string, gitAuth *auto.GitAuth) error {
+	env, err := sess.gitCommandEnv(gitAuth)
+	if err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, "git", "lfs", "pull")
+	cmd.Dir = dir
+	cmd.Env = env
+	if _, stderr, err := sess.runCmd("Git LFS Pull", cmd, nil); err != nil {
+		return errors.Wrapf(err, "running git lfs pull: %s", strings.TrimSpace(stderr))
+	}
+	return nil
+}
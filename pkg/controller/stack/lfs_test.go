@@ -0,0 +1,32 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/shared"
+	"github.com/pulumi/pulumi-kubernetes-operator/pkg/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_CheckGitLFS(t *testing.T) {
+	logger := logging.NewLogger(t.Name(), "Request.Test", "Test_CheckGitLFS")
+	bin := t.TempDir()
+	t.Setenv("PATH", bin)
+
+	sess := newReconcileStackSession(logger, shared.StackSpec{}, nil, namespace)
+	assert.NoError(t, sess.checkGitLFS(), "git-lfs isn't needed unless asked for")
+
+	sess.stack.GitLFS = true
+	err := sess.checkGitLFS()
+	var missing *gitLFSMissingError
+	assert.True(t, errors.As(err, &missing), "%v", err)
+
+	require.NoError(t, os.WriteFile(filepath.Join(bin, gitLFSBinary), []byte("#!/bin/sh\n"), 0755))
+	assert.NoError(t, sess.checkGitLFS())
+}
@@ -0,0 +1,141 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+)
+
+// localWorkspace creates the workspace for the stack from the project source at the local path
+// given in the stack spec, e.g., on a volume synced out of band. The source is copied into dir,
+// rather than used in place, since setting up the stack writes files into the project directory,
+// and the source may be shared with other stacks, or read-only.
+func (sess *reconcileStackSession) localWorkspace(ctx context.Context, dir string, secretsProvider auto.LocalWorkspaceOption) (auto.Workspace, error) {
+	src := sess.stack.LocalPath
+	if err := copyTree(src, dir); err != nil {
+		return nil, errors.Wrapf(err, "copying project source from local path %s", src)
+	}
+	// A git checkout has a commit to record; otherwise, the contents stand in for one.
+	if _, err := revisionAtWorkingDir(dir); err != nil {
+		digest, err := directoryDigest(dir)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading project source from local path %s", src)
+		}
+		sess.localRevision = digest
+	}
+	w, err := auto.NewLocalWorkspace(ctx, auto.WorkDir(filepath.Join(dir, sess.stack.RepoDir)), secretsProvider)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create local workspace")
+	}
+	return w, nil
+}
+
+// revision gives the revision of the project source: the commit checked out, or for a local path
+// which isn't a git checkout, a digest of its contents.
+func (sess *reconcileStackSession) revision() (string, error) {
+	if sess.localRevision != "" {
+		return sess.localRevision, nil
+	}
+	return revisionAtWorkingDir(sess.workdir)
+}
+
+// copyTree copies the directory src, and everything in it, into the directory dst. Symlinks are
+// copied as they are; anything other than directories, regular files and symlinks is skipped.
+func copyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case d.Type().IsRegular():
+			return copyFile(path, target, info.Mode().Perm())
+		}
+		return nil
+	})
+}
+
+func copyFile(src, dst string, perm fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// directoryDigest gives a hexadecimal digest of the names, modes and contents of everything in the
+// directory given.
+func directoryDigest(dir string) (string, error) {
+	h := sha256.New()
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s\x00%s\x00", filepath.ToSlash(rel), info.Mode())
+		switch {
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(h, "%s\x00", link)
+		case d.Type().IsRegular():
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if _, err := io.Copy(h, f); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,45 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_CopyTree(t *testing.T) {
+	src := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "infra", "bin"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "infra", "Pulumi.yaml"), []byte("name: infra\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "infra", "bin", "run"), []byte("#!/bin/sh\n"), 0755))
+	require.NoError(t, os.Symlink("infra/Pulumi.yaml", filepath.Join(src, "link")))
+
+	dst := t.TempDir()
+	require.NoError(t, copyTree(src, dst))
+
+	contents, err := os.ReadFile(filepath.Join(dst, "infra", "Pulumi.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, "name: infra\n", string(contents))
+	info, err := os.Stat(filepath.Join(dst, "infra", "bin", "run"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+	link, err := os.Readlink(filepath.Join(dst, "link"))
+	require.NoError(t, err)
+	assert.Equal(t, "infra/Pulumi.yaml", link)
+
+	// The copy has the same digest as the original, and a change to either alters it.
+	srcDigest, err := directoryDigest(src)
+	require.NoError(t, err)
+	dstDigest, err := directoryDigest(dst)
+	require.NoError(t, err)
+	assert.Equal(t, srcDigest, dstDigest)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dst, "infra", "Pulumi.yaml"), []byte("name: other\n"), 0644))
+	changed, err := directoryDigest(dst)
+	require.NoError(t, err)
+	assert.NotEqual(t, srcDigest, changed)
+}
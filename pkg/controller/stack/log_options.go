@@ -0,0 +1,76 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/shared"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optdestroy"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optpreview"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optrefresh"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optup"
+)
+
+// The automation API has fields for colorizing output, but no options to set them, so these
+// adapt funcs into options, as the automation API does for its own.
+
+type upOption func(*optup.Options)
+
+func (o upOption) ApplyOption(opts *optup.Options) { o(opts) }
+
+type refreshOption func(*optrefresh.Options)
+
+func (o refreshOption) ApplyOption(opts *optrefresh.Options) { o(opts) }
+
+type previewOption func(*optpreview.Options)
+
+func (o previewOption) ApplyOption(opts *optpreview.Options) { o(opts) }
+
+type destroyOption func(*optdestroy.Options)
+
+func (o destroyOption) ApplyOption(opts *optdestroy.Options) { o(opts) }
+
+// logColor gives the colorization of Pulumi's output asked for in the stack spec, which defaults
+// to none, so that the operator's logs are plain text.
+func logColor(opts *shared.LogOptions) string {
+	if opts == nil || opts.Color == "" {
+		return string(shared.LogColorNever)
+	}
+	return string(opts.Color)
+}
+
+// logDiff reports whether the stack spec asks for a detailed diff in Pulumi's output.
+func logDiff(opts *shared.LogOptions) bool {
+	return opts != nil && opts.Diff
+}
+
+// upLogOptions gives the options for rendering the output of an update.
+func (sess *reconcileStackSession) upLogOptions() []optup.Option {
+	color := logColor(sess.stack.LogOptions)
+	opts := []optup.Option{upOption(func(o *optup.Options) { o.Color = color })}
+	if logDiff(sess.stack.LogOptions) {
+		opts = append(opts, optup.Diff())
+	}
+	return opts
+}
+
+// previewLogOptions gives the options for rendering the output of a preview.
+func (sess *reconcileStackSession) previewLogOptions() []optpreview.Option {
+	color := logColor(sess.stack.LogOptions)
+	opts := []optpreview.Option{previewOption(func(o *optpreview.Options) { o.Color = color })}
+	if logDiff(sess.stack.LogOptions) {
+		opts = append(opts, optpreview.Diff())
+	}
+	return opts
+}
+
+// refreshLogOptions gives the options for rendering the output of a refresh, which has no diff.
+func (sess *reconcileStackSession) refreshLogOptions() []optrefresh.Option {
+	color := logColor(sess.stack.LogOptions)
+	return []optrefresh.Option{refreshOption(func(o *optrefresh.Options) { o.Color = color })}
+}
+
+// destroyLogOptions gives the options for rendering the output of a destroy, which has no diff.
+func (sess *reconcileStackSession) destroyLogOptions() []optdestroy.Option {
+	color := logColor(sess.stack.LogOptions)
+	return []optdestroy.Option{destroyOption(func(o *optdestroy.Options) { o.Color = color })}
+}
@@ -0,0 +1,55 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"testing"
+
+	"github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/shared"
+	"github.com/pulumi/pulumi-kubernetes-operator/pkg/logging"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optdestroy"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optpreview"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optrefresh"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optup"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_LogOptions(t *testing.T) {
+	logger := logging.NewLogger(t.Name(), "Request.Test", "Test_LogOptions")
+
+	// By default, output is plain text, without a diff.
+	sess := newReconcileStackSession(logger, shared.StackSpec{}, nil, namespace)
+	var up optup.Options
+	for _, o := range sess.upLogOptions() {
+		o.ApplyOption(&up)
+	}
+	assert.Equal(t, "never", up.Color)
+	assert.False(t, up.Diff)
+
+	sess.stack.LogOptions = &shared.LogOptions{Color: shared.LogColorAlways, Diff: true}
+	up = optup.Options{}
+	for _, o := range sess.upLogOptions() {
+		o.ApplyOption(&up)
+	}
+	assert.Equal(t, "always", up.Color)
+	assert.True(t, up.Diff)
+
+	var preview optpreview.Options
+	for _, o := range sess.previewLogOptions() {
+		o.ApplyOption(&preview)
+	}
+	assert.Equal(t, "always", preview.Color)
+	assert.True(t, preview.Diff)
+
+	var refresh optrefresh.Options
+	for _, o := range sess.refreshLogOptions() {
+		o.ApplyOption(&refresh)
+	}
+	assert.Equal(t, "always", refresh.Color)
+
+	var destroy optdestroy.Options
+	for _, o := range sess.destroyLogOptions() {
+		o.ApplyOption(&destroy)
+	}
+	assert.Equal(t, "always", destroy.Color)
+}
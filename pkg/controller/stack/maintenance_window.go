@@ -0,0 +1,111 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	// The operator image may not have a time zone database, so use the one embedded in Go.
+	_ "time/tzdata"
+
+	"github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/shared"
+	pulumiv1 "github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/v1"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// maintenanceWindow is a parsed shared.MaintenanceWindow.
+type maintenanceWindow struct {
+	// days is the set of days on which the window opens; if empty, it opens every day.
+	days map[time.Weekday]bool
+	// start and end are minutes since midnight.
+	start, end int
+	loc        *time.Location
+}
+
+// parseMaintenanceWindow checks and parses the maintenance window given in a stack spec.
+func parseMaintenanceWindow(spec *shared.MaintenanceWindow) (*maintenanceWindow, error) {
+	w := &maintenanceWindow{days: map[time.Weekday]bool{}, loc: time.UTC}
+	for _, day := range spec.Days {
+		weekday, ok := weekdays[strings.ToLower(day)]
+		if !ok {
+			return nil, fmt.Errorf("unknown day %q; expected one of Mon, Tue, Wed, Thu, Fri, Sat, Sun", day)
+		}
+		w.days[weekday] = true
+	}
+	var err error
+	if w.start, err = parseTimeOfDay(spec.Start); err != nil {
+		return nil, err
+	}
+	if w.end, err = parseTimeOfDay(spec.End); err != nil {
+		return nil, err
+	}
+	if spec.Timezone != "" {
+		if w.loc, err = time.LoadLocation(spec.Timezone); err != nil {
+			return nil, fmt.Errorf("unknown time zone %q", spec.Timezone)
+		}
+	}
+	return w, nil
+}
+
+// parseTimeOfDay parses "HH:MM" into minutes since midnight.
+func parseTimeOfDay(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q; expected HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// until gives how long it is from now until the window next opens, or zero if it is open now.
+func (w *maintenanceWindow) until(now time.Time) time.Duration {
+	local := now.In(w.loc)
+	duration := w.end - w.start
+	if duration <= 0 {
+		duration += 24 * 60
+	}
+	// A window that opened yesterday may still be open; otherwise, the next opening is within the
+	// coming week.
+	for offset := -1; offset <= 7; offset++ {
+		day := time.Date(local.Year(), local.Month(), local.Day()+offset, 0, 0, 0, 0, w.loc)
+		if len(w.days) > 0 && !w.days[day.Weekday()] {
+			continue
+		}
+		opens := time.Date(day.Year(), day.Month(), day.Day(), w.start/60, w.start%60, 0, 0, w.loc)
+		closes := opens.Add(time.Duration(duration) * time.Minute)
+		if !now.Before(opens) && now.Before(closes) {
+			return 0
+		}
+		if opens.After(now) {
+			return opens.Sub(now)
+		}
+	}
+	// Unreachable, since there's at least one day on which the window opens.
+	return 0
+}
+
+// deferUpdate records that the update of the given commit is waiting for the maintenance window to
+// open, and gives the result for processing the stack again when it does.
+func (r *ReconcileStack) deferUpdate(sess *reconcileStackSession, instance *pulumiv1.Stack, commit string, wait time.Duration) reconcile.Result {
+	opens := time.Now().Add(wait).UTC().Format(time.RFC3339)
+	if instance.Status.PendingCommit != commit {
+		r.emitEvent(instance, pulumiv1.StackDeferredOutsideWindowEvent(),
+			"Update of commit %q deferred until the maintenance window opens at %s.", commit, opens)
+	}
+	sess.logger.Info("Outside maintenance window; deferring update", "Stack.Name", sess.stack.Stack,
+		"Commit", commit, "Opens", opens)
+	instance.Status.PendingCommit = commit
+	instance.Status.MarkReconcilingCondition(pulumiv1.ReconcilingDeferredReason,
+		fmt.Sprintf("update deferred until the maintenance window opens at %s", opens))
+	return reconcile.Result{RequeueAfter: wait}
+}
@@ -0,0 +1,79 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/shared"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ParseMaintenanceWindow(t *testing.T) {
+	w, err := parseMaintenanceWindow(&shared.MaintenanceWindow{
+		Days:     []string{"Mon", "fri"},
+		Start:    "02:00",
+		End:      "04:30",
+		Timezone: "Europe/London",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[time.Weekday]bool{time.Monday: true, time.Friday: true}, w.days)
+	assert.Equal(t, 120, w.start)
+	assert.Equal(t, 270, w.end)
+	assert.Equal(t, "Europe/London", w.loc.String())
+
+	for _, spec := range []shared.MaintenanceWindow{
+		{Days: []string{"Monday"}, Start: "02:00", End: "04:00"},
+		{Start: "2am", End: "04:00"},
+		{Start: "02:00", End: "24:00"},
+		{Start: "02:00", End: "04:00", Timezone: "Nowhere/Special"},
+	} {
+		_, err := parseMaintenanceWindow(&spec)
+		assert.Error(t, err, "%+v", spec)
+	}
+}
+
+func Test_MaintenanceWindowUntil(t *testing.T) {
+	at := func(s string) time.Time {
+		t.Helper()
+		tm, err := time.Parse(time.RFC3339, s)
+		require.NoError(t, err)
+		return tm
+	}
+
+	// 2022-06-06 is a Monday.
+	weekdays, err := parseMaintenanceWindow(&shared.MaintenanceWindow{
+		Days:  []string{"Mon", "Tue", "Wed", "Thu", "Fri"},
+		Start: "02:00",
+		End:   "04:00",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(0), weekdays.until(at("2022-06-06T02:00:00Z")))
+	assert.Equal(t, time.Duration(0), weekdays.until(at("2022-06-06T03:59:59Z")))
+	assert.Equal(t, 22*time.Hour, weekdays.until(at("2022-06-06T04:00:00Z")))
+	assert.Equal(t, time.Hour, weekdays.until(at("2022-06-06T01:00:00Z")))
+	// Friday after the window, to Monday.
+	assert.Equal(t, 2*24*time.Hour+22*time.Hour, weekdays.until(at("2022-06-10T04:00:00Z")))
+
+	overnight, err := parseMaintenanceWindow(&shared.MaintenanceWindow{
+		Days:  []string{"Sat"},
+		Start: "22:00",
+		End:   "02:00",
+	})
+	require.NoError(t, err)
+	// Still open early on Sunday, since it opened on Saturday.
+	assert.Equal(t, time.Duration(0), overnight.until(at("2022-06-12T01:00:00Z")))
+	assert.Equal(t, 6*24*time.Hour+20*time.Hour, overnight.until(at("2022-06-12T02:00:00Z")))
+
+	zoned, err := parseMaintenanceWindow(&shared.MaintenanceWindow{
+		Start:    "02:00",
+		End:      "04:00",
+		Timezone: "America/New_York",
+	})
+	require.NoError(t, err)
+	// 02:00 in New York in June is 06:00 UTC.
+	assert.Equal(t, time.Duration(0), zoned.until(at("2022-06-06T06:30:00Z")))
+	assert.Equal(t, time.Hour, zoned.until(at("2022-06-06T05:00:00Z")))
+}
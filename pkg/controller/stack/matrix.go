@@ -0,0 +1,204 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/shared"
+	pulumiv1 "github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// matrixParentLabel is put on each Stack object generated for a variant in a matrix, with the
+// name of the Stack object it was generated from, so they can be found again.
+const matrixParentLabel = "pulumi.com/matrix-parent"
+
+// validateMatrix checks that the variants in a matrix can each be given a Stack object.
+func validateMatrix(matrix []shared.MatrixVariant) error {
+	seen := map[string]bool{}
+	for _, v := range matrix {
+		if v.StackSuffix == "" {
+			return errors.New("each variant must give a stackSuffix")
+		}
+		if seen[v.StackSuffix] {
+			return fmt.Errorf("the stackSuffix %q is used by more than one variant", v.StackSuffix)
+		}
+		seen[v.StackSuffix] = true
+	}
+	return nil
+}
+
+// variantConflictError is returned when the name for a variant's Stack object is taken by one
+// not generated from the parent.
+type variantConflictError struct {
+	name string
+}
+
+func (e *variantConflictError) Error() string {
+	return fmt.Sprintf("a Stack named %q already exists, and was not generated for this matrix", e.name)
+}
+
+// variantName gives the name of the Stack object generated for a variant.
+func variantName(parent string, v shared.MatrixVariant) string {
+	return parent + "-" + v.StackSuffix
+}
+
+// variantLabels gives the labels of the Stack object generated for a variant: the parent's, so
+// that anything selecting the parent by label (e.g., a StackControl) selects the variants too, and
+// the label naming the parent.
+func variantLabels(parentLabels map[string]string, parent string) map[string]string {
+	labels := make(map[string]string, len(parentLabels)+1)
+	for k, v := range parentLabels {
+		labels[k] = v
+	}
+	labels[matrixParentLabel] = parent
+	return labels
+}
+
+// variantSpec gives the spec of the Stack object generated for a variant: the parent's spec, less
+// the matrix, for the suffixed stack, with the variant's configuration merged over the parent's.
+func variantSpec(spec shared.StackSpec, v shared.MatrixVariant) shared.StackSpec {
+	spec.Matrix = nil
+	spec.Stack = spec.Stack + "-" + v.StackSuffix
+	if len(v.Config) > 0 {
+		config := make(map[string]string, len(spec.Config)+len(v.Config))
+		for k, val := range spec.Config {
+			config[k] = val
+		}
+		for k, val := range v.Config {
+			config[k] = val
+		}
+		spec.Config = config
+	}
+	if len(v.SecretRefs) > 0 {
+		refs := make(map[string]shared.ResourceRef, len(spec.SecretRefs)+len(v.SecretRefs))
+		for k, ref := range spec.SecretRefs {
+			refs[k] = ref
+		}
+		for k, ref := range v.SecretRefs {
+			refs[k] = ref
+		}
+		spec.SecretRefs = refs
+	}
+	return spec
+}
+
+// variantStatus summarizes the status of a Stack object generated for a variant.
+func variantStatus(child *pulumiv1.Stack) pulumiv1.VariantStatus {
+	status := pulumiv1.VariantStatus{
+		Name:  child.Name,
+		Stack: child.Spec.Stack,
+		// A generated object whose spec has changed is not ready until it has been processed again.
+		Ready: child.Status.ObservedGeneration == child.Generation &&
+			apimeta.IsStatusConditionTrue(child.Status.Conditions, pulumiv1.ReadyCondition),
+	}
+	if last := child.Status.LastUpdate; last != nil {
+		status.State = last.State
+		status.LastSuccessfulCommit = last.LastSuccessfulCommit
+	}
+	return status
+}
+
+// reconcileMatrix processes a Stack object with a matrix, by creating or updating a Stack object
+// for each variant, deleting those generated for variants no longer listed, and reporting on
+// them in the status. The generated objects are processed as any other stack; since they are
+// owned by this object, a change to their status has this object processed again.
+func (r *ReconcileStack) reconcileMatrix(ctx context.Context, sess *reconcileStackSession, instance *pulumiv1.Stack) (reconcile.Result, error) {
+	reqLogger := sess.logger
+
+	// The generated objects are garbage collected once this object is deleted, and are
+	// finalized themselves; so there's nothing to do here, except to let this object go if it
+	// was given a finalizer before it had a matrix.
+	if instance.GetDeletionTimestamp() != nil {
+		err := sess.removeFinalizerAndUpdate(ctx, instance)
+		if err != nil {
+			reqLogger.Error(err, "Failed to delete Pulumi finalizer", "Stack.Name", instance.Spec.Stack)
+		}
+		return reconcile.Result{}, err
+	}
+
+	if err := validateMatrix(instance.Spec.Matrix); err != nil {
+		msg := fmt.Sprintf("Stack CustomResource has an invalid 'matrix': %s.", err)
+		r.emitEvent(instance, pulumiv1.StackConfigInvalidEvent(), msg)
+		reqLogger.Info(msg)
+		r.markStackFailed(sess, instance, shared.StackOperationUpdate, errors.New(msg), "", "")
+		instance.Status.MarkStalledCondition(pulumiv1.StalledSpecInvalidReason, msg)
+		return reconcile.Result{}, nil
+	}
+
+	wanted := map[string]bool{}
+	var variants []pulumiv1.VariantStatus
+	for _, v := range instance.Spec.Matrix {
+		child := &pulumiv1.Stack{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      variantName(instance.Name, v),
+				Namespace: instance.Namespace,
+			},
+		}
+		op, err := controllerutil.CreateOrUpdate(ctx, r.client, child, func() error {
+			// An existing object not controlled by this one belongs to someone else, so must not be
+			// taken over.
+			if child.ResourceVersion != "" && !metav1.IsControlledBy(child, instance) {
+				return &variantConflictError{name: child.Name}
+			}
+			child.Labels = variantLabels(instance.Labels, instance.Name)
+			child.Spec = variantSpec(instance.Spec, v)
+			return controllerutil.SetControllerReference(instance, child, r.scheme)
+		})
+		var conflict *variantConflictError
+		if errors.As(err, &conflict) {
+			msg := fmt.Sprintf("Stack CustomResource has an invalid 'matrix': %s.", err)
+			r.emitEvent(instance, pulumiv1.StackConfigInvalidEvent(), msg)
+			reqLogger.Info(msg)
+			r.markStackFailed(sess, instance, shared.StackOperationUpdate, errors.New(msg), "", "")
+			instance.Status.MarkStalledCondition(pulumiv1.StalledSpecInvalidReason, msg)
+			return reconcile.Result{}, nil
+		}
+		if err != nil {
+			return reconcile.Result{}, errors.Wrapf(err, "creating or updating the Stack for variant %q", v.StackSuffix)
+		}
+		if op != controllerutil.OperationResultNone {
+			reqLogger.Info("Generated Stack for variant", "Stack.Name", child.Spec.Stack, "Operation", op)
+		}
+		wanted[child.Name] = true
+		variants = append(variants, variantStatus(child))
+	}
+
+	var children pulumiv1.StackList
+	if err := r.client.List(ctx, &children, client.InNamespace(instance.Namespace),
+		client.MatchingLabels{matrixParentLabel: instance.Name}); err != nil {
+		return reconcile.Result{}, errors.Wrap(err, "listing the Stacks generated for variants")
+	}
+	for i := range children.Items {
+		child := &children.Items[i]
+		if wanted[child.Name] || !metav1.IsControlledBy(child, instance) {
+			continue
+		}
+		reqLogger.Info("Deleting Stack for variant no longer in matrix", "Stack.Name", child.Spec.Stack)
+		if err := r.client.Delete(ctx, child, client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+			return reconcile.Result{}, errors.Wrapf(err, "deleting the Stack %q", child.Name)
+		}
+	}
+
+	instance.Status.Variants = variants
+	notReady := 0
+	for _, v := range variants {
+		if !v.Ready {
+			notReady++
+		}
+	}
+	if notReady > 0 {
+		instance.Status.MarkReconcilingCondition(pulumiv1.ReconcilingVariantsReason,
+			fmt.Sprintf("waiting for %d of %d variants to be ready", notReady, len(variants)))
+		return reconcile.Result{}, nil
+	}
+	instance.Status.MarkReadyCondition()
+	return reconcile.Result{}, nil
+}
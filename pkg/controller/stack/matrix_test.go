@@ -0,0 +1,171 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/shared"
+	pulumiv1 "github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/v1"
+	"github.com/pulumi/pulumi-kubernetes-operator/pkg/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func Test_VariantSpec(t *testing.T) {
+	parent := shared.StackSpec{
+		Stack:  "acme/app",
+		Config: map[string]string{"app:replicas": "1", "aws:region": "us-west-2"},
+		SecretRefs: map[string]shared.ResourceRef{
+			"app:token": shared.NewLiteralResourceRef("parent"),
+		},
+		Matrix: []shared.MatrixVariant{{StackSuffix: "east"}},
+	}
+	variant := shared.MatrixVariant{
+		StackSuffix: "east",
+		Config:      map[string]string{"aws:region": "us-east-1"},
+		SecretRefs: map[string]shared.ResourceRef{
+			"app:key": shared.NewLiteralResourceRef("variant"),
+		},
+	}
+
+	spec := variantSpec(parent, variant)
+	assert.Equal(t, "acme/app-east", spec.Stack)
+	assert.Nil(t, spec.Matrix)
+	assert.Equal(t, map[string]string{"app:replicas": "1", "aws:region": "us-east-1"}, spec.Config)
+	assert.Equal(t, map[string]shared.ResourceRef{
+		"app:token": shared.NewLiteralResourceRef("parent"),
+		"app:key":   shared.NewLiteralResourceRef("variant"),
+	}, spec.SecretRefs)
+
+	// The parent's spec is left as it was.
+	assert.Equal(t, "acme/app", parent.Stack)
+	assert.Equal(t, "us-west-2", parent.Config["aws:region"])
+	assert.Len(t, parent.SecretRefs, 1)
+	assert.Len(t, parent.Matrix, 1)
+
+	assert.Equal(t, "app-east", variantName("app", variant))
+}
+
+func Test_ValidateMatrix(t *testing.T) {
+	assert.NoError(t, validateMatrix([]shared.MatrixVariant{{StackSuffix: "east"}, {StackSuffix: "west"}}))
+	assert.Error(t, validateMatrix([]shared.MatrixVariant{{StackSuffix: ""}}))
+	assert.Error(t, validateMatrix([]shared.MatrixVariant{{StackSuffix: "east"}, {StackSuffix: "east"}}))
+}
+
+func Test_VariantStatus(t *testing.T) {
+	child := &pulumiv1.Stack{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-east", Generation: 2},
+		Spec:       shared.StackSpec{Stack: "acme/app-east"},
+	}
+	child.Status.ObservedGeneration = 2
+	child.Status.LastUpdate = &shared.StackUpdateState{
+		State:                shared.SucceededStackStateMessage,
+		LastSuccessfulCommit: "abc123",
+	}
+	child.Status.MarkReadyCondition()
+
+	assert.Equal(t, pulumiv1.VariantStatus{
+		Name:                 "app-east",
+		Stack:                "acme/app-east",
+		Ready:                true,
+		State:                shared.SucceededStackStateMessage,
+		LastSuccessfulCommit: "abc123",
+	}, variantStatus(child))
+
+	// A spec not yet processed is not ready, whatever the conditions say.
+	child.Generation = 3
+	assert.False(t, variantStatus(child).Ready)
+}
+
+func Test_ReconcileMatrixDoesNotAdopt(t *testing.T) {
+	s := runtime.NewScheme()
+	require.NoError(t, pulumiv1.SchemeBuilder.AddToScheme(s))
+	parent := &pulumiv1.Stack{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: namespace, UID: "parent-uid"},
+		Spec: shared.StackSpec{
+			Stack:  "acme/app",
+			Matrix: []shared.MatrixVariant{{StackSuffix: "east"}, {StackSuffix: "west"}},
+		},
+	}
+	// A Stack the user made themselves, which happens to have the name of a variant's.
+	own := &pulumiv1.Stack{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-west", Namespace: namespace},
+		Spec:       shared.StackSpec{Stack: "acme/mine"},
+	}
+	client := fake.NewFakeClientWithScheme(s, parent, own)
+	r := &ReconcileStack{client: client, scheme: s, recorder: record.NewFakeRecorder(10)}
+	logger := logging.NewLogger(t.Name(), "Request.Test", "Test_ReconcileMatrixDoesNotAdopt")
+	sess := newReconcileStackSession(logger, parent.Spec, client, namespace)
+
+	_, err := r.reconcileMatrix(context.TODO(), sess, parent)
+	require.NoError(t, err)
+	assert.True(t, apimeta.IsStatusConditionTrue(parent.Status.Conditions, pulumiv1.StalledCondition))
+	assert.Contains(t, apimeta.FindStatusCondition(parent.Status.Conditions, pulumiv1.StalledCondition).Message, `"app-west"`)
+
+	var got pulumiv1.Stack
+	require.NoError(t, client.Get(context.TODO(), types.NamespacedName{Namespace: namespace, Name: "app-west"}, &got))
+	assert.Equal(t, "acme/mine", got.Spec.Stack, "the user's Stack is left alone")
+	assert.Empty(t, got.OwnerReferences)
+
+	// A Stack generated for the matrix is updated as usual.
+	require.NoError(t, client.Get(context.TODO(), types.NamespacedName{Namespace: namespace, Name: "app-east"}, &got))
+	assert.True(t, metav1.IsControlledBy(&got, parent))
+	parent.Spec.Matrix = parent.Spec.Matrix[:1]
+	parent.Spec.Config = map[string]string{"app:replicas": "2"}
+	sess = newReconcileStackSession(logger, parent.Spec, client, namespace)
+	_, err = r.reconcileMatrix(context.TODO(), sess, parent)
+	require.NoError(t, err)
+	require.NoError(t, client.Get(context.TODO(), types.NamespacedName{Namespace: namespace, Name: "app-east"}, &got))
+	assert.Equal(t, "2", got.Spec.Config["app:replicas"])
+}
+
+func Test_ReconcileMatrixCopiesLabels(t *testing.T) {
+	s := runtime.NewScheme()
+	require.NoError(t, pulumiv1.SchemeBuilder.AddToScheme(s))
+	parent := &pulumiv1.Stack{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "app", Namespace: namespace, UID: "parent-uid",
+			Labels: map[string]string{"team": "payments"},
+		},
+		Spec: shared.StackSpec{
+			Stack:  "acme/app",
+			Matrix: []shared.MatrixVariant{{StackSuffix: "east"}},
+		},
+	}
+	client := fake.NewFakeClientWithScheme(s, parent)
+	r := &ReconcileStack{client: client, scheme: s, recorder: record.NewFakeRecorder(10)}
+	logger := logging.NewLogger(t.Name(), "Request.Test", "Test_ReconcileMatrixCopiesLabels")
+
+	_, err := r.reconcileMatrix(context.TODO(), newReconcileStackSession(logger, parent.Spec, client, namespace), parent)
+	require.NoError(t, err)
+
+	// A StackControl selecting the parent by label selects the variant too.
+	var selected pulumiv1.StackList
+	require.NoError(t, client.List(context.TODO(), &selected, &ctrlclient.ListOptions{
+		Namespace:     namespace,
+		LabelSelector: labels.SelectorFromSet(labels.Set{"team": "payments"}),
+	}))
+	var names []string
+	for _, stack := range selected.Items {
+		names = append(names, stack.Name)
+	}
+	assert.ElementsMatch(t, []string{"app", "app-east"}, names)
+
+	// A label removed from the parent is removed from the variant.
+	parent.Labels = map[string]string{"team": "billing"}
+	_, err = r.reconcileMatrix(context.TODO(), newReconcileStackSession(logger, parent.Spec, client, namespace), parent)
+	require.NoError(t, err)
+	var child pulumiv1.Stack
+	require.NoError(t, client.Get(context.TODO(), types.NamespacedName{Namespace: namespace, Name: "app-east"}, &child))
+	assert.Equal(t, map[string]string{"team": "billing", matrixParentLabel: "app"}, child.Labels)
+}
@@ -0,0 +1,48 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"strings"
+
+	pulumiv1 "github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/v1"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// controlAnnotationsChanged passes updates to a Stack which change the annotations used to pause
+// it or to request that it is processed again. These don't change the generation, so would
+// otherwise be filtered out.
+var controlAnnotationsChanged = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		if e.ObjectOld == nil || e.ObjectNew == nil {
+			return false
+		}
+		return !equalStrings(controlAnnotations(e.ObjectOld.GetAnnotations()),
+			controlAnnotations(e.ObjectNew.GetAnnotations()))
+	},
+}
+
+// controlAnnotations picks out the annotations used to pause a stack, or request that it is
+// processed again.
+func controlAnnotations(annotations map[string]string) map[string]string {
+	control := map[string]string{}
+	for k, v := range annotations {
+		if k == pulumiv1.ReconcileRequestAnnotation || strings.HasPrefix(k, pulumiv1.PausedAnnotationPrefix) {
+			control[k] = v
+		}
+	}
+	return control
+}
+
+func equalStrings(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
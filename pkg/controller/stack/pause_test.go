@@ -0,0 +1,31 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"testing"
+
+	pulumiv1 "github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/v1"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func Test_ControlAnnotationsChanged(t *testing.T) {
+	stack := func(annotations map[string]string) *pulumiv1.Stack {
+		return &pulumiv1.Stack{ObjectMeta: metav1.ObjectMeta{Name: "app", Annotations: annotations}}
+	}
+	changed := func(old, new map[string]string) bool {
+		return controlAnnotationsChanged.Update(event.UpdateEvent{ObjectOld: stack(old), ObjectNew: stack(new)})
+	}
+
+	assert.True(t, changed(nil, map[string]string{"paused.pulumi.com/migration": "true"}))
+	assert.True(t, changed(map[string]string{"paused.pulumi.com/migration": "true"}, nil))
+	assert.True(t, changed(
+		map[string]string{pulumiv1.ReconcileRequestAnnotation: "1"},
+		map[string]string{pulumiv1.ReconcileRequestAnnotation: "2"}))
+	assert.False(t, changed(
+		map[string]string{pulumiv1.ReconcileRequestAnnotation: "1"},
+		map[string]string{pulumiv1.ReconcileRequestAnnotation: "1", "example.com/other": "x"}))
+	assert.False(t, changed(nil, map[string]string{"example.com/other": "x"}))
+}
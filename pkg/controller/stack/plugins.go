@@ -0,0 +1,94 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/shared"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+)
+
+// pluginInstallError is returned when a plugin listed in the spec couldn't be installed.
+type pluginInstallError struct {
+	plugin shared.PluginSpec
+	server string
+	err    error
+}
+
+func (e *pluginInstallError) Error() string {
+	from := ""
+	if e.server != "" {
+		from = " from " + e.server
+	}
+	return fmt.Sprintf("installing plugin %s %s%s: %s", e.plugin.Name, e.plugin.Version, from, e.err.Error())
+}
+
+func (e *pluginInstallError) Unwrap() error {
+	return e.err
+}
+
+// validatePluginServer checks that a plugin download server is an absolute http(s) URL.
+func validatePluginServer(server string) error {
+	u, err := url.Parse(server)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.Errorf("%q is not an http(s) URL", server)
+	}
+	return nil
+}
+
+// validatePluginServers checks the plugin download servers given in the spec, returning an error
+// for the first which is invalid.
+func validatePluginServers(spec shared.StackSpec) error {
+	if spec.PluginDownloadURL != "" {
+		if err := validatePluginServer(spec.PluginDownloadURL); err != nil {
+			return errors.Wrap(err, "pluginDownloadURL")
+		}
+	}
+	for _, plugin := range spec.Plugins {
+		if plugin.Server == "" {
+			continue
+		}
+		if err := validatePluginServer(plugin.Server); err != nil {
+			return errors.Wrapf(err, "server for plugin %s", plugin.Name)
+		}
+	}
+	return nil
+}
+
+// pluginServer gives the server from which to download a plugin: its own, or else the one given
+// for all plugins. If neither is given, it's empty, and the plugin is downloaded as usual.
+func pluginServer(spec shared.StackSpec, plugin shared.PluginSpec) string {
+	if plugin.Server != "" {
+		return plugin.Server
+	}
+	return spec.PluginDownloadURL
+}
+
+// InstallPlugins installs the plugins listed in the spec into the workspace's plugin cache, each
+// from its download server, so that running the program doesn't download them from elsewhere.
+func (sess *reconcileStackSession) InstallPlugins(ctx context.Context, w auto.Workspace) error {
+	for _, plugin := range sess.stack.Plugins {
+		server := pluginServer(sess.stack, plugin)
+		args := []string{"plugin", "install", "resource", plugin.Name, plugin.Version}
+		if server != "" {
+			args = append(args, "--server", server)
+		}
+		cmd := exec.CommandContext(ctx, "pulumi", args...)
+		if _, stderr, err := sess.runCmd("Pulumi Plugin Install", cmd, w); err != nil {
+			if out := strings.TrimSpace(stderr); out != "" {
+				err = errors.Errorf("%s: %s", err.Error(), out)
+			}
+			return &pluginInstallError{plugin: plugin, server: server, err: err}
+		}
+	}
+	return nil
+}
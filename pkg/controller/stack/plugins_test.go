@@ -0,0 +1,40 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"testing"
+
+	"github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/shared"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_PluginServer(t *testing.T) {
+	aws := shared.PluginSpec{Name: "aws", Version: "v5.10.0"}
+	mirrored := shared.PluginSpec{Name: "random", Version: "v4.8.2", Server: "https://mirror.internal/random"}
+
+	spec := shared.StackSpec{}
+	assert.Equal(t, "", pluginServer(spec, aws))
+	assert.Equal(t, "https://mirror.internal/random", pluginServer(spec, mirrored))
+
+	spec.PluginDownloadURL = "https://mirror.internal/plugins"
+	assert.Equal(t, "https://mirror.internal/plugins", pluginServer(spec, aws))
+	assert.Equal(t, "https://mirror.internal/random", pluginServer(spec, mirrored))
+}
+
+func Test_ValidatePluginServers(t *testing.T) {
+	assert.NoError(t, validatePluginServers(shared.StackSpec{}))
+	assert.NoError(t, validatePluginServers(shared.StackSpec{
+		PluginDownloadURL: "https://mirror.internal/plugins",
+		Plugins:           []shared.PluginSpec{{Name: "aws", Version: "v5.10.0", Server: "http://mirror.internal:8080/aws"}},
+	}))
+
+	for _, spec := range []shared.StackSpec{
+		{PluginDownloadURL: "mirror.internal/plugins"},
+		{PluginDownloadURL: "ftp://mirror.internal/plugins"},
+		{PluginDownloadURL: "https://"},
+		{Plugins: []shared.PluginSpec{{Name: "aws", Version: "v5.10.0", Server: "s3://bucket/plugins"}}},
+	} {
+		assert.Error(t, validatePluginServers(spec), "%+v", spec)
+	}
+}
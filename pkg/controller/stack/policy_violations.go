@@ -0,0 +1,67 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"strings"
+	"time"
+
+	pulumiv1 "github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/events"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+)
+
+const (
+	// maxReportedPolicyViolations is the most policy violations recorded in the status, so that an
+	// update which violates a policy for many resources doesn't make the status too large.
+	maxReportedPolicyViolations = 50
+	// policyEventsWait is how long to wait for the engine events of a failed update to be
+	// drained. The automation API closes the event stream once the update has run, but not if the
+	// update failed before it started, so the wait is bounded.
+	policyEventsWait = 10 * time.Second
+	// mandatoryEnforcement is the enforcement level of a policy which blocks an update when
+	// violated, as opposed to one which only warns.
+	mandatoryEnforcement = "mandatory"
+)
+
+// collectPolicyViolations gives a channel for an update's engine events, and a func which returns
+// the violations of mandatory policies reported by the update. The func waits for the channel to
+// be closed, and gives nil if it isn't closed in good time.
+func collectPolicyViolations() (chan<- events.EngineEvent, func() []pulumiv1.PolicyViolation) {
+	engineEvents := make(chan events.EngineEvent)
+	var violations []pulumiv1.PolicyViolation
+	done := make(chan struct{})
+	go func() {
+		for event := range engineEvents {
+			if event.PolicyEvent != nil && event.PolicyEvent.EnforcementLevel == mandatoryEnforcement {
+				violations = append(violations, policyViolation(*event.PolicyEvent))
+			}
+		}
+		close(done)
+	}()
+	return engineEvents, func() []pulumiv1.PolicyViolation {
+		select {
+		case <-done:
+		case <-time.After(policyEventsWait):
+			return nil
+		}
+		if len(violations) > maxReportedPolicyViolations {
+			violations = violations[:maxReportedPolicyViolations]
+		}
+		return violations
+	}
+}
+
+// policyViolation gives the status record of the violation reported by a policy event.
+func policyViolation(event apitype.PolicyEvent) pulumiv1.PolicyViolation {
+	pack := event.PolicyPackName
+	if event.PolicyPackVersion != "" {
+		pack += "@" + event.PolicyPackVersion
+	}
+	return pulumiv1.PolicyViolation{
+		PolicyPack: pack,
+		Rule:       event.PolicyName,
+		Resource:   event.ResourceURN,
+		Message:    strings.TrimSpace(event.Message),
+	}
+}
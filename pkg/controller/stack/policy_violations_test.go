@@ -0,0 +1,57 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"testing"
+
+	pulumiv1 "github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/events"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_CollectPolicyViolations(t *testing.T) {
+	engineEvents, violations := collectPolicyViolations()
+	engineEvents <- events.EngineEvent{EngineEvent: apitype.EngineEvent{
+		DiagnosticEvent: &apitype.DiagnosticEvent{Message: "error: preview failed"},
+	}}
+	engineEvents <- events.EngineEvent{EngineEvent: apitype.EngineEvent{
+		PolicyEvent: &apitype.PolicyEvent{
+			ResourceURN:       "urn:pulumi:dev::proj::aws:s3/bucket:Bucket::logs",
+			Message:           "Buckets must not be public.\n",
+			PolicyName:        "s3-no-public-read",
+			PolicyPackName:    "aws-compliance",
+			PolicyPackVersion: "1.2.0",
+			EnforcementLevel:  "mandatory",
+		},
+	}}
+	engineEvents <- events.EngineEvent{EngineEvent: apitype.EngineEvent{
+		PolicyEvent: &apitype.PolicyEvent{
+			ResourceURN:      "urn:pulumi:dev::proj::aws:s3/bucket:Bucket::logs",
+			Message:          "Buckets should be tagged.",
+			PolicyName:       "s3-tagged",
+			PolicyPackName:   "aws-compliance",
+			EnforcementLevel: "advisory",
+		},
+	}}
+	close(engineEvents)
+
+	assert.Equal(t, []pulumiv1.PolicyViolation{{
+		PolicyPack: "aws-compliance@1.2.0",
+		Rule:       "s3-no-public-read",
+		Resource:   "urn:pulumi:dev::proj::aws:s3/bucket:Bucket::logs",
+		Message:    "Buckets must not be public.",
+	}}, violations())
+}
+
+func Test_CollectPolicyViolationsCapped(t *testing.T) {
+	engineEvents, violations := collectPolicyViolations()
+	for i := 0; i < maxReportedPolicyViolations+10; i++ {
+		engineEvents <- events.EngineEvent{EngineEvent: apitype.EngineEvent{
+			PolicyEvent: &apitype.PolicyEvent{PolicyName: "no-public", EnforcementLevel: "mandatory"},
+		}}
+	}
+	close(engineEvents)
+	assert.Len(t, violations(), maxReportedPolicyViolations)
+}
@@ -0,0 +1,89 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/shared"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+)
+
+const (
+	// defaultPreprocessTimeout is how long the SourcePreprocess command may run if the spec
+	// doesn't say.
+	defaultPreprocessTimeout = 5 * time.Minute
+	// maxPreprocessErrorOutput is the most of the command's error output kept in the error, so
+	// that the status and events stay readable.
+	maxPreprocessErrorOutput = 1024
+)
+
+// sourcePreprocessError is returned when the command given by SourcePreprocess fails.
+type sourcePreprocessError struct {
+	command string
+	err     error
+}
+
+func (e *sourcePreprocessError) Error() string {
+	return fmt.Sprintf("source preprocessing command %q failed: %s", e.command, e.err.Error())
+}
+
+func (e *sourcePreprocessError) Unwrap() error {
+	return e.err
+}
+
+// preprocessTimeout gives how long the SourcePreprocess command may run.
+func preprocessTimeout(preprocess *shared.SourcePreprocess) time.Duration {
+	if preprocess.TimeoutSeconds > 0 {
+		return time.Duration(preprocess.TimeoutSeconds) * time.Second
+	}
+	return defaultPreprocessTimeout
+}
+
+// PreprocessSource runs the command given by SourcePreprocess, if any, in the project directory,
+// and writes what it prints to the output path, if given.
+func (sess *reconcileStackSession) PreprocessSource(ctx context.Context, w auto.Workspace) error {
+	preprocess := sess.stack.SourcePreprocess
+	if preprocess == nil {
+		return nil
+	}
+	if len(preprocess.Command) == 0 {
+		return &sourcePreprocessError{err: errors.New("no command given")}
+	}
+	command := strings.Join(preprocess.Command, " ")
+	var dest string
+	if preprocess.OutputPath != "" {
+		var err error
+		if dest, err = workspaceFilePath(sess.workdir, preprocess.OutputPath); err != nil {
+			return &sourcePreprocessError{command: command, err: err}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, preprocessTimeout(preprocess))
+	defer cancel()
+	cmd := exec.CommandContext(ctx, preprocess.Command[0], preprocess.Command[1:]...)
+	stdout, stderr, err := sess.runStackCmd("Source Preprocess", cmd, w)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			err = errors.Errorf("timed out after %s", preprocessTimeout(preprocess))
+		} else if out := strings.TrimSpace(stderr); out != "" {
+			if len(out) > maxPreprocessErrorOutput {
+				out = "..." + out[len(out)-maxPreprocessErrorOutput:]
+			}
+			err = errors.Errorf("%s: %s", err.Error(), out)
+		}
+		return &sourcePreprocessError{command: command, err: err}
+	}
+
+	if dest != "" {
+		if err := replaceWorkspaceFile(sess.workdir, dest, []byte(stdout)); err != nil {
+			return &sourcePreprocessError{command: command, err: errors.Wrapf(err, "output %q", preprocess.OutputPath)}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/shared"
+	"github.com/pulumi/pulumi-kubernetes-operator/pkg/logging"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/workspace"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// envWorkspace is a workspace in the directory given, with the environment variables given, and
+// no stack settings.
+type envWorkspace struct {
+	auto.Workspace
+	dir  string
+	envs map[string]string
+}
+
+func (w *envWorkspace) WorkDir() string {
+	return w.dir
+}
+
+func (w *envWorkspace) GetEnvVars() map[string]string {
+	return w.envs
+}
+
+func (w *envWorkspace) SetEnvVar(key, value string) {
+	w.envs[key] = value
+}
+
+func (w *envWorkspace) StackSettings(context.Context, string) (*workspace.ProjectStack, error) {
+	return nil, errors.New("no stack settings")
+}
+
+func Test_PreprocessSource(t *testing.T) {
+	logger := logging.NewLogger(t.Name(), "Request.Test", "PreprocessSource")
+	dir := t.TempDir()
+	w := &envWorkspace{dir: dir, envs: map[string]string{"STACK_ENV": "prod"}}
+	sess := newReconcileStackSession(logger, shared.StackSpec{
+		SourcePreprocess: &shared.SourcePreprocess{
+			Command:    []string{"sh", "-c", `echo "config:" && echo "  app:env: $STACK_ENV"`},
+			OutputPath: "Pulumi.dev.yaml",
+		},
+	}, nil, namespace)
+	sess.workdir = dir
+
+	require.NoError(t, sess.PreprocessSource(context.TODO(), w))
+	contents, err := os.ReadFile(filepath.Join(dir, "Pulumi.dev.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, "config:\n  app:env: prod\n", string(contents))
+
+	// A failing command gives an error with what it printed.
+	sess.stack.SourcePreprocess = &shared.SourcePreprocess{Command: []string{"sh", "-c", "echo 'no kustomization.yaml' >&2; exit 1"}}
+	err = sess.PreprocessSource(context.TODO(), w)
+	var preprocessFailed *sourcePreprocessError
+	require.True(t, errors.As(err, &preprocessFailed))
+	assert.Contains(t, err.Error(), "no kustomization.yaml")
+
+	// The output must go within the project directory.
+	sess.stack.SourcePreprocess = &shared.SourcePreprocess{Command: []string{"true"}, OutputPath: "../Pulumi.dev.yaml"}
+	err = sess.PreprocessSource(context.TODO(), w)
+	require.True(t, errors.As(err, &preprocessFailed))
+
+	sess.stack.SourcePreprocess = &shared.SourcePreprocess{Command: []string{"sleep", "5"}, TimeoutSeconds: 1}
+	err = sess.PreprocessSource(context.TODO(), w)
+	require.True(t, errors.As(err, &preprocessFailed))
+	assert.Contains(t, err.Error(), "timed out")
+}
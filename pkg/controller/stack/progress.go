@@ -0,0 +1,120 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/shared"
+	pulumiv1 "github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/events"
+)
+
+// progressReportInterval is the least time between writes of an update's progress to the stack
+// status.
+const progressReportInterval = 5 * time.Second
+
+// updateProgress counts the resource operations of an update as they start and finish.
+type updateProgress struct {
+	// expected is the number of operations the update is expected to run.
+	expected int
+	started  int
+	finished int
+}
+
+// observe accounts for an engine event from the update.
+func (p *updateProgress) observe(event events.EngineEvent) {
+	switch {
+	case event.ResourcePreEvent != nil:
+		p.started++
+	case event.ResOutputsEvent != nil, event.ResOpFailedEvent != nil:
+		p.finished++
+	}
+}
+
+// percent gives the proportion of operations finished, as a percentage. The number expected is a
+// guess, so it's revised to the number started if that's larger, and 100% is left for when the
+// update has actually finished.
+func (p *updateProgress) percent() int64 {
+	total := p.expected
+	if p.started > total {
+		total = p.started
+	}
+	if total == 0 {
+		return 0
+	}
+	percent := int64(p.finished * 100 / total)
+	if percent > 99 {
+		percent = 99
+	}
+	return percent
+}
+
+// trackUpdateProgress consumes the engine events of an update, and calls report with the progress
+// whenever it has changed, at most once per interval. It gives the channel for the update's events,
+// and a func to call when the update has returned, after which no more progress is reported.
+func trackUpdateProgress(expected int, interval time.Duration, report func(int64)) (chan<- events.EngineEvent, func()) {
+	engineEvents := make(chan events.EngineEvent)
+	var mu sync.Mutex
+	stopped := false
+
+	// The automation API closes the channel once the update has run; but not if it fails before
+	// running it, so the stop func doesn't wait for that.
+	go func() {
+		progress := updateProgress{expected: expected}
+		var reported int64
+		var lastReport time.Time
+		for event := range engineEvents {
+			progress.observe(event)
+			if percent := progress.percent(); percent != reported && time.Since(lastReport) >= interval {
+				mu.Lock()
+				if !stopped {
+					report(percent)
+				}
+				mu.Unlock()
+				reported, lastReport = percent, time.Now()
+			}
+		}
+	}()
+	return engineEvents, func() {
+		mu.Lock()
+		stopped = true
+		mu.Unlock()
+	}
+}
+
+// expectedOperations estimates the number of resource operations an update will run, from the
+// number of resources in the stack's state. It gives zero if the state can't be read.
+func (sess *reconcileStackSession) expectedOperations(ctx context.Context) int {
+	state, err := sess.autoStack.Export(ctx)
+	if err != nil {
+		sess.logger.Debug("Could not export stack state to estimate update progress", "Stack.Name", sess.stack.Stack, "Error", err.Error())
+		return 0
+	}
+	var deployment struct {
+		Resources []json.RawMessage `json:"resources"`
+	}
+	if err := json.Unmarshal(state.Deployment, &deployment); err != nil {
+		return 0
+	}
+	return len(deployment.Resources)
+}
+
+// progressReporter gives a func which records the progress of an update in the stack's status.
+// The progress is written to a copy of the stack, so that it's cleared from the status once the
+// update has finished and the status is saved.
+func (sess *reconcileStackSession) progressReporter(ctx context.Context, instance *pulumiv1.Stack) func(int64) {
+	return func(percent int64) {
+		stack := instance.DeepCopy()
+		if stack.Status.LastUpdate == nil {
+			stack.Status.LastUpdate = &shared.StackUpdateState{}
+		}
+		stack.Status.LastUpdate.Progress = percent
+		if err := sess.patchStatus(ctx, stack); err != nil {
+			sess.logger.Debug("Could not record update progress", "Stack.Name", sess.stack.Stack, "Error", err.Error())
+		}
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/events"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_UpdateProgressPercent(t *testing.T) {
+	pre := events.EngineEvent{EngineEvent: apitype.EngineEvent{ResourcePreEvent: &apitype.ResourcePreEvent{}}}
+	outputs := events.EngineEvent{EngineEvent: apitype.EngineEvent{ResOutputsEvent: &apitype.ResOutputsEvent{}}}
+	failed := events.EngineEvent{EngineEvent: apitype.EngineEvent{ResOpFailedEvent: &apitype.ResOpFailedEvent{}}}
+
+	p := updateProgress{expected: 4}
+	assert.Equal(t, int64(0), p.percent())
+	p.observe(pre)
+	p.observe(outputs)
+	assert.Equal(t, int64(25), p.percent())
+	p.observe(pre)
+	p.observe(failed)
+	assert.Equal(t, int64(50), p.percent())
+
+	// More operations than expected; the total is revised, and it never reaches 100% early.
+	for i := 0; i < 4; i++ {
+		p.observe(pre)
+	}
+	assert.Equal(t, int64(33), p.percent())
+	for i := 0; i < 4; i++ {
+		p.observe(outputs)
+	}
+	assert.Equal(t, int64(99), p.percent())
+
+	// Nothing in the stack yet, so progress is relative to the operations started.
+	p = updateProgress{}
+	assert.Equal(t, int64(0), p.percent())
+	p.observe(pre)
+	p.observe(pre)
+	p.observe(outputs)
+	assert.Equal(t, int64(50), p.percent())
+}
+
+func Test_TrackUpdateProgress(t *testing.T) {
+	var mu sync.Mutex
+	var reports []int64
+	engineEvents, stop := trackUpdateProgress(2, 0, func(percent int64) {
+		mu.Lock()
+		defer mu.Unlock()
+		reports = append(reports, percent)
+	})
+
+	engineEvents <- events.EngineEvent{EngineEvent: apitype.EngineEvent{ResourcePreEvent: &apitype.ResourcePreEvent{}}}
+	engineEvents <- events.EngineEvent{EngineEvent: apitype.EngineEvent{ResOutputsEvent: &apitype.ResOutputsEvent{}}}
+	// The second send only returns once the first event has been dealt with.
+	engineEvents <- events.EngineEvent{EngineEvent: apitype.EngineEvent{ResourcePreEvent: &apitype.ResourcePreEvent{}}}
+	stop()
+	engineEvents <- events.EngineEvent{EngineEvent: apitype.EngineEvent{ResOutputsEvent: &apitype.ResOutputsEvent{}}}
+	close(engineEvents)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []int64{50}, reports)
+}
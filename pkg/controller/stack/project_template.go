@@ -0,0 +1,102 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/encoding"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/workspace"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// projectTemplateData is what the templates for project and stack settings are given.
+type projectTemplateData struct {
+	Config, Env map[string]string
+	// Stack is the name of the stack, without any organization or project.
+	Stack string
+}
+
+// WriteProjectTemplate renders the templates for the project and stack settings held in the
+// ConfigMap named by ProjectTemplate, under the keys "Pulumi.yaml" and "Pulumi.<stack>.yaml", and
+// writes them into the project directory in place of any checked-in settings. Other keys are
+// ignored, so that one ConfigMap can serve several stacks. The settings written are loaded, to
+// check that they're valid.
+func (sess *reconcileStackSession) WriteProjectTemplate(ctx context.Context) error {
+	if sess.stack.ProjectTemplate == "" {
+		return nil
+	}
+	var config corev1.ConfigMap
+	if err := sess.kubeClient.Get(ctx, types.NamespacedName{Name: sess.stack.ProjectTemplate, Namespace: sess.namespace}, &config); err != nil {
+		return errors.Wrapf(err, "project template Namespace=%s Name=%s", sess.namespace, sess.stack.ProjectTemplate)
+	}
+
+	data := projectTemplateData{
+		Config: sess.stack.Config,
+		Env:    sess.stack.Env,
+		Stack:  sess.stack.Stack[strings.LastIndex(sess.stack.Stack, "/")+1:],
+	}
+	projectFile, stackFile := "Pulumi.yaml", "Pulumi."+data.Stack+".yaml"
+	written := false
+	for _, name := range []string{projectFile, stackFile} {
+		text, ok := config.Data[name]
+		if !ok {
+			continue
+		}
+		contents, err := renderProjectTemplate(name, text, data)
+		if err != nil {
+			return errors.Wrapf(err, "rendering %s from project template %s", name, sess.stack.ProjectTemplate)
+		}
+		if err := writeSettingsFile(sess.workdir, name, contents); err != nil {
+			return err
+		}
+		if name == projectFile {
+			_, err = workspace.LoadProject(filepath.Join(sess.workdir, name))
+		} else {
+			_, err = workspace.LoadProjectStack(filepath.Join(sess.workdir, name))
+		}
+		if err != nil {
+			return errors.Wrapf(err, "invalid %s rendered from project template %s", name, sess.stack.ProjectTemplate)
+		}
+		written = true
+	}
+	if !written {
+		return errors.Errorf("project template %s has neither %s nor %s", sess.stack.ProjectTemplate, projectFile, stackFile)
+	}
+	return nil
+}
+
+// renderProjectTemplate renders a template for project or stack settings. A reference to a
+// configuration value or environment variable that isn't given is an error.
+func renderProjectTemplate(name, text string, data projectTemplateData) ([]byte, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	var out strings.Builder
+	if err := tmpl.Execute(&out, data); err != nil {
+		return nil, err
+	}
+	return []byte(out.String()), nil
+}
+
+// writeSettingsFile writes a settings file named "<base>.yaml" into dir, removing any with the same
+// base name and another extension (e.g., "Pulumi.json"), which Pulumi might read in its place.
+func writeSettingsFile(dir, name string, contents []byte) error {
+	base := strings.TrimSuffix(name, ".yaml")
+	for _, ext := range encoding.Exts {
+		if err := os.Remove(filepath.Join(dir, base+ext)); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "replacing %s", base+ext)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), contents, 0600); err != nil {
+		return errors.Wrapf(err, "writing %s", name)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/shared"
+	"github.com/pulumi/pulumi-kubernetes-operator/pkg/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func Test_WriteProjectTemplate(t *testing.T) {
+	logger := logging.NewLogger(t.Name(), "Request.Test", "Test_WriteProjectTemplate")
+
+	templates := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "project", Namespace: namespace},
+		Data: map[string]string{
+			"Pulumi.yaml":      "name: app-{{ .Env.REGION }}\nruntime: yaml\n",
+			"Pulumi.prod.yaml": "config:\n  app:replicas: \"{{ .Config.replicas }}\"\n",
+			"Pulumi.dev.yaml":  "config:\n  app:replicas: \"1\"\n",
+		},
+	}
+	invalid := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "invalid", Namespace: namespace},
+		Data:       map[string]string{"Pulumi.yaml": "name: [app\n"},
+	}
+	unrelated := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "unrelated", Namespace: namespace},
+		Data:       map[string]string{"values.yaml": "replicas: 3\n"},
+	}
+	client := fake.NewFakeClientWithScheme(scheme.Scheme, templates, invalid, unrelated)
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Pulumi.yml"), []byte("name: checked-in\nruntime: go\n"), 0644))
+	sess := newReconcileStackSession(logger, shared.StackSpec{
+		Stack:           "acme/app/prod",
+		ProjectTemplate: "project",
+		Config:          map[string]string{"replicas": "3"},
+		Env:             map[string]string{"REGION": "eu"},
+	}, client, namespace)
+	sess.workdir = dir
+	require.NoError(t, sess.WriteProjectTemplate(context.TODO()))
+
+	contents, err := os.ReadFile(filepath.Join(dir, "Pulumi.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, "name: app-eu\nruntime: yaml\n", string(contents))
+	assert.NoFileExists(t, filepath.Join(dir, "Pulumi.yml"), "checked-in settings are replaced")
+	contents, err = os.ReadFile(filepath.Join(dir, "Pulumi.prod.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, "config:\n  app:replicas: \"3\"\n", string(contents))
+	assert.NoFileExists(t, filepath.Join(dir, "Pulumi.dev.yaml"), "only this stack's settings are written")
+
+	for _, spec := range []shared.StackSpec{
+		{Stack: "prod", ProjectTemplate: "project", Config: map[string]string{"replicas": "3"}},
+		{Stack: "prod", ProjectTemplate: "invalid"},
+		{Stack: "prod", ProjectTemplate: "unrelated"},
+		{Stack: "prod", ProjectTemplate: "missing"},
+	} {
+		sess.stack = spec
+		sess.workdir = t.TempDir()
+		assert.Error(t, sess.WriteProjectTemplate(context.TODO()), "%+v", spec)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+)
+
+// systemCABundles are where the system's CA certificates are usually found, as looked for by Go's
+// crypto/x509 on Linux. The first that exists is used as the base of the bundle given to the
+// workspace, since SSL_CERT_FILE and REQUESTS_CA_BUNDLE replace the system's certificates rather
+// than adding to them.
+var systemCABundles = []string{
+	"/etc/ssl/certs/ca-certificates.crt",
+	"/etc/pki/tls/certs/ca-bundle.crt",
+	"/etc/ssl/ca-bundle.pem",
+	"/etc/pki/tls/cacert.pem",
+	"/etc/pki/ca-trust/extracted/pem/tls-ca-bundle.pem",
+	"/etc/ssl/cert.pem",
+}
+
+// providerCAEnvVars are the environment variables by which the common runtimes are given CA
+// certificates to trust: SSL_CERT_FILE for Go (and OpenSSL), NODE_EXTRA_CA_CERTS for Node.js, and
+// REQUESTS_CA_BUNDLE for Python's requests.
+var providerCAEnvVars = []string{"SSL_CERT_FILE", "NODE_EXTRA_CA_CERTS", "REQUESTS_CA_BUNDLE"}
+
+// SetupProviderCACerts writes the CA certificates given in the stack specification, after the
+// system's, to a file in the workspace, and points the workspace environment at it.
+func (sess *reconcileStackSession) SetupProviderCACerts(ctx context.Context, w auto.Workspace) error {
+	if len(sess.stack.ProviderCACerts) == 0 {
+		return nil
+	}
+	var certs []string
+	for i := range sess.stack.ProviderCACerts {
+		cert, err := sess.resolveResourceRef(ctx, &sess.stack.ProviderCACerts[i])
+		if err != nil {
+			return errors.Wrapf(err, "resolving provider CA certificate %d", i)
+		}
+		certs = append(certs, cert)
+	}
+	bundle, err := providerCABundle(systemCABundle(), certs)
+	if err != nil {
+		return err
+	}
+	bundlePath := filepath.Join(sess.rootDir, "provider-ca-certs.pem")
+	if err := os.WriteFile(bundlePath, bundle, 0600); err != nil {
+		return errors.Wrap(err, "writing provider CA certificates")
+	}
+	for _, name := range providerCAEnvVars {
+		w.SetEnvVar(name, bundlePath)
+	}
+	return nil
+}
+
+// systemCABundle gives the path of the system's CA certificates, preferring that given to the
+// operator as SSL_CERT_FILE; or the empty string, if none is found.
+func systemCABundle() string {
+	if path := os.Getenv("SSL_CERT_FILE"); path != "" {
+		return path
+	}
+	for _, path := range systemCABundles {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// providerCABundle concatenates the certificates in the file at systemBundle, if given, with those
+// given, each of which must hold at least one PEM-encoded certificate.
+func providerCABundle(systemBundle string, certs []string) ([]byte, error) {
+	var bundle bytes.Buffer
+	if systemBundle != "" {
+		system, err := os.ReadFile(systemBundle)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading system CA certificates")
+		}
+		bundle.Write(system)
+	}
+	for i, cert := range certs {
+		if !x509.NewCertPool().AppendCertsFromPEM([]byte(cert)) {
+			return nil, errors.Errorf("provider CA certificate %d has no PEM-encoded certificates", i)
+		}
+		if bundle.Len() > 0 && !bytes.HasSuffix(bundle.Bytes(), []byte("\n")) {
+			bundle.WriteByte('\n')
+		}
+		bundle.WriteString(cert)
+	}
+	return bundle.Bytes(), nil
+}
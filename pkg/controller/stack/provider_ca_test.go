@@ -0,0 +1,35 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"encoding/pem"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ProviderCABundle(t *testing.T) {
+	server := httptest.NewTLSServer(nil)
+	defer server.Close()
+	cert := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+
+	system := filepath.Join(t.TempDir(), "ca-certificates.crt")
+	require.NoError(t, os.WriteFile(system, []byte("# system certificates"), 0600))
+	bundle, err := providerCABundle(system, []string{cert, cert})
+	require.NoError(t, err)
+	assert.Equal(t, "# system certificates\n"+cert+cert, string(bundle))
+
+	bundle, err = providerCABundle("", []string{cert})
+	require.NoError(t, err)
+	assert.Equal(t, cert, string(bundle))
+
+	_, err = providerCABundle("", []string{cert, "not a certificate"})
+	assert.Error(t, err)
+	_, err = providerCABundle(filepath.Join(t.TempDir(), "missing.crt"), []string{cert})
+	assert.Error(t, err)
+}
@@ -0,0 +1,109 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	gitv5 "github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	gitplumbing "github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/pkg/errors"
+	"github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/shared"
+	pulumiv1 "github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+)
+
+// branchRefName gives the full name of the ref for a branch given as the Branch field of a stack
+// may give it: as a simple name, "refs/heads/<name>", or "refs/remotes/origin/<name>". It is
+// false for anything else, e.g., a tag.
+func branchRefName(branch string) (gitplumbing.ReferenceName, bool) {
+	refName := gitplumbing.ReferenceName(branch)
+	switch {
+	case refName.IsBranch():
+		return refName, true
+	case refName.IsRemote():
+		if name := strings.TrimPrefix(branch, "refs/remotes/origin/"); name != branch {
+			return gitplumbing.NewBranchReferenceName(name), true
+		}
+		return "", false
+	case strings.HasPrefix(branch, "refs/"):
+		return "", false
+	default:
+		return gitplumbing.NewBranchReferenceName(branch), true
+	}
+}
+
+// transportAuth gives the go-git authentication for the git credentials given, as the automation
+// API would use them to clone the repository.
+func transportAuth(gitAuth *auto.GitAuth) (transport.AuthMethod, error) {
+	switch {
+	case gitAuth == nil:
+		return nil, nil
+	case gitAuth.SSHPrivateKey != "":
+		return gitssh.NewPublicKeys("git", []byte(gitAuth.SSHPrivateKey), gitAuth.Password)
+	case gitAuth.PersonalAccessToken != "":
+		return &githttp.BasicAuth{Username: "git", Password: gitAuth.PersonalAccessToken}, nil
+	case gitAuth.Username != "":
+		return &githttp.BasicAuth{Username: gitAuth.Username, Password: gitAuth.Password}, nil
+	}
+	return nil, nil
+}
+
+// remoteBranchCommit gives the commit at the head of a branch in the repository at url, by
+// listing its refs rather than cloning it.
+func remoteBranchCommit(ctx context.Context, url, branch string, gitAuth *auto.GitAuth) (string, error) {
+	refName, ok := branchRefName(branch)
+	if !ok {
+		return "", errors.Errorf("%q is not a branch", branch)
+	}
+	auth, err := transportAuth(gitAuth)
+	if err != nil {
+		return "", errors.Wrap(err, "setting up git authentication")
+	}
+	remote := gitv5.NewRemote(memory.NewStorage(), &gitconfig.RemoteConfig{Name: "origin", URLs: []string{url}})
+	refs, err := remote.ListContext(ctx, &gitv5.ListOptions{Auth: auth})
+	if err != nil {
+		return "", errors.Wrapf(err, "listing refs of %s", url)
+	}
+	for _, ref := range refs {
+		if ref.Name() == refName {
+			return ref.Hash().String(), nil
+		}
+	}
+	return "", errors.Errorf("branch %q not found in %s", branch, url)
+}
+
+// unchangedSinceLastUpdate reports whether the stack's tracked branch is still at the commit last
+// applied successfully, checked without cloning the repository, and nothing else needs the source:
+// the spec hasn't changed, and no scheduled refresh or backup is due. If it can't tell, e.g.,
+// because the repository can't be reached, it reports false, so the stack is processed as usual.
+func (sess *reconcileStackSession) unchangedSinceLastUpdate(ctx context.Context, instance *pulumiv1.Stack, gitAuth *auto.GitAuth, now time.Time) bool {
+	last := instance.Status.LastUpdate
+	if sess.stack.Branch == "" || sess.stack.LocalPath != "" || sess.stack.StackConfigOnly ||
+		sess.stack.ContinueResyncOnCommitMatch || last == nil ||
+		last.State != shared.SucceededStackStateMessage || last.LastSuccessfulCommit == "" ||
+		instance.Status.ObservedGeneration != instance.GetGeneration() {
+		return false
+	}
+	for _, until := range []func(*pulumiv1.Stack, time.Time) (time.Duration, bool){untilRefresh, untilBackup} {
+		if wait, scheduled := until(instance, now); scheduled && wait <= 0 {
+			return false
+		}
+	}
+
+	listCtx, cancel := sess.cloneContext(ctx)
+	defer cancel()
+	commit, err := remoteBranchCommit(listCtx, sess.stack.ProjectRepo, sess.stack.Branch, gitAuth)
+	if err != nil {
+		sess.logger.Debug("Could not check the branch without cloning", "Stack.Name", sess.stack.Stack, "Error", err.Error())
+		return false
+	}
+	return commit == last.LastSuccessfulCommit
+}
@@ -0,0 +1,55 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	git "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+func Test_BranchRefName(t *testing.T) {
+	for branch, want := range map[string]string{
+		"main":                      "refs/heads/main",
+		"refs/heads/main":           "refs/heads/main",
+		"refs/remotes/origin/main":  "refs/heads/main",
+		"release/v1":                "refs/heads/release/v1",
+		"refs/remotes/upstream/foo": "",
+		"refs/tags/v1.0.0":          "",
+	} {
+		refName, ok := branchRefName(branch)
+		assert.Equal(t, want != "", ok, branch)
+		assert.Equal(t, want, string(refName), branch)
+	}
+}
+
+func Test_RemoteBranchCommit(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	require.NoError(t, err)
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Pulumi.yaml"), []byte("name: test\n"), 0644))
+	_, err = wt.Add("Pulumi.yaml")
+	require.NoError(t, err)
+	hash, err := wt.Commit("commit", &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	require.NoError(t, err)
+
+	commit, err := remoteBranchCommit(context.TODO(), dir, "master", nil)
+	require.NoError(t, err)
+	assert.Equal(t, hash.String(), commit)
+
+	_, err = remoteBranchCommit(context.TODO(), dir, "no-such-branch", nil)
+	assert.Error(t, err)
+	_, err = remoteBranchCommit(context.TODO(), dir, "refs/tags/v1.0.0", nil)
+	assert.Error(t, err)
+}
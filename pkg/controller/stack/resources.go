@@ -0,0 +1,71 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+
+	pulumiv1 "github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/v1"
+)
+
+// maxReportedResourceTypes is the most resource types summarized in the status, so that a stack
+// with many types doesn't make the status too large.
+const maxReportedResourceTypes = 50
+
+// stackResourceType is the type of the resource representing the stack itself, which isn't counted.
+const stackResourceType = "pulumi:pulumi:Stack"
+
+// summarizeResources counts the resources of each type in a deployment exported from a stack, and
+// gives the counts for at most max types, most numerous first, along with whether any were left out.
+func summarizeResources(deployment json.RawMessage, max int) ([]pulumiv1.ResourceTypeCount, bool, error) {
+	var state struct {
+		Resources []struct {
+			Type string `json:"type"`
+		} `json:"resources"`
+	}
+	if err := json.Unmarshal(deployment, &state); err != nil {
+		return nil, false, err
+	}
+	counts := map[string]int{}
+	for _, res := range state.Resources {
+		if res.Type != stackResourceType {
+			counts[res.Type]++
+		}
+	}
+	summary := make([]pulumiv1.ResourceTypeCount, 0, len(counts))
+	for typ, count := range counts {
+		summary = append(summary, pulumiv1.ResourceTypeCount{Type: typ, Count: count})
+	}
+	sort.Slice(summary, func(i, j int) bool {
+		if summary[i].Count != summary[j].Count {
+			return summary[i].Count > summary[j].Count
+		}
+		return summary[i].Type < summary[j].Type
+	})
+	if len(summary) > max {
+		return summary[:max], true, nil
+	}
+	return summary, false, nil
+}
+
+// recordResources summarizes the resources in the stack in the status, if the spec asks for it,
+// and otherwise clears any summary. A failure to read the stack's state is logged, and leaves
+// the previous summary in place.
+func (sess *reconcileStackSession) recordResources(ctx context.Context, instance *pulumiv1.Stack) {
+	if !sess.stack.ReportResources {
+		instance.Status.Resources, instance.Status.ResourcesTruncated = nil, false
+		return
+	}
+	state, err := sess.autoStack.Export(ctx)
+	if err == nil {
+		var summary []pulumiv1.ResourceTypeCount
+		var truncated bool
+		if summary, truncated, err = summarizeResources(state.Deployment, maxReportedResourceTypes); err == nil {
+			instance.Status.Resources, instance.Status.ResourcesTruncated = summary, truncated
+			return
+		}
+	}
+	sess.logger.Error(err, "Could not summarize stack resources", "Stack.Name", sess.stack.Stack)
+}
@@ -0,0 +1,44 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"encoding/json"
+	"testing"
+
+	pulumiv1 "github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_SummarizeResources(t *testing.T) {
+	deployment := json.RawMessage(`{"resources": [
+		{"urn": "urn:pulumi:prod::app::pulumi:pulumi:Stack::app-prod", "type": "pulumi:pulumi:Stack"},
+		{"urn": "urn:pulumi:prod::app::pulumi:providers:aws::default", "type": "pulumi:providers:aws"},
+		{"urn": "urn:pulumi:prod::app::aws:s3/bucket:Bucket::a", "type": "aws:s3/bucket:Bucket"},
+		{"urn": "urn:pulumi:prod::app::aws:s3/bucket:Bucket::b", "type": "aws:s3/bucket:Bucket"},
+		{"urn": "urn:pulumi:prod::app::aws:iam/role:Role::r", "type": "aws:iam/role:Role"}
+	]}`)
+
+	summary, truncated, err := summarizeResources(deployment, 10)
+	require.NoError(t, err)
+	assert.False(t, truncated)
+	assert.Equal(t, []pulumiv1.ResourceTypeCount{
+		{Type: "aws:s3/bucket:Bucket", Count: 2},
+		{Type: "aws:iam/role:Role", Count: 1},
+		{Type: "pulumi:providers:aws", Count: 1},
+	}, summary)
+
+	summary, truncated, err = summarizeResources(deployment, 2)
+	require.NoError(t, err)
+	assert.True(t, truncated)
+	assert.Len(t, summary, 2)
+
+	summary, truncated, err = summarizeResources(json.RawMessage(`{}`), 10)
+	require.NoError(t, err)
+	assert.False(t, truncated)
+	assert.Empty(t, summary)
+
+	_, _, err = summarizeResources(json.RawMessage(`not json`), 10)
+	assert.Error(t, err)
+}
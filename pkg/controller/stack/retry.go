@@ -0,0 +1,102 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/shared"
+	pulumiv1 "github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/v1"
+)
+
+const (
+	defaultRetryInitialDelaySeconds = 5
+	defaultRetryMaxDelaySeconds     = 300
+	defaultRetryMultiplier          = 2
+	defaultRetryJitterPercent       = 10
+	defaultMaxNotFoundRetries       = 10
+)
+
+// retryBackoff calculates how long to wait before retrying a stack that has failed `failures`
+// times in a row, according to the policy given (or the default policy, if nil). The jitter
+// function is given the largest amount of jitter allowed, and returns the amount to add.
+func retryBackoff(policy *shared.RetryPolicy, failures int64, jitter func(max time.Duration) time.Duration) time.Duration {
+	initial, max := int64(defaultRetryInitialDelaySeconds), int64(defaultRetryMaxDelaySeconds)
+	multiplier, jitterPercent := int64(defaultRetryMultiplier), int64(defaultRetryJitterPercent)
+	if policy != nil {
+		if policy.InitialDelaySeconds > 0 {
+			initial = policy.InitialDelaySeconds
+		}
+		if policy.MaxDelaySeconds > 0 {
+			max = policy.MaxDelaySeconds
+		}
+		if policy.Multiplier > 0 {
+			multiplier = policy.Multiplier
+		}
+		if policy.JitterPercent > 0 {
+			jitterPercent = policy.JitterPercent
+		}
+	}
+	if max < initial {
+		max = initial
+	}
+
+	delay := initial
+	for i := int64(1); i < failures && delay < max; i++ {
+		delay *= multiplier
+	}
+	if delay > max {
+		delay = max
+	}
+
+	d := time.Duration(delay) * time.Second
+	d += jitter(d * time.Duration(jitterPercent) / 100)
+	if maxD := time.Duration(max) * time.Second; d > maxD {
+		d = maxD
+	}
+	return d
+}
+
+// notFoundRetryDelay gives how long to wait before retrying a stack that was not found in its
+// backend: the delay given in the policy, if any, or otherwise the usual backoff.
+func notFoundRetryDelay(policy *shared.RetryPolicy, failures int64, jitter func(max time.Duration) time.Duration) time.Duration {
+	if policy != nil && policy.NotFoundDelaySeconds > 0 {
+		return time.Duration(policy.NotFoundDelaySeconds) * time.Second
+	}
+	return retryBackoff(policy, failures, jitter)
+}
+
+// maxNotFoundRetries gives how many times in a row to retry a stack that was not found in its
+// backend, according to the policy given (or the default policy, if nil).
+func maxNotFoundRetries(policy *shared.RetryPolicy) int64 {
+	if policy != nil && policy.MaxNotFoundRetries > 0 {
+		return policy.MaxNotFoundRetries
+	}
+	return defaultMaxNotFoundRetries
+}
+
+// tooManyFailures reports whether the stack has failed as many times in a row as its retry policy
+// allows, so that it shouldn't be retried.
+func tooManyFailures(instance *pulumiv1.Stack) bool {
+	policy := instance.Spec.RetryPolicy
+	if policy == nil || policy.MaxConsecutiveFailures <= 0 || instance.Status.LastUpdate == nil {
+		return false
+	}
+	return instance.Status.LastUpdate.ConsecutiveFailures >= policy.MaxConsecutiveFailures
+}
+
+// tooManyFailuresMessage explains why a stack with too many failures is not retried.
+func tooManyFailuresMessage(instance *pulumiv1.Stack) string {
+	return fmt.Sprintf("Stack has failed %d times in a row; it will not be retried until its spec is changed",
+		instance.Status.LastUpdate.ConsecutiveFailures)
+}
+
+// randomJitter returns a random duration in [0, max).
+func randomJitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max)))
+}
@@ -0,0 +1,82 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/shared"
+	pulumiv1 "github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func noJitter(time.Duration) time.Duration { return 0 }
+
+func maxJitter(max time.Duration) time.Duration { return max }
+
+func Test_RetryBackoffDefaults(t *testing.T) {
+	var got []time.Duration
+	for failures := int64(1); failures <= 8; failures++ {
+		got = append(got, retryBackoff(nil, failures, noJitter))
+	}
+	assert.Equal(t, []time.Duration{
+		5 * time.Second,
+		10 * time.Second,
+		20 * time.Second,
+		40 * time.Second,
+		80 * time.Second,
+		160 * time.Second,
+		300 * time.Second,
+		300 * time.Second,
+	}, got)
+}
+
+func Test_RetryBackoffPolicy(t *testing.T) {
+	policy := &shared.RetryPolicy{
+		InitialDelaySeconds: 10,
+		MaxDelaySeconds:     60,
+		Multiplier:          3,
+	}
+	assert.Equal(t, 10*time.Second, retryBackoff(policy, 0, noJitter))
+	assert.Equal(t, 10*time.Second, retryBackoff(policy, 1, noJitter))
+	assert.Equal(t, 30*time.Second, retryBackoff(policy, 2, noJitter))
+	assert.Equal(t, 60*time.Second, retryBackoff(policy, 3, noJitter))
+	assert.Equal(t, 60*time.Second, retryBackoff(policy, 1000, noJitter))
+}
+
+func Test_RetryBackoffJitter(t *testing.T) {
+	policy := &shared.RetryPolicy{JitterPercent: 50}
+	assert.Equal(t, 7500*time.Millisecond, retryBackoff(policy, 1, maxJitter))
+	// jitter never takes the delay past the maximum
+	assert.Equal(t, 300*time.Second, retryBackoff(policy, 7, maxJitter))
+
+	for i := 0; i < 100; i++ {
+		d := retryBackoff(nil, 2, randomJitter)
+		assert.True(t, d >= 10*time.Second && d < 11*time.Second, "delay %s out of range", d)
+	}
+}
+
+func Test_NotFoundRetryPolicy(t *testing.T) {
+	assert.Equal(t, 20*time.Second, notFoundRetryDelay(nil, 3, noJitter))
+	assert.Equal(t, int64(10), maxNotFoundRetries(nil))
+
+	policy := &shared.RetryPolicy{NotFoundDelaySeconds: 30, MaxNotFoundRetries: 3}
+	assert.Equal(t, 30*time.Second, notFoundRetryDelay(policy, 1, maxJitter))
+	assert.Equal(t, 30*time.Second, notFoundRetryDelay(policy, 8, maxJitter))
+	assert.Equal(t, int64(3), maxNotFoundRetries(policy))
+}
+
+func Test_TooManyFailures(t *testing.T) {
+	stack := &pulumiv1.Stack{}
+	stack.Status.LastUpdate = &shared.StackUpdateState{ConsecutiveFailures: 3}
+	assert.False(t, tooManyFailures(stack), "without a policy, failures are retried indefinitely")
+
+	stack.Spec.RetryPolicy = &shared.RetryPolicy{MaxConsecutiveFailures: 4}
+	assert.False(t, tooManyFailures(stack))
+	stack.Status.LastUpdate.ConsecutiveFailures = 4
+	assert.True(t, tooManyFailures(stack))
+
+	stack.Status.LastUpdate = nil
+	assert.False(t, tooManyFailures(stack), "a stack never processed hasn't failed")
+}
@@ -0,0 +1,48 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+)
+
+// giveWorkspaceToUser makes the workspace and HOME owned by the user given by RunAsUser, if any,
+// so that commands run as that user can write to them. This needs the operator to run as root.
+func (sess *reconcileStackSession) giveWorkspaceToUser() error {
+	user := sess.stack.RunAsUser
+	if user == nil {
+		return nil
+	}
+	for _, dir := range []string{sess.rootDir, sess.homeDir} {
+		if dir == "" {
+			continue
+		}
+		err := filepath.WalkDir(dir, func(path string, _ fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			return os.Lchown(path, int(user.UID), int(user.GID))
+		})
+		if err != nil {
+			return errors.Wrapf(err, "giving the workspace to uid %d, gid %d", user.UID, user.GID)
+		}
+	}
+	return nil
+}
+
+// runStackCmd runs a command which works on the project source, e.g., to install its
+// dependencies, as the user given by RunAsUser, if any.
+func (sess *reconcileStackSession) runStackCmd(title string, cmd *exec.Cmd, workspace auto.Workspace) (string, string, error) {
+	if user := sess.stack.RunAsUser; user != nil {
+		if err := setCommandCredential(cmd, uint32(user.UID), uint32(user.GID)); err != nil {
+			return "", "", err
+		}
+	}
+	return sess.runCmd(title, cmd, workspace)
+}
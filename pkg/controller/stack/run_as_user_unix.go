@@ -0,0 +1,20 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+//go:build !windows
+// +build !windows
+
+package stack
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setCommandCredential makes cmd run with the uid and gid given.
+func setCommandCredential(cmd *exec.Cmd, uid, gid uint32) error {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = &syscall.Credential{Uid: uid, Gid: gid}
+	return nil
+}
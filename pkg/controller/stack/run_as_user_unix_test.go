@@ -0,0 +1,46 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+//go:build !windows
+// +build !windows
+
+package stack
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/shared"
+	"github.com/pulumi/pulumi-kubernetes-operator/pkg/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_GiveWorkspaceToUser(t *testing.T) {
+	logger := logging.NewLogger(t.Name(), "Request.Test", "GiveWorkspaceToUser")
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "project", "node_modules"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "project", "package.json"), []byte("{}"), 0644))
+
+	// Without root, files can only be given to their owner.
+	uid, gid := os.Getuid(), os.Getgid()
+	sess := newReconcileStackSession(logger, shared.StackSpec{
+		RunAsUser: &shared.PosixUser{UID: int64(uid), GID: int64(gid)},
+	}, nil, namespace)
+	sess.rootDir = root
+	require.NoError(t, sess.giveWorkspaceToUser())
+
+	info, err := os.Stat(filepath.Join(root, "project", "package.json"))
+	require.NoError(t, err)
+	stat := info.Sys().(*syscall.Stat_t)
+	assert.Equal(t, uint32(uid), stat.Uid)
+	assert.Equal(t, uint32(gid), stat.Gid)
+}
+
+func Test_SetCommandCredential(t *testing.T) {
+	cmd := exec.Command("npm", "install")
+	require.NoError(t, setCommandCredential(cmd, 1000, 2000))
+	assert.Equal(t, &syscall.Credential{Uid: 1000, Gid: 2000}, cmd.SysProcAttr.Credential)
+}
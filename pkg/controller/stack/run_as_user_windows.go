@@ -0,0 +1,14 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"os/exec"
+
+	"github.com/pkg/errors"
+)
+
+// setCommandCredential is not supported on Windows, which has no uids and gids.
+func setCommandCredential(cmd *exec.Cmd, uid, gid uint32) error {
+	return errors.New("running commands as another user is not supported on Windows")
+}
@@ -0,0 +1,85 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// maxSecretDataSize is the most data stored in a Secret. Kubernetes limits a Secret to 1MiB in
+	// total; this leaves some room for its metadata.
+	maxSecretDataSize = 1<<20 - 64<<10
+	// compressThreshold is the size above which a value is compressed before it is stored in a
+	// Secret. Exported state is JSON, so compresses well.
+	compressThreshold = 64 << 10
+	// compressedKeySuffix is appended to the key of a compressed value.
+	compressedKeySuffix = ".gz"
+)
+
+// secretValue gives the key and data under which to store a value in a Secret: as it is, if it's
+// small, otherwise gzip-compressed, with compressedKeySuffix appended to the key.
+func secretValue(key string, data []byte) (string, []byte, error) {
+	if len(data) <= compressThreshold {
+		return key, data, nil
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return "", nil, errors.Wrap(err, "compressing value")
+	}
+	if err := zw.Close(); err != nil {
+		return "", nil, errors.Wrap(err, "compressing value")
+	}
+	return key + compressedKeySuffix, buf.Bytes(), nil
+}
+
+// secretDataSize gives the total size of the data in a Secret.
+func secretDataSize(secret *corev1.Secret) int {
+	size := 0
+	for k, v := range secret.Data {
+		size += len(k) + len(v)
+	}
+	return size
+}
+
+// storeSecret creates or updates the Secret with the name given in the stack's namespace, having
+// update change its data, and applying the stack's object metadata. It's an error if the data
+// would be too large for a Secret, in which case the Secret is left as it was.
+func (sess *reconcileStackSession) storeSecret(ctx context.Context, name string, update func(*corev1.Secret)) error {
+	var secret corev1.Secret
+	key := types.NamespacedName{Namespace: sess.namespace, Name: name}
+	err := sess.kubeClient.Get(ctx, key, &secret)
+	create := k8serrors.IsNotFound(err)
+	switch {
+	case create:
+		secret = corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: key.Namespace, Name: key.Name},
+			Type:       corev1.SecretTypeOpaque,
+		}
+	case err != nil:
+		return errors.Wrapf(err, "getting Namespace=%s Name=%s", key.Namespace, key.Name)
+	}
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	update(&secret)
+	if size := secretDataSize(&secret); size > maxSecretDataSize {
+		return errors.Errorf("data for Namespace=%s Name=%s is too large for a Secret (%d bytes)", key.Namespace, key.Name, size)
+	}
+	applyObjectMetadata(&secret.ObjectMeta, sess.stack.ObjectMeta)
+	if create {
+		err = sess.kubeClient.Create(ctx, &secret)
+	} else {
+		err = sess.kubeClient.Update(ctx, &secret)
+	}
+	return errors.Wrapf(err, "storing Namespace=%s Name=%s", key.Namespace, key.Name)
+}
@@ -0,0 +1,57 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/shared"
+	"github.com/pulumi/pulumi-kubernetes-operator/pkg/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func Test_SecretValue(t *testing.T) {
+	key, data, err := secretValue("deployment.json", []byte(`{"version":3}`))
+	require.NoError(t, err)
+	assert.Equal(t, "deployment.json", key)
+	assert.Equal(t, []byte(`{"version":3}`), data)
+
+	large := bytes.Repeat([]byte(`{"urn":"urn:pulumi:dev::app::aws:s3/bucket:Bucket::b"},`), 2*compressThreshold/50)
+	key, data, err = secretValue("deployment.json", large)
+	require.NoError(t, err)
+	assert.Equal(t, "deployment.json.gz", key)
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	unzipped, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, large, unzipped)
+}
+
+func Test_StoreSecretTooLarge(t *testing.T) {
+	logger := logging.NewLogger(t.Name(), "Request.Test", "Test_StoreSecretTooLarge")
+	existing := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "app-state"},
+		Data:       map[string][]byte{"deployment.json": []byte("old")},
+	}
+	client := fake.NewFakeClientWithScheme(scheme.Scheme, existing)
+	sess := newReconcileStackSession(logger, shared.StackSpec{}, client, namespace)
+
+	err := sess.storeSecret(context.TODO(), "app-state", func(secret *corev1.Secret) {
+		secret.Data["deployment.json"] = make([]byte, maxSecretDataSize+1)
+	})
+	assert.Error(t, err)
+
+	var secret corev1.Secret
+	require.NoError(t, client.Get(context.TODO(), types.NamespacedName{Namespace: namespace, Name: "app-state"}, &secret))
+	assert.Equal(t, []byte("old"), secret.Data["deployment.json"], "the secret is left as it was")
+}
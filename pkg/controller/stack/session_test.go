@@ -7,18 +7,34 @@ import (
 	"errors"
 	"fmt"
 	"github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/shared"
+	pulumiv1 "github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/v1"
 	"github.com/pulumi/pulumi-kubernetes-operator/pkg/logging"
 	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource/config"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/workspace"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
+	git "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
 	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
@@ -448,3 +464,1510 @@ func (suite *GitAuthTestSuite) TestSetupGitAuthWithRefs() {
 		})
 	}
 }
+
+func TestProviderDefaultsConfig(t *testing.T) {
+	m, err := providerDefaultsConfig(map[string]map[string]string{
+		"aws":        {"region": "us-west-2", "profile": "ops"},
+		"kubernetes": {"namespace": "apps"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, auto.ConfigMap{
+		"aws:region":           {Value: "us-west-2"},
+		"aws:profile":          {Value: "ops"},
+		"kubernetes:namespace": {Value: "apps"},
+	}, m)
+
+	_, err = providerDefaultsConfig(map[string]map[string]string{"": {"region": "us-west-2"}})
+	assert.Error(t, err)
+	_, err = providerDefaultsConfig(map[string]map[string]string{"aws:region": {"x": "y"}})
+	assert.Error(t, err)
+	_, err = providerDefaultsConfig(map[string]map[string]string{"aws": {"": "y"}})
+	assert.Error(t, err)
+}
+
+func TestResourceDefaultsConfig(t *testing.T) {
+	m, err := resourceDefaultsConfig(nil)
+	require.NoError(t, err)
+	assert.Empty(t, m)
+
+	m, err = resourceDefaultsConfig(&shared.ResourceDefaults{
+		DisableDefaultProviders: []string{"aws", "kubernetes"},
+		Protect:                 true,
+		AdditionalTags:          map[string]string{"team": "platform"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, auto.ConfigMap{
+		"pulumi:disable-default-providers": {Value: `["aws","kubernetes"]`},
+		"resourceDefaults:protect":         {Value: "true"},
+		"resourceDefaults:additionalTags":  {Value: `{"team":"platform"}`},
+	}, m)
+
+	_, err = resourceDefaultsConfig(&shared.ResourceDefaults{DisableDefaultProviders: []string{""}})
+	assert.Error(t, err)
+}
+
+func TestUserAgent(t *testing.T) {
+	logger := logging.NewLogger(t.Name(), "Request.Test", "TestUserAgent")
+
+	sess := newReconcileStackSession(logger, shared.StackSpec{}, nil, namespace)
+	assert.Equal(t, execAgent, sess.userAgent())
+
+	os.Setenv(userAgentSuffixEnv, "cluster-a")
+	defer os.Unsetenv(userAgentSuffixEnv)
+	assert.Equal(t, execAgent+" cluster-a", sess.userAgent())
+
+	sess = newReconcileStackSession(logger, shared.StackSpec{UserAgentSuffix: "tenant-b"}, nil, namespace)
+	assert.Equal(t, execAgent+" tenant-b", sess.userAgent())
+}
+
+func TestGetStackOutputsSelection(t *testing.T) {
+	logger := logging.NewLogger(t.Name(), "Request.Test", "TestGetStackOutputsSelection")
+	outs := auto.OutputMap{
+		"bucketName": {Value: "my-bucket"},
+		"bucketArn":  {Value: "arn:aws:s3:::my-bucket"},
+		"dbPassword": {Value: "hunter2", Secret: true},
+		"dbHost":     {Value: "db.example.com"},
+	}
+
+	for _, test := range []struct {
+		name     string
+		selector *shared.OutputSelector
+		expected []string
+		err      string
+	}{
+		{
+			name:     "NoSelector",
+			expected: []string{"bucketArn", "bucketName", "dbHost", "dbPassword"},
+		},
+		{
+			name:     "Include",
+			selector: &shared.OutputSelector{Include: []string{"bucket*"}},
+			expected: []string{"bucketArn", "bucketName"},
+		},
+		{
+			name:     "Exclude",
+			selector: &shared.OutputSelector{Exclude: []string{"*Arn"}},
+			expected: []string{"bucketName", "dbHost", "dbPassword"},
+		},
+		{
+			name:     "ExcludeWins",
+			selector: &shared.OutputSelector{Include: []string{"db*"}, Exclude: []string{"dbHost"}},
+			expected: []string{"dbPassword"},
+		},
+		{
+			name:     "BadPattern",
+			selector: &shared.OutputSelector{Include: []string{"["}},
+			err:      "invalid output include pattern",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			session := newReconcileStackSession(logger, shared.StackSpec{Outputs: test.selector}, nil, namespace)
+			o, err := session.GetStackOutputs(outs)
+			if test.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.err)
+				return
+			}
+			require.NoError(t, err)
+			var keys []string
+			for k := range o {
+				keys = append(keys, k)
+			}
+			assert.ElementsMatch(t, test.expected, keys)
+			if v, ok := o["dbPassword"]; ok {
+				assert.Equal(t, `"[secret]"`, string(v.Raw))
+			}
+		})
+	}
+}
+
+func TestGetStackOutputsAllSecret(t *testing.T) {
+	logger := logging.NewLogger(t.Name(), "Request.Test", "TestGetStackOutputsAllSecret")
+	outs := auto.OutputMap{
+		"bucketName": {Value: "my-bucket"},
+		"dbPassword": {Value: "hunter2", Secret: true},
+	}
+
+	session := newReconcileStackSession(logger, shared.StackSpec{}, nil, namespace)
+	o, err := session.GetStackOutputs(outs)
+	require.NoError(t, err)
+	assert.Equal(t, `"my-bucket"`, string(o["bucketName"].Raw))
+	assert.Equal(t, `"[secret]"`, string(o["dbPassword"].Raw))
+
+	session = newReconcileStackSession(logger, shared.StackSpec{AllOutputsSecret: true}, nil, namespace)
+	o, err = session.GetStackOutputs(outs)
+	require.NoError(t, err)
+	assert.Equal(t, `"[secret]"`, string(o["bucketName"].Raw))
+	assert.Equal(t, `"[secret]"`, string(o["dbPassword"].Raw))
+}
+
+func TestMissingOutputs(t *testing.T) {
+	outs := auto.OutputMap{
+		"bucketName": {Value: "my-bucket"},
+		"dbPassword": {Value: "hunter2", Secret: true},
+	}
+	assert.Empty(t, missingOutputs(nil, outs))
+	assert.Empty(t, missingOutputs([]string{"dbPassword", "bucketName"}, outs))
+	assert.Equal(t, []string{"vpcId", "subnetIds"}, missingOutputs([]string{"vpcId", "bucketName", "subnetIds"}, outs))
+}
+
+func TestOutputsDocument(t *testing.T) {
+	raw := auto.OutputMap{
+		"bucketName": {Value: "my-bucket"},
+		"dbPassword": {Value: "hunter2", Secret: true},
+		"endpoints":  {Value: map[string]interface{}{"web": "https://example.com", "api": "https://api.example.com"}},
+	}
+	outs := shared.StackOutputs{
+		"bucketName": apiextensionsv1.JSON{Raw: []byte(`"my-bucket"`)},
+		"dbPassword": apiextensionsv1.JSON{Raw: []byte(`"[secret]"`)},
+		"endpoints":  apiextensionsv1.JSON{Raw: []byte(`{"web": "https://example.com", "api": "https://api.example.com"}`)},
+	}
+
+	doc, err := outputsDocument(outs, raw, false)
+	require.NoError(t, err)
+	assert.Equal(t, `{"bucketName":"my-bucket","endpoints":{"api":"https://api.example.com","web":"https://example.com"}}`, doc)
+
+	doc, err = outputsDocument(outs, raw, true)
+	require.NoError(t, err)
+	assert.Equal(t, `{}`, doc)
+}
+
+func TestResyncFrequencySeconds(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		spec     shared.StackSpec
+		expected int64
+	}{
+		{name: "Commit", spec: shared.StackSpec{Commit: "abc123"}, expected: 0},
+		{name: "CommitWithResync", spec: shared.StackSpec{Commit: "abc123", ResyncFrequencySeconds: 120}, expected: 120},
+		{name: "CommitContinueResync", spec: shared.StackSpec{Commit: "abc123", ContinueResyncOnCommitMatch: true}, expected: 60},
+		{name: "Branch", spec: shared.StackSpec{Branch: "main"}, expected: 60},
+		{name: "BranchWithResync", spec: shared.StackSpec{Branch: "main", ResyncFrequencySeconds: 300}, expected: 300},
+		{name: "MinimumFloor", spec: shared.StackSpec{Branch: "main", ResyncFrequencySeconds: 10}, expected: 60},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, resyncFrequencySeconds(test.spec))
+		})
+	}
+}
+
+func TestSubminuteResync(t *testing.T) {
+	spec := shared.StackSpec{Branch: "main", ResyncFrequencySeconds: 10}
+	t.Setenv(allowSubminuteResyncEnv, "true")
+	assert.Equal(t, int64(10), resyncFrequencySeconds(spec))
+	assert.Equal(t, int64(60), resyncFrequencySeconds(shared.StackSpec{Branch: "main"}), "the default is unchanged")
+	t.Setenv(allowSubminuteResyncEnv, "false")
+	assert.Equal(t, int64(60), resyncFrequencySeconds(spec))
+	t.Setenv(allowSubminuteResyncEnv, "sometimes")
+	assert.Equal(t, int64(60), resyncFrequencySeconds(spec))
+}
+
+func TestIsBackendUnavailableError(t *testing.T) {
+	exit := errors.New("exit status 1")
+	assert.False(t, isBackendUnavailableError(nil, "dial tcp: lookup api.pulumi.com: connection refused", ""))
+	assert.False(t, isBackendUnavailableError(exit, "error: program failed", ""))
+	assert.True(t, isBackendUnavailableError(exit,
+		`error: failed to get stack: Get "https://api.pulumi.com/api/stacks/o/p/s": dial tcp: lookup api.pulumi.com: no such host`, ""))
+	assert.True(t, isBackendUnavailableError(exit,
+		`error: Get "https://api.pulumi.com/api/user": [503] Service Unavailable`, "https://app.pulumi.com"))
+	assert.True(t, isBackendUnavailableError(
+		errors.New(`failed to create and/or select stack: Post "https://pulumi.example.com/api/stacks": dial tcp 10.0.0.1:443: i/o timeout`),
+		"", "https://pulumi.example.com"))
+	assert.True(t, isBackendUnavailableError(exit,
+		`error: read "s3://state/.pulumi/stacks/dev.json": dial tcp: lookup state.s3.amazonaws.com: no such host`, "s3://state"))
+
+	// The same errors from anything other than the backend don't count.
+	assert.False(t, isBackendUnavailableError(exit,
+		`error: Get "https://api.pulumi.com/api/user": dial tcp: lookup api.pulumi.com: no such host`, "https://pulumi.example.com"))
+	assert.False(t, isBackendUnavailableError(
+		errors.New("failed to clone: dial tcp: lookup github.com: no such host"), "", ""))
+	assert.False(t, isBackendUnavailableError(exit,
+		"error: creating EC2 instance: dial tcp 52.94.236.248:443: connect: connection refused", ""))
+	assert.False(t, isBackendUnavailableError(exit,
+		`error: Get "https://10.0.0.1:6443/api/v1/namespaces": dial tcp 10.0.0.1:6443: connect: connection refused`,
+		"https://api.pulumi.com"))
+	assert.False(t, isBackendUnavailableError(exit, "npm ERR! network request failed, reason: i/o timeout", ""))
+	assert.False(t, isBackendUnavailableError(exit, "error: dial tcp: connection refused", "file:///state"))
+}
+
+func TestWaitForFinalizer(t *testing.T) {
+	logger := logging.NewLogger(t.Name(), "Request.Test", "TestWaitForFinalizer")
+
+	s := runtime.NewScheme()
+	require.NoError(t, pulumiv1.SchemeBuilder.AddToScheme(s))
+	stack := &pulumiv1.Stack{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "finalized",
+			Namespace:  namespace,
+			Finalizers: []string{pulumiFinalizer},
+		},
+	}
+	client := fake.NewFakeClientWithScheme(s, stack)
+	sess := newReconcileStackSession(logger, shared.StackSpec{}, client, namespace)
+	assert.NoError(t, sess.waitForFinalizer(context.TODO(), types.NamespacedName{Namespace: namespace, Name: "finalized"}))
+
+	// A missing object is an error rather than something to wait for.
+	assert.Error(t, sess.waitForFinalizer(context.TODO(), types.NamespacedName{Namespace: namespace, Name: "missing"}))
+}
+
+func TestFinalizerSettleDelay(t *testing.T) {
+	assert.Equal(t, time.Duration(0), finalizerSettleDelay())
+
+	os.Setenv(finalizerSettleDelayEnv, "2s")
+	defer os.Unsetenv(finalizerSettleDelayEnv)
+	assert.Equal(t, 2*time.Second, finalizerSettleDelay())
+
+	os.Setenv(finalizerSettleDelayEnv, "soon")
+	assert.Equal(t, time.Duration(0), finalizerSettleDelay())
+}
+
+func TestWorkspaceFilePath(t *testing.T) {
+	for _, test := range []struct {
+		rel      string
+		expected string
+		err      bool
+	}{
+		{rel: "values.yaml", expected: "/work/project/values.yaml"},
+		{rel: "config/../values.yaml", expected: "/work/project/values.yaml"},
+		{rel: "./config/values.yaml", expected: "/work/project/config/values.yaml"},
+		{rel: "", err: true},
+		{rel: ".", err: true},
+		{rel: "/etc/passwd", err: true},
+		{rel: "../values.yaml", err: true},
+		{rel: "config/../../values.yaml", err: true},
+	} {
+		t.Run(test.rel, func(t *testing.T) {
+			p, err := workspaceFilePath("/work/project", test.rel)
+			if test.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, p)
+		})
+	}
+}
+
+func TestWriteWorkspaceFiles(t *testing.T) {
+	logger := logging.NewLogger(t.Name(), "Request.Test", "TestWriteWorkspaceFiles")
+
+	config := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "files", Namespace: namespace},
+		Data:       map[string]string{"values.yaml": "replicas: 3\n"},
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "secret-files", Namespace: namespace},
+		Data:       map[string][]byte{"token": []byte("s3cr3t")},
+	}
+	client := fake.NewFakeClientWithScheme(scheme.Scheme, config, secret)
+
+	dir := t.TempDir()
+	sess := newReconcileStackSession(logger, shared.StackSpec{
+		WorkspaceFiles: []shared.WorkspaceFile{
+			{Path: "values.yaml", ConfigMapRef: "files", Key: "values.yaml"},
+			{Path: "creds/token", SecretRef: "secret-files", Key: "token"},
+		},
+	}, client, namespace)
+	sess.workdir = dir
+	require.NoError(t, sess.WriteWorkspaceFiles(context.TODO()))
+
+	contents, err := os.ReadFile(filepath.Join(dir, "values.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, "replicas: 3\n", string(contents))
+	contents, err = os.ReadFile(filepath.Join(dir, "creds", "token"))
+	require.NoError(t, err)
+	assert.Equal(t, "s3cr3t", string(contents))
+
+	for _, file := range []shared.WorkspaceFile{
+		{Path: "values.yaml", Key: "values.yaml"},
+		{Path: "values.yaml", ConfigMapRef: "files", SecretRef: "secret-files", Key: "values.yaml"},
+		{Path: "values.yaml", ConfigMapRef: "files", Key: "missing"},
+		{Path: "../escape", ConfigMapRef: "files", Key: "values.yaml"},
+	} {
+		sess.stack.WorkspaceFiles = []shared.WorkspaceFile{file}
+		assert.Error(t, sess.WriteWorkspaceFiles(context.TODO()), "%+v", file)
+	}
+
+	// A symlinked directory in the source must not lead the file outside the project.
+	outside := t.TempDir()
+	require.NoError(t, os.Symlink(outside, filepath.Join(dir, "link")))
+	sess.stack.WorkspaceFiles = []shared.WorkspaceFile{{Path: "link/values.yaml", ConfigMapRef: "files", Key: "values.yaml"}}
+	assert.Error(t, sess.WriteWorkspaceFiles(context.TODO()))
+	_, err = os.Stat(filepath.Join(outside, "values.yaml"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestUntilRefresh(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	stack := &pulumiv1.Stack{}
+
+	_, scheduled := untilRefresh(stack, now)
+	assert.False(t, scheduled)
+
+	stack.Spec.RefreshSchedule = &shared.RefreshSchedule{IntervalSeconds: 600}
+	wait, scheduled := untilRefresh(stack, now)
+	assert.True(t, scheduled)
+	assert.Equal(t, time.Duration(0), wait, "a stack never updated nor refreshed is due")
+
+	stack.Status.LastUpdate = &shared.StackUpdateState{LastResyncTime: metav1.NewTime(now.Add(-4 * time.Minute))}
+	wait, _ = untilRefresh(stack, now)
+	assert.Equal(t, 6*time.Minute, wait)
+
+	lastRefresh := metav1.NewTime(now.Add(-11 * time.Minute))
+	stack.Status.LastRefresh = &lastRefresh
+	wait, _ = untilRefresh(stack, now)
+	assert.Equal(t, -time.Minute, wait)
+
+	// The interval is no less than a minute.
+	stack.Spec.RefreshSchedule.IntervalSeconds = 5
+	lastRefresh = metav1.NewTime(now)
+	wait, _ = untilRefresh(stack, now)
+	assert.Equal(t, time.Minute, wait)
+}
+
+func TestRequeueAfter(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	stack := &pulumiv1.Stack{}
+	assert.Equal(t, time.Duration(0), requeueAfter(stack, 0, now))
+	assert.Equal(t, time.Minute, requeueAfter(stack, time.Minute, now))
+
+	lastRefresh := metav1.NewTime(now.Add(-8 * time.Minute))
+	stack.Status.LastRefresh = &lastRefresh
+	stack.Spec.RefreshSchedule = &shared.RefreshSchedule{IntervalSeconds: 600}
+	assert.Equal(t, 2*time.Minute, requeueAfter(stack, 0, now))
+	assert.Equal(t, time.Minute, requeueAfter(stack, time.Minute, now))
+	assert.Equal(t, 2*time.Minute, requeueAfter(stack, 5*time.Minute, now))
+
+	// An overdue refresh is requeued promptly rather than not at all.
+	lastRefresh = metav1.NewTime(now.Add(-time.Hour))
+	assert.Equal(t, time.Second, requeueAfter(stack, 5*time.Minute, now))
+}
+
+func TestResourcesChanged(t *testing.T) {
+	summary := func(changes map[string]int) auto.UpdateSummary {
+		return auto.UpdateSummary{ResourceChanges: &changes}
+	}
+	assert.False(t, resourcesChanged(auto.UpdateSummary{}))
+	assert.False(t, resourcesChanged(summary(map[string]int{"same": 12})))
+	assert.False(t, resourcesChanged(summary(map[string]int{"same": 3, "read": 1, "create": 0})))
+	assert.True(t, resourcesChanged(summary(map[string]int{"same": 3, "create": 1})))
+	assert.True(t, resourcesChanged(summary(map[string]int{"update": 2})))
+	assert.True(t, resourcesChanged(summary(map[string]int{"delete": 1})))
+	assert.True(t, resourcesChanged(summary(map[string]int{"replace": 1})))
+}
+
+func TestMaxConcurrentReconcilesFromEnv(t *testing.T) {
+	n, err := maxConcurrentReconcilesFromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, defaultMaxConcurrentReconciles, n)
+
+	defer os.Unsetenv(maxConcurrentReconcilesEnv)
+	os.Setenv(maxConcurrentReconcilesEnv, "3")
+	n, err = maxConcurrentReconcilesFromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	for _, invalid := range []string{"", "lots", "0", "-2"} {
+		os.Setenv(maxConcurrentReconcilesEnv, invalid)
+		_, err = maxConcurrentReconcilesFromEnv()
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestPlannedDeletions(t *testing.T) {
+	steps := []apitype.StepEventMetadata{
+		{Op: apitype.OpSame, URN: "urn:a"},
+		{Op: apitype.OpUpdate, URN: "urn:b"},
+		{Op: apitype.OpCreate, URN: "urn:c"},
+		{Op: apitype.OpDelete, URN: "urn:e"},
+		{Op: apitype.OpCreateReplacement, URN: "urn:d"},
+		{Op: apitype.OpReplace, URN: "urn:d"},
+		{Op: apitype.OpDeleteReplaced, URN: "urn:d"},
+	}
+	deletions, existing := plannedDeletions(steps)
+	assert.Equal(t, []string{"urn:d", "urn:e"}, deletions)
+	assert.Equal(t, 4, existing)
+}
+
+func TestDeletionGuardTripped(t *testing.T) {
+	n := func(i int64) *int64 { return &i }
+
+	refuseAll := &shared.DeletionGuard{}
+	assert.False(t, deletionGuardTripped(refuseAll, 0, 10))
+	assert.True(t, deletionGuardTripped(refuseAll, 1, 10))
+
+	byCount := &shared.DeletionGuard{MaxDeletes: n(2)}
+	assert.False(t, deletionGuardTripped(byCount, 2, 10))
+	assert.True(t, deletionGuardTripped(byCount, 3, 10))
+
+	byPercent := &shared.DeletionGuard{MaxDeletePercent: n(20)}
+	assert.False(t, deletionGuardTripped(byPercent, 2, 10))
+	assert.True(t, deletionGuardTripped(byPercent, 3, 10))
+
+	both := &shared.DeletionGuard{MaxDeletes: n(5), MaxDeletePercent: n(50)}
+	assert.False(t, deletionGuardTripped(both, 5, 10))
+	assert.True(t, deletionGuardTripped(both, 6, 20))
+	assert.True(t, deletionGuardTripped(both, 3, 5))
+}
+
+func TestSummarizeURNs(t *testing.T) {
+	assert.Equal(t, "a, b", summarizeURNs([]string{"a", "b"}, 2))
+	assert.Equal(t, "a, b, and 2 more", summarizeURNs([]string{"a", "b", "c", "d"}, 2))
+}
+
+func TestApplyConfigMergeMode(t *testing.T) {
+	checkedIn := func() *workspace.ProjectStack {
+		return &workspace.ProjectStack{
+			SecretsProvider: "passphrase",
+			EncryptionSalt:  "v1:salt",
+			Config: config.Map{
+				config.MustMakeKey("aws", "region"): config.NewValue("us-east-1"),
+			},
+		}
+	}
+
+	for _, mode := range []shared.ConfigMergeMode{"", shared.ConfigMergeModeMerge} {
+		stackConfig := checkedIn()
+		applyConfigMergeMode(stackConfig, mode)
+		assert.Equal(t, checkedIn(), stackConfig, "mode %q", mode)
+	}
+
+	stackConfig := checkedIn()
+	applyConfigMergeMode(stackConfig, shared.ConfigMergeModeReplace)
+	assert.Empty(t, stackConfig.Config)
+	assert.Equal(t, "passphrase", stackConfig.SecretsProvider)
+	assert.Equal(t, "v1:salt", stackConfig.EncryptionSalt)
+}
+
+func TestResourcesDeleted(t *testing.T) {
+	assert.Equal(t, int64(0), resourcesDeleted(auto.UpdateSummary{}))
+	changes := map[string]int{"same": 4, "delete": 3, "replace": 1}
+	assert.Equal(t, int64(3), resourcesDeleted(auto.UpdateSummary{ResourceChanges: &changes}))
+}
+
+func TestResolveSecretsProvider(t *testing.T) {
+	logger := logging.NewLogger(t.Name(), "Request.Test", "TestResolveSecretsProvider")
+
+	sess := newReconcileStackSession(logger, shared.StackSpec{SecretsProvider: "awskms://alias/key"}, nil, namespace)
+	require.NoError(t, sess.resolveSecretsProvider(context.TODO()))
+	assert.Equal(t, "awskms://alias/key", sess.stack.SecretsProvider)
+
+	ref := shared.NewLiteralResourceRef("hashivault://key?token=s3cr3t")
+	sess = newReconcileStackSession(logger, shared.StackSpec{SecretsProviderRef: &ref}, nil, namespace)
+	require.NoError(t, sess.resolveSecretsProvider(context.TODO()))
+	assert.Equal(t, "hashivault://key?token=s3cr3t", sess.stack.SecretsProvider)
+
+	sess = newReconcileStackSession(logger, shared.StackSpec{SecretsProvider: "passphrase", SecretsProviderRef: &ref}, nil, namespace)
+	assert.Error(t, sess.resolveSecretsProvider(context.TODO()))
+
+	missing := shared.NewEnvResourceRef("MISSING_SECRETS_PROVIDER")
+	sess = newReconcileStackSession(logger, shared.StackSpec{SecretsProviderRef: &missing}, nil, namespace)
+	assert.Error(t, sess.resolveSecretsProvider(context.TODO()))
+}
+
+func TestPreviewHasChanges(t *testing.T) {
+	assert.False(t, previewHasChanges(nil))
+	assert.False(t, previewHasChanges(map[apitype.OpType]int{apitype.OpSame: 7, apitype.OpRead: 1}))
+	assert.False(t, previewHasChanges(map[apitype.OpType]int{apitype.OpSame: 7, apitype.OpUpdate: 0}))
+	assert.True(t, previewHasChanges(map[apitype.OpType]int{apitype.OpSame: 7, apitype.OpUpdate: 1}))
+	assert.True(t, previewHasChanges(map[apitype.OpType]int{apitype.OpReplace: 1}))
+}
+
+func TestLookupDefaultAccessToken(t *testing.T) {
+	logger := logging.NewLogger(t.Name(), "Request.Test", "TestLookupDefaultAccessToken")
+
+	local := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "pulumi-token", Namespace: namespace},
+		Data:       map[string][]byte{"accessToken": []byte("local-token")},
+	}
+	operatorWide := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "pulumi-token", Namespace: "operator"},
+		Data:       map[string][]byte{"accessToken": []byte("operator-token")},
+	}
+	empty := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "empty", Namespace: namespace},
+	}
+	client := fake.NewFakeClientWithScheme(scheme.Scheme, local, operatorWide, empty)
+	sess := newReconcileStackSession(logger, shared.StackSpec{}, client, namespace)
+
+	_, found := sess.lookupDefaultAccessToken(context.TODO())
+	assert.False(t, found)
+
+	defer os.Unsetenv(defaultAccessTokenSecretEnv)
+	for ref, expected := range map[string]string{
+		"pulumi-token":          "local-token",
+		"operator/pulumi-token": "operator-token",
+		"missing":               "",
+		"empty":                 "",
+	} {
+		os.Setenv(defaultAccessTokenSecretEnv, ref)
+		token, found := sess.lookupDefaultAccessToken(context.TODO())
+		assert.Equal(t, expected != "", found, ref)
+		assert.Equal(t, expected, token, ref)
+	}
+}
+
+func TestPathMatches(t *testing.T) {
+	for _, test := range []struct {
+		patterns []string
+		path     string
+		expected bool
+	}{
+		{[]string{"infra/app"}, "infra/app/index.ts", true},
+		{[]string{"infra/app/"}, "infra/app/index.ts", true},
+		{[]string{"infra/*.ts"}, "infra/index.ts", true},
+		{[]string{"infra/*.ts"}, "infra/app/index.ts", false},
+		{[]string{"infra/*"}, "infra/app/index.ts", true},
+		{[]string{"infra/app"}, "infra/application/index.ts", false},
+		{[]string{"docs", "infra/app"}, "README.md", false},
+		{[]string{"README.md"}, "README.md", true},
+	} {
+		matched, err := pathMatches(test.patterns, test.path)
+		require.NoError(t, err)
+		assert.Equal(t, test.expected, matched, "%v %s", test.patterns, test.path)
+	}
+
+	_, err := pathMatches([]string{"infra/["}, "infra/app")
+	assert.Error(t, err)
+}
+
+func TestChangedPathsMatch(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	require.NoError(t, err)
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+
+	commit := func(files map[string]string) string {
+		for name, contents := range files {
+			require.NoError(t, os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755))
+			require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(contents), 0644))
+			_, err := wt.Add(name)
+			require.NoError(t, err)
+		}
+		hash, err := wt.Commit("commit", &git.CommitOptions{
+			Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+		})
+		require.NoError(t, err)
+		return hash.String()
+	}
+
+	first := commit(map[string]string{"app/index.ts": "one", "docs/README.md": "one"})
+	docsOnly := commit(map[string]string{"docs/README.md": "two"})
+	appToo := commit(map[string]string{"app/index.ts": "two"})
+
+	matched, err := changedPathsMatch(dir, first, docsOnly, []string{"app"})
+	require.NoError(t, err)
+	assert.False(t, matched)
+
+	matched, err = changedPathsMatch(dir, first, appToo, []string{"app"})
+	require.NoError(t, err)
+	assert.True(t, matched)
+
+	matched, err = changedPathsMatch(dir, docsOnly, appToo, []string{"docs", "lib"})
+	require.NoError(t, err)
+	assert.False(t, matched)
+
+	_, err = changedPathsMatch(dir, "0123456789012345678901234567890123456789", appToo, []string{"app"})
+	assert.Error(t, err)
+}
+
+func TestSSHKeyScanArgs(t *testing.T) {
+	for _, test := range []struct {
+		url      string
+		expected []string
+	}{
+		{url: "git@github.com:foo/bar.git", expected: []string{"-H", "github.com"}},
+		{url: "ssh://git@github.com/foo/bar.git", expected: []string{"-H", "github.com"}},
+		{url: "ssh://git@git.example.com:7999/scm/proj/repo.git", expected: []string{"-p", "7999", "-H", "git.example.com"}},
+		{url: "ssh://git@[::1]:2222/repo.git", expected: []string{"-p", "2222", "-H", "::1"}},
+	} {
+		t.Run(test.url, func(t *testing.T) {
+			args, err := sshKeyScanArgs(test.url)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, args)
+		})
+	}
+
+	_, err := sshKeyScanArgs("file:///srv/git/repo.git")
+	assert.Error(t, err)
+}
+
+func TestGitRepoWithNonStandardPort(t *testing.T) {
+	logger := logging.NewLogger(t.Name(), "Request.Test", "TestGitRepoWithNonStandardPort")
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: secretName, Namespace: namespace},
+		Data: map[string][]byte{
+			"username": []byte("jenkins"),
+			"password": []byte("hunter2"),
+		},
+	}
+	client := fake.NewFakeClientWithScheme(scheme.Scheme, secret)
+	secretRef := func(key string) shared.ResourceRef {
+		return shared.ResourceRef{
+			SelectorType: shared.ResourceSelectorSecret,
+			ResourceSelector: shared.ResourceSelector{
+				SecretRef: &shared.SecretSelector{Namespace: namespace, Name: secretName, Key: key},
+			},
+		}
+	}
+
+	const repoURL = "https://git.example.com:8443/scm/proj/repo.git"
+	session := newReconcileStackSession(logger, shared.StackSpec{
+		ProjectRepo: repoURL,
+		RepoDir:     "stacks/dev",
+		Branch:      "main",
+		GitAuth: &shared.GitAuthConfig{
+			BasicAuth: &shared.BasicAuth{
+				UserName: secretRef("username"),
+				Password: secretRef("password"),
+			},
+		},
+	}, client, namespace)
+
+	gitAuth, err := session.SetupGitAuth(context.TODO())
+	require.NoError(t, err)
+
+	repo := session.gitRepo(gitAuth)
+	assert.Equal(t, repoURL, repo.URL)
+	assert.Equal(t, "stacks/dev", repo.ProjectPath)
+	assert.Equal(t, "main", repo.Branch)
+	assert.Equal(t, &auto.GitAuth{Username: "jenkins", Password: "hunter2"}, repo.Auth)
+}
+
+func TestUntilBreakLock(t *testing.T) {
+	now := time.Now()
+	assert.Equal(t, 5*time.Minute, untilBreakLock(600, now.Add(-5*time.Minute), now))
+	assert.Equal(t, time.Duration(0), untilBreakLock(600, now.Add(-10*time.Minute), now))
+	assert.True(t, untilBreakLock(600, now.Add(-time.Hour), now) < 0)
+}
+
+func TestUsesPassphrase(t *testing.T) {
+	salted := &workspace.ProjectStack{EncryptionSalt: "v1:abcd"}
+	assert.True(t, usesPassphrase("passphrase", &workspace.ProjectStack{}))
+	assert.True(t, usesPassphrase("", salted))
+	assert.True(t, usesPassphrase("", &workspace.ProjectStack{SecretsProvider: "passphrase"}))
+	assert.False(t, usesPassphrase("", &workspace.ProjectStack{}))
+	assert.False(t, usesPassphrase("default", salted))
+	assert.False(t, usesPassphrase("awskms://alias/key", &workspace.ProjectStack{}))
+	assert.False(t, usesPassphrase("", &workspace.ProjectStack{SecretsProvider: "gcpkms://key", EncryptionSalt: "v1:abcd"}))
+}
+
+func TestUsesVault(t *testing.T) {
+	assert.True(t, usesVault("hashivault://mykey", &workspace.ProjectStack{}))
+	assert.True(t, usesVault("", &workspace.ProjectStack{SecretsProvider: "hashivault://mykey"}))
+	assert.False(t, usesVault("", &workspace.ProjectStack{}))
+	assert.False(t, usesVault("passphrase", &workspace.ProjectStack{SecretsProvider: "hashivault://mykey"}))
+	assert.False(t, usesVault("awskms://alias/key", &workspace.ProjectStack{}))
+}
+
+func TestProjectForDestroy(t *testing.T) {
+	assert.Equal(t, "website", projectForDestroy("acme/website/prod", ""))
+	assert.Equal(t, "website", projectForDestroy("acme/website/prod", "other"))
+	assert.Equal(t, "recorded", projectForDestroy("prod", "recorded"))
+	assert.Equal(t, "", projectForDestroy("acme/prod", ""))
+}
+
+func TestFinalizeWithoutSourceNeedsProject(t *testing.T) {
+	logger := logging.NewLogger(t.Name(), "Request.Test", "TestFinalizeWithoutSourceNeedsProject")
+	r := &ReconcileStack{recorder: record.NewFakeRecorder(10)}
+	sess := newReconcileStackSession(logger, shared.StackSpec{Stack: "prod", DestroyOnFinalize: true}, nil, namespace)
+	instance := &pulumiv1.Stack{}
+	instance.SetFinalizers([]string{pulumiFinalizer})
+
+	result, err := r.finalizeWithoutSource(context.TODO(), sess, instance, errors.New("repository not found"))
+	assert.NoError(t, err)
+	assert.NotZero(t, result.RequeueAfter)
+	assert.Equal(t, shared.StackOperationDestroy, instance.Status.LastUpdate.Kind)
+	assert.Equal(t, shared.FailedStackStateMessage, instance.Status.LastUpdate.State)
+	assert.Contains(t, instance.GetFinalizers(), pulumiFinalizer)
+}
+
+func TestNormalizeStackName(t *testing.T) {
+	for _, c := range []struct {
+		name, project, backend string
+		want                   string
+	}{
+		{"dev", "website", "", "dev"},
+		{"acme/dev", "website", "", "acme/dev"},
+		{"acme/website/dev", "website", "https://api.pulumi.com", "acme/website/dev"},
+		{"dev", "website", "s3://state-bucket", "dev"},
+		{"organization/website/dev", "website", "file:///state", "dev"},
+	} {
+		got, err := normalizeStackName(c.name, c.project, c.backend)
+		assert.NoError(t, err, c.name)
+		assert.Equal(t, c.want, got, c.name)
+	}
+
+	for _, c := range []struct {
+		name, project, backend string
+	}{
+		{"acme/dev", "website", "s3://state-bucket"},
+		{"acme/other/dev", "website", "https://api.pulumi.com"},
+		{"acme/other/dev", "website", "azblob://state"},
+		{"acme//dev", "website", ""},
+		{"a/b/c/d", "website", ""},
+	} {
+		_, err := normalizeStackName(c.name, c.project, c.backend)
+		var badName *stackNameError
+		assert.True(t, errors.As(err, &badName), c.name)
+	}
+}
+
+func TestCloneTimeout(t *testing.T) {
+	// A git server which accepts connections, but never responds.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		var conns []net.Conn
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				break
+			}
+			conns = append(conns, conn)
+		}
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+
+	logger := logging.NewLogger(t.Name(), "Request.Test", "TestCloneTimeout")
+	sess := newReconcileStackSession(logger, shared.StackSpec{
+		Stack:               "dev",
+		ProjectRepo:         "http://" + listener.Addr().String() + "/project.git",
+		Branch:              "refs/heads/main",
+		CloneTimeoutSeconds: 1,
+	}, nil, namespace)
+	defer sess.CleanupPulumiDir()
+
+	err = sess.SetupPulumiWorkdir(context.TODO(), &auto.GitAuth{})
+	var timeout *cloneTimeoutError
+	assert.True(t, errors.As(err, &timeout), "expected a clone timeout, got %v", err)
+	sess.CleanupPulumiDir()
+
+	// If a mirror then fails for another reason, that's what's reported.
+	sess.stack.ProjectRepoMirrors = []string{"http://127.0.0.1:1/project.git"}
+	err = sess.SetupPulumiWorkdir(context.TODO(), &auto.GitAuth{})
+	require.Error(t, err)
+	assert.False(t, errors.As(err, &timeout), "expected no clone timeout, got %v", err)
+}
+
+func TestExpandRepoDir(t *testing.T) {
+	config := map[string]string{"region": "eu-west-1", "aws:region": "us-east-1"}
+	env := map[string]string{"TIER": "prod"}
+
+	for in, want := range map[string]string{
+		"":                             "",
+		"infra/app":                    "infra/app",
+		"regions/{{ .Config.region }}": "regions/eu-west-1",
+		`regions/{{ index .Config "aws:region" }}`: "regions/us-east-1",
+		"{{ .Env.TIER }}/{{ .Config.region }}":     "prod/eu-west-1",
+	} {
+		got, err := expandRepoDir(in, config, env)
+		assert.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+
+	for _, in := range []string{
+		"regions/{{ .Config.zone }}",
+		"regions/{{ .Config.region",
+		"{{ .Env.TIER }}",
+		"../{{ .Config.region }}",
+		"/regions/{{ .Config.region }}",
+	} {
+		_, err := expandRepoDir(in, config, nil)
+		assert.Error(t, err, in)
+	}
+}
+
+func TestRefreshChanges(t *testing.T) {
+	const (
+		bucket   = "urn:pulumi:prod::site::aws:s3/bucket:Bucket::assets"
+		instance = "urn:pulumi:prod::site::aws:ec2/instance:Instance::web"
+		record   = "urn:pulumi:prod::site::cloudflare:index/record:Record::www"
+	)
+	outputs := func(tags string) *apitype.StepEventStateMetadata {
+		return &apitype.StepEventStateMetadata{Outputs: map[string]interface{}{"tags": tags}}
+	}
+	steps := []apitype.StepEventMetadata{
+		{Op: apitype.OpRefresh, URN: bucket, Type: "aws:s3/bucket:Bucket", Old: outputs("a"), New: outputs("a")},
+		{Op: apitype.OpRefresh, URN: instance, Type: "aws:ec2/instance:Instance", Old: outputs("a"), New: outputs("b")},
+		{Op: apitype.OpUpdate, URN: record, Type: "cloudflare:index/record:Record"},
+	}
+
+	assert.Equal(t, []string{instance, record}, refreshChanges(steps, nil))
+	assert.Equal(t, []string{record}, refreshChanges(steps, []string{"aws:ec2/instance:Instance"}))
+	assert.Equal(t, []string{record}, refreshChanges(steps, []string{"aws:*"}))
+	assert.Equal(t, []string{instance}, refreshChanges(steps, []string{record}))
+	assert.Empty(t, refreshChanges(steps, []string{"*::web", "cloudflare:*"}))
+}
+
+func TestCheckAccessToken(t *testing.T) {
+	t.Setenv("PULUMI_ACCESS_TOKEN", "")
+
+	assert.NoError(t, checkAccessToken(nil, "file:///state", ""), "self-managed backends need no token")
+	assert.NoError(t, checkAccessToken(nil, "", ""), "an unknown backend is left to Pulumi")
+	assert.NoError(t, checkAccessToken(nil, "https://pulumi.example.com", ""), "only Pulumi Cloud is checked")
+	assert.NoError(t, checkAccessToken(map[string]string{"PULUMI_ACCESS_TOKEN": "pul-123"}, "https://api.pulumi.com", ""))
+
+	err := checkAccessToken(map[string]string{}, "https://api.pulumi.com", "pulumi-token")
+	var noToken *accessTokenError
+	require.True(t, errors.As(err, &noToken))
+	assert.Contains(t, err.Error(), "https://api.pulumi.com")
+	assert.Contains(t, err.Error(), `"pulumi-token"`)
+
+	err = checkAccessToken(map[string]string{}, "https://app.pulumi.com/", "")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "accessTokenSecret")
+
+	home := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(home, "credentials.json"),
+		[]byte(`{"current":"https://api.pulumi.com","accessTokens":{"https://api.pulumi.com":"pul-789"}}`), 0600))
+	assert.NoError(t, checkAccessToken(map[string]string{"PULUMI_HOME": home}, "https://api.pulumi.com", ""),
+		"a stored login is used")
+
+	t.Setenv("PULUMI_ACCESS_TOKEN", "pul-456")
+	assert.NoError(t, checkAccessToken(map[string]string{}, "https://api.pulumi.com", ""), "the operator's own token is used")
+}
+
+func TestResolveDownwardRef(t *testing.T) {
+	t.Setenv(clusterNameEnv, "")
+	logger := logging.NewLogger(t.Name(), "Request.Test", "TestResolveDownwardRef")
+	sess := newReconcileStackSession(logger, shared.StackSpec{}, nil, namespace)
+	sess.meta = metav1.ObjectMeta{
+		Name:        "app",
+		Namespace:   "tenant-a",
+		Labels:      map[string]string{"team": "platform"},
+		Annotations: map[string]string{"example.com/region": "eu-west-1"},
+	}
+	resolve := func(fieldPath string) (string, error) {
+		ref := shared.ResourceRef{
+			SelectorType:     shared.ResourceSelectorDownward,
+			ResourceSelector: shared.ResourceSelector{Downward: &shared.DownwardSelector{FieldPath: fieldPath}},
+		}
+		return sess.resolveResourceRef(context.Background(), &ref)
+	}
+
+	for fieldPath, expected := range map[string]string{
+		"metadata.name":                              "app",
+		"metadata.namespace":                         "tenant-a",
+		"metadata.labels['team']":                    "platform",
+		"metadata.annotations['example.com/region']": "eu-west-1",
+	} {
+		value, err := resolve(fieldPath)
+		assert.NoError(t, err, fieldPath)
+		assert.Equal(t, expected, value, fieldPath)
+	}
+
+	for _, fieldPath := range []string{"metadata.labels['missing']", "spec.stack", "clusterName"} {
+		_, err := resolve(fieldPath)
+		assert.Error(t, err, fieldPath)
+	}
+
+	t.Setenv(clusterNameEnv, "prod-eu")
+	value, err := resolve("clusterName")
+	assert.NoError(t, err)
+	assert.Equal(t, "prod-eu", value)
+}
+
+func TestGitCredentialRotation(t *testing.T) {
+	// A git server which accepts only the current token, and has no repository to give anyway.
+	var mu sync.Mutex
+	currentToken, seenToken := "new-token", ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, password, _ := r.BasicAuth()
+		mu.Lock()
+		defer mu.Unlock()
+		seenToken = password
+		if password != currentToken {
+			w.Header().Set("WWW-Authenticate", `Basic realm="git"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "git-auth", Namespace: namespace},
+		Data:       map[string][]byte{"accessToken": []byte("old-token")},
+	}
+	client := fake.NewFakeClientWithScheme(scheme.Scheme, secret)
+	logger := logging.NewLogger(t.Name(), "Request.Test", "TestGitCredentialRotation")
+	spec := shared.StackSpec{
+		Stack:         "dev",
+		ProjectRepo:   server.URL + "/project.git",
+		Branch:        "refs/heads/main",
+		GitAuthSecret: "git-auth",
+	}
+
+	// The token in the secret has been rotated at the server, but not yet in the secret.
+	sess := newReconcileStackSession(logger, spec, client, namespace)
+	gitAuth, err := sess.SetupGitAuth(context.TODO())
+	require.NoError(t, err)
+	err = sess.SetupPulumiWorkdir(context.TODO(), gitAuth)
+	var authFailed *gitAuthError
+	assert.True(t, errors.As(err, &authFailed), "expected an authentication failure, got %v", err)
+
+	// Once the secret is updated, the next attempt uses the new token.
+	secret.Data["accessToken"] = []byte("new-token")
+	require.NoError(t, client.Update(context.TODO(), secret))
+	sess = newReconcileStackSession(logger, spec, client, namespace)
+	gitAuth, err = sess.SetupGitAuth(context.TODO())
+	require.NoError(t, err)
+	err = sess.SetupPulumiWorkdir(context.TODO(), gitAuth)
+	require.Error(t, err, "there is no repository to clone")
+	assert.False(t, errors.As(err, &authFailed), "expected the new token to be accepted, got %v", err)
+	mu.Lock()
+	assert.Equal(t, "new-token", seenToken)
+	mu.Unlock()
+}
+
+func TestSecretsProviderURL(t *testing.T) {
+	for _, tc := range []struct {
+		key  shared.SecretsProviderKey
+		want string
+	}{
+		{shared.SecretsProviderKey{Type: shared.SecretsProviderAWSKMS, KeyID: "alias/mykey", Region: "us-east-1"},
+			"awskms://alias/mykey?region=us-east-1"},
+		{shared.SecretsProviderKey{Type: shared.SecretsProviderAWSKMS, KeyID: "arn:aws:kms:us-east-1:111122223333:key/1234", Region: "us-east-1",
+			Params: map[string]string{"awssdk": "v2"}},
+			"awskms:///arn:aws:kms:us-east-1:111122223333:key/1234?awssdk=v2&region=us-east-1"},
+		{shared.SecretsProviderKey{Type: shared.SecretsProviderAzureKeyVault, KeyID: "acmecorpvault.vault.azure.net/keys/mykeyname"},
+			"azurekeyvault://acmecorpvault.vault.azure.net/keys/mykeyname"},
+		{shared.SecretsProviderKey{Type: shared.SecretsProviderGCPKMS, KeyID: "projects/p/locations/l/keyRings/r/cryptoKeys/k"},
+			"gcpkms://projects/p/locations/l/keyRings/r/cryptoKeys/k"},
+		{shared.SecretsProviderKey{Type: shared.SecretsProviderHashiVault, KeyID: "mykey"}, "hashivault://mykey"},
+	} {
+		got, err := secretsProviderURL(&tc.key)
+		require.NoError(t, err, "%+v", tc.key)
+		assert.Equal(t, tc.want, got)
+	}
+
+	for _, key := range []shared.SecretsProviderKey{
+		{Type: shared.SecretsProviderAWSKMS, KeyID: "alias/mykey"},
+		{Type: shared.SecretsProviderGCPKMS, Region: "europe-west1"},
+		{Type: shared.SecretsProviderGCPKMS, KeyID: "projects/p/locations/l/keyRings/r/cryptoKeys/k", Region: "europe-west1"},
+		{Type: "passphrase", KeyID: "mykey"},
+	} {
+		_, err := secretsProviderURL(&key)
+		assert.Error(t, err, "%+v", key)
+	}
+
+	// The secrets provider given as a URL takes precedence.
+	logger := logging.NewLogger(t.Name(), "Request.Test", "TestSecretsProviderURL")
+	key := &shared.SecretsProviderKey{Type: shared.SecretsProviderHashiVault, KeyID: "mykey"}
+	sess := newReconcileStackSession(logger, shared.StackSpec{SecretsProvider: "passphrase", SecretsProviderKey: key}, nil, namespace)
+	require.NoError(t, sess.resolveSecretsProvider(context.TODO()))
+	assert.Equal(t, "passphrase", sess.stack.SecretsProvider)
+	sess = newReconcileStackSession(logger, shared.StackSpec{SecretsProviderKey: key}, nil, namespace)
+	require.NoError(t, sess.resolveSecretsProvider(context.TODO()))
+	assert.Equal(t, "hashivault://mykey", sess.stack.SecretsProvider)
+}
+
+// stackCreatingWorkspace is a workspace in which creating a stack gives the error given.
+type stackCreatingWorkspace struct {
+	auto.Workspace
+	createErr error
+}
+
+func (w *stackCreatingWorkspace) CreateStack(context.Context, string) error {
+	return w.createErr
+}
+
+func TestUpsertStack(t *testing.T) {
+	s, created, err := upsertStack(context.TODO(), "dev", &stackCreatingWorkspace{})
+	require.NoError(t, err)
+	assert.True(t, created)
+	assert.Equal(t, "dev", s.Name())
+
+	_, created, err = upsertStack(context.TODO(), "dev", &stackCreatingWorkspace{createErr: errors.New("backend unavailable")})
+	assert.Error(t, err)
+	assert.False(t, created)
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	repo := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(repo, "config"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(repo, "config", "prod.yaml"),
+		[]byte("secretsprovider: passphrase\nconfig:\n  app:replicas: \"3\"\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(repo, "config", "broken.yaml"), []byte("config: [\n"), 0644))
+
+	stackConfig, err := loadConfigFile(repo, "config/prod.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, "passphrase", stackConfig.SecretsProvider)
+	assert.Contains(t, stackConfig.Config, config.MustMakeKey("app", "replicas"))
+
+	for _, path := range []string{"config/broken.yaml", "config/missing.yaml", "../prod.yaml", "/etc/passwd"} {
+		_, err := loadConfigFile(repo, path)
+		var badFile *configFileError
+		assert.True(t, errors.As(err, &badFile), "%s: %v", path, err)
+	}
+}
+
+func TestValidateGitCommitter(t *testing.T) {
+	assert.NoError(t, validateGitCommitter(nil))
+	assert.NoError(t, validateGitCommitter(&shared.GitCommitter{Name: "Pulumi Operator", Email: "operator@example.com"}))
+
+	for _, committer := range []shared.GitCommitter{
+		{Email: "operator@example.com"},
+		{Name: "Pulumi <Operator>", Email: "operator@example.com"},
+		{Name: "Pulumi Operator"},
+		{Name: "Pulumi Operator", Email: "not an address"},
+		{Name: "Pulumi Operator", Email: "Pulumi Operator <operator@example.com>"},
+	} {
+		assert.Error(t, validateGitCommitter(&committer), "%+v", committer)
+	}
+}
+
+func TestIsStackGoneError(t *testing.T) {
+	gone := []string{
+		"failed to remove stack: exit status 255\ncode: 255\nstdout: \nstderr: error: no stack named 'acme/app/dev' found\n",
+		"failed to remove stack: exit status 255\ncode: 255\nstdout: \nstderr: error: [404] Not found\n",
+	}
+	for _, msg := range gone {
+		assert.True(t, isStackGoneError(errors.New(msg)), msg)
+	}
+	other := "failed to remove stack: exit status 255\ncode: 255\nstdout: \nstderr: error: 'dev' still has resources; removal rejected\n"
+	assert.False(t, isStackGoneError(errors.New(other)))
+}
+
+func TestSetupWorkspaceEnvSkipsUpdateCheck(t *testing.T) {
+	logger := logging.NewLogger(t.Name(), "Request.Test", "SetupWorkspaceEnv")
+	client := fake.NewFakeClientWithScheme(scheme.Scheme)
+
+	for _, tc := range []struct {
+		name    string
+		spec    shared.StackSpec
+		wantSet bool
+		want    string
+	}{
+		{name: "default", wantSet: true, want: "true"},
+		{name: "enabled", spec: shared.StackSpec{EnableCLIUpdateCheck: true}},
+		{
+			name:    "given in env",
+			spec:    shared.StackSpec{Env: map[string]string{"PULUMI_SKIP_UPDATE_CHECK": "false"}},
+			wantSet: true,
+			want:    "false",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			sess := newReconcileStackSession(logger, tc.spec, client, namespace)
+			w := &envWorkspace{envs: map[string]string{}}
+			require.NoError(t, sess.setupWorkspaceEnv(context.Background(), w))
+			value, set := w.envs["PULUMI_SKIP_UPDATE_CHECK"]
+			assert.Equal(t, tc.wantSet, set)
+			assert.Equal(t, tc.want, value)
+		})
+	}
+}
+
+// projectWorkspace is a workspace holding only project settings.
+type projectWorkspace struct {
+	auto.Workspace
+	project *workspace.Project
+}
+
+func (w *projectWorkspace) ProjectSettings(context.Context) (*workspace.Project, error) {
+	return w.project, nil
+}
+
+func (w *projectWorkspace) SaveProjectSettings(_ context.Context, project *workspace.Project) error {
+	w.project = project
+	return nil
+}
+
+func TestSetRefreshDuringUpdate(t *testing.T) {
+	logger := logging.NewLogger(t.Name(), "Request.Test", "SetRefreshDuringUpdate")
+	w := &projectWorkspace{project: &workspace.Project{Name: "app"}}
+
+	sess := newReconcileStackSession(logger, shared.StackSpec{}, nil, namespace)
+	require.NoError(t, sess.setRefreshDuringUpdate(context.Background(), w))
+	assert.Nil(t, w.project.Options)
+
+	sess = newReconcileStackSession(logger, shared.StackSpec{RefreshDuringUpdate: true}, nil, namespace)
+	require.NoError(t, sess.setRefreshDuringUpdate(context.Background(), w))
+	require.NotNil(t, w.project.Options)
+	assert.Equal(t, "always", w.project.Options.Refresh)
+	assert.Equal(t, "app", string(w.project.Name))
+}
+
+func TestSecretFileRefRedacted(t *testing.T) {
+	// A file mounted by the Secrets Store CSI driver is read like any other file.
+	path := filepath.Join(t.TempDir(), "db-password")
+	require.NoError(t, os.WriteFile(path, []byte("hunter2"), 0600))
+
+	logger := logging.NewLogger(t.Name(), "Request.Test", "SecretFileRefRedacted")
+	ref := shared.NewFileSystemResourceRef(path)
+	sess := newReconcileStackSession(logger, shared.StackSpec{}, nil, namespace)
+	resolved, err := sess.resolveResourceRef(context.Background(), &ref)
+	require.NoError(t, err)
+	assert.Equal(t, "hunter2", resolved)
+
+	assert.Equal(t, map[string]string{
+		"app:user":     "admin",
+		"app:password": "[secret]",
+	}, redactConfig(auto.ConfigMap{
+		"app:user":     {Value: "admin"},
+		"app:password": {Value: resolved, Secret: true},
+	}))
+}
+
+func TestSpecConfigRefs(t *testing.T) {
+	t.Setenv(clusterNameEnv, "prod-eu")
+	logger := logging.NewLogger(t.Name(), "Request.Test", "SpecConfigRefs")
+	downward := func(fieldPath string) shared.ResourceRef {
+		return shared.ResourceRef{
+			SelectorType:     shared.ResourceSelectorDownward,
+			ResourceSelector: shared.ResourceSelector{Downward: &shared.DownwardSelector{FieldPath: fieldPath}},
+		}
+	}
+	sess := newReconcileStackSession(logger, shared.StackSpec{
+		Config: map[string]string{"app:replicas": "2", "app:cluster": "overridden"},
+		ConfigRefs: map[string]shared.ResourceRef{
+			"app:namespace": downward("metadata.namespace"),
+			"app:cluster":   downward("clusterName"),
+		},
+		SecretRefs: map[string]shared.ResourceRef{
+			"app:token": shared.NewLiteralResourceRef("t0ken"),
+		},
+	}, nil, namespace)
+	sess.meta = metav1.ObjectMeta{Name: "app", Namespace: "tenant-a"}
+
+	config, err := sess.specConfig(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, auto.ConfigMap{
+		"app:replicas":  {Value: "2"},
+		"app:namespace": {Value: "tenant-a"},
+		"app:cluster":   {Value: "prod-eu"},
+		"app:token":     {Value: "t0ken", Secret: true},
+	}, config)
+
+	sess.stack.ConfigRefs["app:missing"] = downward("metadata.labels['missing']")
+	_, err = sess.specConfig(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "app:missing")
+}
+
+func TestReplaceWorkspaceFileSymlink(t *testing.T) {
+	dir, outside := t.TempDir(), t.TempDir()
+	require.NoError(t, os.Symlink(outside, filepath.Join(dir, "link")))
+
+	require.NoError(t, replaceWorkspaceFile(dir, filepath.Join(dir, "conf", "app", "settings.json"), []byte("{}")))
+	contents, err := os.ReadFile(filepath.Join(dir, "conf", "app", "settings.json"))
+	require.NoError(t, err)
+	assert.Equal(t, "{}", string(contents))
+
+	err = replaceWorkspaceFile(dir, filepath.Join(dir, "link", "new", "settings.json"), []byte("{}"))
+	assert.Error(t, err)
+	_, err = os.Stat(filepath.Join(outside, "new"))
+	assert.True(t, os.IsNotExist(err), "no directory is created outside the workdir")
+}
+
+func TestSetStackReferenceAccess(t *testing.T) {
+	t.Setenv("PULUMI_ACCESS_TOKEN", "")
+	logger := logging.NewLogger(t.Name(), "Request.Test", "SetStackReferenceAccess")
+	ref := func(name, token string) shared.StackReference {
+		r := shared.StackReference{Name: name}
+		if token != "" {
+			tokenRef := shared.NewLiteralResourceRef(token)
+			r.AccessToken = &tokenRef
+		}
+		return r
+	}
+	setAccess := func(envs map[string]string, backend string, refs ...shared.StackReference) (map[string]string, error) {
+		sess := newReconcileStackSession(logger, shared.StackSpec{StackReferences: refs}, nil, namespace)
+		w := &envWorkspace{envs: envs}
+		err := sess.SetStackReferenceAccess(context.Background(), w, backend)
+		return w.envs, err
+	}
+
+	// A token given for the references is used if the stack has none of its own.
+	envs, err := setAccess(map[string]string{}, "", ref("acme/network/prod", "pul-ref"), ref("acme/db/prod", "pul-ref"))
+	require.NoError(t, err)
+	assert.Equal(t, "pul-ref", envs["PULUMI_ACCESS_TOKEN"])
+
+	// The stack's own token is kept.
+	envs, err = setAccess(map[string]string{"PULUMI_ACCESS_TOKEN": "pul-own"}, "", ref("acme/network/prod", "pul-ref"))
+	require.NoError(t, err)
+	assert.Equal(t, "pul-own", envs["PULUMI_ACCESS_TOKEN"])
+
+	// Different tokens can't stand in for the stack's own.
+	_, err = setAccess(map[string]string{}, "", ref("acme/network/prod", "pul-a"), ref("other/db/prod", "pul-b"))
+	assert.Error(t, err)
+	envs, err = setAccess(map[string]string{"PULUMI_ACCESS_TOKEN": "pul-own"}, "",
+		ref("acme/network/prod", "pul-a"), ref("other/db/prod", "pul-b"))
+	require.NoError(t, err)
+	assert.Equal(t, "pul-own", envs["PULUMI_ACCESS_TOKEN"])
+
+	// An organization is needed, except on a self-managed backend.
+	_, err = setAccess(map[string]string{}, "https://api.pulumi.com", ref("network/prod", ""))
+	assert.Error(t, err)
+	_, err = setAccess(map[string]string{}, "s3://state", ref("prod", ""), ref("network/prod", ""), ref("organization/network/prod", ""))
+	assert.NoError(t, err)
+	_, err = setAccess(map[string]string{}, "s3://state", ref("network//prod", ""))
+	assert.Error(t, err)
+}
+
+func TestSetupKubeconfig(t *testing.T) {
+	logger := logging.NewLogger(t.Name(), "Request.Test", "SetupKubeconfig")
+	kubeconfig := shared.NewLiteralResourceRef("apiVersion: v1\nkind: Config\n")
+	sess := newReconcileStackSession(logger, shared.StackSpec{Kubeconfig: &kubeconfig}, nil, namespace)
+	sess.rootDir = t.TempDir()
+	w := &envWorkspace{envs: map[string]string{}}
+
+	require.NoError(t, sess.SetupKubeconfig(context.Background(), w))
+	path := filepath.Join(sess.rootDir, "kubeconfig")
+	assert.Equal(t, map[string]string{"KUBECONFIG": path, "KUBE_CONFIG_PATH": path}, w.envs)
+	contents, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "apiVersion: v1\nkind: Config\n", string(contents))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// Without a kubeconfig, the ambient one is left to be used.
+	sess = newReconcileStackSession(logger, shared.StackSpec{}, nil, namespace)
+	w = &envWorkspace{envs: map[string]string{}}
+	require.NoError(t, sess.SetupKubeconfig(context.Background(), w))
+	assert.Empty(t, w.envs)
+}
+
+func TestSpecConfigKubeContext(t *testing.T) {
+	logger := logging.NewLogger(t.Name(), "Request.Test", "SpecConfigKubeContext")
+	sess := newReconcileStackSession(logger, shared.StackSpec{KubeContext: "staging"}, nil, namespace)
+	config, err := sess.specConfig(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, auto.ConfigValue{Value: "staging"}, config["kubernetes:context"])
+
+	// A context given in Config overrides KubeContext.
+	sess = newReconcileStackSession(logger, shared.StackSpec{
+		KubeContext: "staging",
+		Config:      map[string]string{"kubernetes:context": "production"},
+	}, nil, namespace)
+	config, err = sess.specConfig(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, auto.ConfigValue{Value: "production"}, config["kubernetes:context"])
+}
+
+func TestMarkStackFailedRecordsKind(t *testing.T) {
+	logger := logging.NewLogger(t.Name(), "Request.Test", "TestMarkStackFailedRecordsKind")
+	r := &ReconcileStack{recorder: record.NewFakeRecorder(10)}
+	sess := newReconcileStackSession(logger, shared.StackSpec{Stack: "dev"}, nil, namespace)
+	instance := &pulumiv1.Stack{}
+	instance.Status.LastUpdate = &shared.StackUpdateState{
+		Kind:  shared.StackOperationUpdate,
+		State: shared.SucceededStackStateMessage,
+	}
+
+	sess.pulumiVersion = "3.39.3"
+
+	r.markStackFailed(sess, instance, shared.StackOperationRefresh, errors.New("boom"), "abc123", "")
+	assert.Equal(t, shared.StackOperationRefresh, instance.Status.LastUpdate.Kind)
+	assert.Equal(t, shared.FailedStackStateMessage, instance.Status.LastUpdate.State)
+	assert.Equal(t, "abc123", instance.Status.LastUpdate.LastAttemptedCommit)
+	assert.Equal(t, "3.39.3", instance.Status.LastUpdate.PulumiVersion)
+}
+
+func TestSetUpdateTiming(t *testing.T) {
+	started := metav1.NewTime(time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC))
+	earlier := metav1.NewTime(started.Add(-24 * time.Hour))
+	for _, tc := range []struct {
+		name     string
+		failed   bool
+		previous *shared.StackUpdateState
+		took     time.Duration
+		duration int64
+	}{
+		{name: "success", took: 90 * time.Second, duration: 90},
+		{name: "under a second", took: 400 * time.Millisecond, duration: 0},
+		{name: "failure", failed: true, took: 5 * time.Minute, duration: 300},
+		{
+			name:   "failure after an earlier update",
+			failed: true,
+			previous: &shared.StackUpdateState{
+				State:           shared.SucceededStackStateMessage,
+				StartedAt:       earlier,
+				FinishedAt:      metav1.NewTime(earlier.Add(time.Minute)),
+				DurationSeconds: 60,
+			},
+			took:     30 * time.Second,
+			duration: 30,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			logger := logging.NewLogger(t.Name(), "Request.Test", "TestSetUpdateTiming")
+			r := &ReconcileStack{recorder: record.NewFakeRecorder(10)}
+			sess := newReconcileStackSession(logger, shared.StackSpec{Stack: "dev"}, nil, namespace)
+			instance := &pulumiv1.Stack{}
+			instance.Status.LastUpdate = tc.previous
+			finished := metav1.NewTime(started.Add(tc.took))
+
+			if tc.failed {
+				r.markStackFailed(sess, instance, shared.StackOperationUpdate, errors.New("boom"), "abc123", "")
+			} else {
+				instance.Status.LastUpdate = &shared.StackUpdateState{State: shared.SucceededStackStateMessage}
+			}
+			setUpdateTiming(instance.Status.LastUpdate, started, finished)
+
+			last := instance.Status.LastUpdate
+			assert.Equal(t, started, last.StartedAt)
+			assert.Equal(t, finished, last.FinishedAt)
+			assert.Equal(t, tc.duration, last.DurationSeconds)
+			if tc.failed {
+				assert.Equal(t, shared.FailedStackStateMessage, last.State)
+			}
+		})
+	}
+}
+
+func TestWithPulumiVersion(t *testing.T) {
+	assert.Equal(t, "Successfully updated stack.", withPulumiVersion("Successfully updated stack", ""))
+	assert.Equal(t, "Successfully updated stack, using Pulumi 3.39.3.", withPulumiVersion("Successfully updated stack", "3.39.3"))
+}
+
+func TestCheckPulumiBinary(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, perm os.FileMode) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte("#!/bin/sh\n"), perm))
+		return path
+	}
+
+	assert.NoError(t, checkPulumiBinary(write("bin/pulumi", 0755)))
+	assert.Error(t, checkPulumiBinary(write("noexec/pulumi", 0644)))
+	assert.Error(t, checkPulumiBinary(write("bin/pulumi-3.39.3", 0755)))
+	assert.Error(t, checkPulumiBinary(filepath.Join(dir, "missing", "pulumi")))
+	assert.Error(t, checkPulumiBinary("bin/pulumi"))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "dir", "pulumi"), 0755))
+	assert.Error(t, checkPulumiBinary(filepath.Join(dir, "dir", "pulumi")))
+}
+
+func TestUsePulumiBinaryFromEnv(t *testing.T) {
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "pulumi")
+	require.NoError(t, ioutil.WriteFile(binary, []byte("#!/bin/sh\n"), 0755))
+
+	path := os.Getenv("PATH")
+	defer os.Setenv("PATH", path)
+	os.Setenv(pulumiBinaryPathEnv, binary)
+	defer os.Unsetenv(pulumiBinaryPathEnv)
+
+	require.NoError(t, usePulumiBinaryFromEnv())
+	assert.Equal(t, dir+string(os.PathListSeparator)+path, os.Getenv("PATH"))
+}
+
+func TestFirstSuccessful(t *testing.T) {
+	var tried []string
+	url, err := firstSuccessful([]string{"https://primary/repo.git", "https://mirror1/repo.git", "https://mirror2/repo.git"},
+		func(url string) error {
+			tried = append(tried, url)
+			if url == "https://mirror1/repo.git" {
+				return nil
+			}
+			return errors.New("unreachable")
+		})
+	require.NoError(t, err)
+	assert.Equal(t, "https://mirror1/repo.git", url)
+	assert.Equal(t, []string{"https://primary/repo.git", "https://mirror1/repo.git"}, tried)
+
+	_, err = firstSuccessful([]string{"https://primary/repo.git", "https://mirror1/repo.git"},
+		func(url string) error { return errors.New("unreachable") })
+	require.Error(t, err)
+	assert.Equal(t, "https://primary/repo.git: unreachable; https://mirror1/repo.git: unreachable", err.Error())
+}
+
+func TestEmptyDir(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub", ".git"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "Pulumi.yaml"), []byte("name: test\n"), 0644))
+
+	require.NoError(t, emptyDir(dir))
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestLimitOutputsSize(t *testing.T) {
+	outs := shared.StackOutputs{
+		"small":  apiextensionsv1.JSON{Raw: []byte(`"a"`)},
+		"medium": apiextensionsv1.JSON{Raw: []byte(`"0123456789"`)},
+		"large":  apiextensionsv1.JSON{Raw: []byte(`"0123456789012345678901234567890123456789"`)},
+	}
+
+	kept, omitted := limitOutputsSize(outs, 1000)
+	assert.Equal(t, outs, kept)
+	assert.Empty(t, omitted)
+
+	// {"medium":"0123456789","small":"a"} is 35 bytes.
+	kept, omitted = limitOutputsSize(outs, 35)
+	assert.Equal(t, []string{"large"}, omitted)
+	assert.Equal(t, shared.StackOutputs{"small": outs["small"], "medium": outs["medium"]}, kept)
+	assert.Len(t, outs, 3, "the outputs given should not be changed")
+
+	kept, omitted = limitOutputsSize(outs, 20)
+	assert.Equal(t, []string{"large", "medium"}, omitted)
+	assert.Equal(t, shared.StackOutputs{"small": outs["small"]}, kept)
+
+	kept, omitted = limitOutputsSize(outs, 1)
+	assert.Len(t, omitted, 3)
+	assert.Empty(t, kept)
+}
+
+func TestInvalidURN(t *testing.T) {
+	_, ok := invalidURN(nil)
+	assert.True(t, ok)
+	_, ok = invalidURN([]string{
+		"urn:pulumi:dev::proj::aws:s3/bucket:Bucket::logs",
+		"urn:pulumi:dev::proj::my:component:Thing$aws:ec2/instance:Instance::web",
+	})
+	assert.True(t, ok)
+	urn, ok := invalidURN([]string{"urn:pulumi:dev::proj::aws:s3/bucket:Bucket::logs", "logs"})
+	assert.False(t, ok)
+	assert.Equal(t, "logs", urn)
+}
+
+func TestCheckProjectFile(t *testing.T) {
+	dir := t.TempDir()
+	err := checkProjectFile(dir, "stacks/dev")
+	var notFound *projectNotFoundError
+	require.True(t, errors.As(err, &notFound))
+	assert.Contains(t, err.Error(), `"stacks/dev"`)
+	assert.Contains(t, checkProjectFile(dir, "").Error(), `"."`)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "Pulumi.yml"), []byte("name: test\n"), 0644))
+	assert.NoError(t, checkProjectFile(dir, "stacks/dev"))
+}
+
+func TestSetupHomeDir(t *testing.T) {
+	sharedHome := t.TempDir()
+	sharedPulumiHome := filepath.Join(sharedHome, ".pulumi")
+	require.NoError(t, os.MkdirAll(filepath.Join(sharedHome, ".kube"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(sharedHome, ".kube", "config"), []byte("kind: Config\n"), 0644))
+
+	home := t.TempDir()
+	require.NoError(t, setupHomeDir(home, sharedHome, sharedPulumiHome))
+
+	kubeconfig, err := ioutil.ReadFile(filepath.Join(home, ".kube", "config"))
+	require.NoError(t, err)
+	assert.Equal(t, "kind: Config\n", string(kubeconfig))
+	_, err = os.Stat(filepath.Join(home, ".ssh", "known_hosts"))
+	assert.True(t, os.IsNotExist(err), "absent files are not copied")
+
+	// Changing the copy leaves the operator's file alone.
+	require.NoError(t, ioutil.WriteFile(filepath.Join(home, ".kube", "config"), []byte("changed"), 0600))
+	kubeconfig, err = ioutil.ReadFile(filepath.Join(sharedHome, ".kube", "config"))
+	require.NoError(t, err)
+	assert.Equal(t, "kind: Config\n", string(kubeconfig))
+
+	// Plugins and caches are shared.
+	plugins, err := os.Readlink(filepath.Join(home, ".pulumi", "plugins"))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(sharedPulumiHome, "plugins"), plugins)
+	npmCache, err := os.Readlink(filepath.Join(home, ".npm"))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(sharedHome, ".npm"), npmCache)
+}
@@ -0,0 +1,127 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+)
+
+// gitClone clones the project repository from the URL given into dir, for the stacks which need
+// what the automation API (which uses go-git) can't do: checking out only the paths given in the
+// stack spec and the project directory, or fetching only the branch deployed. It uses the git
+// command, with the same authentication.
+func (sess *reconcileStackSession) gitClone(ctx context.Context, url, dir string, gitAuth *auto.GitAuth) error {
+	env, err := sess.gitCommandEnv(gitAuth)
+	if err != nil {
+		return err
+	}
+	git := func(args ...string) error {
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Dir = dir
+		cmd.Env = env
+		_, stderr, err := sess.runCmd("Git Clone", cmd, nil)
+		if err != nil {
+			return errors.Wrapf(err, "running git %s: %s", args[0], strings.TrimSpace(stderr))
+		}
+		return nil
+	}
+
+	sparse := len(sess.stack.SparseCheckoutPaths) > 0
+	cloneArgs := []string{"clone", "--no-checkout"}
+	if sparse {
+		// Only the trees are fetched up front; the blobs for the paths checked out are fetched when
+		// they're needed. A server that doesn't support filtering sends everything.
+		cloneArgs = append(cloneArgs, "--filter=blob:none")
+	}
+	if sess.stack.SingleBranch {
+		cloneArgs = append(cloneArgs, "--single-branch")
+	}
+	if sess.stack.Branch != "" {
+		cloneArgs = append(cloneArgs, "--branch", shortRefName(sess.stack.Branch))
+	}
+	if err := git(append(cloneArgs, "--", url, ".")...); err != nil {
+		return err
+	}
+	if sparse {
+		if err := git("sparse-checkout", "init", "--cone"); err != nil {
+			return err
+		}
+		if err := git(append([]string{"sparse-checkout", "set", "--"}, sparseCheckoutPaths(sess.stack.SparseCheckoutPaths, sess.stack.RepoDir)...)...); err != nil {
+			return err
+		}
+	}
+	ref := "HEAD"
+	if sess.stack.Commit != "" {
+		ref = sess.stack.Commit
+	}
+	return git("checkout", "--detach", ref)
+}
+
+// sparseCheckoutPaths gives the directories to check out: those given, and the project
+// directory, so that Pulumi.yaml and the stack settings are there.
+func sparseCheckoutPaths(paths []string, repoDir string) []string {
+	var dirs []string
+	for _, p := range append(append([]string{}, paths...), repoDir) {
+		p = strings.Trim(filepath.ToSlash(filepath.Clean(p)), "/")
+		if p == "" || p == "." || contains(dirs, p) {
+			continue
+		}
+		dirs = append(dirs, p)
+	}
+	return dirs
+}
+
+// shortRefName gives the name of a branch or tag as accepted by `git clone --branch`, from the
+// forms accepted for the stack's branch.
+func shortRefName(ref string) string {
+	for _, prefix := range []string{"refs/heads/", "refs/remotes/origin/", "refs/tags/"} {
+		if strings.HasPrefix(ref, prefix) {
+			return strings.TrimPrefix(ref, prefix)
+		}
+	}
+	return ref
+}
+
+// gitCommandEnv gives the environment for running git with the authentication given. Credentials
+// are passed in the environment rather than the command line or the repository's configuration.
+func (sess *reconcileStackSession) gitCommandEnv(gitAuth *auto.GitAuth) ([]string, error) {
+	env := append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if gitAuth == nil {
+		return env, nil
+	}
+
+	basicAuth := func(username, password string) []string {
+		credentials := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+		return []string{
+			"GIT_CONFIG_COUNT=1",
+			"GIT_CONFIG_KEY_0=http.extraHeader",
+			"GIT_CONFIG_VALUE_0=Authorization: Basic " + credentials,
+		}
+	}
+	switch {
+	case gitAuth.SSHPrivateKey != "":
+		if gitAuth.Password != "" {
+			return nil, errors.New("sparse checkout does not support SSH private keys with a password")
+		}
+		keyFile := filepath.Join(sess.homeDir, "git_ssh_key")
+		if err := os.WriteFile(keyFile, []byte(gitAuth.SSHPrivateKey), 0600); err != nil {
+			return nil, errors.Wrap(err, "writing SSH private key")
+		}
+		env = append(env, fmt.Sprintf("GIT_SSH_COMMAND=ssh -i %s -o IdentitiesOnly=yes", keyFile))
+	case gitAuth.PersonalAccessToken != "":
+		// As with the automation API, the username can be anything non-empty.
+		env = append(env, basicAuth("git", gitAuth.PersonalAccessToken)...)
+	case gitAuth.Username != "" && gitAuth.Password != "":
+		env = append(env, basicAuth(gitAuth.Username, gitAuth.Password)...)
+	}
+	return env, nil
+}
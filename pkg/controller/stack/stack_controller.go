@@ -9,11 +9,19 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"net/mail"
+	"net/url"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"text/template"
 	"time"
 
 	"github.com/operator-framework/operator-lib/handler"
@@ -24,25 +32,37 @@ import (
 	"github.com/pulumi/pulumi-kubernetes-operator/pkg/logging"
 	"github.com/pulumi/pulumi-kubernetes-operator/version"
 	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/events"
 	"github.com/pulumi/pulumi/sdk/v3/go/auto/optdestroy"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optpreview"
 	"github.com/pulumi/pulumi/sdk/v3/go/auto/optrefresh"
 	"github.com/pulumi/pulumi/sdk/v3/go/auto/optup"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/encoding"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/tokens"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/workspace"
 	giturls "github.com/whilp/git-urls"
 	git "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
 	corev1 "k8s.io/api/core/v1"
 	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/retry"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	crhandler "sigs.k8s.io/controller-runtime/pkg/handler"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
@@ -53,11 +73,42 @@ import (
 var (
 	log       = logf.Log.WithName("controller_stack")
 	execAgent = fmt.Sprintf("pulumi-kubernetes-operator/%s", version.Version)
+	// knownHostsMu serializes writes to the operator's SSH known hosts file.
+	knownHostsMu sync.Mutex
 )
 
 const (
 	pulumiFinalizer                = "finalizer.stack.pulumi.com"
 	defaultMaxConcurrentReconciles = 10
+	maxConcurrentReconcilesEnv     = "MAX_CONCURRENT_RECONCILES"
+	// userAgentSuffixEnv names the environment variable giving an operator-wide suffix for the user
+	// agent, used when a stack doesn't give its own.
+	userAgentSuffixEnv = "PULUMI_USER_AGENT_SUFFIX"
+	// finalizerSettleDelayEnv names the environment variable giving an extra delay (as a
+	// duration, e.g., "2s") to wait after the finalizer has been observed in the cache.
+	finalizerSettleDelayEnv = "FINALIZER_SETTLE_DELAY"
+	finalizerPollInterval   = 100 * time.Millisecond
+	finalizerPollTimeout    = 10 * time.Second
+	// defaultBackendEnv names the environment variable giving an operator-wide backend URL, used
+	// for stacks that don't give their own.
+	defaultBackendEnv = "PULUMI_DEFAULT_BACKEND_URL"
+	// defaultAccessTokenSecretEnv names the environment variable giving an operator-wide secret
+	// holding a Pulumi access token (under the key "accessToken"), used for stacks that don't give
+	// their own.
+	defaultAccessTokenSecretEnv = "PULUMI_DEFAULT_ACCESS_TOKEN_SECRET"
+	// pulumiBinaryPathEnv names the environment variable giving the path of the pulumi binary to
+	// use, if not the one found on PATH.
+	pulumiBinaryPathEnv = "PULUMI_BINARY_PATH"
+	// clusterNameEnv names the environment variable giving the name of the cluster the operator
+	// runs in, for downward references to clusterName.
+	clusterNameEnv = "CLUSTER_NAME"
+	// allowSubminuteResyncEnv names the environment variable which, if "true", lets stacks be
+	// resynced more often than once a minute.
+	allowSubminuteResyncEnv = "ALLOW_SUBMINUTE_RESYNC"
+	// minResyncFrequencySeconds is the shortest resync interval allowed, unless sub-minute resyncs
+	// are allowed; each resync fetches the source and may run the program, which loads the
+	// operator, the git host and the backend.
+	minResyncFrequencySeconds = 60
 )
 
 // Add creates a new Stack Controller and adds it to the Manager. The Manager will set fields on the Controller
@@ -69,29 +120,78 @@ func Add(mgr manager.Manager) error {
 	if err := setupInClusterKubeconfig(); err != nil {
 		log.Error(err, "skipping in-cluster kubeconfig setup due to non-existent ServiceAccount")
 	}
-	return add(mgr, newReconciler(mgr))
+	if err := usePulumiBinaryFromEnv(); err != nil {
+		return err
+	}
+	audit, err := auditLogFromEnv()
+	if err != nil {
+		return err
+	}
+	return add(mgr, newReconciler(mgr, audit))
+}
+
+// usePulumiBinaryFromEnv makes the pulumi binary given by the environment variable
+// PULUMI_BINARY_PATH, if set, the one that's run for every stack. The automation API runs the
+// command "pulumi" as found on PATH, so the binary must have that name, and its directory is put
+// first on PATH.
+func usePulumiBinaryFromEnv() error {
+	binary := os.Getenv(pulumiBinaryPathEnv)
+	if binary == "" {
+		return nil
+	}
+	if err := checkPulumiBinary(binary); err != nil {
+		return errors.Wrapf(err, "invalid %s", pulumiBinaryPathEnv)
+	}
+	dir := filepath.Dir(binary)
+	if err := os.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH")); err != nil {
+		return err
+	}
+	log.Info("Using pulumi binary", "path", binary)
+	return nil
+}
+
+// checkPulumiBinary checks that the path given is an executable file named pulumi.
+func checkPulumiBinary(binary string) error {
+	if !filepath.IsAbs(binary) {
+		return fmt.Errorf("%q is not an absolute path", binary)
+	}
+	if filepath.Base(binary) != "pulumi" {
+		return fmt.Errorf("%q is not named pulumi", binary)
+	}
+	info, err := os.Stat(binary)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%q is not a regular file", binary)
+	}
+	if info.Mode().Perm()&0111 == 0 {
+		return fmt.Errorf("%q is not executable", binary)
+	}
+	return nil
 }
 
 // newReconciler returns a new reconcile.Reconciler
-func newReconciler(mgr manager.Manager) reconcile.Reconciler {
+func newReconciler(mgr manager.Manager, audit *auditLog) reconcile.Reconciler {
 	return &ReconcileStack{
 		client:   mgr.GetClient(),
 		scheme:   mgr.GetScheme(),
 		recorder: mgr.GetEventRecorderFor("stack-controller"),
+		events:   newEventThrottle(eventThrottleWindow()),
+		audit:    audit,
 	}
 }
 
 // add adds a new Controller to mgr with r as the reconcile.Reconciler
 func add(mgr manager.Manager, r reconcile.Reconciler) error {
-	var err error
-	maxConcurrentReconciles := defaultMaxConcurrentReconciles
-	maxConcurrentReconcilesStr, set := os.LookupEnv("MAX_CONCURRENT_RECONCILES")
-	if set {
-		maxConcurrentReconciles, err = strconv.Atoi(maxConcurrentReconcilesStr)
-		if err != nil {
-			return err
-		}
+	// The number of workers is fixed when the controller starts, so changing it means restarting
+	// the operator. The value in effect is reported by controller-runtime in the gauge
+	// controller_runtime_max_concurrent_reconciles.
+	maxConcurrentReconciles, err := maxConcurrentReconcilesFromEnv()
+	if err != nil {
+		return err
 	}
+	log.Info("Configured stack controller", "maxConcurrentReconciles", maxConcurrentReconciles)
 
 	// Create a new controller
 	c, err := controller.New("stack-controller", mgr, controller.Options{
@@ -109,9 +209,11 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 	//  - https://github.com/kubernetes-sigs/kubebuilder/issues/1103
 	//  - https://github.com/kubernetes-sigs/controller-runtime/pull/553
 	//  - https://book-v1.book.kubebuilder.io/basics/status_subresource.html
+	// Changes to the annotations which pause a stack, or request that it is processed again, are
+	// let through as well.
 	// Set up predicates.
 	predicates := []predicate.Predicate{
-		predicate.Or(predicate.GenerationChangedPredicate{}, libpredicate.NoGenerationPredicate{}),
+		predicate.Or(predicate.GenerationChangedPredicate{}, libpredicate.NoGenerationPredicate{}, controlAnnotationsChanged),
 	}
 
 	stackInformer, err := mgr.GetCache().GetInformer(context.Background(), &pulumiv1.Stack{})
@@ -130,9 +232,52 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 		return err
 	}
 
+	// If the operator is given an address for the git webhook, pushes it's notified of have the
+	// stacks tracking the branch pushed to processed straight away.
+	if addr := os.Getenv(gitWebhookAddressEnv); addr != "" {
+		if err := mgr.GetFieldIndexer().IndexField(context.Background(), &pulumiv1.Stack{}, gitWebhookIndex, gitWebhookIndexKeys); err != nil {
+			return err
+		}
+		events := make(chan event.GenericEvent)
+		if err := c.Watch(&source.Channel{Source: events}, &crhandler.EnqueueRequestForObject{}); err != nil {
+			return err
+		}
+		if err := mgr.Add(&gitWebhook{addr: addr, client: mgr.GetClient(), events: events}); err != nil {
+			return err
+		}
+	}
+
+	// Watch the Stack objects generated for the variants in a matrix, so that the Stack they were
+	// generated from can report on them. Status changes count here, so these are not filtered by
+	// generation.
+	err = c.Watch(&source.Kind{Type: &pulumiv1.Stack{}}, &crhandler.EnqueueRequestForOwner{
+		OwnerType:    &pulumiv1.Stack{},
+		IsController: true,
+	})
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
+// maxConcurrentReconcilesFromEnv gives the number of stacks that may be processed concurrently,
+// from the environment variable MAX_CONCURRENT_RECONCILES if set, or the default otherwise.
+func maxConcurrentReconcilesFromEnv() (int, error) {
+	raw, set := os.LookupEnv(maxConcurrentReconcilesEnv)
+	if !set {
+		return defaultMaxConcurrentReconciles, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, errors.Wrapf(err, "parsing %s", maxConcurrentReconcilesEnv)
+	}
+	if n < 1 {
+		return 0, errors.Errorf("%s must be at least 1, got %d", maxConcurrentReconcilesEnv, n)
+	}
+	return n, nil
+}
+
 // blank assignment to verify that ReconcileStack implements reconcile.Reconciler
 var _ reconcile.Reconciler = &ReconcileStack{}
 
@@ -143,11 +288,15 @@ type ReconcileStack struct {
 	client   client.Client
 	scheme   *runtime.Scheme
 	recorder record.EventRecorder
+	// events coalesces repeated events, if set.
+	events *eventThrottle
+	// audit records each successful update, if set.
+	audit *auditLog
 }
 
 // Reconcile reads that state of the cluster for a Stack object and makes changes based on the state read
 // and what is in the Stack.Spec
-func (r *ReconcileStack) Reconcile(ctx context.Context, request reconcile.Request) (_ reconcile.Result, reterr error) {
+func (r *ReconcileStack) Reconcile(ctx context.Context, request reconcile.Request) (res reconcile.Result, reterr error) {
 	reqLogger := logging.WithValues(log, "Request.Namespace", request.Namespace, "Request.Name", request.Name)
 	reqLogger.Info("Reconciling Stack")
 
@@ -186,6 +335,7 @@ func (r *ReconcileStack) Reconcile(ctx context.Context, request reconcile.Reques
 	// This helper helps with updates, from here onwards.
 	stack := instance.Spec
 	sess := newReconcileStackSession(reqLogger, stack, r.client, request.Namespace)
+	sess.meta = instance.ObjectMeta
 
 	// We can exit early if there is no clean-up to do.
 	if isStackMarkedToBeDeleted && !stack.DestroyOnFinalize {
@@ -204,6 +354,18 @@ func (r *ReconcileStack) Reconcile(ctx context.Context, request reconcile.Reques
 	// error return (now we have successfully fetched the object) means it is "in progress" and not
 	// ready.
 	saveStatus := func() {
+		// A stack that would be retried after failing too many times in a row is left alone
+		// instead, until its spec is changed.
+		retrying := res.Requeue || res.RequeueAfter > 0
+		if reterr == nil && retrying && !isStackMarkedToBeDeleted && tooManyFailures(instance) &&
+			!apimeta.IsStatusConditionTrue(instance.Status.Conditions, pulumiv1.ReadyCondition) &&
+			!apimeta.IsStatusConditionTrue(instance.Status.Conditions, pulumiv1.StalledCondition) {
+			msg := tooManyFailuresMessage(instance)
+			r.emitEvent(instance, pulumiv1.StackStalledEvent(), msg)
+			reqLogger.Info(msg, "Stack.Name", stack.Stack)
+			instance.Status.MarkStalledCondition(pulumiv1.StalledTooManyFailuresReason, msg)
+			res = reconcile.Result{}
+		}
 		if reterr == nil {
 			instance.Status.ObservedGeneration = instance.GetGeneration()
 		} else {
@@ -217,20 +379,188 @@ func (r *ReconcileStack) Reconcile(ctx context.Context, request reconcile.Reques
 	}
 	defer saveStatus()
 
-	// Ensure either branch or commit has been specified in the stack CR if stack is not marked for deletion
+	// A change to the spec is a fresh start for a stack that has been failing.
+	if instance.Status.LastUpdate != nil && instance.Status.ObservedGeneration != instance.GetGeneration() {
+		instance.Status.LastUpdate.ConsecutiveFailures = 0
+	}
+	if !isStackMarkedToBeDeleted && tooManyFailures(instance) {
+		msg := tooManyFailuresMessage(instance)
+		reqLogger.Info(msg, "Stack.Name", stack.Stack)
+		instance.Status.MarkStalledCondition(pulumiv1.StalledTooManyFailuresReason, msg)
+		return reconcile.Result{}, nil
+	}
+
+	// A paused stack is left alone until it is resumed, which will change its annotations and so
+	// have it processed again.
+	if key, paused := instance.IsPaused(); !isStackMarkedToBeDeleted && paused {
+		msg := fmt.Sprintf("Stack is paused by the annotation %q.", key)
+		reqLogger.Info(msg, "Stack.Name", stack.Stack)
+		instance.Status.MarkStalledCondition(pulumiv1.StalledPausedReason, msg)
+		return reconcile.Result{}, nil
+	}
+
+	// A stack with a matrix is deployed by the Stack objects generated for its variants.
+	if len(sess.stack.Matrix) > 0 {
+		return r.reconcileMatrix(ctx, sess, instance)
+	}
+
+	if !isStackMarkedToBeDeleted && (sess.stack.ProjectRepo == "") == (sess.stack.LocalPath == "") {
+		msg := "Stack CustomResource needs to specify exactly one of 'projectRepo' and 'localPath'."
+		r.emitEvent(instance, pulumiv1.StackConfigInvalidEvent(), msg)
+		reqLogger.Info(msg)
+		r.markStackFailed(sess, instance, shared.StackOperationUpdate, errors.New(msg), "", "")
+		instance.Status.MarkStalledCondition(pulumiv1.StalledSpecInvalidReason, msg)
+		return reconcile.Result{}, nil
+	}
+
+	if !isStackMarkedToBeDeleted && sess.stack.LocalPath != "" && !filepath.IsAbs(sess.stack.LocalPath) {
+		msg := fmt.Sprintf("Stack CustomResource has a 'localPath' which is not an absolute path: %q.", sess.stack.LocalPath)
+		r.emitEvent(instance, pulumiv1.StackConfigInvalidEvent(), msg)
+		reqLogger.Info(msg)
+		r.markStackFailed(sess, instance, shared.StackOperationUpdate, errors.New(msg), "", "")
+		instance.Status.MarkStalledCondition(pulumiv1.StalledSpecInvalidReason, msg)
+		return reconcile.Result{}, nil
+	}
+
+	// Ensure either branch or commit has been specified in the stack CR if stack is not marked for
+	// deletion, unless the source is at a local path.
 	if !isStackMarkedToBeDeleted &&
+		sess.stack.LocalPath == "" &&
 		sess.stack.Commit == "" &&
 		sess.stack.Branch == "" {
 
 		msg := "Stack CustomResource needs to specify either 'branch' or 'commit' for the tracking repo."
 		r.emitEvent(instance, pulumiv1.StackConfigInvalidEvent(), msg)
 		reqLogger.Info(msg)
-		r.markStackFailed(sess, instance, errors.New(msg), "", "")
+		r.markStackFailed(sess, instance, shared.StackOperationUpdate, errors.New(msg), "", "")
 		instance.Status.MarkStalledCondition(pulumiv1.StalledSpecInvalidReason, msg)
 		// this object won't be processable until the spec is changed, so no reason to requeue explicitly
 		return reconcile.Result{}, nil
 	}
 
+	if !isStackMarkedToBeDeleted && sess.stack.SingleBranch && sess.stack.Branch == "" {
+		msg := "Stack CustomResource specifies 'singleBranch', which needs 'branch' to be given."
+		r.emitEvent(instance, pulumiv1.StackConfigInvalidEvent(), msg)
+		reqLogger.Info(msg)
+		r.markStackFailed(sess, instance, shared.StackOperationUpdate, errors.New(msg), "", "")
+		instance.Status.MarkStalledCondition(pulumiv1.StalledSpecInvalidReason, msg)
+		return reconcile.Result{}, nil
+	}
+
+	if key := sess.stack.SecretsProviderKey; !isStackMarkedToBeDeleted && key != nil &&
+		sess.stack.SecretsProvider == "" && sess.stack.SecretsProviderRef == nil {
+		if _, err := secretsProviderURL(key); err != nil {
+			msg := fmt.Sprintf("Stack CustomResource has an invalid 'secretsProviderKey': %s.", err.Error())
+			r.emitEvent(instance, pulumiv1.StackConfigInvalidEvent(), msg)
+			reqLogger.Info(msg)
+			r.markStackFailed(sess, instance, shared.StackOperationUpdate, errors.New(msg), "", "")
+			instance.Status.MarkStalledCondition(pulumiv1.StalledSpecInvalidReason, msg)
+			return reconcile.Result{}, nil
+		}
+	}
+
+	// The engine can't apply delete-before-replace to a whole stack, so refuse the spec rather than
+	// silently running updates with replace-then-delete semantics.
+	if !isStackMarkedToBeDeleted && sess.stack.DeleteBeforeReplace {
+		msg := "Stack CustomResource specifies 'deleteBeforeReplace', which is not supported stack-wide; " +
+			"use the deleteBeforeReplace resource option in the program instead."
+		r.emitEvent(instance, pulumiv1.StackConfigInvalidEvent(), msg)
+		reqLogger.Info(msg)
+		r.markStackFailed(sess, instance, shared.StackOperationUpdate, errors.New(msg), "", "")
+		instance.Status.MarkStalledCondition(pulumiv1.StalledSpecInvalidReason, msg)
+		return reconcile.Result{}, nil
+	}
+
+	if urn, ok := invalidURN(sess.stack.RefreshTargets); !isStackMarkedToBeDeleted && !ok {
+		msg := fmt.Sprintf("Stack CustomResource has an invalid URN in 'refreshTargets': %q.", urn)
+		r.emitEvent(instance, pulumiv1.StackConfigInvalidEvent(), msg)
+		reqLogger.Info(msg)
+		r.markStackFailed(sess, instance, shared.StackOperationUpdate, errors.New(msg), "", "")
+		instance.Status.MarkStalledCondition(pulumiv1.StalledSpecInvalidReason, msg)
+		return reconcile.Result{}, nil
+	}
+
+	if urn, ok := invalidURN(sess.stack.FinalizeTargets); !isStackMarkedToBeDeleted && !ok {
+		msg := fmt.Sprintf("Stack CustomResource has an invalid URN in 'finalizeTargets': %q.", urn)
+		r.emitEvent(instance, pulumiv1.StackConfigInvalidEvent(), msg)
+		reqLogger.Info(msg)
+		r.markStackFailed(sess, instance, shared.StackOperationUpdate, errors.New(msg), "", "")
+		instance.Status.MarkStalledCondition(pulumiv1.StalledSpecInvalidReason, msg)
+		return reconcile.Result{}, nil
+	}
+
+	if err := validatePluginServers(sess.stack); !isStackMarkedToBeDeleted && err != nil {
+		msg := fmt.Sprintf("Stack CustomResource has an invalid plugin download server: %s.", err.Error())
+		r.emitEvent(instance, pulumiv1.StackConfigInvalidEvent(), msg)
+		reqLogger.Info(msg)
+		r.markStackFailed(sess, instance, shared.StackOperationUpdate, errors.New(msg), "", "")
+		instance.Status.MarkStalledCondition(pulumiv1.StalledSpecInvalidReason, msg)
+		return reconcile.Result{}, nil
+	}
+
+	if err := validateGitCommitter(sess.stack.GitCommitter); !isStackMarkedToBeDeleted && err != nil {
+		msg := fmt.Sprintf("Stack CustomResource has an invalid 'gitCommitter': %s.", err.Error())
+		r.emitEvent(instance, pulumiv1.StackConfigInvalidEvent(), msg)
+		reqLogger.Info(msg)
+		r.markStackFailed(sess, instance, shared.StackOperationUpdate, errors.New(msg), "", "")
+		instance.Status.MarkStalledCondition(pulumiv1.StalledSpecInvalidReason, msg)
+		return reconcile.Result{}, nil
+	}
+
+	if !isStackMarkedToBeDeleted && sess.stack.RefreshDuringUpdate && sess.stack.ExpectNoRefreshChanges {
+		msg := "Stack CustomResource specifies 'refreshDuringUpdate', which can't be combined with 'expectNoRefreshChanges'."
+		r.emitEvent(instance, pulumiv1.StackConfigInvalidEvent(), msg)
+		reqLogger.Info(msg)
+		r.markStackFailed(sess, instance, shared.StackOperationUpdate, errors.New(msg), "", "")
+		instance.Status.MarkStalledCondition(pulumiv1.StalledSpecInvalidReason, msg)
+		return reconcile.Result{}, nil
+	}
+
+	if err := validateCredentialsEndpoint(sess.stack.CredentialsEndpoint); !isStackMarkedToBeDeleted && err != nil {
+		msg := fmt.Sprintf("Stack CustomResource has an invalid 'credentialsEndpoint': %s.", err.Error())
+		r.emitEvent(instance, pulumiv1.StackConfigInvalidEvent(), msg)
+		reqLogger.Info(msg)
+		r.markStackFailed(sess, instance, shared.StackOperationUpdate, errors.New(msg), "", "")
+		instance.Status.MarkStalledCondition(pulumiv1.StalledSpecInvalidReason, msg)
+		return reconcile.Result{}, nil
+	}
+
+	if repoDir, err := expandRepoDir(sess.stack.RepoDir, sess.stack.Config, sess.stack.Env); err != nil {
+		if !isStackMarkedToBeDeleted {
+			msg := fmt.Sprintf("Stack CustomResource has an invalid 'repoDir': %s.", err.Error())
+			r.emitEvent(instance, pulumiv1.StackConfigInvalidEvent(), msg)
+			reqLogger.Info(msg)
+			r.markStackFailed(sess, instance, shared.StackOperationUpdate, errors.New(msg), "", "")
+			instance.Status.MarkStalledCondition(pulumiv1.StalledSpecInvalidReason, msg)
+			return reconcile.Result{}, nil
+		}
+	} else {
+		sess.stack.RepoDir = repoDir
+	}
+
+	var window *maintenanceWindow
+	if spec := sess.stack.MaintenanceWindow; !isStackMarkedToBeDeleted && spec != nil {
+		if window, err = parseMaintenanceWindow(spec); err != nil {
+			msg := fmt.Sprintf("Stack CustomResource has an invalid 'maintenanceWindow': %s.", err.Error())
+			r.emitEvent(instance, pulumiv1.StackConfigInvalidEvent(), msg)
+			reqLogger.Info(msg)
+			r.markStackFailed(sess, instance, shared.StackOperationUpdate, errors.New(msg), "", "")
+			instance.Status.MarkStalledCondition(pulumiv1.StalledSpecInvalidReason, msg)
+			return reconcile.Result{}, nil
+		}
+	}
+
+	// Deleting orphaned resources is only allowed with a deletion guard, so that the deletions
+	// can be limited.
+	if !isStackMarkedToBeDeleted && sess.stack.DeleteOrphanedResources && sess.stack.DeletionGuard == nil {
+		msg := "Stack CustomResource specifies 'deleteOrphanedResources' without a 'deletionGuard'."
+		r.emitEvent(instance, pulumiv1.StackConfigInvalidEvent(), msg)
+		reqLogger.Info(msg)
+		r.markStackFailed(sess, instance, shared.StackOperationUpdate, errors.New(msg), "", "")
+		instance.Status.MarkStalledCondition(pulumiv1.StalledSpecInvalidReason, msg)
+		return reconcile.Result{}, nil
+	}
+
 	// We're ready to do some actual work. Until we have a definitive outcome, mark the stack as
 	// reconciling.
 	instance.Status.MarkReconcilingCondition(pulumiv1.ReconcilingProcessingReason, pulumiv1.ReconcilingProcessingMessage)
@@ -238,51 +568,190 @@ func (r *ReconcileStack) Reconcile(ctx context.Context, request reconcile.Reques
 		return reconcile.Result{}, err
 	}
 
+	// Destroying a stack needs only its state, so if the project, backend and stack name were
+	// recorded when it was last processed, there's no need to fetch the source and set up the
+	// program to finalize the stack.
+	if isStackMarkedToBeDeleted && sess.stack.DestroyOnFinalize && instance.Status.StackName != "" {
+		if project := projectForDestroy(instance.Status.StackName, instance.Status.Project); project != "" {
+			sess.stack.Stack = instance.Status.StackName
+			return r.finalizeFromState(ctx, sess, instance, project, instance.Status.Backend)
+		}
+	}
+
 	// Step 1. Set up the workdir, select the right stack and populate config if supplied.
 	gitAuth, err := sess.SetupGitAuth(ctx)
+	if err != nil && isStackMarkedToBeDeleted && sess.stack.DestroyOnFinalize {
+		return r.finalizeWithoutSource(ctx, sess, instance, err)
+	}
 	if err != nil {
 		r.emitEvent(instance, pulumiv1.StackGitAuthFailureEvent(), "Failed to setup git authentication: %v", err.Error())
 		reqLogger.Error(err, "Failed to setup git authentication", "Stack.Name", stack.Stack)
-		r.markStackFailed(sess, instance, err, "", "")
+		r.markStackFailed(sess, instance, shared.StackOperationUpdate, err, "", "")
+		instance.Status.MarkStalledCondition(pulumiv1.StalledSourceUnavailableReason, err.Error())
+		return reconcile.Result{}, nil
+	}
+
+	if sess.gitAuthFallbacks, err = sess.SetupGitAuthFallbacks(ctx); err != nil {
+		r.emitEvent(instance, pulumiv1.StackGitAuthFailureEvent(), "Failed to setup git authentication: %v", err.Error())
+		reqLogger.Error(err, "Failed to setup git authentication", "Stack.Name", stack.Stack)
+		r.markStackFailed(sess, instance, shared.StackOperationUpdate, err, "", "")
 		instance.Status.MarkStalledCondition(pulumiv1.StalledSourceUnavailableReason, err.Error())
 		return reconcile.Result{}, nil
 	}
 
-	if gitAuth.SSHPrivateKey != "" {
+	if usesSSHKey(gitAuth, sess.gitAuthFallbacks) {
 		// Add the project repo's public SSH keys to the SSH known hosts
 		// to perform the necessary key checking during SSH git cloning.
-		sess.addSSHKeysToKnownHosts(sess.stack.ProjectRepo)
+		for _, url := range sess.repoURLs() {
+			sess.addSSHKeysToKnownHosts(url)
+		}
+	}
+
+	// A tracked branch which hasn't moved needn't be cloned to find that out.
+	if !isStackMarkedToBeDeleted && sess.unchangedSinceLastUpdate(ctx, instance, gitAuth, time.Now()) {
+		polledAt := metav1.Now()
+		instance.Status.LastPollTime = &polledAt
+		reqLogger.Info("Commit hash unchanged. Will poll again.", "pollFrequencySeconds", resyncFrequencySeconds(sess.stack))
+		instance.Status.MarkReadyCondition()
+		resyncFreq := time.Duration(resyncFrequencySeconds(sess.stack)) * time.Second
+		return reconcile.Result{RequeueAfter: requeueAfter(instance, resyncFreq, time.Now())}, nil
 	}
 
 	if err = sess.SetupPulumiWorkdir(ctx, gitAuth); err != nil {
+		// The program isn't needed to destroy the stack, so deletion isn't held up by the
+		// source being unavailable.
+		if isStackMarkedToBeDeleted && sess.stack.DestroyOnFinalize {
+			return r.finalizeWithoutSource(ctx, sess, instance, err)
+		}
+		// A wrong project directory won't fix itself, but a new commit on a tracked branch may fix it.
+		var timeout *cloneTimeoutError
+		if errors.As(err, &timeout) {
+			r.emitEvent(instance, pulumiv1.StackGitTimeoutEvent(), "%s.", err.Error())
+			reqLogger.Error(err, "Timed out cloning project repository", "Stack.Name", stack.Stack)
+			r.markStackFailed(sess, instance, shared.StackOperationUpdate, err, "", "")
+			instance.Status.MarkReconcilingCondition(pulumiv1.ReconcilingRetryReason, err.Error())
+			return retryAfterFailure(instance), nil
+		}
+		// The credentials are read afresh each time, so a retry picks up rotated credentials.
+		var authFailed *gitAuthError
+		if errors.As(err, &authFailed) {
+			r.emitEvent(instance, pulumiv1.StackGitAuthFailureEvent(), "%s.", err.Error())
+			reqLogger.Error(err, "Git authentication failed", "Stack.Name", stack.Stack)
+			r.markStackFailed(sess, instance, shared.StackOperationUpdate, err, "", "")
+			instance.Status.MarkReconcilingCondition(pulumiv1.ReconcilingRetryReason, err.Error())
+			return retryAfterFailure(instance), nil
+		}
+		// A missing git-lfs needs the operator's image to change, so there's no point retrying.
+		var noLFS *gitLFSMissingError
+		if errors.As(err, &noLFS) {
+			r.emitEvent(instance, pulumiv1.StackInitializationFailureEvent(), "%s.", err.Error())
+			reqLogger.Error(err, "Git LFS not available", "Stack.Name", stack.Stack)
+			r.markStackFailed(sess, instance, shared.StackOperationUpdate, err, "", "")
+			instance.Status.MarkStalledCondition(pulumiv1.StalledSpecInvalidReason, err.Error())
+			return reconcile.Result{}, nil
+		}
+		var noToken *accessTokenError
+		if errors.As(err, &noToken) {
+			r.emitEvent(instance, pulumiv1.StackAuthMissingEvent(), "%s.", err.Error())
+			reqLogger.Error(err, "No Pulumi access token", "Stack.Name", stack.Stack)
+			r.markStackFailed(sess, instance, shared.StackOperationUpdate, err, "", "")
+			// The secret giving the token may not have been created yet, so this is retried.
+			instance.Status.MarkReconcilingCondition(pulumiv1.ReconcilingRetryReason, err.Error())
+			return retryAfterFailure(instance), nil
+		}
+		var badName *stackNameError
+		if errors.As(err, &badName) {
+			r.emitEvent(instance, pulumiv1.StackConfigInvalidEvent(), "%s.", err.Error())
+			reqLogger.Info(err.Error())
+			r.markStackFailed(sess, instance, shared.StackOperationUpdate, err, "", "")
+			instance.Status.MarkStalledCondition(pulumiv1.StalledSpecInvalidReason, err.Error())
+			return reconcile.Result{}, nil
+		}
+		var badConfigFile *configFileError
+		if errors.As(err, &badConfigFile) {
+			r.emitEvent(instance, pulumiv1.StackConfigInvalidEvent(), "%s.", err.Error())
+			reqLogger.Info(err.Error())
+			r.markStackFailed(sess, instance, shared.StackOperationUpdate, err, "", "")
+			instance.Status.MarkStalledCondition(pulumiv1.StalledSpecInvalidReason, err.Error())
+			// A new commit on a tracked branch may fix the file.
+			if len(sess.stack.Branch) > 0 {
+				return reconcile.Result{RequeueAfter: time.Duration(resyncFrequencySeconds(sess.stack)) * time.Second}, nil
+			}
+			return reconcile.Result{}, nil
+		}
+		var pluginFailed *pluginInstallError
+		if errors.As(err, &pluginFailed) {
+			r.emitEvent(instance, pulumiv1.StackPluginInstallFailureEvent(), "%s.", err.Error())
+			reqLogger.Error(err, "Failed to install plugin", "Stack.Name", stack.Stack)
+			r.markStackFailed(sess, instance, shared.StackOperationUpdate, err, "", "")
+			instance.Status.MarkReconcilingCondition(pulumiv1.ReconcilingRetryReason, err.Error())
+			return retryAfterFailure(instance), nil
+		}
+		var noDisk *insufficientDiskError
+		if errors.As(err, &noDisk) {
+			r.emitEvent(instance, pulumiv1.StackInsufficientDiskEvent(), "%s.", err.Error())
+			reqLogger.Error(err, "Insufficient disk space for workspace", "Stack.Name", stack.Stack)
+			r.markStackFailed(sess, instance, shared.StackOperationUpdate, err, "", "")
+			instance.Status.MarkReconcilingCondition(pulumiv1.ReconcilingRetryReason, err.Error())
+			return retryAfterFailure(instance), nil
+		}
+		var preprocessFailed *sourcePreprocessError
+		if errors.As(err, &preprocessFailed) {
+			r.emitEvent(instance, pulumiv1.StackPreprocessFailureEvent(), "%s.", err.Error())
+			reqLogger.Error(err, "Failed to preprocess source", "Stack.Name", stack.Stack)
+			r.markStackFailed(sess, instance, shared.StackOperationUpdate, err, "", "")
+			instance.Status.MarkReconcilingCondition(pulumiv1.ReconcilingRetryReason, err.Error())
+			return retryAfterFailure(instance), nil
+		}
+		var notFound *projectNotFoundError
+		if errors.As(err, &notFound) {
+			r.emitEvent(instance, pulumiv1.StackProjectNotFoundEvent(), "%s.", err.Error())
+			reqLogger.Error(err, "Pulumi project not found", "Stack.Name", stack.Stack)
+			r.markStackFailed(sess, instance, shared.StackOperationUpdate, err, "", "")
+			instance.Status.MarkStalledCondition(pulumiv1.StalledSpecInvalidReason, err.Error())
+			if len(sess.stack.Branch) > 0 {
+				return reconcile.Result{RequeueAfter: time.Duration(resyncFrequencySeconds(sess.stack)) * time.Second}, nil
+			}
+			return reconcile.Result{}, nil
+		}
 		r.emitEvent(instance, pulumiv1.StackInitializationFailureEvent(), "Failed to initialize stack: %v", err.Error())
 		reqLogger.Error(err, "Failed to setup Pulumi workdir", "Stack.Name", stack.Stack)
-		r.markStackFailed(sess, instance, err, "", "")
+		r.markStackFailed(sess, instance, shared.StackOperationUpdate, err, "", "")
 		instance.Status.MarkReconcilingCondition(pulumiv1.ReconcilingRetryReason, err.Error())
 		// this can fail for reasons which might go away without intervention; so, retry explicitly
-		return reconcile.Result{Requeue: true}, nil
+		return retryAfterFailure(instance), nil
 	}
 
 	// Delete the temporary directory after the reconciliation is completed (regardless of success or failure).
 	defer sess.CleanupPulumiDir()
+	instance.Status.Project = sess.project
+	instance.Status.Backend = sess.backend
+	instance.Status.StackName = sess.stack.Stack
+	if sess.stackCreated && instance.Status.StackCreatedAt == nil {
+		createdAt := metav1.Now()
+		instance.Status.StackCreatedAt = &createdAt
+		r.emitEvent(instance, pulumiv1.StackCreatedEvent(), "Created stack %q in the backend.", sess.stack.Stack)
+	}
 
-	currentCommit, err := revisionAtWorkingDir(sess.workdir)
+	currentCommit, err := sess.revision()
 	if err != nil {
 		return reconcile.Result{}, err
 	}
+	polledAt := metav1.Now()
+	instance.Status.LastPollTime = &polledAt
 
 	// Step 2. If there are extra environment variables, read them in now and use them for subsequent commands.
 	if err = sess.SetEnvs(ctx, stack.Envs, request.Namespace); err != nil {
 		err := errors.Wrap(err, "could not find ConfigMap for Envs")
-		r.markStackFailed(sess, instance, err, currentCommit, "")
+		r.markStackFailed(sess, instance, shared.StackOperationUpdate, err, currentCommit, "")
 		instance.Status.MarkReconcilingCondition(pulumiv1.ReconcilingRetryReason, err.Error())
-		return reconcile.Result{Requeue: true}, nil
+		return retryAfterFailure(instance), nil
 	}
 	if err = sess.SetSecretEnvs(ctx, stack.SecretEnvs, request.Namespace); err != nil {
 		err := errors.Wrap(err, "could not find Secret for SecretEnvs")
-		r.markStackFailed(sess, instance, err, currentCommit, "")
+		r.markStackFailed(sess, instance, shared.StackOperationUpdate, err, currentCommit, "")
 		instance.Status.MarkReconcilingCondition(pulumiv1.ReconcilingRetryReason, err.Error())
-		return reconcile.Result{Requeue: true}, nil
+		return retryAfterFailure(instance), nil
 	}
 
 	// This is enough preparation to be able to destroy the stack, if it's being deleted, or to
@@ -291,7 +760,9 @@ func (r *ReconcileStack) Reconcile(ctx context.Context, request reconcile.Reques
 	if isStackMarkedToBeDeleted {
 		if contains(instance.GetFinalizers(), pulumiFinalizer) {
 			err := sess.finalize(ctx, instance)
-			// Manage extra status here
+			if err != nil {
+				r.markStackFailed(sess, instance, shared.StackOperationDestroy, err, "", "")
+			}
 			return reconcile.Result{}, err
 		}
 	} else {
@@ -301,7 +772,14 @@ func (r *ReconcileStack) Reconcile(ctx context.Context, request reconcile.Reques
 			if err != nil {
 				return reconcile.Result{}, err
 			}
-			time.Sleep(2 * time.Second) // arbitrary sleep after finalizer add to avoid stale obj for permalink
+			// Wait for the cache to catch up with the update, so the status patch for the
+			// permalink is computed against the object with the finalizer.
+			if err := sess.waitForFinalizer(ctx, client.ObjectKeyFromObject(instance)); err != nil {
+				return reconcile.Result{}, err
+			}
+			if delay := finalizerSettleDelay(); delay > 0 {
+				time.Sleep(delay)
+			}
 			// Add default permalink for the stack in the Pulumi Service.
 			if err := sess.addDefaultPermalink(ctx, instance); err != nil {
 				return reconcile.Result{}, err
@@ -309,17 +787,40 @@ func (r *ReconcileStack) Reconcile(ctx context.Context, request reconcile.Reques
 		}
 	}
 
-	// If a branch is specified, then track changes to the branch.
-	trackBranch := len(sess.stack.Branch) > 0
-
-	resyncFreqSeconds := sess.stack.ResyncFrequencySeconds
-	if sess.stack.ResyncFrequencySeconds != 0 && sess.stack.ResyncFrequencySeconds < 60 {
-		resyncFreqSeconds = 60
+	// If a branch is specified, then track changes to the branch. Source at a local path is synced
+	// out of band, so it's tracked likewise.
+	trackBranch := len(sess.stack.Branch) > 0 || sess.stack.LocalPath != ""
+	resyncFreqSeconds := resyncFrequencySeconds(sess.stack)
+	resyncFreq := time.Duration(resyncFreqSeconds) * time.Second
+
+	// A stack which is only to be configured is done with, since setting up the workspace created
+	// the stack and set its configuration. A tracked branch may change the checked-in
+	// configuration, so it's polled.
+	if sess.stack.StackConfigOnly {
+		if instance.Status.ObservedGeneration != instance.GetGeneration() {
+			r.emitEvent(instance, pulumiv1.StackConfiguredEvent(), "Stack configured at commit %q; not updated, since 'stackConfigOnly' is set.", currentCommit)
+		}
+		reqLogger.Info("Configured stack without updating it", "Stack.Name", stack.Stack, "Commit", currentCommit)
+		instance.Status.MarkReadyCondition()
+		if trackBranch {
+			return reconcile.Result{RequeueAfter: resyncFreq}, nil
+		}
+		return reconcile.Result{}, nil
 	}
 
-	if trackBranch || sess.stack.ContinueResyncOnCommitMatch {
-		if resyncFreqSeconds == 0 {
-			resyncFreqSeconds = 60
+	// A stack that has been updated successfully at the current commit, and not changed since, is
+	// only refreshed or backed up, if either is due.
+	upToDate := instance.Status.LastUpdate != nil &&
+		instance.Status.LastUpdate.State == shared.SucceededStackStateMessage &&
+		instance.Status.LastUpdate.LastSuccessfulCommit == currentCommit &&
+		instance.Status.ObservedGeneration == instance.GetGeneration() &&
+		!sess.stack.ContinueResyncOnCommitMatch
+	if upToDate {
+		if wait, scheduled := untilRefresh(instance, time.Now()); scheduled && wait <= 0 {
+			return r.refreshOnSchedule(ctx, sess, instance, resyncFreq)
+		}
+		if wait, scheduled := untilBackup(instance, time.Now()); scheduled && wait <= 0 {
+			return r.backupOnSchedule(ctx, sess, instance, resyncFreq)
 		}
 	}
 
@@ -329,28 +830,68 @@ func (r *ReconcileStack) Reconcile(ctx context.Context, request reconcile.Reques
 			reqLogger.Info("Commit hash unchanged. Will poll again.", "pollFrequencySeconds", resyncFreqSeconds)
 			// Reconcile every resyncFreqSeconds to check for new commits to the branch.
 			instance.Status.MarkReadyCondition()
-			return reconcile.Result{RequeueAfter: time.Duration(resyncFreqSeconds) * time.Second}, nil
+			return reconcile.Result{RequeueAfter: requeueAfter(instance, resyncFreq, time.Now())}, nil
 		}
 
-		if instance.Status.LastUpdate.LastSuccessfulCommit != currentCommit {
+		if lastCommit := instance.Status.LastUpdate.LastSuccessfulCommit; lastCommit != currentCommit {
 			r.emitEvent(instance, pulumiv1.StackUpdateDetectedEvent(), "New commit detected: %q.", currentCommit)
 			reqLogger.Info("New commit hash found", "Current commit", currentCommit,
-				"Last commit", instance.Status.LastUpdate.LastSuccessfulCommit)
+				"Last commit", lastCommit)
+
+			// If the stack only cares about some paths, and none of them have changed since the last
+			// commit applied (and the spec hasn't changed either), there's no need to run an update.
+			if len(sess.stack.Paths) > 0 && lastCommit != "" && instance.Status.ObservedGeneration == instance.GetGeneration() {
+				matched, err := changedPathsMatch(sess.workdir, lastCommit, currentCommit, sess.stack.Paths)
+				switch {
+				case err != nil:
+					reqLogger.Error(err, "Could not compare commits; updating stack", "Current commit", currentCommit,
+						"Last commit", lastCommit)
+				case !matched:
+					r.emitEvent(instance, pulumiv1.StackSkippedUnrelatedChangeEvent(),
+						"Commit %q changes no paths relevant to the stack; skipping update.", currentCommit)
+					instance.Status.LastUpdate.LastAttemptedCommit = currentCommit
+					instance.Status.LastUpdate.LastSuccessfulCommit = currentCommit
+					instance.Status.LastUpdate.LastResyncTime = metav1.Now()
+					instance.Status.MarkReadyCondition()
+					return reconcile.Result{RequeueAfter: requeueAfter(instance, resyncFreq, time.Now())}, nil
+				}
+			}
+
+			// A burst of commits is applied in one update, once it has settled.
+			if sess.stack.CommitDebounceSeconds > 0 && instance.Status.ObservedGeneration == instance.GetGeneration() {
+				if wait := settleCommit(instance, currentCommit, sess.stack.CommitDebounceSeconds, time.Now()); wait > 0 {
+					reqLogger.Info("Waiting for new commit to settle", "Current commit", currentCommit, "Wait", wait.String())
+					return reconcile.Result{RequeueAfter: wait}, nil
+				}
+			}
+		}
+	}
+	instance.Status.SettlingCommit, instance.Status.SettlingSince = "", nil
+
+	// Outside the maintenance window, the update waits until it opens.
+	if window != nil {
+		if wait := window.until(time.Now()); wait > 0 {
+			return r.deferUpdate(sess, instance, currentCommit, wait), nil
 		}
 	}
+	instance.Status.PendingCommit = ""
 
-	// Step 3. If a stack refresh is requested, run it now.
-	if sess.stack.Refresh {
+	// Step 3. If a stack refresh is requested, run it now, unless it's done as part of the update.
+	// Deleting orphaned resources also needs a refresh, so the update sees what has drifted.
+	if (sess.stack.Refresh && !sess.stack.RefreshDuringUpdate) || sess.stack.DeleteOrphanedResources {
 		permalink, err := sess.RefreshStack(ctx, sess.stack.ExpectNoRefreshChanges)
 		if err != nil {
-			r.markStackFailed(sess, instance, errors.Wrap(err, "refreshing stack"), currentCommit, permalink)
+			r.markStackFailed(sess, instance, shared.StackOperationRefresh, errors.Wrap(err, "refreshing stack"), currentCommit, permalink)
 			instance.Status.MarkReconcilingCondition(pulumiv1.ReconcilingRetryReason, err.Error())
-			return reconcile.Result{Requeue: true}, nil
+			return retryAfterFailure(instance), nil
 		}
 		if instance.Status.LastUpdate == nil {
 			instance.Status.LastUpdate = &shared.StackUpdateState{}
 		}
+		instance.Status.LastUpdate.Kind = shared.StackOperationRefresh
 		instance.Status.LastUpdate.Permalink = permalink
+		refreshedAt := metav1.Now()
+		instance.Status.LastRefresh = &refreshedAt
 
 		err = sess.patchStatus(ctx, instance)
 		if err != nil {
@@ -360,41 +901,155 @@ func (r *ReconcileStack) Reconcile(ctx context.Context, request reconcile.Reques
 		reqLogger.Info("Successfully refreshed Stack", "Stack.Name", stack.Stack)
 	}
 
-	// Step 4. Run a `pulumi up --skip-preview`.
+	// Step 4. If the stack has a deletion guard, preview the update and check it doesn't delete too
+	// much.
+	if guard := sess.stack.DeletionGuard; guard != nil && guard.OverrideCommit != currentCommit {
+		deletions, existing, err := sess.PreviewDeletions(ctx)
+		if err != nil {
+			r.markStackFailed(sess, instance, shared.StackOperationPreview, errors.Wrap(err, "previewing update for deletion guard"), currentCommit, "")
+			instance.Status.MarkReconcilingCondition(pulumiv1.ReconcilingRetryReason, err.Error())
+			return retryAfterFailure(instance), nil
+		}
+		if deletionGuardTripped(guard, len(deletions), existing) {
+			msg := fmt.Sprintf("Update would delete %d of %d resources, which exceeds the deletion guard: %s",
+				len(deletions), existing, summarizeURNs(deletions, 10))
+			r.emitEvent(instance, pulumiv1.StackDeletionGuardTrippedEvent(), msg)
+			r.markStackFailed(sess, instance, shared.StackOperationPreview, errors.New(msg), currentCommit, "")
+			instance.Status.MarkStalledCondition(pulumiv1.StalledDeletionGuardReason, msg)
+			// A new commit may not have the same deletions, so keep polling a tracked branch.
+			if trackBranch {
+				return reconcile.Result{RequeueAfter: resyncFreq}, nil
+			}
+			return reconcile.Result{}, nil
+		}
+	}
+
+	// Step 5. Run a `pulumi up --skip-preview`.
 	// TODO: is it possible to support a --dry-run with a preview?
-	status, permalink, result, err := sess.UpdateStack(ctx)
+	configChanges := sess.configChanges(ctx)
+	updateStartedAt := metav1.Now()
+	policyEvents, policyViolations := collectPolicyViolations()
+	updateStreams := []chan<- events.EngineEvent{policyEvents}
+	var completedSteps func() []apitype.StepEventMetadata
+	if r.audit != nil {
+		var stepEvents chan<- events.EngineEvent
+		stepEvents, completedSteps = collectSteps()
+		updateStreams = append(updateStreams, stepEvents)
+	}
+	status, permalink, result, err := sess.UpdateStack(ctx, sess.progressReporter(ctx, instance), updateStreams...)
+	updateFinishedAt := metav1.Now()
+	if status != shared.StackUpdateConflict {
+		instance.Status.LockedSince = nil
+	}
+	if status != shared.StackNotFound && instance.Status.LastUpdate != nil {
+		instance.Status.LastUpdate.NotFoundRetries = 0
+	}
 	switch status {
 	case shared.StackUpdateConflict:
 		r.emitEvent(instance,
 			pulumiv1.StackUpdateConflictDetectedEvent(),
 			"Conflict with another concurrent update. "+
 				"If Stack CR specifies 'retryOnUpdateConflict' a retry will trigger automatically.")
+		if sess.stack.BreakLockAfterSeconds > 0 {
+			return r.retryLockedStack(ctx, sess, instance), nil
+		}
 		if sess.stack.RetryOnUpdateConflict {
 			reqLogger.Error(err, "Conflict with another concurrent update -- will retry shortly", "Stack.Name", stack.Stack)
 			instance.Status.MarkReconcilingCondition(pulumiv1.ReconcilingRetryReason, "conflict with concurrent update, retryOnUpdateConflict set")
-			return reconcile.Result{RequeueAfter: time.Second * 5}, nil
+			recordFailure(instance)
+			return retryAfterFailure(instance), nil
 		}
 		reqLogger.Error(err, "Conflict with another concurrent update -- NOT retrying", "Stack.Name", stack.Stack)
 		instance.Status.MarkStalledCondition(pulumiv1.StalledConflictReason, "conflict with concurrent update, retryOnUpdateConflict not set")
 		return reconcile.Result{}, nil
+	case shared.StackBackendUnavailable:
+		return r.retryBackendUnavailable(sess, instance, err), nil
+	case shared.StackUpdateTimedOut:
+		r.emitEvent(instance, pulumiv1.StackUpdateTimeoutEvent(), "%s.", err.Error())
+		r.markStackFailed(sess, instance, shared.StackOperationUpdate, err, currentCommit, permalink)
+		setUpdateTiming(instance.Status.LastUpdate, updateStartedAt, updateFinishedAt)
+		instance.Status.MarkReconcilingCondition(pulumiv1.ReconcilingRetryReason, err.Error())
+		return retryAfterFailure(instance), nil
 	case shared.StackNotFound:
+		recordFailure(instance)
+		// A change to the spec may well be what's needed, so the count starts again after one.
+		if instance.Status.ObservedGeneration != instance.GetGeneration() {
+			instance.Status.LastUpdate.NotFoundRetries = 0
+		}
+		instance.Status.LastUpdate.NotFoundRetries++
+		if attempts := instance.Status.LastUpdate.NotFoundRetries; attempts > maxNotFoundRetries(stack.RetryPolicy) {
+			msg := fmt.Sprintf("Stack not found in the backend after %d attempts; giving up.", attempts)
+			r.emitEvent(instance, pulumiv1.StackNotFoundGaveUpEvent(), msg)
+			reqLogger.Error(err, "Stack not found -- NOT retrying", "Stack.Name", stack.Stack)
+			instance.Status.MarkStalledCondition(pulumiv1.StalledStackNotFoundReason, msg)
+			// A new commit may create the stack, so keep polling a tracked branch.
+			if trackBranch {
+				return reconcile.Result{RequeueAfter: resyncFreq}, nil
+			}
+			return reconcile.Result{}, nil
+		}
 		r.emitEvent(instance, pulumiv1.StackNotFoundEvent(), "Stack not found. Will retry.")
 		reqLogger.Error(err, "Stack not found -- will retry shortly", "Stack.Name", stack.Stack, "Err:")
 		instance.Status.MarkReconcilingCondition(pulumiv1.ReconcilingRetryReason, "stack not found in backend; retrying")
-		return reconcile.Result{RequeueAfter: time.Second * 5}, nil
+		return reconcile.Result{RequeueAfter: notFoundRetryDelay(stack.RetryPolicy, instance.Status.LastUpdate.ConsecutiveFailures, randomJitter)}, nil
 	default:
 		if err != nil {
-			r.markStackFailed(sess, instance, err, currentCommit, permalink)
+			// A policy violation is reported apart from the error, so it can be queried.
+			instance.Status.PolicyViolations = policyViolations()
+			if n := len(instance.Status.PolicyViolations); n > 0 {
+				r.emitEvent(instance, pulumiv1.StackPolicyViolationEvent(), "Update blocked by %d policy violation(s).", n)
+			}
+			r.markStackFailed(sess, instance, shared.StackOperationUpdate, err, currentCommit, permalink)
+			setUpdateTiming(instance.Status.LastUpdate, updateStartedAt, updateFinishedAt)
+			instance.Status.MarkReconcilingCondition(pulumiv1.ReconcilingRetryReason, err.Error())
+			return retryAfterFailure(instance), nil
+		}
+		instance.Status.PolicyViolations = nil
+	}
+
+	// The update has been applied, whatever follows; so it's recorded now.
+	if r.audit != nil {
+		entry := newAuditEntry(instance, currentCommit, permalink, completedSteps(), updateFinishedAt.Time)
+		entry.PulumiVersion = sess.pulumiVersion
+		if err := r.audit.record(ctx, entry); err != nil {
+			reqLogger.Error(err, "Failed to write audit log entry", "Stack.Name", stack.Stack)
+		}
+	}
+
+	// If the stack is expected to have converged, check that there's nothing left to do.
+	if sess.stack.ExpectNoChanges {
+		changes, err := sess.PreviewChanges(ctx)
+		if err != nil {
+			r.markStackFailed(sess, instance, shared.StackOperationPreview, errors.Wrap(err, "previewing stack to check convergence"), currentCommit, permalink)
 			instance.Status.MarkReconcilingCondition(pulumiv1.ReconcilingRetryReason, err.Error())
-			return reconcile.Result{Requeue: true}, nil
+			return retryAfterFailure(instance), nil
 		}
+		if changes {
+			msg := "Stack has not converged; a preview after the update shows further changes."
+			r.emitEvent(instance, pulumiv1.StackNotConvergedEvent(), msg)
+			r.markStackFailed(sess, instance, shared.StackOperationUpdate, errors.New(msg), currentCommit, permalink)
+			setUpdateTiming(instance.Status.LastUpdate, updateStartedAt, updateFinishedAt)
+			instance.Status.MarkReconcilingCondition(pulumiv1.ReconcilingRetryReason, msg)
+			return retryAfterFailure(instance), nil
+		}
+	}
+
+	// Other stacks may depend on outputs the program has stopped exporting.
+	if missing := missingOutputs(sess.stack.RequiredOutputs, result.Outputs); len(missing) > 0 {
+		msg := fmt.Sprintf("Stack is missing required outputs: %s.", strings.Join(missing, ", "))
+		r.emitEvent(instance, pulumiv1.StackMissingOutputEvent(), msg)
+		r.markStackFailed(sess, instance, shared.StackOperationUpdate, errors.New(msg), currentCommit, permalink)
+		setUpdateTiming(instance.Status.LastUpdate, updateStartedAt, updateFinishedAt)
+		instance.Status.MarkReconcilingCondition(pulumiv1.ReconcilingRetryReason, msg)
+		return retryAfterFailure(instance), nil
 	}
 
 	// At this point, the stack has been processed successfully. Mark it as ready, and rely on the
 	// post-return hook `saveStatus` to account for any last minute exceptions.
 	instance.Status.MarkReadyCondition()
+	sess.recordResources(ctx, instance)
 
-	// Step 5. Capture outputs onto the resulting status object.
+	// Step 6. Capture outputs onto the resulting status object.
 	outs, err := sess.GetStackOutputs(result.Outputs)
 	if err != nil {
 		r.emitEvent(instance, pulumiv1.StackOutputRetrievalFailureEvent(), "Failed to get Stack outputs: %v.", err.Error())
@@ -406,46 +1061,346 @@ func (r *ReconcileStack) Reconcile(ctx context.Context, request reconcile.Reques
 		return reconcile.Result{}, nil
 	}
 
+	var omitted []string
+	if sess.stack.Outputs != nil && sess.stack.Outputs.MaxSize > 0 {
+		outs, omitted = limitOutputsSize(outs, sess.stack.Outputs.MaxSize)
+		if len(omitted) > 0 {
+			r.emitEvent(instance, pulumiv1.StackOutputsTruncatedEvent(),
+				"Stack outputs exceed %d bytes; omitted from status: %s.", sess.stack.Outputs.MaxSize, strings.Join(omitted, ", "))
+		}
+	}
 	instance.Status.Outputs = outs
+	instance.Status.OutputsTruncated = len(omitted) > 0
+	instance.Status.OutputsJSON = ""
+	if sess.stack.OutputsJSON {
+		doc, err := outputsDocument(outs, result.Outputs, sess.stack.AllOutputsSecret)
+		if err != nil {
+			r.emitEvent(instance, pulumiv1.StackOutputRetrievalFailureEvent(), "Failed to get Stack outputs: %v.", err.Error())
+			reqLogger.Error(err, "Failed to get Stack outputs", "Stack.Name", stack.Stack)
+			return reconcile.Result{}, err
+		}
+		instance.Status.OutputsJSON = doc
+	}
 	instance.Status.LastUpdate = &shared.StackUpdateState{
+		Kind:                 shared.StackOperationUpdate,
+		ProjectRepo:          sess.repoURL,
+		GitAuthMethod:        sess.gitAuthMethod,
+		PulumiVersion:        sess.pulumiVersion,
 		State:                shared.SucceededStackStateMessage,
 		LastAttemptedCommit:  currentCommit,
 		LastSuccessfulCommit: currentCommit,
 		Permalink:            permalink,
 		LastResyncTime:       metav1.Now(),
+		Changed:              resourcesChanged(result.Summary),
+		ResourcesDeleted:     resourcesDeleted(result.Summary),
+		ConfigChanges:        configChanges,
+	}
+	setUpdateTiming(instance.Status.LastUpdate, updateStartedAt, updateFinishedAt)
+
+	switch {
+	case sess.stack.DeleteOrphanedResources && instance.Status.LastUpdate.ResourcesDeleted > 0:
+		r.emitEvent(instance, pulumiv1.StackUpdateSuccessfulEvent(), "%s", withPulumiVersion(
+			fmt.Sprintf("Successfully updated stack; deleted %d orphaned resources", instance.Status.LastUpdate.ResourcesDeleted),
+			sess.pulumiVersion))
+	case instance.Status.LastUpdate.Changed:
+		r.emitEvent(instance, pulumiv1.StackUpdateSuccessfulEvent(), "%s", withPulumiVersion("Successfully updated stack", sess.pulumiVersion))
+	default:
+		r.emitEvent(instance, pulumiv1.StackUpdateNoChangesEvent(), "Stack is up to date; no resources changed.")
+	}
+	if wait, scheduled := untilBackup(instance, time.Now()); scheduled && wait <= 0 {
+		// A failed backup is reported, but doesn't fail the update; it's retried on schedule.
+		_ = r.backupState(ctx, sess, instance)
 	}
+	r.exportState(ctx, sess, instance)
 
-	r.emitEvent(instance, pulumiv1.StackUpdateSuccessfulEvent(), "Successfully updated stack.")
-	if trackBranch || sess.stack.ContinueResyncOnCommitMatch {
-		// Reconcile every 60 seconds to check for new commits to the branch.
-		reqLogger.Debug("Will requeue in", "seconds", resyncFreqSeconds)
-		return reconcile.Result{RequeueAfter: time.Duration(resyncFreqSeconds) * time.Second}, nil
+	if !trackBranch && !sess.stack.ContinueResyncOnCommitMatch {
+		resyncFreq = 0
+	}
+	// Reconcile every resyncFreqSeconds to check for new commits to the branch, or sooner if a
+	// scheduled refresh is due.
+	if after := requeueAfter(instance, resyncFreq, time.Now()); after > 0 {
+		reqLogger.Debug("Will requeue in", "seconds", after.Seconds())
+		return reconcile.Result{RequeueAfter: after}, nil
 	}
 
 	return reconcile.Result{}, nil
 }
 
-func (r *ReconcileStack) emitEvent(instance *pulumiv1.Stack, event pulumiv1.StackEvent, messageFmt string, args ...interface{}) {
-	r.recorder.Eventf(instance, event.EventType(), event.Reason(), messageFmt, args...)
+// refreshOnSchedule runs a scheduled refresh of a stack that is otherwise up to date, without
+// updating it, and gives the result for processing the stack again.
+func (r *ReconcileStack) refreshOnSchedule(ctx context.Context, sess *reconcileStackSession, instance *pulumiv1.Stack, resyncFreq time.Duration) (reconcile.Result, error) {
+	sess.logger.Info("Running scheduled refresh", "Stack.Name", sess.stack.Stack)
+	permalink, err := sess.RefreshStack(ctx, sess.stack.ExpectNoRefreshChanges)
+	if err != nil {
+		r.emitEvent(instance, pulumiv1.StackRefreshFailureEvent(), "Failed to refresh stack: %v.", err.Error())
+		sess.logger.Error(err, "Scheduled refresh failed -- will retry", "Stack.Name", sess.stack.Stack)
+		instance.Status.MarkReconcilingCondition(pulumiv1.ReconcilingRetryReason, err.Error())
+		recordFailure(instance)
+		return retryAfterFailure(instance), nil
+	}
+
+	refreshedAt := metav1.Now()
+	instance.Status.LastRefresh = &refreshedAt
+	instance.Status.LastUpdate.Kind = shared.StackOperationRefresh
+	instance.Status.LastUpdate.Permalink = permalink
+	instance.Status.LastUpdate.ConsecutiveFailures = 0
+	instance.Status.MarkReadyCondition()
+	r.emitEvent(instance, pulumiv1.StackRefreshSuccessfulEvent(), "Successfully refreshed stack.")
+	r.exportState(ctx, sess, instance)
+	return reconcile.Result{RequeueAfter: requeueAfter(instance, resyncFreq, refreshedAt.Time)}, nil
 }
 
-// markStackFailed updates the status of the Stack object `instance` locally, to reflect a failure to process the stack.
-func (r *ReconcileStack) markStackFailed(sess *reconcileStackSession, instance *pulumiv1.Stack, err error, currentCommit string, permalink shared.Permalink) {
-	r.emitEvent(instance, pulumiv1.StackUpdateFailureEvent(), "Failed to update Stack: %v.", err.Error())
-	sess.logger.Error(err, "Failed to update Stack", "Stack.Name", sess.stack.Stack)
-	// Update Stack status with failed state
-	if instance.Status.LastUpdate == nil {
-		instance.Status.LastUpdate = &shared.StackUpdateState{}
+// untilRefresh gives how long it is from now until the stack is due a scheduled refresh; zero or
+// less means it is due. Refreshes are scheduled from the last refresh or, failing that, the last
+// successful update. The second return value is false if the stack has no refresh schedule.
+func untilRefresh(instance *pulumiv1.Stack, now time.Time) (time.Duration, bool) {
+	schedule := instance.Spec.RefreshSchedule
+	if schedule == nil {
+		return 0, false
 	}
-	instance.Status.LastUpdate.LastAttemptedCommit = currentCommit
-	instance.Status.LastUpdate.State = shared.FailedStackStateMessage
-	instance.Status.LastUpdate.Permalink = permalink
-	instance.Status.LastUpdate.LastResyncTime = metav1.Now()
+	interval := schedule.IntervalSeconds
+	if interval < 60 {
+		interval = 60
+	}
+	var last time.Time
+	switch {
+	case instance.Status.LastRefresh != nil:
+		last = instance.Status.LastRefresh.Time
+	case instance.Status.LastUpdate != nil:
+		last = instance.Status.LastUpdate.LastResyncTime.Time
+	}
+	if last.IsZero() {
+		return 0, true
+	}
+	return last.Add(time.Duration(interval) * time.Second).Sub(now), true
 }
 
-func (sess *reconcileStackSession) finalize(ctx context.Context, stack *pulumiv1.Stack) error {
-	sess.logger.Info("Finalizing the stack")
-	// Run finalization logic for pulumiFinalizer. If the
+// requeueAfter gives the delay before processing a stack again, given its resync frequency (zero
+// meaning it isn't resynced): the soonest of the next resync, the next scheduled refresh, and the
+// next scheduled backup, or zero if there is none.
+func requeueAfter(instance *pulumiv1.Stack, resyncFreq time.Duration, now time.Time) time.Duration {
+	after := resyncFreq
+	for _, until := range []func(*pulumiv1.Stack, time.Time) (time.Duration, bool){untilRefresh, untilBackup} {
+		wait, scheduled := until(instance, now)
+		if !scheduled {
+			continue
+		}
+		if wait < time.Second {
+			wait = time.Second
+		}
+		if after == 0 || wait < after {
+			after = wait
+		}
+	}
+	return after
+}
+
+// resyncFrequencySeconds gives the interval at which a stack should be processed again after it
+// has been processed successfully, or zero if it needs no resync. Stacks that track a branch, or
+// which are rerun even when the source is unchanged, are resynced every minute unless configured
+// otherwise; and no stack is resynced more often than once a minute, unless the operator allows
+// sub-minute resyncs.
+func resyncFrequencySeconds(spec shared.StackSpec) int64 {
+	resyncFreqSeconds := spec.ResyncFrequencySeconds
+	if resyncFreqSeconds != 0 && resyncFreqSeconds < minResyncFrequencySeconds && !subminuteResyncAllowed() {
+		resyncFreqSeconds = minResyncFrequencySeconds
+	}
+
+	if len(spec.Branch) > 0 || spec.ContinueResyncOnCommitMatch {
+		if resyncFreqSeconds == 0 {
+			resyncFreqSeconds = 60
+		}
+	}
+	return resyncFreqSeconds
+}
+
+func (r *ReconcileStack) emitEvent(instance *pulumiv1.Stack, event pulumiv1.StackEvent, messageFmt string, args ...interface{}) {
+	msg := fmt.Sprintf(messageFmt, args...)
+	if r.events != nil {
+		allowed, suppressed := r.events.allow(eventKey{
+			namespace: instance.GetNamespace(),
+			name:      instance.GetName(),
+			reason:    event.Reason(),
+			message:   msg,
+		})
+		if !allowed {
+			return
+		}
+		if suppressed > 0 {
+			msg = fmt.Sprintf("%s (%d similar events suppressed)", msg, suppressed)
+		}
+	}
+	r.recorder.Event(instance, event.EventType(), event.Reason(), msg)
+}
+
+// withPulumiVersion finishes an event message with the version of Pulumi used, if it's known.
+func withPulumiVersion(msg, version string) string {
+	if version == "" {
+		return msg + "."
+	}
+	return fmt.Sprintf("%s, using Pulumi %s.", msg, version)
+}
+
+// markStackFailed updates the status of the Stack object `instance` locally, to reflect a failure to process the stack.
+func (r *ReconcileStack) markStackFailed(sess *reconcileStackSession, instance *pulumiv1.Stack, kind shared.StackOperationKind, err error, currentCommit string, permalink shared.Permalink) {
+	r.emitEvent(instance, pulumiv1.StackUpdateFailureEvent(), "Failed to update Stack: %v.", err.Error())
+	sess.logger.Error(err, "Failed to update Stack", "Stack.Name", sess.stack.Stack)
+	// Update Stack status with failed state
+	recordFailure(instance)
+	instance.Status.LastUpdate.LastAttemptedCommit = currentCommit
+	instance.Status.LastUpdate.Kind = kind
+	instance.Status.LastUpdate.ProjectRepo = sess.repoURL
+	instance.Status.LastUpdate.GitAuthMethod = sess.gitAuthMethod
+	instance.Status.LastUpdate.PulumiVersion = sess.pulumiVersion
+	instance.Status.LastUpdate.State = shared.FailedStackStateMessage
+	instance.Status.LastUpdate.Permalink = permalink
+	instance.Status.LastUpdate.LastResyncTime = metav1.Now()
+}
+
+// retryBackendUnavailable records that the stack could not be processed because the Pulumi backend
+// was unreachable, and gives the result for retrying it. This is reported distinctly from a failed
+// update, since it's likely to be an infrastructure outage rather than a problem with the stack.
+func (r *ReconcileStack) retryBackendUnavailable(sess *reconcileStackSession, instance *pulumiv1.Stack, err error) reconcile.Result {
+	r.emitEvent(instance, pulumiv1.StackBackendUnavailableEvent(), "Pulumi backend is unreachable; will retry: %v", err.Error())
+	sess.logger.Error(err, "Pulumi backend is unreachable -- will retry", "Stack.Name", sess.stack.Stack)
+	instance.Status.MarkReconcilingCondition(pulumiv1.ReconcilingRetryReason, "Pulumi backend is unreachable; retrying")
+	recordFailure(instance)
+	return retryAfterFailure(instance)
+}
+
+// retryLockedStack handles an update that was prevented by the stack's lock, when the stack gives
+// BreakLockAfterSeconds. The lock is assumed to be stale once it has been held for that long, and
+// the update holding it is cancelled so that the next attempt can proceed.
+func (r *ReconcileStack) retryLockedStack(ctx context.Context, sess *reconcileStackSession, instance *pulumiv1.Stack) reconcile.Result {
+	now := time.Now()
+	if instance.Status.LockedSince == nil {
+		lockedSince := metav1.NewTime(now)
+		instance.Status.LockedSince = &lockedSince
+	}
+	recordFailure(instance)
+	result := retryAfterFailure(instance)
+
+	remaining := untilBreakLock(sess.stack.BreakLockAfterSeconds, instance.Status.LockedSince.Time, now)
+	if remaining > 0 {
+		sess.logger.Info("Stack is locked by another update -- will retry", "Stack.Name", sess.stack.Stack,
+			"LockedSince", instance.Status.LockedSince.Time, "BreakLockIn", remaining)
+		instance.Status.MarkReconcilingCondition(pulumiv1.ReconcilingRetryReason, "stack is locked by another update; retrying")
+		if !sess.stack.RetryOnUpdateConflict || remaining < result.RequeueAfter {
+			result.RequeueAfter = remaining
+		}
+		return result
+	}
+
+	if err := sess.CancelUpdate(ctx); err != nil {
+		sess.logger.Error(err, "Failed to break stack lock", "Stack.Name", sess.stack.Stack)
+		instance.Status.MarkReconcilingCondition(pulumiv1.ReconcilingRetryReason,
+			fmt.Sprintf("failed to break stack lock: %s", err.Error()))
+		return result
+	}
+	r.emitEvent(instance, pulumiv1.StackLockBrokenEvent(),
+		"Cancelled the update holding the stack's lock, which had prevented updates since %s.",
+		instance.Status.LockedSince.UTC().Format(time.RFC3339))
+	sess.logger.Info("Broke stale stack lock -- will retry", "Stack.Name", sess.stack.Stack)
+	instance.Status.LockedSince = nil
+	instance.Status.MarkReconcilingCondition(pulumiv1.ReconcilingRetryReason, "broke stale stack lock; retrying")
+	return reconcile.Result{Requeue: true}
+}
+
+// untilBreakLock gives how long until a stack lock, first seen at lockedSince, is considered stale.
+func untilBreakLock(breakAfterSeconds int64, lockedSince, now time.Time) time.Duration {
+	return lockedSince.Add(time.Duration(breakAfterSeconds) * time.Second).Sub(now)
+}
+
+// recordFailure counts a failed attempt to process the stack in its status. The count is reset
+// when the stack is next processed successfully, since that replaces the last update state.
+func recordFailure(instance *pulumiv1.Stack) {
+	if instance.Status.LastUpdate == nil {
+		instance.Status.LastUpdate = &shared.StackUpdateState{}
+	}
+	instance.Status.LastUpdate.ConsecutiveFailures++
+}
+
+// retryAfterFailure gives the result for requeueing a stack that failed to be processed, backing
+// off according to the stack's retry policy and the number of consecutive failures so far.
+func retryAfterFailure(instance *pulumiv1.Stack) reconcile.Result {
+	var failures int64
+	if instance.Status.LastUpdate != nil {
+		failures = instance.Status.LastUpdate.ConsecutiveFailures
+	}
+	return reconcile.Result{RequeueAfter: retryBackoff(instance.Spec.RetryPolicy, failures, randomJitter)}
+}
+
+// setUpdateTiming records when an update started and finished, and how long it took, in the
+// given update state.
+func setUpdateTiming(state *shared.StackUpdateState, startedAt, finishedAt metav1.Time) {
+	state.StartedAt = startedAt
+	state.FinishedAt = finishedAt
+	state.DurationSeconds = int64(finishedAt.Sub(startedAt.Time).Seconds())
+}
+
+// finalizeWithoutSource destroys a stack being deleted when its workspace can't be set up from the
+// project repository, e.g., because the repository or branch has gone, so that deletion isn't
+// held up indefinitely. This needs the project name, which may have been read before setting up
+// the workspace failed.
+func (r *ReconcileStack) finalizeWithoutSource(ctx context.Context, sess *reconcileStackSession, instance *pulumiv1.Stack, sourceErr error) (reconcile.Result, error) {
+	project := projectForDestroy(sess.stack.Stack, sess.project)
+	if project == "" {
+		err := errors.Wrap(sourceErr, "cannot destroy the stack without its project repository, since the project name is not known")
+		r.markStackFailed(sess, instance, shared.StackOperationDestroy, err, "", "")
+		instance.Status.MarkReconcilingCondition(pulumiv1.ReconcilingRetryReason, err.Error())
+		return retryAfterFailure(instance), nil
+	}
+	r.emitEvent(instance, pulumiv1.StackDestroyWithoutSourceEvent(),
+		"Could not set up the workspace from the project repository (%s); destroying the stack from its state.", sourceErr.Error())
+	sess.logger.Info("Destroying stack without project repository", "Stack.Name", sess.stack.Stack,
+		"Project", project, "Cause", sourceErr.Error())
+	backend := sess.backend
+	if backend == "" {
+		backend = instance.Status.Backend
+	}
+	return r.finalizeFromState(ctx, sess, instance, project, backend)
+}
+
+// finalizeFromState destroys a stack being deleted using a workspace set up from the backend
+// alone: the project repository is not cloned, and no dependencies are installed, since the
+// program is not run. The backend is that given by the project file, if known.
+func (r *ReconcileStack) finalizeFromState(ctx context.Context, sess *reconcileStackSession, instance *pulumiv1.Stack, project, backend string) (reconcile.Result, error) {
+	if err := sess.SetupDestroyWorkdir(ctx, project, backend); err != nil {
+		if sess.backend != "" {
+			backend = sess.backend
+		}
+		if isBackendUnavailableError(err, "", backend) {
+			return r.retryBackendUnavailable(sess, instance, err), nil
+		}
+		r.markStackFailed(sess, instance, shared.StackOperationDestroy, err, "", "")
+		instance.Status.MarkReconcilingCondition(pulumiv1.ReconcilingRetryReason, err.Error())
+		return retryAfterFailure(instance), nil
+	}
+	defer sess.CleanupPulumiDir()
+
+	if err := sess.SetEnvs(ctx, sess.stack.Envs, sess.namespace); err != nil {
+		err := errors.Wrap(err, "could not find ConfigMap for Envs")
+		r.markStackFailed(sess, instance, shared.StackOperationDestroy, err, "", "")
+		instance.Status.MarkReconcilingCondition(pulumiv1.ReconcilingRetryReason, err.Error())
+		return retryAfterFailure(instance), nil
+	}
+	if err := sess.SetSecretEnvs(ctx, sess.stack.SecretEnvs, sess.namespace); err != nil {
+		err := errors.Wrap(err, "could not find Secret for SecretEnvs")
+		r.markStackFailed(sess, instance, shared.StackOperationDestroy, err, "", "")
+		instance.Status.MarkReconcilingCondition(pulumiv1.ReconcilingRetryReason, err.Error())
+		return retryAfterFailure(instance), nil
+	}
+
+	if err := sess.finalize(ctx, instance); err != nil {
+		r.markStackFailed(sess, instance, shared.StackOperationDestroy, err, "", "")
+		return reconcile.Result{}, err
+	}
+	return reconcile.Result{}, nil
+}
+
+func (sess *reconcileStackSession) finalize(ctx context.Context, stack *pulumiv1.Stack) error {
+	sess.logger.Info("Finalizing the stack")
+	// Run finalization logic for pulumiFinalizer. If the
 	// finalization logic fails, don't remove the finalizer so
 	// that we can retry during the next reconciliation.
 	if err := sess.finalizeStack(ctx); err != nil {
@@ -501,6 +1456,48 @@ func (sess *reconcileStackSession) addFinalizerAndUpdate(ctx context.Context, st
 	})
 }
 
+// waitForFinalizer polls the (possibly cached) client until the stack given by key is seen to
+// have this controller's finalizer, or the poll times out.
+func (sess *reconcileStackSession) waitForFinalizer(ctx context.Context, key client.ObjectKey) error {
+	return wait.PollImmediate(finalizerPollInterval, finalizerPollTimeout, func() (bool, error) {
+		var stack pulumiv1.Stack
+		if err := sess.kubeClient.Get(ctx, key, &stack); err != nil {
+			return false, err
+		}
+		return contains(stack.GetFinalizers(), pulumiFinalizer), nil
+	})
+}
+
+// subminuteResyncAllowed reports whether the operator is configured to let stacks be resynced more
+// often than once a minute. It defaults to false.
+func subminuteResyncAllowed() bool {
+	raw := os.Getenv(allowSubminuteResyncEnv)
+	if raw == "" {
+		return false
+	}
+	allowed, err := strconv.ParseBool(raw)
+	if err != nil {
+		log.Error(err, "ignoring invalid setting for sub-minute resyncs", "env", allowSubminuteResyncEnv, "value", raw)
+		return false
+	}
+	return allowed
+}
+
+// finalizerSettleDelay returns the extra delay to wait after adding the finalizer, as configured
+// in the environment. It defaults to no delay.
+func finalizerSettleDelay() time.Duration {
+	raw := os.Getenv(finalizerSettleDelayEnv)
+	if raw == "" {
+		return 0
+	}
+	delay, err := time.ParseDuration(raw)
+	if err != nil {
+		log.Error(err, "ignoring invalid finalizer settle delay", "env", finalizerSettleDelayEnv, "value", raw)
+		return 0
+	}
+	return delay
+}
+
 type reconcileStackSession struct {
 	logger     logging.Logger
 	kubeClient client.Client
@@ -509,6 +1506,30 @@ type reconcileStackSession struct {
 	namespace  string
 	workdir    string
 	rootDir    string
+	// repoURL is the URL the project repository was cloned from.
+	repoURL string
+	// stackCreated records whether the stack was created in the backend when setting up the
+	// workspace, rather than already existing.
+	stackCreated bool
+	// gitAuthFallbacks are the credentials to try, in order, if cloning with those from
+	// SetupGitAuth fails.
+	gitAuthFallbacks []*auto.GitAuth
+	// gitAuthMethod names the credentials the project repository was cloned with.
+	gitAuthMethod string
+	// pulumiVersion is the version of the Pulumi CLI used in the workspace, once it's set up.
+	pulumiVersion string
+	// homeDir is the HOME used when processing the stack.
+	homeDir string
+	// project is the name of the Pulumi project, once the workspace is set up.
+	project string
+	// backend is the URL of the backend Pulumi uses, once the workspace is set up, if it's given
+	// by the environment or the project file.
+	backend string
+	// localRevision is the digest of the project source, when it's from a local path which isn't a
+	// git checkout.
+	localRevision string
+	// meta is the metadata of the Stack object, for downward references.
+	meta metav1.ObjectMeta
 }
 
 func newReconcileStackSession(
@@ -573,6 +1594,237 @@ func (sess *reconcileStackSession) SetEnvRefsForWorkspace(ctx context.Context, w
 	return nil
 }
 
+// SetupKubeconfig writes the kubeconfig given in the stack specification, if any, to the stack's
+// workspace and points the workspace environment at it. Stacks without their own kubeconfig are
+// left to use the ambient one.
+func (sess *reconcileStackSession) SetupKubeconfig(ctx context.Context, w auto.Workspace) error {
+	if sess.stack.Kubeconfig == nil {
+		return nil
+	}
+	kubeconfig, err := sess.resolveResourceRef(ctx, sess.stack.Kubeconfig)
+	if err != nil {
+		return errors.Wrap(err, "resolving kubeconfig")
+	}
+	kubeconfigPath := filepath.Join(sess.rootDir, "kubeconfig")
+	if err := os.WriteFile(kubeconfigPath, []byte(kubeconfig), 0600); err != nil {
+		return errors.Wrap(err, "writing kubeconfig")
+	}
+	w.SetEnvVar("KUBECONFIG", kubeconfigPath)
+	w.SetEnvVar("KUBE_CONFIG_PATH", kubeconfigPath)
+	return nil
+}
+
+// WriteWorkspaceFiles writes the files given in the stack specification into the project
+// directory, replacing any that are already there.
+func (sess *reconcileStackSession) WriteWorkspaceFiles(ctx context.Context) error {
+	for _, file := range sess.stack.WorkspaceFiles {
+		dest, err := workspaceFilePath(sess.workdir, file.Path)
+		if err != nil {
+			return err
+		}
+		contents, err := sess.resolveWorkspaceFile(ctx, file)
+		if err != nil {
+			return errors.Wrapf(err, "resolving workspace file %q", file.Path)
+		}
+		if err := replaceWorkspaceFile(sess.workdir, dest, contents); err != nil {
+			return errors.Wrapf(err, "workspace file %q", file.Path)
+		}
+	}
+	return nil
+}
+
+// replaceWorkspaceFile writes contents to the file at dest, which must be within dir, creating
+// any directories needed and replacing any file already there.
+func replaceWorkspaceFile(dir, dest string, contents []byte) error {
+	// The checked-out source may have symlinks, so check where the file will really go before
+	// creating any directories, and replace rather than write through any file already there.
+	if err := checkWithinDir(dir, existingAncestor(filepath.Dir(dest))); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return errors.Wrap(err, "creating directory")
+	}
+	if err := checkWithinDir(dir, filepath.Dir(dest)); err != nil {
+		return err
+	}
+	if err := os.Remove(dest); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "replacing file")
+	}
+	if err := os.WriteFile(dest, contents, 0600); err != nil {
+		return errors.Wrap(err, "writing file")
+	}
+	return nil
+}
+
+// resolveWorkspaceFile fetches the contents of a workspace file from the ConfigMap or Secret it
+// refers to.
+func (sess *reconcileStackSession) resolveWorkspaceFile(ctx context.Context, file shared.WorkspaceFile) ([]byte, error) {
+	switch {
+	case file.ConfigMapRef != "" && file.SecretRef != "":
+		return nil, errors.New("only one of configMapRef and secretRef may be given")
+	case file.ConfigMapRef != "":
+		var config corev1.ConfigMap
+		if err := sess.kubeClient.Get(ctx, types.NamespacedName{Name: file.ConfigMapRef, Namespace: sess.namespace}, &config); err != nil {
+			return nil, errors.Wrapf(err, "Namespace=%s Name=%s", sess.namespace, file.ConfigMapRef)
+		}
+		if val, ok := config.Data[file.Key]; ok {
+			return []byte(val), nil
+		}
+		if val, ok := config.BinaryData[file.Key]; ok {
+			return val, nil
+		}
+		return nil, errors.Errorf("No key %s found in configmap %s/%s", file.Key, sess.namespace, file.ConfigMapRef)
+	case file.SecretRef != "":
+		var secret corev1.Secret
+		if err := sess.kubeClient.Get(ctx, types.NamespacedName{Name: file.SecretRef, Namespace: sess.namespace}, &secret); err != nil {
+			return nil, errors.Wrapf(err, "Namespace=%s Name=%s", sess.namespace, file.SecretRef)
+		}
+		val, ok := secret.Data[file.Key]
+		if !ok {
+			return nil, errors.Errorf("No key %s found in secret %s/%s", file.Key, sess.namespace, file.SecretRef)
+		}
+		return val, nil
+	default:
+		return nil, errors.New("one of configMapRef and secretRef must be given")
+	}
+}
+
+// expandRepoDir expands the project directory given in the stack spec as a template, with the
+// stack's inline configuration and environment, and checks that the result is a path within the
+// repository.
+func expandRepoDir(repoDir string, config, env map[string]string) (string, error) {
+	tmpl, err := template.New("repoDir").Option("missingkey=error").Parse(repoDir)
+	if err != nil {
+		return "", err
+	}
+	var out strings.Builder
+	if err := tmpl.Execute(&out, struct {
+		Config, Env map[string]string
+	}{config, env}); err != nil {
+		return "", err
+	}
+	dir := out.String()
+	if dir == "" {
+		return "", nil
+	}
+	cleaned := filepath.Clean(dir)
+	if filepath.IsAbs(dir) || cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+		return "", errors.Errorf("%q is not a relative path within the repository", dir)
+	}
+	return dir, nil
+}
+
+// workspaceFilePath returns the location of a workspace file given by a path relative to the
+// project directory dir, or an error if the path would lead outside dir.
+func workspaceFilePath(dir, rel string) (string, error) {
+	if rel == "" || filepath.IsAbs(rel) {
+		return "", errors.Errorf("workspace file path %q must be a relative path", rel)
+	}
+	cleaned := filepath.Clean(rel)
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+		return "", errors.Errorf("workspace file path %q leads outside the project directory", rel)
+	}
+	return filepath.Join(dir, cleaned), nil
+}
+
+// existingAncestor gives the deepest of path and its ancestors which exists (as a file, directory
+// or symlink).
+func existingAncestor(path string) string {
+	for {
+		if _, err := os.Lstat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
+
+// checkWithinDir returns an error if target, once symlinks are resolved, is not dir or inside it.
+func checkWithinDir(dir, target string) error {
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	realTarget, err := filepath.EvalSymlinks(target)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(realDir, realTarget)
+	if err != nil {
+		return err
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return errors.Errorf("%s leads outside the project directory", target)
+	}
+	return nil
+}
+
+// SetStackReferenceAccess checks that the stack references in the stack specification are named
+// as the backend given expects, and that the credentials given for them can be resolved. If the
+// stack has no access token of its own, the one given for the references is used; it's an error if
+// they give different tokens, since none of them can be assumed to serve the stack itself.
+func (sess *reconcileStackSession) SetStackReferenceAccess(ctx context.Context, w auto.Workspace, backend string) error {
+	tokens := map[string]bool{}
+	var token string
+	for _, ref := range sess.stack.StackReferences {
+		if err := checkStackReferenceName(ref.Name, backend); err != nil {
+			return err
+		}
+		if ref.AccessToken == nil {
+			continue
+		}
+		resolved, err := sess.resolveResourceRef(ctx, ref.AccessToken)
+		if err != nil {
+			return errors.Wrapf(err, "resolving access token for stack reference %q", ref.Name)
+		}
+		if resolved == "" {
+			return errors.Errorf("access token for stack reference %q is empty", ref.Name)
+		}
+		tokens[resolved] = true
+		token = resolved
+	}
+	switch {
+	case len(tokens) == 0 || hasOwnAccessToken(w):
+		return nil
+	case len(tokens) > 1:
+		return errors.New("stack references give different access tokens, and the stack has none of its own: " +
+			"give accessTokenSecret, or PULUMI_ACCESS_TOKEN in envRefs")
+	}
+	w.SetEnvVar("PULUMI_ACCESS_TOKEN", token)
+	return nil
+}
+
+// hasOwnAccessToken reports whether the stack has an access token, given in its workspace or by
+// the operator's environment.
+func hasOwnAccessToken(w auto.Workspace) bool {
+	return w.GetEnvVars()["PULUMI_ACCESS_TOKEN"] != "" || os.Getenv("PULUMI_ACCESS_TOKEN") != ""
+}
+
+// checkStackReferenceName checks that the name of a referenced stack has a form the backend
+// accepts. Self-managed backends have no organizations, so the name need not give one; otherwise,
+// the name must be fully qualified, since the organization of the user can't be assumed.
+func checkStackReferenceName(name, backend string) error {
+	parts := strings.Split(name, "/")
+	for _, part := range parts {
+		if part == "" {
+			return errors.Errorf("stack reference %q has an empty part", name)
+		}
+	}
+	if isSelfManagedBackend(backend) {
+		if len(parts) > 3 {
+			return errors.Errorf("stack reference %q has too many parts", name)
+		}
+		return nil
+	}
+	if len(parts) != 3 {
+		return errors.Errorf("stack reference %q is not a fully qualified stack name (<org>/<project>/<stack>)", name)
+	}
+	return nil
+}
+
 func (sess *reconcileStackSession) resolveResourceRef(ctx context.Context, ref *shared.ResourceRef) (string, error) {
 	switch ref.SelectorType {
 	case shared.ResourceSelectorEnv:
@@ -615,11 +1867,62 @@ func (sess *reconcileStackSession) resolveResourceRef(ctx context.Context, ref *
 			return string(secretVal), nil
 		}
 		return "", errors.New("Mising secret reference in ResourceRef")
+	case shared.ResourceSelectorDownward:
+		if ref.Downward != nil {
+			return resolveDownwardField(ref.Downward.FieldPath, sess.meta)
+		}
+		return "", errors.New("missing downward reference in ResourceRef")
 	default:
 		return "", errors.Errorf("Unsupported selector type: %v", ref.SelectorType)
 	}
 }
 
+// resolveDownwardField gives the value of a field of the Stack object with the metadata given, or
+// of the operator's configuration. A label or annotation which isn't present is an error, as is an
+// empty cluster name, so that a missing value doesn't go unnoticed.
+func resolveDownwardField(fieldPath string, meta metav1.ObjectMeta) (string, error) {
+	switch fieldPath {
+	case "metadata.name":
+		return meta.Name, nil
+	case "metadata.namespace":
+		return meta.Namespace, nil
+	case "metadata.uid":
+		return string(meta.UID), nil
+	case "clusterName":
+		if name := os.Getenv(clusterNameEnv); name != "" {
+			return name, nil
+		}
+		return "", errors.Errorf("no cluster name given to the operator in %s", clusterNameEnv)
+	}
+	for prefix, values := range map[string]map[string]string{
+		"metadata.labels":      meta.Labels,
+		"metadata.annotations": meta.Annotations,
+	} {
+		if !strings.HasPrefix(fieldPath, prefix+"['") || !strings.HasSuffix(fieldPath, "']") {
+			continue
+		}
+		key := strings.TrimSuffix(strings.TrimPrefix(fieldPath, prefix+"['"), "']")
+		if v, ok := values[key]; ok {
+			return v, nil
+		}
+		return "", errors.Errorf("no %s %q on the stack", strings.TrimSuffix(strings.TrimPrefix(prefix, "metadata."), "s"), key)
+	}
+	return "", errors.Errorf("unsupported downward field path: %q", fieldPath)
+}
+
+// userAgent returns the user agent to report to the Pulumi backend for operations on this stack;
+// that is, the operator's own agent, followed by any suffix given for attribution.
+func (sess *reconcileStackSession) userAgent() string {
+	suffix := sess.stack.UserAgentSuffix
+	if suffix == "" {
+		suffix = os.Getenv(userAgentSuffixEnv)
+	}
+	if suffix == "" {
+		return execAgent
+	}
+	return execAgent + " " + suffix
+}
+
 // runCmd runs the given command with stdout and stderr hooked up to the logger.
 func (sess *reconcileStackSession) runCmd(title string, cmd *exec.Cmd, workspace auto.Workspace) (string, string, error) {
 	// If not overridden, set the command to run in the working directory.
@@ -708,60 +2011,466 @@ func (sess *reconcileStackSession) lookupPulumiAccessToken(ctx context.Context)
 	return "", false
 }
 
-func (sess *reconcileStackSession) SetupPulumiWorkdir(ctx context.Context, gitAuth *auto.GitAuth) error {
-	repo := auto.GitRepo{
+// lookupDefaultAccessToken fetches the operator-wide default access token, from the secret named
+// in the environment (as "<name>" for a secret in the stack's namespace, or
+// "<namespace>/<name>"), if there is one.
+func (sess *reconcileStackSession) lookupDefaultAccessToken(ctx context.Context) (string, bool) {
+	ref := os.Getenv(defaultAccessTokenSecretEnv)
+	if ref == "" {
+		return "", false
+	}
+	key := types.NamespacedName{Namespace: sess.namespace, Name: ref}
+	if i := strings.Index(ref, "/"); i >= 0 {
+		key = types.NamespacedName{Namespace: ref[:i], Name: ref[i+1:]}
+	}
+
+	var secret corev1.Secret
+	if err := sess.kubeClient.Get(ctx, key, &secret); err != nil {
+		sess.logger.Error(err, "Could not find default secret for Pulumi API access",
+			"Namespace", key.Namespace, "Name", key.Name)
+		return "", false
+	}
+	accessToken := string(secret.Data["accessToken"])
+	if accessToken == "" {
+		err := errors.New("Secret accessToken data is empty")
+		sess.logger.Error(err, "Illegal empty default secret accessToken data for Pulumi API access",
+			"Namespace", key.Namespace, "Name", key.Name)
+		return "", false
+	}
+	return accessToken, true
+}
+
+// gitRepo gives the repository to clone for the stack. The URL is passed through as given, so
+// that a non-standard port or a base path (e.g., https://git.example.com:8443/scm/proj/repo.git)
+// is kept, and the credentials from SetupGitAuth are used for whichever scheme it has.
+func (sess *reconcileStackSession) gitRepo(gitAuth *auto.GitAuth) auto.GitRepo {
+	return auto.GitRepo{
 		URL:         sess.stack.ProjectRepo,
 		ProjectPath: sess.stack.RepoDir,
 		CommitHash:  sess.stack.Commit,
 		Branch:      sess.stack.Branch,
 		Auth:        gitAuth,
 	}
+}
 
-	sess.logger.Debug("Setting up pulumi workdir for stack", "stack", sess.stack)
-	// Create a new workspace.
-	secretsProvider := auto.SecretsProvider(sess.stack.SecretsProvider)
+// projectNotFoundError is returned when there's no Pulumi project file in the project directory.
+type projectNotFoundError struct {
+	// repoDir is the project directory, relative to the root of the repository.
+	repoDir string
+}
 
-	// Create the temporary workdir
-	dir, err := os.MkdirTemp("", "pulumi_auto")
-	if err != nil {
-		return errors.Wrap(err, "unable to create tmp directory for workspace")
+func (e *projectNotFoundError) Error() string {
+	dir := e.repoDir
+	if dir == "" {
+		dir = "."
 	}
-	sess.rootDir = dir
+	return fmt.Sprintf("no Pulumi.yaml project file found in directory %q of the repository; check 'repoDir'", dir)
+}
 
-	// Cleanup the rootdir on failure setting up the workspace.
-	defer func() {
-		if err != nil {
-			_ = os.RemoveAll(sess.rootDir)
+// checkProjectFile checks that there's a Pulumi project file (Pulumi.yaml, or with another
+// extension Pulumi understands) in the project directory, since the automation API fails less
+// clearly without one. The project directory is given as an absolute path to check, and as the
+// path relative to the root of the repository, to report.
+func checkProjectFile(workdir, repoDir string) error {
+	for _, ext := range encoding.Exts {
+		if info, err := os.Stat(filepath.Join(workdir, "Pulumi"+ext)); err == nil && !info.IsDir() {
+			return nil
 		}
-	}()
-
-	var w auto.Workspace
-	w, err = auto.NewLocalWorkspace(ctx, auto.WorkDir(dir), auto.Repo(repo), secretsProvider)
-	if err != nil {
-		return errors.Wrap(err, "failed to create local workspace")
 	}
+	return &projectNotFoundError{repoDir: repoDir}
+}
 
-	sess.workdir = w.WorkDir()
-
-	if sess.stack.Backend != "" {
-		w.SetEnvVar("PULUMI_BACKEND_URL", sess.stack.Backend)
+// cloneWorkspace creates the workspace for the stack by cloning the project repository into dir.
+func (sess *reconcileStackSession) cloneWorkspace(ctx context.Context, dir string, gitAuth *auto.GitAuth, secretsProvider auto.LocalWorkspaceOption) (auto.Workspace, error) {
+	if err := sess.checkGitLFS(); err != nil {
+		return nil, err
 	}
-	if accessToken, found := sess.lookupPulumiAccessToken(ctx); found {
-		w.SetEnvVar("PULUMI_ACCESS_TOKEN", accessToken)
+	// The project repository is cloned when creating the workspace, so try each mirror in turn
+	// if that fails, and each set of credentials suited to it, starting from an empty directory
+	// each time.
+	repo := sess.gitRepo(gitAuth)
+	candidates := gitAuthCandidates(gitAuth, sess.gitAuthFallbacks)
+	var w auto.Workspace
+	var timedOut bool
+	var err error
+	clone := func(url string, auth *auto.GitAuth) error {
+		// Only the last attempt decides whether the clone is reported as having timed out.
+		timedOut = false
+		if err := emptyDir(dir); err != nil {
+			return err
+		}
+		cloneCtx, cancel := sess.cloneContext(ctx)
+		defer cancel()
+		var err error
+		if len(sess.stack.SparseCheckoutPaths) > 0 || sess.stack.SingleBranch {
+			if err = sess.gitClone(cloneCtx, url, dir, auth); err == nil {
+				w, err = auto.NewLocalWorkspace(ctx, auto.WorkDir(filepath.Join(dir, sess.stack.RepoDir)), secretsProvider)
+			}
+		} else {
+			repo.URL = url
+			repo.Auth = auth
+			w, err = auto.NewLocalWorkspace(cloneCtx, auto.WorkDir(dir), auto.Repo(repo), secretsProvider)
+		}
+		if err == nil && sess.stack.GitLFS {
+			err = sess.gitLFSPull(cloneCtx, dir, auth)
+		}
+		if err != nil {
+			if cloneCtx.Err() == context.DeadlineExceeded {
+				timedOut = true
+			}
+			sess.logger.Error(err, "Failed to create local workspace", "Stack.Name", sess.stack.Stack, "URL", url)
+		}
+		return err
 	}
-
-	if err = sess.SetEnvRefsForWorkspace(ctx, w); err != nil {
+	sess.repoURL, err = firstSuccessful(sess.repoURLs(), func(url string) error {
+		method, err := firstSuitableAuth(url, candidates, func(auth *auto.GitAuth) error {
+			return clone(url, auth)
+		})
+		sess.gitAuthMethod = method
 		return err
+	})
+	if err != nil {
+		if timedOut {
+			return nil, &cloneTimeoutError{timeoutSeconds: sess.stack.CloneTimeoutSeconds, err: err}
+		}
+		if isGitAuthFailure(err) {
+			return nil, &gitAuthError{err: err}
+		}
+		return nil, errors.Wrap(err, "failed to create local workspace")
 	}
+	return w, nil
+}
 
-	var a auto.Stack
+// cloneContext gives the context in which to clone the project repository, which is limited by
+// the clone timeout, if one is given.
+func (sess *reconcileStackSession) cloneContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if sess.stack.CloneTimeoutSeconds > 0 {
+		return context.WithTimeout(ctx, time.Duration(sess.stack.CloneTimeoutSeconds)*time.Second)
+	}
+	return context.WithCancel(ctx)
+}
 
-	if sess.stack.UseLocalStackOnly {
+// cloneTimeoutError is returned when the project repository couldn't be cloned, and the last
+// attempt was abandoned because it took too long.
+type cloneTimeoutError struct {
+	timeoutSeconds int64
+	err            error
+}
+
+func (e *cloneTimeoutError) Error() string {
+	return fmt.Sprintf("cloning the project repository timed out after %ds: %s", e.timeoutSeconds, e.err.Error())
+}
+
+// gitAuthFailureMessages are fragments of the errors reported by go-git and the git command when
+// the credentials for a repository are missing or rejected.
+var gitAuthFailureMessages = []string{
+	"authentication required",
+	"authorization failed",
+	"unable to authenticate",
+	"Authentication failed",
+	"Permission denied (publickey",
+	"could not read Username",
+}
+
+// isGitAuthFailure reports whether the error from cloning a repository is because the credentials
+// for it were missing or rejected.
+func isGitAuthFailure(err error) bool {
+	for _, msg := range gitAuthFailureMessages {
+		if strings.Contains(err.Error(), msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// gitAuthError is returned when the project repository couldn't be cloned because the credentials
+// for it were missing or rejected, e.g., because they were rotated and the secret holding them
+// hasn't been updated yet.
+type gitAuthError struct {
+	err error
+}
+
+func (e *gitAuthError) Error() string {
+	return fmt.Sprintf("authenticating to the project repository: %s", e.err.Error())
+}
+
+// stackNameError is returned when the stack name doesn't have a form the backend accepts.
+type stackNameError struct {
+	name, reason string
+}
+
+func (e *stackNameError) Error() string {
+	return fmt.Sprintf("invalid stack name %q: %s", e.name, e.reason)
+}
+
+// accessTokenError is returned when the stack's backend needs an access token, and none could be
+// found.
+type accessTokenError struct {
+	backend string
+	// secret is the secret the stack names for the access token, if any.
+	secret string
+}
+
+func (e *accessTokenError) Error() string {
+	if e.secret != "" {
+		return fmt.Sprintf("no access token for backend %s: secret %q is missing or has an empty accessToken", e.backend, e.secret)
+	}
+	return fmt.Sprintf("no access token for backend %s: give accessTokenSecret, or PULUMI_ACCESS_TOKEN in envRefs", e.backend)
+}
+
+// pulumiCloudURL is the URL under which Pulumi Cloud logins are stored.
+const pulumiCloudURL = "https://api.pulumi.com"
+
+// checkAccessToken checks that there's an access token for the backend, if it's explicitly Pulumi
+// Cloud: in the workspace environment given, the operator's, or a login stored in the Pulumi home
+// given in the workspace environment. Without it, Pulumi fails only once it tries to reach the
+// backend, with a less helpful error. Other backends, including an unknown one (i.e., whichever
+// Pulumi is logged in to), are left to Pulumi. The secret is that named by the stack for the token,
+// if any.
+func checkAccessToken(env map[string]string, backend, secret string) error {
+	if !isPulumiCloudBackend(backend) {
+		return nil
+	}
+	if env["PULUMI_ACCESS_TOKEN"] != "" || os.Getenv("PULUMI_ACCESS_TOKEN") != "" {
+		return nil
+	}
+	if hasStoredAccessToken(env["PULUMI_HOME"], pulumiCloudURL) {
+		return nil
+	}
+	return &accessTokenError{backend: backend, secret: secret}
+}
+
+// isPulumiCloudBackend reports whether the backend URL is that of Pulumi Cloud.
+func isPulumiCloudBackend(url string) bool {
+	url = strings.TrimSuffix(url, "/")
+	return url == pulumiCloudURL || url == "https://app.pulumi.com"
+}
+
+// hasStoredAccessToken reports whether there's a login for the backend URL given in the
+// credentials file in the Pulumi home directory given, as written by `pulumi login`.
+func hasStoredAccessToken(pulumiHome, backend string) bool {
+	if pulumiHome == "" {
+		return false
+	}
+	data, err := os.ReadFile(filepath.Join(pulumiHome, "credentials.json"))
+	if err != nil {
+		return false
+	}
+	var creds workspace.Credentials
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return false
+	}
+	return creds.AccessTokens[backend] != ""
+}
+
+// backendURL gives the URL of the backend Pulumi will use for the workspace: that given in the
+// environment, or failing that, in the project file. It's empty if neither gives one, in which
+// case Pulumi uses whichever backend it's logged in to.
+func backendURL(w auto.Workspace, projectBackend string) string {
+	if url, ok := w.GetEnvVars()["PULUMI_BACKEND_URL"]; ok {
+		return url
+	}
+	if url := os.Getenv("PULUMI_BACKEND_URL"); url != "" {
+		return url
+	}
+	return projectBackend
+}
+
+// isSelfManagedBackend reports whether the backend URL is for a self-managed backend (one kept in
+// the filesystem or a storage bucket), rather than Pulumi Cloud or another service.
+func isSelfManagedBackend(url string) bool {
+	for _, scheme := range []string{"file://", "s3://", "azblob://", "gs://"} {
+		if strings.HasPrefix(url, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeStackName checks that the stack name has a form the backend accepts, and gives it in
+// the form to use. Pulumi Cloud accepts `stack`, `org/stack` and `org/project/stack`; the
+// organization defaults to that of the access token's user. Self-managed backends have no
+// organizations, so accept only `stack`, although a fully qualified name for the project is
+// reduced to that. If the backend isn't known, the name is checked only for its shape.
+func normalizeStackName(name, project, backend string) (string, error) {
+	parts := strings.Split(name, "/")
+	for _, part := range parts {
+		if part == "" {
+			return "", &stackNameError{name: name, reason: "expected stack, org/stack or org/project/stack"}
+		}
+	}
+	if len(parts) > 3 {
+		return "", &stackNameError{name: name, reason: "expected stack, org/stack or org/project/stack"}
+	}
+	if len(parts) == 3 && project != "" && parts[1] != project {
+		return "", &stackNameError{name: name, reason: fmt.Sprintf("it names project %q, but the project is %q", parts[1], project)}
+	}
+	if !isSelfManagedBackend(backend) {
+		return name, nil
+	}
+	switch len(parts) {
+	case 2:
+		return "", &stackNameError{name: name, reason: fmt.Sprintf(
+			"the self-managed backend %s has no organizations, so give just the stack name", backend)}
+	case 3:
+		return parts[2], nil
+	}
+	return name, nil
+}
+
+// repoURLs gives the URLs to clone the project repository from, in the order to try them.
+func (sess *reconcileStackSession) repoURLs() []string {
+	return append([]string{sess.stack.ProjectRepo}, sess.stack.ProjectRepoMirrors...)
+}
+
+// upsertStack creates the stack in the backend, or selects it if it already exists, as
+// auto.UpsertStack does; and reports whether the stack was created.
+func upsertStack(ctx context.Context, stackName string, w auto.Workspace) (auto.Stack, bool, error) {
+	s, err := auto.NewStack(ctx, stackName, w)
+	if err == nil {
+		return s, true, nil
+	}
+	if !auto.IsCreateStack409Error(err) {
+		return s, false, err
+	}
+	s, err = auto.SelectStack(ctx, stackName, w)
+	return s, false, err
+}
+
+// firstSuccessful calls try with each of the URLs in turn until it succeeds, and returns the URL
+// for which it succeeded. If it fails for all of them, the errors are returned together.
+func firstSuccessful(urls []string, try func(url string) error) (string, error) {
+	var failures []string
+	for _, url := range urls {
+		err := try(url)
+		if err == nil {
+			return url, nil
+		}
+		failures = append(failures, fmt.Sprintf("%s: %s", url, err.Error()))
+	}
+	return "", errors.New(strings.Join(failures, "; "))
+}
+
+// emptyDir removes everything in the directory given.
+func emptyDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sess *reconcileStackSession) SetupPulumiWorkdir(ctx context.Context, gitAuth *auto.GitAuth) error {
+	sess.logger.Debug("Setting up pulumi workdir for stack", "stack", sess.stack)
+	// This is resolved after logging the spec above, since it may be sensitive.
+	if err := sess.resolveSecretsProvider(ctx); err != nil {
+		return err
+	}
+	// Create a new workspace.
+	secretsProvider := auto.SecretsProvider(sess.stack.SecretsProvider)
+
+	// Create the temporary workdir
+	dir, err := os.MkdirTemp("", "pulumi_auto")
+	if err != nil {
+		return errors.Wrap(err, "unable to create tmp directory for workspace")
+	}
+	sess.rootDir = dir
+
+	// Cleanup the rootdir on failure setting up the workspace.
+	defer func() {
+		if err != nil {
+			_ = os.RemoveAll(sess.rootDir)
+			if sess.homeDir != "" {
+				_ = os.RemoveAll(sess.homeDir)
+			}
+		}
+	}()
+
+	// Fail before fetching the source, rather than part way through with an obscure write error.
+	if err = checkDiskSpace(dir, minWorkspaceFreeBytes()); err != nil {
+		return err
+	}
+
+	if err = sess.makeHomeDir(); err != nil {
+		return err
+	}
+
+	var w auto.Workspace
+	if sess.stack.LocalPath != "" {
+		w, err = sess.localWorkspace(ctx, dir, secretsProvider)
+	} else {
+		w, err = sess.cloneWorkspace(ctx, dir, gitAuth, secretsProvider)
+	}
+	if err != nil {
+		return err
+	}
+
+	sess.workdir = w.WorkDir()
+	sess.pulumiVersion = w.PulumiVersion()
+	if err = sess.WriteProjectTemplate(ctx); err != nil {
+		return err
+	}
+	if err = checkProjectFile(sess.workdir, sess.stack.RepoDir); err != nil {
+		return err
+	}
+	var projectBackend string
+	if project, err := w.ProjectSettings(ctx); err == nil {
+		sess.project = string(project.Name)
+		if project.Backend != nil {
+			projectBackend = project.Backend.URL
+		}
+	}
+
+	if err = sess.setupWorkspaceEnv(ctx, w); err != nil {
+		return err
+	}
+
+	backend := backendURL(w, projectBackend)
+	sess.backend = backend
+	if sess.stack.Stack, err = normalizeStackName(sess.stack.Stack, sess.project, backend); err != nil {
+		return err
+	}
+	if err = sess.SetStackReferenceAccess(ctx, w, backend); err != nil {
+		return err
+	}
+	// Checked once every source of an access token has been applied to the workspace.
+	if err = checkAccessToken(w.GetEnvVars(), backend, sess.stack.AccessTokenSecret); err != nil {
+		return err
+	}
+
+	if err = sess.SetupKubeconfig(ctx, w); err != nil {
+		return err
+	}
+	if err = sess.SetupProviderCACerts(ctx, w); err != nil {
+		return err
+	}
+
+	if err = sess.WriteWorkspaceFiles(ctx); err != nil {
+		return err
+	}
+	if err = sess.giveWorkspaceToUser(); err != nil {
+		return err
+	}
+	if err = sess.PreprocessSource(ctx, w); err != nil {
+		return err
+	}
+	if err = sess.setRefreshDuringUpdate(ctx, w); err != nil {
+		return err
+	}
+
+	var a auto.Stack
+
+	if sess.stack.UseLocalStackOnly {
 		sess.logger.Info("Using local stack", "stack", sess.stack.Stack)
 		a, err = auto.SelectStack(ctx, sess.stack.Stack, w)
 	} else {
 		sess.logger.Info("Upserting stack", "stack", sess.stack.Stack, "workspace", w)
-		a, err = auto.UpsertStack(ctx, sess.stack.Stack, w)
+		a, sess.stackCreated, err = upsertStack(ctx, sess.stack.Stack, w)
 	}
 	if err != nil {
 		return errors.Wrapf(err, "failed to create and/or select stack: %s", sess.stack.Stack)
@@ -774,7 +2483,7 @@ func (sess *reconcileStackSession) SetupPulumiWorkdir(ctx context.Context, gitAu
 	if err != nil {
 		return err
 	}
-	sess.logger.Debug("Initial autostack config", "config", c)
+	sess.logger.Debug("Initial autostack config", "config", redactConfig(c))
 
 	// Ensure stack settings file in workspace is populated appropriately.
 	if err = sess.ensureStackSettings(ctx, w); err != nil {
@@ -788,6 +2497,10 @@ func (sess *reconcileStackSession) SetupPulumiWorkdir(ctx context.Context, gitAu
 		return errors.Wrap(err, "failed to set stack config")
 	}
 
+	if err = sess.InstallPlugins(ctx, sess.autoStack.Workspace()); err != nil {
+		return err
+	}
+
 	// Install project dependencies
 	if err = sess.InstallProjectDependencies(ctx, sess.autoStack.Workspace()); err != nil {
 		return errors.Wrap(err, "installing project dependencies")
@@ -796,12 +2509,326 @@ func (sess *reconcileStackSession) SetupPulumiWorkdir(ctx context.Context, gitAu
 	return nil
 }
 
+// SetupDestroyWorkdir sets up a workspace in which to destroy the stack, without the project
+// repository. Destroying a stack needs only its state, and the credentials for its backend and
+// providers, so a project file giving the project name, and the backend if known, is enough; the
+// program is not run.
+func (sess *reconcileStackSession) SetupDestroyWorkdir(ctx context.Context, project, backend string) (err error) {
+	if err = sess.resolveSecretsProvider(ctx); err != nil {
+		return err
+	}
+
+	dir, err := os.MkdirTemp("", "pulumi_auto")
+	if err != nil {
+		return errors.Wrap(err, "unable to create tmp directory for workspace")
+	}
+	sess.rootDir = dir
+	defer func() {
+		if err != nil {
+			sess.CleanupPulumiDir()
+		}
+	}()
+
+	if err = sess.makeHomeDir(); err != nil {
+		return err
+	}
+
+	proj := workspace.Project{
+		Name: tokens.PackageName(project),
+		// Any runtime will do, since the program is not run.
+		Runtime: workspace.NewProjectRuntimeInfo("nodejs", nil),
+	}
+	if backend != "" {
+		proj.Backend = &workspace.ProjectBackend{URL: backend}
+	}
+	w, err := auto.NewLocalWorkspace(ctx,
+		auto.WorkDir(dir),
+		auto.SecretsProvider(sess.stack.SecretsProvider),
+		auto.Project(proj))
+	if err != nil {
+		return errors.Wrap(err, "failed to create local workspace")
+	}
+	sess.workdir = w.WorkDir()
+	sess.project = project
+
+	if err = sess.setupWorkspaceEnv(ctx, w); err != nil {
+		return err
+	}
+	sess.backend = backendURL(w, backend)
+	if sess.stack.Stack, err = normalizeStackName(sess.stack.Stack, project, sess.backend); err != nil {
+		return err
+	}
+	if err = sess.SetupKubeconfig(ctx, w); err != nil {
+		return err
+	}
+	if err = sess.SetupProviderCACerts(ctx, w); err != nil {
+		return err
+	}
+
+	a, err := auto.SelectStack(ctx, sess.stack.Stack, w)
+	if err != nil {
+		return errors.Wrapf(err, "failed to select stack: %s", sess.stack.Stack)
+	}
+	sess.autoStack = &a
+	return nil
+}
+
+// projectForDestroy gives the name of the project to use to destroy a stack without its project
+// repository: from the stack name, if it's fully qualified, or as otherwise known.
+func projectForDestroy(stackName, recorded string) string {
+	if parts := strings.Split(stackName, "/"); len(parts) == 3 {
+		return parts[1]
+	}
+	return recorded
+}
+
+// makeHomeDir creates the HOME used when processing the stack. Stacks are processed
+// concurrently, so each gets its own HOME, rather than sharing (and perhaps altering) the
+// operator's.
+func (sess *reconcileStackSession) makeHomeDir() error {
+	home, err := os.MkdirTemp("", "pulumi_home")
+	if err != nil {
+		return errors.Wrap(err, "unable to create tmp directory for HOME")
+	}
+	sess.homeDir = home
+	if err = setupHomeDir(home, os.Getenv("HOME"), sharedPulumiHome()); err != nil {
+		return errors.Wrap(err, "setting up HOME")
+	}
+	return nil
+}
+
+// setupWorkspaceEnv sets the environment variables Pulumi needs in the workspace: the HOME for the
+// stack, whether the CLI checks for updates, those given in the stack specification (including
+// for the credentials endpoint), and the backend and credentials for it.
+func (sess *reconcileStackSession) setupWorkspaceEnv(ctx context.Context, w auto.Workspace) error {
+	w.SetEnvVar("HOME", sess.homeDir)
+	w.SetEnvVar("PULUMI_HOME", filepath.Join(sess.homeDir, ".pulumi"))
+	// The update check calls out to the Pulumi service, and warns of a newer version in the logs,
+	// neither of which help when the CLI comes with the operator image.
+	if !sess.stack.EnableCLIUpdateCheck {
+		w.SetEnvVar("PULUMI_SKIP_UPDATE_CHECK", "true")
+	}
+
+	// Inline environment variables go first, so that any other source of environment
+	// variables takes precedence.
+	for k, v := range sess.stack.Env {
+		w.SetEnvVar(k, v)
+	}
+	if err := sess.setCredentialsEndpoint(ctx, w); err != nil {
+		return err
+	}
+
+	// The operator-wide defaults for the backend and access token are used only if the stack
+	// doesn't give its own, whether in the fields for them, or in Env (or, for the access token,
+	// in EnvRefs, which are applied below and override it).
+	if sess.stack.Backend != "" {
+		w.SetEnvVar("PULUMI_BACKEND_URL", sess.stack.Backend)
+	} else if _, set := w.GetEnvVars()["PULUMI_BACKEND_URL"]; !set {
+		if backend := os.Getenv(defaultBackendEnv); backend != "" {
+			w.SetEnvVar("PULUMI_BACKEND_URL", backend)
+		}
+	}
+	if accessToken, found := sess.lookupPulumiAccessToken(ctx); found {
+		w.SetEnvVar("PULUMI_ACCESS_TOKEN", accessToken)
+	} else if _, set := w.GetEnvVars()["PULUMI_ACCESS_TOKEN"]; !set && sess.stack.AccessTokenSecret == "" {
+		if accessToken, found := sess.lookupDefaultAccessToken(ctx); found {
+			w.SetEnvVar("PULUMI_ACCESS_TOKEN", accessToken)
+		}
+	}
+
+	if err := sess.SetEnvRefsForWorkspace(ctx, w); err != nil {
+		return err
+	}
+
+	if err := sess.SetPassphrase(ctx, w); err != nil {
+		return err
+	}
+
+	return sess.SetVaultCredentials(ctx, w)
+}
+
+// resolveSecretsProvider resolves the reference to the secrets provider given in the stack
+// specification, if any, so that it's used in place of SecretsProvider. Failing both, it composes
+// the provider URL from SecretsProviderKey, if that's given.
+func (sess *reconcileStackSession) resolveSecretsProvider(ctx context.Context) error {
+	if sess.stack.SecretsProviderRef == nil {
+		if key := sess.stack.SecretsProviderKey; key != nil && sess.stack.SecretsProvider == "" {
+			secretsProvider, err := secretsProviderURL(key)
+			if err != nil {
+				return errors.Wrap(err, "composing secrets provider")
+			}
+			sess.stack.SecretsProvider = secretsProvider
+		}
+		return nil
+	}
+	if sess.stack.SecretsProvider != "" {
+		return errors.New("only one of secretsProvider and secretsProviderRef may be given")
+	}
+	secretsProvider, err := sess.resolveResourceRef(ctx, sess.stack.SecretsProviderRef)
+	if err != nil {
+		return errors.Wrap(err, "resolving secrets provider")
+	}
+	sess.stack.SecretsProvider = secretsProvider
+	return nil
+}
+
+// secretsProviderURL composes the URL for a cloud secrets provider from the parts of its key,
+// checking that those needed by the provider are given.
+func secretsProviderURL(key *shared.SecretsProviderKey) (string, error) {
+	if key.KeyID == "" {
+		return "", errors.Errorf("keyId is required for %s", key.Type)
+	}
+	query := url.Values{}
+	for k, v := range key.Params {
+		query.Set(k, v)
+	}
+	var u string
+	switch key.Type {
+	case shared.SecretsProviderAWSKMS:
+		if key.Region == "" {
+			return "", errors.New("region is required for awskms")
+		}
+		query.Set("region", key.Region)
+		// An ARN has a colon after the scheme, so it goes in the path rather than the host.
+		if strings.HasPrefix(key.KeyID, "arn:") {
+			u = "awskms:///" + key.KeyID
+		} else {
+			u = "awskms://" + key.KeyID
+		}
+	case shared.SecretsProviderAzureKeyVault, shared.SecretsProviderGCPKMS, shared.SecretsProviderHashiVault:
+		if key.Region != "" {
+			return "", errors.Errorf("region is not used for %s; give it in keyId", key.Type)
+		}
+		u = string(key.Type) + "://" + key.KeyID
+	default:
+		return "", errors.Errorf("unknown secrets provider type %q", key.Type)
+	}
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u, nil
+}
+
+// SetPassphrase gives the workspace the passphrase for the passphrase secrets provider, if the
+// stack specification refers to one. Otherwise, if the stack uses the passphrase secrets provider,
+// it checks that the passphrase is in the environment, since Pulumi would fail less clearly without
+// it.
+func (sess *reconcileStackSession) SetPassphrase(ctx context.Context, w auto.Workspace) error {
+	if ref := sess.stack.PassphraseRef; ref != nil {
+		if ref.SelectorType == shared.ResourceSelectorFS && ref.FileSystem != nil {
+			w.SetEnvVar("PULUMI_CONFIG_PASSPHRASE_FILE", ref.FileSystem.Path)
+			return nil
+		}
+		passphrase, err := sess.resolveResourceRef(ctx, ref)
+		if err != nil {
+			return errors.Wrap(err, "resolving passphrase")
+		}
+		w.SetEnvVar("PULUMI_CONFIG_PASSPHRASE", passphrase)
+		return nil
+	}
+
+	// The checked-in stack settings may say the passphrase provider is used; if there are none,
+	// only the spec says which provider is used.
+	stackConfig, err := w.StackSettings(ctx, sess.stack.Stack)
+	if err != nil {
+		stackConfig = &workspace.ProjectStack{}
+	}
+	if !usesPassphrase(sess.stack.SecretsProvider, stackConfig) {
+		return nil
+	}
+	if envVarSet(w, "PULUMI_CONFIG_PASSPHRASE") || envVarSet(w, "PULUMI_CONFIG_PASSPHRASE_FILE") {
+		return nil
+	}
+	return errors.New("stack uses the passphrase secrets provider, but no passphrase is given; " +
+		"give it with passphraseRef")
+}
+
+// envVarSet reports whether an environment variable is set for Pulumi, either in the workspace or
+// in the operator's own environment.
+func envVarSet(w auto.Workspace, name string) bool {
+	if _, ok := w.GetEnvVars()[name]; ok {
+		return true
+	}
+	_, ok := os.LookupEnv(name)
+	return ok
+}
+
+// SetVaultCredentials gives the workspace the address of and token for HashiCorp Vault, if the
+// stack specification refers to them. If the stack uses the Vault transit secrets provider, it
+// checks that both are in the environment, since Pulumi would otherwise fail (or prompt) less
+// clearly.
+func (sess *reconcileStackSession) SetVaultCredentials(ctx context.Context, w auto.Workspace) error {
+	for name, ref := range map[string]*shared.ResourceRef{
+		"VAULT_ADDR":  sess.stack.VaultAddressRef,
+		"VAULT_TOKEN": sess.stack.VaultTokenRef,
+	} {
+		if ref == nil {
+			continue
+		}
+		val, err := sess.resolveResourceRef(ctx, ref)
+		if err != nil {
+			return errors.Wrapf(err, "resolving %s", name)
+		}
+		w.SetEnvVar(name, val)
+	}
+
+	stackConfig, err := w.StackSettings(ctx, sess.stack.Stack)
+	if err != nil {
+		stackConfig = &workspace.ProjectStack{}
+	}
+	if !usesVault(sess.stack.SecretsProvider, stackConfig) {
+		return nil
+	}
+	var missing []string
+	if !envVarSet(w, "VAULT_ADDR") {
+		missing = append(missing, "VAULT_ADDR (give it with vaultAddressRef)")
+	}
+	if !envVarSet(w, "VAULT_TOKEN") {
+		missing = append(missing, "VAULT_TOKEN (give it with vaultTokenRef)")
+	}
+	if len(missing) > 0 {
+		return errors.Errorf("stack uses the Vault transit secrets provider, but is missing %s",
+			strings.Join(missing, " and "))
+	}
+	return nil
+}
+
+// usesVault reports whether a stack uses the HashiCorp Vault transit secrets provider, according to
+// the secrets provider given in its specification, or failing that, its checked-in stack settings.
+func usesVault(secretsProvider string, stackConfig *workspace.ProjectStack) bool {
+	if secretsProvider == "" {
+		secretsProvider = stackConfig.SecretsProvider
+	}
+	return strings.HasPrefix(secretsProvider, "hashivault://")
+}
+
+// usesPassphrase reports whether a stack uses the passphrase secrets provider, according to the
+// secrets provider given in its specification, or failing that, its checked-in stack settings.
+// Stack settings with an encryption salt and no secrets provider are for the passphrase provider.
+func usesPassphrase(secretsProvider string, stackConfig *workspace.ProjectStack) bool {
+	if secretsProvider != "" {
+		return secretsProvider == "passphrase"
+	}
+	switch stackConfig.SecretsProvider {
+	case "passphrase":
+		return true
+	case "":
+		return stackConfig.EncryptionSalt != ""
+	}
+	return false
+}
+
 func (sess *reconcileStackSession) ensureStackSettings(ctx context.Context, w auto.Workspace) error {
 	// We may have a project stack file already checked-in. Try and read that first
 	// since we don't want to clobber it unnecessarily.
 	// If not found, stackConfig will be a pointer to a zeroed-out workspace.ProjectStack.
-	stackConfig, err := w.StackSettings(ctx, sess.stack.Stack)
-	if err != nil {
+	var stackConfig *workspace.ProjectStack
+	var err error
+	if sess.stack.ConfigFile != "" {
+		if stackConfig, err = loadConfigFile(sess.rootDir, sess.stack.ConfigFile); err != nil {
+			return err
+		}
+	} else if stackConfig, err = w.StackSettings(ctx, sess.stack.Stack); err != nil {
 		sess.logger.Info("Missing stack config file. Will assume no stack config checked-in.", "Cause", err)
 		stackConfig = &workspace.ProjectStack{}
 	}
@@ -816,18 +2843,125 @@ func (sess *reconcileStackSession) ensureStackSettings(ctx context.Context, w au
 		// https://github.com/pulumi/pulumi-kubernetes-operator/issues/135
 		stackConfig.SecretsProvider = sess.stack.SecretsProvider
 	}
+	applyConfigMergeMode(stackConfig, sess.stack.ConfigMergeMode)
 	if err := w.SaveStackSettings(ctx, sess.stack.Stack, stackConfig); err != nil {
 		return errors.Wrap(err, "failed to save stack settings.")
 	}
 	return nil
 }
 
+// configFileError is returned when the file of stack settings given by ConfigFile is missing or
+// invalid.
+type configFileError struct {
+	path string
+	err  error
+}
+
+func (e *configFileError) Error() string {
+	return fmt.Sprintf("config file %q: %s", e.path, e.err.Error())
+}
+
+func (e *configFileError) Unwrap() error {
+	return e.err
+}
+
+// loadConfigFile loads the stack settings in the file at the path given, relative to the root of
+// the source repository at repoRoot, which it must be within.
+func loadConfigFile(repoRoot, path string) (*workspace.ProjectStack, error) {
+	file, err := workspaceFilePath(repoRoot, path)
+	if err == nil {
+		err = checkWithinDir(repoRoot, file)
+	}
+	if err != nil {
+		return nil, &configFileError{path: path, err: err}
+	}
+	stackConfig, err := workspace.LoadProjectStack(file)
+	if err != nil {
+		return nil, &configFileError{path: path, err: err}
+	}
+	return stackConfig, nil
+}
+
+// applyConfigMergeMode prepares checked-in stack settings for configuration from the spec to be
+// applied on top, according to the merge mode. Only the configuration values are affected; in
+// particular, the secrets provider and encryption salt are kept, since they are needed to use
+// secrets whether or not they come from the checked-in file.
+func applyConfigMergeMode(stackConfig *workspace.ProjectStack, mode shared.ConfigMergeMode) {
+	if mode == shared.ConfigMergeModeReplace {
+		stackConfig.Config = nil
+	}
+}
+
 func (sess *reconcileStackSession) CleanupPulumiDir() {
 	if sess.rootDir != "" {
 		if err := os.RemoveAll(sess.rootDir); err != nil {
 			sess.logger.Error(err, "Failed to delete temporary root dir: %s", sess.rootDir)
 		}
 	}
+	if sess.homeDir != "" {
+		if err := os.RemoveAll(sess.homeDir); err != nil {
+			sess.logger.Error(err, "Failed to delete temporary home dir: %s", sess.homeDir)
+		}
+	}
+}
+
+// sharedPulumiHome gives the operator's Pulumi home directory.
+func sharedPulumiHome() string {
+	if home := os.Getenv("PULUMI_HOME"); home != "" {
+		return home
+	}
+	return filepath.Join(os.Getenv("HOME"), ".pulumi")
+}
+
+// setupHomeDir prepares a HOME directory for processing a stack, from the operator's HOME. The
+// kubeconfig and SSH known hosts are copied, so that changes to them don't affect other stacks.
+// Pulumi plugins and package manager caches are shared, since they are costly to download and are
+// safe to use concurrently.
+func setupHomeDir(home, sharedHome, sharedPulumiHome string) error {
+	for _, file := range []string{".kube/config", ".ssh/known_hosts"} {
+		if err := copyFileIfExists(filepath.Join(sharedHome, file), filepath.Join(home, file)); err != nil {
+			return err
+		}
+	}
+	if err := copyFileIfExists(filepath.Join(sharedPulumiHome, "credentials.json"),
+		filepath.Join(home, ".pulumi", "credentials.json")); err != nil {
+		return err
+	}
+	links := map[string]string{
+		filepath.Join(sharedPulumiHome, "plugins"): filepath.Join(home, ".pulumi", "plugins"),
+	}
+	// npm's cache; pip's, yarn's and Go's build caches; and Go's module cache.
+	for _, cache := range []string{".npm", ".cache", "go"} {
+		links[filepath.Join(sharedHome, cache)] = filepath.Join(home, cache)
+	}
+	for target, link := range links {
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(link), 0700); err != nil {
+			return err
+		}
+		if err := os.Symlink(target, link); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFileIfExists copies the file at src to dest, creating dest's directory as needed. It does
+// nothing if there's no file at src.
+func copyFileIfExists(src, dest string) error {
+	data, err := os.ReadFile(src)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(dest, data, 0600)
 }
 
 // Determine the actual commit information from the working directory (Spec commit etc. is optional).
@@ -843,6 +2977,59 @@ func revisionAtWorkingDir(workingDir string) (string, error) {
 	return headRef.Hash().String(), nil
 }
 
+// changedPathsMatch reports whether any file changed between the commits from and to, in the git
+// repository at workingDir, matches one of the patterns given.
+func changedPathsMatch(workingDir, from, to string, patterns []string) (bool, error) {
+	gitRepo, err := git.PlainOpenWithOptions(workingDir, &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to resolve git repository from working directory: %s", workingDir)
+	}
+	var trees [2]*object.Tree
+	for i, rev := range []string{from, to} {
+		commit, err := gitRepo.CommitObject(plumbing.NewHash(rev))
+		if err != nil {
+			return false, errors.Wrapf(err, "resolving commit %s", rev)
+		}
+		if trees[i], err = commit.Tree(); err != nil {
+			return false, errors.Wrapf(err, "resolving tree of commit %s", rev)
+		}
+	}
+	changes, err := object.DiffTree(trees[0], trees[1])
+	if err != nil {
+		return false, errors.Wrapf(err, "comparing commits %s and %s", from, to)
+	}
+	for _, change := range changes {
+		// A file that was moved counts as changed both where it was and where it is.
+		for _, name := range []string{change.From.Name, change.To.Name} {
+			if name == "" {
+				continue
+			}
+			matched, err := pathMatches(patterns, name)
+			if err != nil || matched {
+				return matched, err
+			}
+		}
+	}
+	return false, nil
+}
+
+// pathMatches reports whether the slash-separated path p, or any directory containing it, matches
+// one of the patterns given.
+func pathMatches(patterns []string, p string) (bool, error) {
+	for ; p != "." && p != "/"; p = path.Dir(p) {
+		for _, pattern := range patterns {
+			matched, err := path.Match(strings.TrimSuffix(pattern, "/"), p)
+			if err != nil {
+				return false, errors.Wrapf(err, "invalid path pattern %q", pattern)
+			}
+			if matched {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
 func (sess *reconcileStackSession) InstallProjectDependencies(ctx context.Context, workspace auto.Workspace) error {
 	project, err := workspace.ProjectSettings(ctx)
 	if err != nil {
@@ -860,7 +3047,7 @@ func (sess *reconcileStackSession) InstallProjectDependencies(ctx context.Contex
 		}
 		// TODO: Consider using `npm ci` instead if there is a `package-lock.json` or `npm-shrinkwrap.json` present
 		cmd := exec.Command(npm, "install")
-		_, _, err := sess.runCmd("NPM/Yarn", cmd, workspace)
+		_, _, err := sess.runStackCmd("NPM/Yarn", cmd, workspace)
 		return err
 	case "python":
 		python3, _ := exec.LookPath("python3")
@@ -882,18 +3069,18 @@ func (sess *reconcileStackSession) InstallProjectDependencies(ctx context.Contex
 		// Emulate the same steps as the CLI does in https://github.com/pulumi/pulumi/blob/master/sdk/python/python.go#L97-L99.
 		// TODO[pulumi/pulumi#5164]: Ideally the CLI would automatically do these - since it already knows how.
 		cmd := exec.Command(python3, "-m", "venv", venv)
-		_, _, err := sess.runCmd("Pip Install", cmd, workspace)
+		_, _, err := sess.runStackCmd("Pip Install", cmd, workspace)
 		if err != nil {
 			return err
 		}
 		venvPython := filepath.Join(venv, "bin", "python")
 		cmd = exec.Command(venvPython, "-m", "pip", "install", "--upgrade", "pip", "setuptools", "wheel")
-		_, _, err = sess.runCmd("Pip Install", cmd, workspace)
+		_, _, err = sess.runStackCmd("Pip Install", cmd, workspace)
 		if err != nil {
 			return err
 		}
 		cmd = exec.Command(venvPython, "-m", "pip", "install", "-r", "requirements.txt")
-		_, _, err = sess.runCmd("Pip Install", cmd, workspace)
+		_, _, err = sess.runStackCmd("Pip Install", cmd, workspace)
 		if err != nil {
 			return err
 		}
@@ -910,10 +3097,56 @@ func (sess *reconcileStackSession) InstallProjectDependencies(ctx context.Contex
 }
 
 func (sess *reconcileStackSession) UpdateConfig(ctx context.Context) error {
-	m := make(auto.ConfigMap)
-	for k, v := range sess.stack.Config {
+	m, err := sess.specConfig(ctx)
+	if err != nil {
+		return err
+	}
+	if err := sess.autoStack.SetAllConfig(ctx, m); err != nil {
+		return err
+	}
+	sess.logger.Debug("Updated stack config", "Stack.Name", sess.stack.Stack, "config", redactConfig(m))
+	return nil
+}
+
+// specConfig gives the configuration given in the stack spec, with any references resolved. Values
+// are applied in the order documented for ConfigMergeMode, later ones taking precedence.
+func (sess *reconcileStackSession) specConfig(ctx context.Context) (auto.ConfigMap, error) {
+	m, err := providerDefaultsConfig(sess.stack.ProviderDefaults)
+	if err != nil {
+		return nil, err
+	}
+	resourceDefaults, err := resourceDefaultsConfig(sess.stack.ResourceDefaults)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range resourceDefaults {
+		m[k] = v
+	}
+	if sess.stack.KubeContext != "" {
+		m["kubernetes:context"] = auto.ConfigValue{
+			Value:  sess.stack.KubeContext,
+			Secret: false,
+		}
+	}
+	config := sess.stack.Config
+	if sess.stack.ConfigInterpolation {
+		if config, err = interpolateConfig(config, sess.stack.Env); err != nil {
+			return nil, err
+		}
+	}
+	for k, v := range config {
+		m[k] = auto.ConfigValue{
+			Value:  v,
+			Secret: false,
+		}
+	}
+	for k, ref := range sess.stack.ConfigRefs {
+		resolved, err := sess.resolveResourceRef(ctx, &ref)
+		if err != nil {
+			return nil, errors.Wrapf(err, "updating configRef for: %q", k)
+		}
 		m[k] = auto.ConfigValue{
-			Value:  v,
+			Value:  resolved,
 			Secret: false,
 		}
 	}
@@ -927,27 +3160,139 @@ func (sess *reconcileStackSession) UpdateConfig(ctx context.Context) error {
 	for k, ref := range sess.stack.SecretRefs {
 		resolved, err := sess.resolveResourceRef(ctx, &ref)
 		if err != nil {
-			return errors.Wrapf(err, "updating secretRef for: %q", k)
+			return nil, errors.Wrapf(err, "updating secretRef for: %q", k)
 		}
 		m[k] = auto.ConfigValue{
 			Value:  resolved,
 			Secret: true,
 		}
 	}
-	if err := sess.autoStack.SetAllConfig(ctx, m); err != nil {
-		return err
+	return m, nil
+}
+
+// redactedConfigValue stands in for the value of secret configuration in logs.
+const redactedConfigValue = "[secret]"
+
+// redactConfig gives the configuration values to log, with secret values, e.g., those from
+// SecretRefs, replaced so they don't appear in the operator's logs.
+func redactConfig(m auto.ConfigMap) map[string]string {
+	redacted := make(map[string]string, len(m))
+	for k, v := range m {
+		if v.Secret {
+			redacted[k] = redactedConfigValue
+		} else {
+			redacted[k] = v.Value
+		}
 	}
-	sess.logger.Debug("Updated stack config", "Stack.Name", sess.stack.Stack, "config", m)
-	return nil
+	return redacted
+}
+
+// providerDefaultsConfig converts the provider defaults given in the stack spec into
+// provider-namespaced configuration, e.g., {"aws": {"region": "us-west-2"}} becomes
+// "aws:region" = "us-west-2".
+func providerDefaultsConfig(defaults map[string]map[string]string) (auto.ConfigMap, error) {
+	m := make(auto.ConfigMap)
+	for provider, settings := range defaults {
+		if provider == "" || strings.Contains(provider, ":") {
+			return nil, errors.Errorf("invalid provider namespace %q in providerDefaults", provider)
+		}
+		for k, v := range settings {
+			if k == "" || strings.Contains(k, ":") {
+				return nil, errors.Errorf("invalid key %q for provider %q in providerDefaults", k, provider)
+			}
+			m[provider+":"+k] = auto.ConfigValue{
+				Value:  v,
+				Secret: false,
+			}
+		}
+	}
+	return m, nil
+}
+
+// resourceDefaultsConfig converts the resource defaults given in the stack spec into configuration.
+// Only "pulumi:disable-default-providers" is acted on by the engine; the keys in the
+// "resourceDefaults" namespace are for the program to read.
+func resourceDefaultsConfig(defaults *shared.ResourceDefaults) (auto.ConfigMap, error) {
+	m := make(auto.ConfigMap)
+	if defaults == nil {
+		return m, nil
+	}
+	if len(defaults.DisableDefaultProviders) > 0 {
+		for _, pkg := range defaults.DisableDefaultProviders {
+			if pkg == "" {
+				return nil, errors.New("empty package name in resourceDefaults.disableDefaultProviders")
+			}
+		}
+		disabled, err := json.Marshal(defaults.DisableDefaultProviders)
+		if err != nil {
+			return nil, err
+		}
+		m["pulumi:disable-default-providers"] = auto.ConfigValue{Value: string(disabled)}
+	}
+	if defaults.Protect {
+		m["resourceDefaults:protect"] = auto.ConfigValue{Value: "true"}
+	}
+	if defaults.RetainOnDelete {
+		m["resourceDefaults:retainOnDelete"] = auto.ConfigValue{Value: "true"}
+	}
+	if len(defaults.AdditionalTags) > 0 {
+		tags, err := json.Marshal(defaults.AdditionalTags)
+		if err != nil {
+			return nil, err
+		}
+		m["resourceDefaults:additionalTags"] = auto.ConfigValue{Value: string(tags)}
+	}
+	return m, nil
+}
+
+// setRefreshDuringUpdate has the stack refreshed as part of each update, as with `pulumi up
+// --refresh`, if the spec asks for it. The automation API used here has no option for that, so it's
+// done with the project option `refresh: always`, which the CLI reads for each operation.
+func (sess *reconcileStackSession) setRefreshDuringUpdate(ctx context.Context, w auto.Workspace) error {
+	if !sess.stack.RefreshDuringUpdate {
+		return nil
+	}
+	project, err := w.ProjectSettings(ctx)
+	if err != nil {
+		return errors.Wrap(err, "reading project settings to refresh during updates")
+	}
+	if project.Options == nil {
+		project.Options = &workspace.ProjectOptions{}
+	}
+	project.Options.Refresh = "always"
+	return errors.Wrap(w.SaveProjectSettings(ctx, project), "saving project settings to refresh during updates")
 }
 
 func (sess *reconcileStackSession) RefreshStack(ctx context.Context, expectNoChanges bool) (shared.Permalink, error) {
 	writer := sess.logger.LogWriterDebug("Pulumi Refresh")
 	defer contract.IgnoreClose(writer)
-	opts := []optrefresh.Option{optrefresh.ProgressStreams(writer), optrefresh.UserAgent(execAgent)}
-	if expectNoChanges {
+	opts := append([]optrefresh.Option{optrefresh.ProgressStreams(writer), optrefresh.UserAgent(sess.userAgent())},
+		sess.refreshLogOptions()...)
+
+	// If some changes are to be tolerated, the refresh can't be asked to fail on any change, so
+	// the changes are collected from its events and checked afterwards.
+	var steps []apitype.StepEventMetadata
+	var eventsDone chan struct{}
+	switch {
+	case expectNoChanges && len(sess.stack.RefreshIgnore) > 0:
+		// The event stream is closed by the automation API once the refresh is run.
+		engineEvents := make(chan events.EngineEvent)
+		eventsDone = make(chan struct{})
+		go func() {
+			for event := range engineEvents {
+				if event.ResOutputsEvent != nil {
+					steps = append(steps, event.ResOutputsEvent.Metadata)
+				}
+			}
+			close(eventsDone)
+		}()
+		opts = append(opts, optrefresh.EventStreams(engineEvents))
+	case expectNoChanges:
 		opts = append(opts, optrefresh.ExpectNoChanges())
 	}
+	if len(sess.stack.RefreshTargets) > 0 {
+		opts = append(opts, optrefresh.Target(sess.stack.RefreshTargets))
+	}
 	result, err := sess.autoStack.Refresh(ctx, opts...)
 	if err != nil {
 		return "", errors.Wrapf(err, "refreshing stack %q", sess.stack.Stack)
@@ -958,18 +3303,113 @@ func (sess *reconcileStackSession) RefreshStack(ctx context.Context, expectNoCha
 		sess.logger.Error(err, "No permalink found.", "Namespace", sess.namespace)
 	}
 	permalink := shared.Permalink(p)
+
+	if eventsDone != nil {
+		<-eventsDone
+		if urns := refreshChanges(steps, sess.stack.RefreshIgnore); len(urns) > 0 {
+			return permalink, errors.Errorf("refreshing stack %q found changes to %d resources not ignored: %s",
+				sess.stack.Stack, len(urns), summarizeURNs(urns, 10))
+		}
+	}
 	return permalink, nil
 }
 
+// refreshChanges gives the sorted URNs of the resources changed by the refresh steps given, other
+// than those matching any of the ignore patterns.
+func refreshChanges(steps []apitype.StepEventMetadata, ignore []string) []string {
+	var urns []string
+	for _, step := range steps {
+		switch step.Op {
+		case apitype.OpSame, apitype.OpRead:
+			continue
+		case apitype.OpRefresh:
+			// A refresh step is reported whether or not the resource has drifted.
+			if step.Old != nil && step.New != nil && len(step.Diffs) == 0 &&
+				reflect.DeepEqual(step.Old.Outputs, step.New.Outputs) {
+				continue
+			}
+		}
+		if matchesAnyPattern(ignore, step.Type) || matchesAnyPattern(ignore, step.URN) {
+			continue
+		}
+		urns = append(urns, step.URN)
+	}
+	sort.Strings(urns)
+	return urns
+}
+
+// matchesAnyPattern reports whether s matches any of the patterns given, in which `*` matches any
+// sequence of characters.
+func matchesAnyPattern(patterns []string, s string) bool {
+	for _, pattern := range patterns {
+		parts := strings.Split(pattern, "*")
+		for i := range parts {
+			parts[i] = regexp.QuoteMeta(parts[i])
+		}
+		if regexp.MustCompile("^" + strings.Join(parts, ".*") + "$").MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// invalidURN checks the URNs given, and returns the first that isn't valid and false, or true if
+// they are all valid.
+func invalidURN(urns []string) (string, bool) {
+	for _, urn := range urns {
+		if !resource.URN(urn).IsValid() {
+			return urn, false
+		}
+	}
+	return "", true
+}
+
+// validateGitCommitter checks that a git identity, if given, has a name and a plain email address,
+// as git needs for a commit.
+func validateGitCommitter(committer *shared.GitCommitter) error {
+	if committer == nil {
+		return nil
+	}
+	if strings.TrimSpace(committer.Name) == "" {
+		return errors.New("name must be given")
+	}
+	if strings.ContainsAny(committer.Name, "<>\n") {
+		return errors.Errorf("name %q must not contain '<', '>' or a newline", committer.Name)
+	}
+	addr, err := mail.ParseAddress(committer.Email)
+	if err != nil || addr.Address != committer.Email {
+		return errors.Errorf("email %q is not a plain email address", committer.Email)
+	}
+	return nil
+}
+
+// CancelUpdate cancels the update in progress on the stack, which releases the stack's lock.
+func (sess *reconcileStackSession) CancelUpdate(ctx context.Context) error {
+	return sess.autoStack.Cancel(ctx)
+}
+
 // UpdateStack runs the update on the stack and returns an update status code
 // and error. In certain cases, an update may be unabled to proceed due to locking,
-// in which case the operator will requeue itself to retry later.
-func (sess *reconcileStackSession) UpdateStack(ctx context.Context) (shared.StackUpdateStatus, shared.Permalink, *auto.UpResult, error) {
+// in which case the operator will requeue itself to retry later. The update's engine events are
+// also sent to any extra streams given.
+func (sess *reconcileStackSession) UpdateStack(ctx context.Context, reportProgress func(int64), extraStreams ...chan<- events.EngineEvent) (shared.StackUpdateStatus, shared.Permalink, *auto.UpResult, error) {
 	writer := sess.logger.LogWriterDebug("Pulumi Update")
 	defer contract.IgnoreClose(writer)
 
-	result, err := sess.autoStack.Up(ctx, optup.ProgressStreams(writer), optup.UserAgent(execAgent))
+	engineEvents, stopProgress := trackUpdateProgress(sess.expectedOperations(ctx), progressReportInterval, reportProgress)
+	updateCtx, timedOut, stopLimit := sess.updateContext(ctx)
+	result, err := sess.autoStack.Up(updateCtx, append([]optup.Option{
+		optup.ProgressStreams(writer),
+		optup.UserAgent(sess.userAgent()),
+		optup.EventStreams(append([]chan<- events.EngineEvent{engineEvents}, extraStreams...)...)},
+		sess.upLogOptions()...)...)
+	stopProgress()
+	stopLimit()
 	if err != nil {
+		if timedOut() {
+			return shared.StackUpdateTimedOut, shared.Permalink(""), nil, errors.Wrapf(err,
+				"update cancelled after running for longer than %ds", sess.stack.MaxUpdateDurationSeconds)
+		}
 		// If this is the "conflict" error message, we will want to gracefully quit and retry.
 		if auto.IsConcurrentUpdateError(err) {
 			return shared.StackUpdateConflict, shared.Permalink(""), nil, err
@@ -978,6 +3418,10 @@ func (sess *reconcileStackSession) UpdateStack(ctx context.Context) (shared.Stac
 		if strings.Contains(result.StdErr, "error: [404] Not found") {
 			return shared.StackNotFound, shared.Permalink(""), nil, err
 		}
+		// If the backend couldn't be reached, the update may well succeed once it's back.
+		if isBackendUnavailableError(err, result.StdErr, sess.backend) {
+			return shared.StackBackendUnavailable, shared.Permalink(""), nil, err
+		}
 		return shared.StackUpdateFailed, shared.Permalink(""), nil, err
 	}
 	p, err := auto.GetPermalink(result.StdOut)
@@ -989,12 +3433,198 @@ func (sess *reconcileStackSession) UpdateStack(ctx context.Context) (shared.Stac
 	return shared.StackUpdateSucceeded, permalink, &result, nil
 }
 
+// PreviewDeletions previews an update of the stack, and gives the URNs of the resources it would
+// delete or replace, along with the number of resources already in the stack.
+func (sess *reconcileStackSession) PreviewDeletions(ctx context.Context) ([]string, int, error) {
+	writer := sess.logger.LogWriterDebug("Pulumi Preview")
+	defer contract.IgnoreClose(writer)
+
+	// The event stream is closed by the automation API once the preview is run.
+	engineEvents := make(chan events.EngineEvent)
+	var steps []apitype.StepEventMetadata
+	eventsDone := make(chan struct{})
+	go func() {
+		for event := range engineEvents {
+			if event.ResourcePreEvent != nil {
+				steps = append(steps, event.ResourcePreEvent.Metadata)
+			}
+		}
+		close(eventsDone)
+	}()
+
+	_, err := sess.autoStack.Preview(ctx, append([]optpreview.Option{
+		optpreview.ProgressStreams(writer),
+		optpreview.UserAgent(sess.userAgent()),
+		optpreview.EventStreams(engineEvents)},
+		sess.previewLogOptions()...)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	<-eventsDone
+	deletions, existing := plannedDeletions(steps)
+	return deletions, existing, nil
+}
+
+// PreviewChanges previews an update of the stack, and reports whether it would change any
+// resources.
+func (sess *reconcileStackSession) PreviewChanges(ctx context.Context) (bool, error) {
+	writer := sess.logger.LogWriterDebug("Pulumi Preview")
+	defer contract.IgnoreClose(writer)
+
+	result, err := sess.autoStack.Preview(ctx, append([]optpreview.Option{
+		optpreview.ProgressStreams(writer), optpreview.UserAgent(sess.userAgent())},
+		sess.previewLogOptions()...)...)
+	if err != nil {
+		return false, err
+	}
+	return previewHasChanges(result.ChangeSummary), nil
+}
+
+// previewHasChanges reports whether the summary of a preview has any changes to resources; that
+// is, anything other than leaving resources as they are, or reading them.
+func previewHasChanges(summary map[apitype.OpType]int) bool {
+	for op, count := range summary {
+		if op != apitype.OpSame && op != apitype.OpRead && count > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// plannedDeletions gives the sorted URNs of the resources deleted or replaced by the given steps,
+// and the number of resources the steps act on that already exist.
+func plannedDeletions(steps []apitype.StepEventMetadata) ([]string, int) {
+	deleted := map[string]struct{}{}
+	existing := map[string]struct{}{}
+	for _, step := range steps {
+		switch step.Op {
+		case apitype.OpCreate:
+			continue
+		case apitype.OpDelete, apitype.OpReplace, apitype.OpCreateReplacement, apitype.OpDeleteReplaced:
+			deleted[step.URN] = struct{}{}
+		}
+		existing[step.URN] = struct{}{}
+	}
+	urns := make([]string, 0, len(deleted))
+	for urn := range deleted {
+		urns = append(urns, urn)
+	}
+	sort.Strings(urns)
+	return urns, len(existing)
+}
+
+// deletionGuardTripped reports whether deleting the given number of resources, out of those
+// existing, exceeds the limits of the deletion guard.
+func deletionGuardTripped(guard *shared.DeletionGuard, deletions, existing int) bool {
+	if guard.MaxDeletes == nil && guard.MaxDeletePercent == nil {
+		return deletions > 0
+	}
+	if guard.MaxDeletes != nil && int64(deletions) > *guard.MaxDeletes {
+		return true
+	}
+	if guard.MaxDeletePercent != nil && existing > 0 &&
+		int64(deletions)*100 > *guard.MaxDeletePercent*int64(existing) {
+		return true
+	}
+	return false
+}
+
+// summarizeURNs lists the given URNs, up to a limit, for inclusion in a message.
+func summarizeURNs(urns []string, limit int) string {
+	if len(urns) <= limit {
+		return strings.Join(urns, ", ")
+	}
+	return fmt.Sprintf("%s, and %d more", strings.Join(urns[:limit], ", "), len(urns)-limit)
+}
+
+// resourcesChanged reports whether the update summarised changed any resources; that is, whether
+// it did anything other than leave resources as they were, or read them.
+func resourcesChanged(summary auto.UpdateSummary) bool {
+	if summary.ResourceChanges == nil {
+		return false
+	}
+	for op, count := range *summary.ResourceChanges {
+		if op != string(apitype.OpSame) && op != string(apitype.OpRead) && count > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// resourcesDeleted gives the number of resources deleted by the update summarised.
+func resourcesDeleted(summary auto.UpdateSummary) int64 {
+	if summary.ResourceChanges == nil {
+		return 0
+	}
+	return int64((*summary.ResourceChanges)[string(apitype.OpDelete)])
+}
+
+// backendUnavailableMessages are fragments of the errors reported by the Pulumi CLI when it cannot
+// connect to the backend.
+var backendUnavailableMessages = []string{
+	"connection refused",
+	"connection reset by peer",
+	"no such host",
+	"i/o timeout",
+	"TLS handshake timeout",
+	"Client.Timeout exceeded",
+	"[502] Bad Gateway",
+	"[503] Service Unavailable",
+	"[504] Gateway Timeout",
+}
+
+// isBackendUnavailableError reports whether the error (or the stderr output accompanying it)
+// indicates that the Pulumi backend with the URL given could not be reached. Only a line naming
+// the backend's host counts, since a provider, package manager or git host being unreachable
+// gives the same errors.
+func isBackendUnavailableError(err error, stderr, backend string) bool {
+	if err == nil {
+		return false
+	}
+	host := backendHost(backend)
+	if host == "" {
+		return false
+	}
+	for _, line := range strings.Split(err.Error()+"\n"+stderr, "\n") {
+		if !strings.Contains(line, host) {
+			continue
+		}
+		for _, msg := range backendUnavailableMessages {
+			if strings.Contains(line, msg) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// backendHost gives the host Pulumi connects to for the backend URL given: that of the Pulumi
+// Cloud API if the URL is empty (Pulumi's default) or Pulumi Cloud's, otherwise the URL's host
+// (for a storage bucket, its name). It's empty for a backend in the filesystem.
+func backendHost(backend string) string {
+	if backend == "" || isPulumiCloudBackend(backend) {
+		return strings.TrimPrefix(pulumiCloudURL, "https://")
+	}
+	u, err := url.Parse(backend)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
 // GetStackOutputs gets the stack outputs and parses them into a map.
 func (sess *reconcileStackSession) GetStackOutputs(outs auto.OutputMap) (shared.StackOutputs, error) {
 	o := make(shared.StackOutputs)
 	for k, v := range outs {
+		selected, err := selectOutput(sess.stack.Outputs, k)
+		if err != nil {
+			return nil, err
+		}
+		if !selected {
+			continue
+		}
 		var value apiextensionsv1.JSON
-		if v.Secret {
+		if v.Secret || sess.stack.AllOutputsSecret {
 			value = apiextensionsv1.JSON{Raw: []byte(`"[secret]"`)}
 		} else {
 			// Marshal the OutputMap value only, to use in unmarshaling to StackOutputs
@@ -1012,74 +3642,234 @@ func (sess *reconcileStackSession) GetStackOutputs(outs auto.OutputMap) (shared.
 	return o, nil
 }
 
+// missingOutputs gives those of the required output names which are not among the stack's
+// outputs, in the order given.
+func missingOutputs(required []string, outs auto.OutputMap) []string {
+	var missing []string
+	for _, name := range required {
+		if _, ok := outs[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// outputsDocument gives the outputs recorded in the status as a single JSON object, leaving out
+// those which are secret (according to the stack's outputs, raw). Object keys are sorted at every
+// level, so the same outputs always give the same document.
+func outputsDocument(outs shared.StackOutputs, raw auto.OutputMap, allSecret bool) (string, error) {
+	doc := map[string]interface{}{}
+	if !allSecret {
+		for k, v := range outs {
+			if raw[k].Secret {
+				continue
+			}
+			var value interface{}
+			if err := json.Unmarshal(v.Raw, &value); err != nil {
+				return "", errors.Wrapf(err, "reading stack output %q", k)
+			}
+			doc[k] = value
+		}
+	}
+	bs, err := json.Marshal(doc)
+	if err != nil {
+		return "", errors.Wrap(err, "marshaling stack outputs")
+	}
+	return string(bs), nil
+}
+
+// limitOutputsSize omits the largest outputs until the rest, serialized as JSON, are no bigger than
+// maxSize bytes. It returns the outputs kept, and the names of those omitted, in the order they were
+// omitted.
+func limitOutputsSize(outs shared.StackOutputs, maxSize int64) (shared.StackOutputs, []string) {
+	size := func(o shared.StackOutputs) int64 {
+		bs, _ := json.Marshal(o)
+		return int64(len(bs))
+	}
+	if size(outs) <= maxSize {
+		return outs, nil
+	}
+
+	names := make([]string, 0, len(outs))
+	for k := range outs {
+		names = append(names, k)
+	}
+	entrySize := func(k string) int { return len(k) + len(outs[k].Raw) }
+	sort.Slice(names, func(i, j int) bool {
+		if si, sj := entrySize(names[i]), entrySize(names[j]); si != sj {
+			return si > sj
+		}
+		return names[i] < names[j]
+	})
+
+	kept := make(shared.StackOutputs, len(outs))
+	for k, v := range outs {
+		kept[k] = v
+	}
+	var omitted []string
+	for _, k := range names {
+		if size(kept) <= maxSize {
+			break
+		}
+		delete(kept, k)
+		omitted = append(omitted, k)
+	}
+	return kept, omitted
+}
+
+// selectOutput reports whether the output with the given name is chosen by the selector; a nil
+// selector chooses all outputs.
+func selectOutput(selector *shared.OutputSelector, name string) (bool, error) {
+	if selector == nil {
+		return true, nil
+	}
+	included := len(selector.Include) == 0
+	for _, pattern := range selector.Include {
+		match, err := path.Match(pattern, name)
+		if err != nil {
+			return false, errors.Wrapf(err, "invalid output include pattern %q", pattern)
+		}
+		if match {
+			included = true
+			break
+		}
+	}
+	for _, pattern := range selector.Exclude {
+		match, err := path.Match(pattern, name)
+		if err != nil {
+			return false, errors.Wrapf(err, "invalid output exclude pattern %q", pattern)
+		}
+		if match {
+			return false, nil
+		}
+	}
+	return included, nil
+}
+
 func (sess *reconcileStackSession) DestroyStack(ctx context.Context) error {
 	writer := sess.logger.LogWriterInfo("Pulumi Destroy")
 	defer contract.IgnoreClose(writer)
 
-	_, err := sess.autoStack.Destroy(ctx, optdestroy.ProgressStreams(writer), optdestroy.UserAgent(execAgent))
+	opts := append([]optdestroy.Option{
+		optdestroy.ProgressStreams(writer), optdestroy.UserAgent(sess.userAgent())},
+		sess.destroyLogOptions()...)
+	if len(sess.stack.FinalizeTargets) > 0 {
+		opts = append(opts, optdestroy.Target(sess.stack.FinalizeTargets))
+	}
+	_, err := sess.autoStack.Destroy(ctx, opts...)
 	if err != nil {
 		return errors.Wrapf(err, "destroying resources for stack '%s'", sess.stack.Stack)
 	}
 
+	// The resources not targeted remain, so the stack must too.
+	if len(sess.stack.FinalizeTargets) > 0 {
+		return nil
+	}
 	err = sess.autoStack.Workspace().RemoveStack(ctx, sess.stack.Stack)
 	if err != nil {
+		// If the stack has already been removed, e.g., out-of-band, that's the outcome wanted;
+		// failing here would leave the finalizer in place for good.
+		if isStackGoneError(err) {
+			sess.logger.Info("Stack already removed from the backend", "Stack.Name", sess.stack.Stack)
+			return nil
+		}
 		return errors.Wrapf(err, "removing stack '%s'", sess.stack.Stack)
 	}
 	return nil
 }
 
-// SetupGitAuth sets up the authentication option to use for the git source
-// repository of the stack. If neither gitAuth or gitAuthSecret are set,
-// a pointer to a zero value of GitAuth is returned — representing
-// unauthenticated git access.
-func (sess *reconcileStackSession) SetupGitAuth(ctx context.Context) (*auto.GitAuth, error) {
-	gitAuth := &auto.GitAuth{}
-
-	if sess.stack.GitAuth != nil {
-		if sess.stack.GitAuth.SSHAuth != nil {
-			privateKey, err := sess.resolveResourceRef(ctx, &sess.stack.GitAuth.SSHAuth.SSHPrivateKey)
-			if err != nil {
-				return nil, errors.Wrap(err, "resolving gitAuth SSH private key")
-			}
-			gitAuth.SSHPrivateKey = privateKey
+// stackGoneRe matches the messages given by `pulumi stack rm` for a stack which doesn't exist:
+// the first by self-managed backends and the service when the stack isn't found on lookup, the
+// second by the service if it goes between lookup and removal.
+var stackGoneRe = regexp.MustCompile(`no stack named.*found|\[404\] Not found`)
 
-			if sess.stack.GitAuth.SSHAuth.Password != nil {
-				password, err := sess.resolveResourceRef(ctx, sess.stack.GitAuth.SSHAuth.Password)
-				if err != nil {
-					return nil, errors.Wrap(err, "resolving gitAuth SSH password")
-				}
-				gitAuth.Password = password
-			}
+// isStackGoneError says whether an error from removing a stack is because it doesn't exist.
+func isStackGoneError(err error) bool {
+	return stackGoneRe.MatchString(err.Error())
+}
 
-			return gitAuth, nil
+// resolveGitAuthConfig resolves the references in a git authentication configuration to give
+// the credentials to use.
+func (sess *reconcileStackSession) resolveGitAuthConfig(ctx context.Context, cfg *shared.GitAuthConfig) (*auto.GitAuth, error) {
+	gitAuth := &auto.GitAuth{}
+	if cfg.SSHAuth != nil {
+		privateKey, err := sess.resolveResourceRef(ctx, &cfg.SSHAuth.SSHPrivateKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "resolving gitAuth SSH private key")
 		}
+		gitAuth.SSHPrivateKey = privateKey
 
-		if sess.stack.GitAuth.PersonalAccessToken != nil {
-			accessToken, err := sess.resolveResourceRef(ctx, sess.stack.GitAuth.PersonalAccessToken)
+		if cfg.SSHAuth.Password != nil {
+			password, err := sess.resolveResourceRef(ctx, cfg.SSHAuth.Password)
 			if err != nil {
-				return nil, errors.Wrap(err, "resolving gitAuth personal access token")
+				return nil, errors.Wrap(err, "resolving gitAuth SSH password")
 			}
-			gitAuth.PersonalAccessToken = accessToken
-			return gitAuth, nil
+			gitAuth.Password = password
 		}
 
-		if sess.stack.GitAuth.BasicAuth == nil {
-			return nil, errors.New("gitAuth config must specify exactly one of " +
-				"'personalAccessToken', 'sshPrivateKey' or 'basicAuth'")
-		}
+		return gitAuth, nil
+	}
 
-		userName, err := sess.resolveResourceRef(ctx, &sess.stack.GitAuth.BasicAuth.UserName)
+	if cfg.PersonalAccessToken != nil {
+		accessToken, err := sess.resolveResourceRef(ctx, cfg.PersonalAccessToken)
 		if err != nil {
-			return nil, errors.Wrap(err, "resolving gitAuth username")
+			return nil, errors.Wrap(err, "resolving gitAuth personal access token")
 		}
+		gitAuth.PersonalAccessToken = accessToken
+		return gitAuth, nil
+	}
+
+	if cfg.BasicAuth == nil {
+		return nil, errors.New("gitAuth config must specify exactly one of " +
+			"'personalAccessToken', 'sshPrivateKey' or 'basicAuth'")
+	}
+
+	userName, err := sess.resolveResourceRef(ctx, &cfg.BasicAuth.UserName)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolving gitAuth username")
+	}
+
+	password, err := sess.resolveResourceRef(ctx, &cfg.BasicAuth.Password)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolving gitAuth password")
+	}
+
+	gitAuth.Username = userName
+	gitAuth.Password = password
+	return gitAuth, nil
+}
 
-		password, err := sess.resolveResourceRef(ctx, &sess.stack.GitAuth.BasicAuth.Password)
+// SetupGitAuthFallbacks resolves the git authentication options to fall back on, in order, if
+// cloning with that from SetupGitAuth fails.
+func (sess *reconcileStackSession) SetupGitAuthFallbacks(ctx context.Context) ([]*auto.GitAuth, error) {
+	if sess.stack.LocalPath != "" {
+		return nil, nil
+	}
+	var fallbacks []*auto.GitAuth
+	for i := range sess.stack.GitAuthFallbacks {
+		gitAuth, err := sess.resolveGitAuthConfig(ctx, &sess.stack.GitAuthFallbacks[i])
 		if err != nil {
-			return nil, errors.Wrap(err, "resolving gitAuth password")
+			return nil, errors.Wrapf(err, "gitAuthFallbacks[%d]", i)
 		}
+		fallbacks = append(fallbacks, gitAuth)
+	}
+	return fallbacks, nil
+}
+
+// SetupGitAuth sets up the authentication option to use for the git source
+// repository of the stack. If neither gitAuth or gitAuthSecret are set,
+// a pointer to a zero value of GitAuth is returned — representing
+// unauthenticated git access.
+func (sess *reconcileStackSession) SetupGitAuth(ctx context.Context) (*auto.GitAuth, error) {
+	gitAuth := &auto.GitAuth{}
+
+	// Source at a local path is not fetched, so needs no authentication.
+	if sess.stack.LocalPath != "" {
+		return gitAuth, nil
+	}
 
-		gitAuth.Username = userName
-		gitAuth.Password = password
+	if sess.stack.GitAuth != nil {
+		return sess.resolveGitAuthConfig(ctx, sess.stack.GitAuth)
 	} else if sess.stack.GitAuthSecret != "" {
 		namespacedName := types.NamespacedName{Name: sess.stack.GitAuthSecret, Namespace: sess.namespace}
 
@@ -1162,24 +3952,11 @@ func (sess *reconcileStackSession) patchStatus(ctx context.Context, o *pulumiv1.
 // and adds them to the SSH known hosts to perform strict key checking during SSH
 // git cloning.
 func (sess *reconcileStackSession) addSSHKeysToKnownHosts(projectRepoURL string) error {
-	// Parse the Stack project repo SSH host and port (if exists) from the git SSH URL
-	// e.g. git@github.com:foo/bar.git returns "github.com" for host
-	// e.g. git@example.com:1234:foo/bar.git returns "example.com" for host and "1234" for port
-	u, err := giturls.Parse(projectRepoURL)
-	if err != nil {
-		return errors.Wrap(err, "error parsing project repo URL to use with ssh-keyscan")
-	}
-	hostPort := strings.Split(u.Host, ":")
-	if len(hostPort) == 0 || len(hostPort) > 2 {
-		return errors.Wrap(err, "error parsing project repo URL to use with ssh-keyscan")
-	}
-
 	// SSH key scan the repo's URL (host port) to get the public keys.
-	args := []string{}
-	if len(hostPort) == 2 {
-		args = append(args, "-p", hostPort[1])
+	args, err := sshKeyScanArgs(projectRepoURL)
+	if err != nil {
+		return err
 	}
-	args = append(args, "-H", hostPort[0])
 	sshKeyScan, _ := exec.LookPath("ssh-keyscan")
 	cmd := exec.Command(sshKeyScan, args...)
 	cmd.Dir = os.Getenv("HOME")
@@ -1188,7 +3965,10 @@ func (sess *reconcileStackSession) addSSHKeysToKnownHosts(projectRepoURL string)
 		return errors.Wrap(err, "error running ssh-keyscan")
 	}
 
-	// Add the repo public keys to the SSH known hosts to enforce key checking.
+	// Add the repo public keys to the SSH known hosts to enforce key checking. The file is
+	// shared by all stacks, since the repository is cloned within the operator process.
+	knownHostsMu.Lock()
+	defer knownHostsMu.Unlock()
 	filename := fmt.Sprintf("%s/%s", os.Getenv("HOME"), ".ssh/known_hosts")
 	f, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
 	if err != nil {
@@ -1201,6 +3981,46 @@ func (sess *reconcileStackSession) addSSHKeysToKnownHosts(projectRepoURL string)
 	return nil
 }
 
+// sshKeyScanArgs returns the arguments to give ssh-keyscan for the host (and port, if it is
+// not the default) of a git repository URL.
+// e.g. git@github.com:foo/bar.git gives "-H github.com"
+// e.g. ssh://git@example.com:7999/foo/bar.git gives "-p 7999 -H example.com"
+func sshKeyScanArgs(projectRepoURL string) ([]string, error) {
+	u, err := giturls.Parse(projectRepoURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing project repo URL to use with ssh-keyscan")
+	}
+	host := u.Hostname()
+	if host == "" {
+		return nil, fmt.Errorf("no host found in project repo URL %q to use with ssh-keyscan", projectRepoURL)
+	}
+	args := []string{}
+	if port := u.Port(); port != "" {
+		args = append(args, "-p", port)
+	}
+	return append(args, "-H", host), nil
+}
+
+// applyObjectMetadata adds the labels and annotations given in the stack spec, if any, to the
+// metadata of an object the operator writes. Other labels and annotations are left alone.
+func applyObjectMetadata(meta *metav1.ObjectMeta, spec *shared.ObjectMetadata) {
+	if spec == nil {
+		return
+	}
+	for k, v := range spec.Labels {
+		if meta.Labels == nil {
+			meta.Labels = map[string]string{}
+		}
+		meta.Labels[k] = v
+	}
+	for k, v := range spec.Annotations {
+		if meta.Annotations == nil {
+			meta.Annotations = map[string]string{}
+		}
+		meta.Annotations[k] = v
+	}
+}
+
 func contains(list []string, s string) bool {
 	for _, v := range list {
 		if v == s {
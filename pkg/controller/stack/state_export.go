@@ -0,0 +1,73 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/shared"
+	pulumiv1 "github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// stateExportKey is the key under which an export is stored in the export secret, with
+// compressedKeySuffix appended if it's compressed.
+const stateExportKey = "deployment.json"
+
+// exportState exports the stack's last deployment to the Secret given in the spec, and records
+// where in the stack's status. A failure is reported with an event and logged, but otherwise
+// ignored, since the operation itself succeeded.
+func (r *ReconcileStack) exportState(ctx context.Context, sess *reconcileStackSession, instance *pulumiv1.Stack) {
+	if sess.stack.StateExport == nil {
+		return
+	}
+	ref, err := sess.ExportState(ctx)
+	if err != nil {
+		r.emitEvent(instance, pulumiv1.StackStateExportFailureEvent(), "Failed to export stack state: %v.", err.Error())
+		sess.logger.Error(err, "Failed to export stack state", "Stack.Name", sess.stack.Stack)
+		return
+	}
+	sess.logger.Info("Exported stack state", "Stack.Name", sess.stack.Stack, "Secret", ref.SecretName, "Key", ref.Key)
+	if instance.Status.LastUpdate == nil {
+		instance.Status.LastUpdate = &shared.StackUpdateState{}
+	}
+	instance.Status.LastUpdate.StateRef = ref
+}
+
+// ExportState exports the stack's deployment, and stores it in the stack's export secret.
+func (sess *reconcileStackSession) ExportState(ctx context.Context) (*shared.StateRef, error) {
+	data, err := sess.exportDeployment(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return sess.storeStateExport(ctx, data)
+}
+
+// storeStateExport stores an exported deployment in the stack's export secret, compressed if it's
+// large, creating the secret if necessary, and gives a reference to it.
+func (sess *reconcileStackSession) storeStateExport(ctx context.Context, data []byte) (*shared.StateRef, error) {
+	key, data, err := secretValue(stateExportKey, data)
+	if err != nil {
+		return nil, err
+	}
+	name := sess.stack.StateExport.SecretName
+	err = sess.storeSecret(ctx, name, func(secret *corev1.Secret) {
+		setStateExport(secret, key, data)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "storing export")
+	}
+	return &shared.StateRef{SecretName: name, Key: key}, nil
+}
+
+// setStateExport puts an export in the secret under the key given, removing any previous export
+// stored under the other key. Other entries in the secret are left alone.
+func setStateExport(secret *corev1.Secret, key string, data []byte) {
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	delete(secret.Data, stateExportKey)
+	delete(secret.Data, stateExportKey+compressedKeySuffix)
+	secret.Data[key] = data
+}
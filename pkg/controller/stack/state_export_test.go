@@ -0,0 +1,40 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/shared"
+	"github.com/pulumi/pulumi-kubernetes-operator/pkg/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func Test_StoreStateExport(t *testing.T) {
+	logger := logging.NewLogger(t.Name(), "Request.Test", "Test_StoreStateExport")
+	existing := &corev1.Secret{
+		Data: map[string][]byte{"deployment.json.gz": []byte("old"), "README": []byte("state of app")},
+	}
+	existing.Namespace, existing.Name = namespace, "app-state"
+	client := fake.NewFakeClientWithScheme(scheme.Scheme, existing)
+	sess := newReconcileStackSession(logger, shared.StackSpec{
+		StateExport: &shared.StateExport{SecretName: "app-state"},
+	}, client, namespace)
+
+	ref, err := sess.storeStateExport(context.TODO(), []byte(`{"version":3}`))
+	require.NoError(t, err)
+	assert.Equal(t, &shared.StateRef{SecretName: "app-state", Key: "deployment.json"}, ref)
+
+	var secret corev1.Secret
+	require.NoError(t, client.Get(context.TODO(), types.NamespacedName{Namespace: namespace, Name: "app-state"}, &secret))
+	assert.Equal(t, map[string][]byte{
+		"README":          []byte("state of app"),
+		"deployment.json": []byte(`{"version":3}`),
+	}, secret.Data)
+}
@@ -0,0 +1,58 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// updateCancelGracePeriod is how long an update cancelled for running too long is given to stop by
+// itself, before its pulumi process is killed.
+const updateCancelGracePeriod = time.Minute
+
+// limitUpdate limits how long an update may run. Once the limit is reached, cancelUpdate is
+// called to cancel the update through the backend, which releases the stack's lock. If that fails,
+// or the update hasn't stopped after the grace period, the context for the update is cancelled,
+// which kills the pulumi process. It gives the context in which to run the update, a func
+// reporting whether the limit was reached, and a func to call once the update has returned.
+func limitUpdate(ctx context.Context, limit, grace time.Duration, cancelUpdate func(context.Context) error) (context.Context, func() bool, func()) {
+	updateCtx, cancel := context.WithCancel(ctx)
+	var timedOut int32
+	timer := time.AfterFunc(limit, func() {
+		atomic.StoreInt32(&timedOut, 1)
+		cancelCtx, cancelTimeout := context.WithTimeout(context.Background(), grace)
+		defer cancelTimeout()
+		if err := cancelUpdate(cancelCtx); err != nil {
+			log.Error(err, "Failed to cancel update which ran for too long")
+			cancel()
+			return
+		}
+		select {
+		case <-updateCtx.Done():
+		case <-cancelCtx.Done():
+			cancel()
+		}
+	})
+	reachedLimit := func() bool {
+		return atomic.LoadInt32(&timedOut) == 1
+	}
+	stop := func() {
+		timer.Stop()
+		cancel()
+	}
+	return updateCtx, reachedLimit, stop
+}
+
+// updateContext gives the context in which to run an update of the stack, which is limited by
+// MaxUpdateDurationSeconds, if given; along with a func reporting whether the limit was reached,
+// and a func to call once the update has returned.
+func (sess *reconcileStackSession) updateContext(ctx context.Context) (context.Context, func() bool, func()) {
+	if sess.stack.MaxUpdateDurationSeconds <= 0 {
+		updateCtx, cancel := context.WithCancel(ctx)
+		return updateCtx, func() bool { return false }, cancel
+	}
+	limit := time.Duration(sess.stack.MaxUpdateDurationSeconds) * time.Second
+	return limitUpdate(ctx, limit, updateCancelGracePeriod, sess.CancelUpdate)
+}
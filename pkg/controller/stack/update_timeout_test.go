@@ -0,0 +1,59 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_LimitUpdate(t *testing.T) {
+	// An update finishing within the limit is left alone.
+	cancelled := make(chan struct{}, 1)
+	cancelUpdate := func(context.Context) error {
+		cancelled <- struct{}{}
+		return nil
+	}
+	ctx, timedOut, stop := limitUpdate(context.Background(), time.Hour, time.Minute, cancelUpdate)
+	stop()
+	assert.False(t, timedOut())
+	assert.Len(t, cancelled, 0)
+	assert.Error(t, ctx.Err(), "the context is done with once the update has returned")
+
+	// An update running too long is cancelled through the backend, and stops by itself.
+	ctx, timedOut, stop = limitUpdate(context.Background(), 10*time.Millisecond, time.Minute, cancelUpdate)
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("update not cancelled after the limit")
+	}
+	assert.True(t, timedOut())
+	assert.NoError(t, ctx.Err(), "the update is given time to stop")
+	stop()
+
+	// An update which doesn't stop once cancelled has its process killed after the grace period.
+	ctx, timedOut, stop = limitUpdate(context.Background(), 10*time.Millisecond, 10*time.Millisecond, cancelUpdate)
+	defer stop()
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("update not stopped after the grace period")
+	}
+	assert.True(t, timedOut())
+
+	// An update which can't be cancelled through the backend has its process killed straight away.
+	ctx, timedOut, stop = limitUpdate(context.Background(), 10*time.Millisecond, time.Hour, func(context.Context) error {
+		return errors.New("cancel not supported")
+	})
+	defer stop()
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("update not stopped when it couldn't be cancelled")
+	}
+	assert.True(t, timedOut())
+}
@@ -0,0 +1,232 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	pulumiv1 "github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/v1"
+	"github.com/pulumi/pulumi-kubernetes-operator/pkg/logging"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+const (
+	// gitWebhookAddressEnv names the environment variable giving the address (e.g., ":8090") to
+	// listen on for git webhook deliveries. If it's not set, there's no webhook endpoint, and
+	// stacks tracking a branch only poll it.
+	gitWebhookAddressEnv = "GIT_WEBHOOK_ADDRESS"
+	// gitWebhookIndex names the index of stacks by the repository and branch they track, for
+	// those which give a webhook secret.
+	gitWebhookIndex = "spec.gitWebhookRepoBranch"
+	// maxWebhookPayload is the largest webhook payload read; GitHub caps payloads at 25MB.
+	maxWebhookPayload = 25 << 20
+)
+
+// gitWebhookIndexKeys gives the keys under which a stack is indexed for webhook deliveries: its
+// repository and branch, if it tracks a branch and gives a webhook secret.
+func gitWebhookIndexKeys(obj client.Object) []string {
+	stack, ok := obj.(*pulumiv1.Stack)
+	if !ok || stack.Spec.GitWebhookSecret == nil || stack.Spec.ProjectRepo == "" {
+		return nil
+	}
+	refName, ok := branchRefName(stack.Spec.Branch)
+	if !ok || stack.Spec.Branch == "" {
+		return nil
+	}
+	return []string{gitWebhookKey(stack.Spec.ProjectRepo, refName.String())}
+}
+
+func gitWebhookKey(repo, ref string) string {
+	return normalizeRepoURL(repo) + "#" + ref
+}
+
+// normalizeRepoURL reduces the URL of a repository to its host and path, so that the various URLs
+// for the same repository, e.g., "https://github.com/acme/app.git" and
+// "git@github.com:acme/app.git", compare equal.
+func normalizeRepoURL(raw string) string {
+	s := strings.TrimSpace(raw)
+	if strings.Contains(s, "://") {
+		if u, err := url.Parse(s); err == nil {
+			s = u.Hostname() + u.Path
+		}
+	} else if i := strings.Index(s, ":"); i > 0 {
+		// An scp-like address, e.g., "git@github.com:acme/app.git".
+		host := s[:i]
+		if at := strings.LastIndex(host, "@"); at >= 0 {
+			host = host[at+1:]
+		}
+		s = host + "/" + strings.TrimPrefix(s[i+1:], "/")
+	}
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "/"), ".git")
+	return strings.ToLower(s)
+}
+
+// verifyWebhook checks that a webhook delivery was made with the secret given: by its signature,
+// for GitHub (and others using the same header), or its token, for GitLab.
+func verifyWebhook(header http.Header, body []byte, secret string) bool {
+	if secret == "" {
+		return false
+	}
+	if sig := header.Get("X-Hub-Signature-256"); sig != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+		return hmac.Equal([]byte(sig), []byte(want))
+	}
+	if token := header.Get("X-Gitlab-Token"); token != "" {
+		return subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1
+	}
+	return false
+}
+
+// pushPayload has the parts of a GitHub or GitLab push event used to find the stacks affected.
+type pushPayload struct {
+	Ref        string `json:"ref"`
+	Repository struct {
+		// GitHub
+		CloneURL string `json:"clone_url"`
+		SSHURL   string `json:"ssh_url"`
+		HTMLURL  string `json:"html_url"`
+		// GitLab
+		GitHTTPURL string `json:"git_http_url"`
+		GitSSHURL  string `json:"git_ssh_url"`
+	} `json:"repository"`
+}
+
+// keys gives the index keys for the repository and branch pushed to.
+func (p *pushPayload) keys() []string {
+	if !strings.HasPrefix(p.Ref, "refs/heads/") {
+		return nil
+	}
+	seen := map[string]bool{}
+	var keys []string
+	r := p.Repository
+	for _, u := range []string{r.CloneURL, r.SSHURL, r.HTMLURL, r.GitHTTPURL, r.GitSSHURL} {
+		if u == "" {
+			continue
+		}
+		if key := gitWebhookKey(u, p.Ref); !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// gitWebhook receives push events from git hosts, and has the stacks tracking the branch pushed to
+// processed, if the delivery was made with their webhook secret.
+type gitWebhook struct {
+	addr   string
+	client client.Client
+	events chan<- event.GenericEvent
+}
+
+// Start serves webhook deliveries until the context is done.
+func (h *gitWebhook) Start(ctx context.Context) error {
+	srv := &http.Server{Addr: h.addr, Handler: h, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	log.Info("Serving git webhook", "address", h.addr)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func (h *gitWebhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookPayload+1))
+	if err != nil {
+		http.Error(w, "reading body", http.StatusBadRequest)
+		return
+	}
+	if len(body) > maxWebhookPayload {
+		http.Error(w, "payload too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	// Only pushes are of interest; anything else (e.g., GitHub's "ping") is acknowledged and
+	// otherwise ignored.
+	if e := r.Header.Get("X-GitHub-Event"); e != "" && e != "push" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if e := r.Header.Get("X-Gitlab-Event"); e != "" && e != "Push Hook" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var payload pushPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	logger := logging.WithValues(log, "Ref", payload.Ref)
+	candidates, verified := 0, 0
+	seen := map[client.ObjectKey]bool{}
+	for _, key := range payload.keys() {
+		var stacks pulumiv1.StackList
+		if err := h.client.List(r.Context(), &stacks, client.MatchingFields{gitWebhookIndex: key}); err != nil {
+			logger.Error(err, "Failed to list stacks for git webhook")
+			http.Error(w, "listing stacks", http.StatusInternalServerError)
+			return
+		}
+		for i := range stacks.Items {
+			stack := &stacks.Items[i]
+			objKey := client.ObjectKeyFromObject(stack)
+			// The keys are checked here as well as asked for, so a client without the index
+			// gives the same result.
+			if seen[objKey] || !contains(gitWebhookIndexKeys(stack), key) {
+				continue
+			}
+			seen[objKey] = true
+			candidates++
+			sess := newReconcileStackSession(logger, stack.Spec, h.client, stack.Namespace)
+			secret, err := sess.resolveResourceRef(r.Context(), stack.Spec.GitWebhookSecret)
+			if err != nil {
+				logger.Error(err, "Failed to resolve git webhook secret", "Namespace", stack.Namespace, "Name", stack.Name)
+				continue
+			}
+			if !verifyWebhook(r.Header, body, secret) {
+				continue
+			}
+			verified++
+			logger.Info("Git webhook push received; processing stack", "Namespace", stack.Namespace, "Name", stack.Name)
+			select {
+			case h.events <- event.GenericEvent{Object: stack}:
+			case <-r.Context().Done():
+				http.Error(w, "cancelled", http.StatusServiceUnavailable)
+				return
+			}
+		}
+	}
+
+	switch {
+	case candidates > 0 && verified == 0:
+		http.Error(w, "signature not verified", http.StatusUnauthorized)
+	case verified > 0:
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, "%d stacks queued\n", verified)
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stack
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/shared"
+	pulumiv1 "github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func Test_NormalizeRepoURL(t *testing.T) {
+	for _, u := range []string{
+		"https://github.com/acme/app.git",
+		"https://github.com/acme/app",
+		"https://github.com/Acme/App/",
+		"git@github.com:acme/app.git",
+		"ssh://git@github.com:22/acme/app.git",
+	} {
+		assert.Equal(t, "github.com/acme/app", normalizeRepoURL(u), u)
+	}
+}
+
+func signature(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func Test_VerifyWebhook(t *testing.T) {
+	body := []byte(`{"ref":"refs/heads/main"}`)
+
+	github := http.Header{}
+	github.Set("X-Hub-Signature-256", signature("s3cret", string(body)))
+	assert.True(t, verifyWebhook(github, body, "s3cret"))
+	assert.False(t, verifyWebhook(github, body, "other"))
+	assert.False(t, verifyWebhook(github, []byte(`{"ref":"refs/heads/evil"}`), "s3cret"))
+
+	gitlab := http.Header{}
+	gitlab.Set("X-Gitlab-Token", "s3cret")
+	assert.True(t, verifyWebhook(gitlab, body, "s3cret"))
+	assert.False(t, verifyWebhook(gitlab, body, "other"))
+
+	assert.False(t, verifyWebhook(http.Header{}, body, "s3cret"))
+	assert.False(t, verifyWebhook(gitlab, body, ""))
+}
+
+func Test_GitWebhookQueuesStacks(t *testing.T) {
+	s := runtime.NewScheme()
+	require.NoError(t, pulumiv1.SchemeBuilder.AddToScheme(s))
+	secret := shared.NewLiteralResourceRef("s3cret")
+	stackFor := func(name, repo, branch string, secret *shared.ResourceRef) *pulumiv1.Stack {
+		return &pulumiv1.Stack{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec: shared.StackSpec{
+				ProjectRepo:      repo,
+				Branch:           branch,
+				GitWebhookSecret: secret,
+			},
+		}
+	}
+	client := fake.NewFakeClientWithScheme(s,
+		stackFor("main", "git@github.com:acme/app.git", "main", &secret),
+		stackFor("full-ref", "https://github.com/acme/app", "refs/heads/main", &secret),
+		stackFor("other-branch", "https://github.com/acme/app", "develop", &secret),
+		stackFor("other-repo", "https://github.com/acme/lib", "main", &secret),
+		stackFor("polling", "https://github.com/acme/app", "main", nil))
+
+	events := make(chan event.GenericEvent, 10)
+	h := &gitWebhook{client: client, events: events}
+	body := `{"ref":"refs/heads/main","repository":{"clone_url":"https://github.com/acme/app.git","ssh_url":"git@github.com:acme/app.git"}}`
+
+	deliver := func(sig string) int {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set("X-GitHub-Event", "push")
+		req.Header.Set("X-Hub-Signature-256", sig)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, deliver(signature("wrong", body)))
+	assert.Len(t, events, 0)
+
+	assert.Equal(t, http.StatusAccepted, deliver(signature("s3cret", body)))
+	var queued []string
+	for len(events) > 0 {
+		queued = append(queued, (<-events).Object.GetName())
+	}
+	assert.ElementsMatch(t, []string{"main", "full-ref"}, queued)
+}
@@ -0,0 +1,184 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stackcontrol
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	pulumiv1 "github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/v1"
+	"github.com/pulumi/pulumi-kubernetes-operator/pkg/logging"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// stackControlFinalizer is given to each StackControl, so that the stacks it paused can be resumed
+// when it is deleted.
+const stackControlFinalizer = "finalizer.stackcontrol.pulumi.com"
+
+var log = logf.Log.WithName("controller_stackcontrol")
+
+// Add creates a new StackControl Controller and adds it to the Manager. The Manager will set fields
+// on the Controller and Start it when the Manager is Started.
+func Add(mgr manager.Manager) error {
+	return add(mgr, newReconciler(mgr))
+}
+
+// newReconciler returns a new reconcile.Reconciler
+func newReconciler(mgr manager.Manager) *ReconcileStackControl {
+	return &ReconcileStackControl{client: mgr.GetClient()}
+}
+
+// add adds a new Controller to mgr with r as the reconcile.Reconciler
+func add(mgr manager.Manager, r *ReconcileStackControl) error {
+	c, err := controller.New("stackcontrol-controller", mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+
+	if err = c.Watch(&source.Kind{Type: &pulumiv1.StackControl{}}, &handler.EnqueueRequestForObject{}); err != nil {
+		return err
+	}
+
+	// A Stack which is created, or has its labels changed, may now be selected by a StackControl
+	// in its namespace, or no longer be; so each of them is processed again.
+	return c.Watch(&source.Kind{Type: &pulumiv1.Stack{}},
+		handler.EnqueueRequestsFromMapFunc(r.controlsInNamespace), predicate.LabelChangedPredicate{})
+}
+
+// controlsInNamespace gives a request for each StackControl in the namespace of the object given.
+func (r *ReconcileStackControl) controlsInNamespace(obj client.Object) []reconcile.Request {
+	var controls pulumiv1.StackControlList
+	if err := r.client.List(context.Background(), &controls, client.InNamespace(obj.GetNamespace())); err != nil {
+		log.Error(err, "Failed to list StackControls", "Namespace", obj.GetNamespace())
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, control := range controls.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: control.Namespace, Name: control.Name},
+		})
+	}
+	return requests
+}
+
+// blank assignment to verify that ReconcileStackControl implements reconcile.Reconciler
+var _ reconcile.Reconciler = &ReconcileStackControl{}
+
+// ReconcileStackControl reconciles a StackControl object, by annotating the Stacks it selects.
+type ReconcileStackControl struct {
+	client client.Client
+}
+
+// Reconcile brings the annotations of the Stacks in a StackControl's namespace into line with the
+// StackControl: those it selects are paused, if it says so, and given its reconcile request, if
+// it has one; and any others it paused are resumed. When it is deleted, all the Stacks it paused
+// are resumed.
+func (r *ReconcileStackControl) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
+	reqLogger := logging.WithValues(log, "Request.Namespace", request.Namespace, "Request.Name", request.Name)
+
+	control := &pulumiv1.StackControl{}
+	if err := r.client.Get(ctx, request.NamespacedName, control); err != nil {
+		return reconcile.Result{}, client.IgnoreNotFound(err)
+	}
+
+	pausedKey := pulumiv1.PausedAnnotationPrefix + control.Name
+	if errs := validation.IsQualifiedName(pausedKey); len(errs) > 0 {
+		// This won't work until the object is recreated with another name, so don't requeue.
+		reqLogger.Info("StackControl name cannot be used in an annotation key; not processing it", "Errors", errs)
+		return reconcile.Result{}, nil
+	}
+	deleting := control.GetDeletionTimestamp() != nil
+	if deleting && !controllerutil.ContainsFinalizer(control, stackControlFinalizer) {
+		return reconcile.Result{}, nil
+	}
+
+	// When the StackControl is being deleted, or its selector is invalid, it selects nothing, so
+	// the stacks it paused are resumed.
+	selector := labels.Nothing()
+	var selectorErr error
+	if !deleting {
+		if selector, selectorErr = metav1.LabelSelectorAsSelector(&control.Spec.Selector); selectorErr != nil {
+			reqLogger.Info("StackControl has an invalid selector; resuming the Stacks it paused", "Error", selectorErr.Error())
+			selector = labels.Nothing()
+		}
+		if !controllerutil.ContainsFinalizer(control, stackControlFinalizer) {
+			controllerutil.AddFinalizer(control, stackControlFinalizer)
+			if err := r.client.Update(ctx, control); err != nil {
+				return reconcile.Result{}, errors.Wrap(err, "adding finalizer")
+			}
+		}
+	}
+
+	var stacks pulumiv1.StackList
+	if err := r.client.List(ctx, &stacks, client.InNamespace(control.Namespace)); err != nil {
+		return reconcile.Result{}, errors.Wrap(err, "listing Stacks")
+	}
+	var selected []string
+	for i := range stacks.Items {
+		stack := &stacks.Items[i]
+		matches := selector.Matches(labels.Set(stack.GetLabels()))
+		if matches {
+			selected = append(selected, stack.Name)
+		}
+		patch := client.MergeFrom(stack.DeepCopy())
+		if !annotate(stack, pausedKey, matches && control.Spec.Paused, matches, control.Spec.ReconcileRequest) {
+			continue
+		}
+		reqLogger.Info("Updating annotations of selected Stack", "Stack.Name", stack.Name,
+			"Paused", matches && control.Spec.Paused)
+		if err := r.client.Patch(ctx, stack, patch); client.IgnoreNotFound(err) != nil {
+			return reconcile.Result{}, errors.Wrapf(err, "annotating Stack %q", stack.Name)
+		}
+	}
+
+	if deleting {
+		controllerutil.RemoveFinalizer(control, stackControlFinalizer)
+		return reconcile.Result{}, r.client.Update(ctx, control)
+	}
+
+	control.Status.Stacks = selected
+	control.Status.Error = ""
+	if selectorErr != nil {
+		control.Status.Error = "invalid selector: " + selectorErr.Error()
+	}
+	control.Status.ObservedGeneration = control.GetGeneration()
+	return reconcile.Result{}, r.client.Status().Update(ctx, control)
+}
+
+// annotate sets the annotations of a stack to say whether it is paused by the key given and, if
+// it is selected, to carry the reconcile request given. It reports whether anything changed.
+func annotate(stack *pulumiv1.Stack, pausedKey string, pause, selected bool, reconcileRequest string) bool {
+	annotations := stack.GetAnnotations()
+	changed := false
+	if _, ok := annotations[pausedKey]; ok && !pause {
+		delete(annotations, pausedKey)
+		changed = true
+	}
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	if pause && annotations[pausedKey] != "true" {
+		annotations[pausedKey] = "true"
+		changed = true
+	}
+	if selected && reconcileRequest != "" && annotations[pulumiv1.ReconcileRequestAnnotation] != reconcileRequest {
+		annotations[pulumiv1.ReconcileRequestAnnotation] = reconcileRequest
+		changed = true
+	}
+	if changed {
+		stack.SetAnnotations(annotations)
+	}
+	return changed
+}
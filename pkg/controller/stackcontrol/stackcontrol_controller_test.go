@@ -0,0 +1,163 @@
+// Copyright 2021, Pulumi Corporation.  All rights reserved.
+
+package stackcontrol
+
+import (
+	"context"
+	"testing"
+
+	pulumiv1 "github.com/pulumi/pulumi-kubernetes-operator/pkg/apis/pulumi/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const namespace = "default"
+
+func stackWithLabels(name string, labels map[string]string) *pulumiv1.Stack {
+	return &pulumiv1.Stack{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+	}
+}
+
+func getStack(t *testing.T, c client.Client, name string) *pulumiv1.Stack {
+	var stack pulumiv1.Stack
+	require.NoError(t, c.Get(context.TODO(), types.NamespacedName{Namespace: namespace, Name: name}, &stack))
+	return &stack
+}
+
+func Test_ReconcilePausesSelectedStacks(t *testing.T) {
+	s := runtime.NewScheme()
+	require.NoError(t, pulumiv1.SchemeBuilder.AddToScheme(s))
+
+	control := &pulumiv1.StackControl{
+		ObjectMeta: metav1.ObjectMeta{Name: "migration", Namespace: namespace},
+		Spec: pulumiv1.StackControlSpec{
+			Selector:         metav1.LabelSelector{MatchLabels: map[string]string{"team": "payments"}},
+			Paused:           true,
+			ReconcileRequest: "now",
+		},
+	}
+	c := fake.NewFakeClientWithScheme(s, control,
+		stackWithLabels("payments", map[string]string{"team": "payments"}),
+		stackWithLabels("search", map[string]string{"team": "search"}))
+	r := &ReconcileStackControl{client: c}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: namespace, Name: "migration"}}
+
+	_, err := r.Reconcile(context.TODO(), req)
+	require.NoError(t, err)
+
+	payments := getStack(t, c, "payments")
+	key, paused := payments.IsPaused()
+	assert.True(t, paused)
+	assert.Equal(t, "paused.pulumi.com/migration", key)
+	assert.Equal(t, "now", payments.GetAnnotations()[pulumiv1.ReconcileRequestAnnotation])
+	_, paused = getStack(t, c, "search").IsPaused()
+	assert.False(t, paused)
+	assert.Empty(t, getStack(t, c, "search").GetAnnotations())
+
+	require.NoError(t, c.Get(context.TODO(), req.NamespacedName, control))
+	assert.Equal(t, []string{"payments"}, control.Status.Stacks)
+	assert.Contains(t, control.GetFinalizers(), stackControlFinalizer)
+
+	// Resuming takes the annotation away again.
+	control.Spec.Paused = false
+	require.NoError(t, c.Update(context.TODO(), control))
+	_, err = r.Reconcile(context.TODO(), req)
+	require.NoError(t, err)
+	_, paused = getStack(t, c, "payments").IsPaused()
+	assert.False(t, paused)
+}
+
+func Test_ReconcileDeletedResumesStacks(t *testing.T) {
+	s := runtime.NewScheme()
+	require.NoError(t, pulumiv1.SchemeBuilder.AddToScheme(s))
+
+	now := metav1.Now()
+	control := &pulumiv1.StackControl{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "migration",
+			Namespace:         namespace,
+			Finalizers:        []string{stackControlFinalizer},
+			DeletionTimestamp: &now,
+		},
+		Spec: pulumiv1.StackControlSpec{
+			Selector: metav1.LabelSelector{MatchLabels: map[string]string{"team": "payments"}},
+			Paused:   true,
+		},
+	}
+	paused := stackWithLabels("payments", map[string]string{"team": "payments"})
+	paused.SetAnnotations(map[string]string{
+		"paused.pulumi.com/migration": "true",
+		"paused.pulumi.com/by-hand":   "true",
+	})
+	c := fake.NewFakeClientWithScheme(s, control, paused)
+	r := &ReconcileStackControl{client: c}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: namespace, Name: "migration"}}
+
+	_, err := r.Reconcile(context.TODO(), req)
+	require.NoError(t, err)
+
+	// Only the annotation belonging to the StackControl is removed.
+	assert.Equal(t, map[string]string{"paused.pulumi.com/by-hand": "true"}, getStack(t, c, "payments").GetAnnotations())
+	// With its finalizer removed, the StackControl is gone.
+	err = c.Get(context.TODO(), req.NamespacedName, control)
+	assert.True(t, k8serrors.IsNotFound(err))
+}
+
+func Test_ReconcileInvalidSelectorResumesStacks(t *testing.T) {
+	s := runtime.NewScheme()
+	require.NoError(t, pulumiv1.SchemeBuilder.AddToScheme(s))
+
+	invalid := metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+		{Key: "team", Operator: metav1.LabelSelectorOpIn},
+	}}
+	control := &pulumiv1.StackControl{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "migration",
+			Namespace:  namespace,
+			Finalizers: []string{stackControlFinalizer},
+		},
+		Spec: pulumiv1.StackControlSpec{Selector: invalid, Paused: true},
+	}
+	now := metav1.Now()
+	deleted := &pulumiv1.StackControl{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "freeze",
+			Namespace:         namespace,
+			Finalizers:        []string{stackControlFinalizer},
+			DeletionTimestamp: &now,
+		},
+		Spec: pulumiv1.StackControlSpec{Selector: invalid, Paused: true},
+	}
+	paused := stackWithLabels("payments", map[string]string{"team": "payments"})
+	paused.SetAnnotations(map[string]string{
+		"paused.pulumi.com/migration": "true",
+		"paused.pulumi.com/freeze":    "true",
+	})
+	c := fake.NewFakeClientWithScheme(s, control, deleted, paused)
+	r := &ReconcileStackControl{client: c}
+
+	// A StackControl given an invalid selector resumes the stacks it paused, and says why.
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: namespace, Name: "migration"}}
+	_, err := r.Reconcile(context.TODO(), req)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"paused.pulumi.com/freeze": "true"}, getStack(t, c, "payments").GetAnnotations())
+	require.NoError(t, c.Get(context.TODO(), req.NamespacedName, control))
+	assert.Empty(t, control.Status.Stacks)
+	assert.Contains(t, control.Status.Error, "invalid selector")
+
+	// One being deleted with an invalid selector still resumes its stacks, and goes away.
+	req = reconcile.Request{NamespacedName: types.NamespacedName{Namespace: namespace, Name: "freeze"}}
+	_, err = r.Reconcile(context.TODO(), req)
+	require.NoError(t, err)
+	assert.Empty(t, getStack(t, c, "payments").GetAnnotations())
+	err = c.Get(context.TODO(), req.NamespacedName, deleted)
+	assert.True(t, k8serrors.IsNotFound(err))
+}